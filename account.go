@@ -0,0 +1,518 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"trading-bot/api"
+	"trading-bot/connector"
+	"trading-bot/data"
+	"trading-bot/logger"
+	"trading-bot/order"
+	"trading-bot/strategy"
+)
+
+// Структура для настроек торгового счета. Незаданные поля берутся из общих настроек бота
+type accountConfig struct {
+	Name               string            `json:"name"`                  // Имя счета (в журналах, отчетах и файлах состояния)
+	Broker             string            `json:"broker"`                // Площадка исполнения: "finam", "transaq" или "paper"
+	AccessToken        string            `json:"access_token"`          // Токен Trade API Финам
+	AccountID          string            `json:"account_id"`            // Счет Trade API Финам
+	ClientCode         string            `json:"client_code"`           // Код клиента Transaq
+	PaperCapital       float64           `json:"paper_capital"`         // Начальный капитал виртуального брокера
+	Risk               *order.RiskLimits `json:"risk"`                  // Лимиты риска счета
+	Strategies         []string          `json:"strategies"`            // Стратегии, торгующие на счете (пусто - все)
+	OrderQuantity      int               `json:"order_quantity"`        // Размер ордера по сигналу (в лотах)
+	KillSwitchHTTPAddr string            `json:"kill_switch_http_addr"` // Адрес HTTP управления аварийной остановкой счета
+}
+
+// Функция возвращает настройки счетов. Без списка счетов бот работает с одним счетом
+// по общим настройкам
+func (c *botConfig) accountConfigs() []accountConfig {
+	if len(c.Accounts) == 0 {
+		return []accountConfig{{}}
+	}
+	return c.Accounts
+}
+
+// Функция для заполнения незаданных настроек счета общими настройками. HTTP управление
+// аварийной остановкой из общих настроек достается первому счету
+func (c *botConfig) resolveAccount(account accountConfig, accessToken string, first bool) accountConfig {
+	if account.Broker == "" {
+		account.Broker = c.Broker
+	}
+	if account.AccessToken == "" {
+		account.AccessToken = accessToken
+	}
+	if account.ClientCode == "" {
+		account.ClientCode = c.ClientCode
+	}
+	if account.PaperCapital == 0 {
+		account.PaperCapital = c.PaperCapital
+	}
+	if account.Risk == nil {
+		risk := c.Risk
+		account.Risk = &risk
+	}
+	if account.Risk.LotSizes == nil {
+		account.Risk.LotSizes = c.LotSizes
+	}
+	if account.Risk.DailyLossStatePath == "" {
+		account.Risk.DailyLossStatePath = c.Risk.DailyLossStatePath
+	}
+	if account.OrderQuantity == 0 {
+		account.OrderQuantity = c.OrderQuantity
+	}
+	if account.KillSwitchHTTPAddr == "" && first {
+		account.KillSwitchHTTPAddr = c.KillSwitch.HTTPAddr
+	}
+	return account
+}
+
+// Функция возвращает путь к файлу состояния счета: имя счета добавляется перед расширением
+// (для счета без имени путь не меняется)
+func accountPath(path, account string) string {
+	if path == "" || account == "" {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + account + ext
+}
+
+// Торговый счет: своя цепочка площадки, лимиты риска, аварийная остановка, менеджер ордеров,
+// учет позиций, журнал, сверка и условные ордера
+type tradingAccount struct {
+	name       string
+	config     accountConfig
+	broker     order.Broker
+	paper      *order.PaperBroker
+	killSwitch *order.KillSwitch
+	manager    *order.OrderManager
+	keeper     *order.PositionKeeper
+	costs      *order.CostModel
+	margin     *order.MarginModel
+	journal    *order.Journal
+	reconciler *order.Reconciler
+	stops      *order.StopManager
+	algos      *order.AlgoExecutor
+	roller     *order.FuturesRoller // Перенос позиций по фьючерсам (nil, если фьючерсы не торгуются)
+	bonds      *order.BondSpecs     // Облигации (nil, если облигации не торгуются)
+
+	pricesMu   sync.RWMutex       // Цены читаются проверками риска из горутин алгоритмов, сверки и аварийной остановки
+	lastPrices map[string]float64 // Последние цены из REST API
+}
+
+// Функция для создания торгового счета по настройкам
+func newTradingAccount(settings *botConfig, account accountConfig, specs *instrumentSpecs, transaqConnector *connector.TransaqConnector) (*tradingAccount, error) {
+	a := &tradingAccount{
+		name:       account.Name,
+		config:     account,
+		bonds:      specs.bonds,
+		lastPrices: make(map[string]float64),
+	}
+
+	broker, paperBroker, err := newBroker(settings, account, specs, transaqConnector)
+	if err != nil {
+		return nil, fmt.Errorf("error creating broker: %w", err)
+	}
+	a.paper = paperBroker
+
+	// Маржинальная модель: у виртуального брокера своя, в бою ставки риска берутся из настроек
+	// и от площадки. Без настроек маржинальной торговли счет наличный
+	if paperBroker != nil {
+		a.margin = paperBroker.MarginModel()
+	} else if a.margin = settings.marginModel(); a.margin == nil {
+		a.margin = order.NewMarginModel(order.CashMarginConfig(), settings.LotSizes)
+	}
+	a.margin.AttachToBroker(broker)
+	if paperBroker == nil {
+		if specs.futures != nil {
+			a.margin.AttachSource(specs.futures)
+		}
+		if specs.bonds != nil {
+			a.margin.AttachBonds(specs.bonds)
+		}
+		a.margin.AttachValuation(specs.valuation)
+	}
+	logger.Logger.Info().Str("account", a.name).Str("broker", broker.Name()).Msg("Execution venue selected")
+
+	// Ограничение частоты ордеров, снятий и изменений и контроль отношения сообщений к сделкам
+	throttledBroker := order.NewThrottledBroker(broker, settings.Throttle)
+	broker = throttledBroker
+
+	// Журнал ордеров и сделок: намерение и ответ площадки сбрасываются на диск до подтверждения
+	a.journal, err = order.OpenJournal(accountPath(settings.JournalPath, a.name), a.name)
+	if err != nil {
+		return nil, fmt.Errorf("error opening order journal: %w", err)
+	}
+	broker = order.NewJournaledBroker(broker, a.journal)
+
+	// Защита от повторной отправки ордеров: клиентские ID и поиск ордера после потери ответа
+	idempotentBroker, err := order.NewIdempotentBroker(broker, order.DefaultIdempotentConfig(accountPath(settings.OrderStorePath, a.name)))
+	if err != nil {
+		return nil, fmt.Errorf("error loading client order IDs: %w", err)
+	}
+	a.journal.AttachToIdempotent(idempotentBroker)
+	if err := idempotentBroker.Recover(); err != nil {
+		logger.Logger.Error().Err(err).Str("account", a.name).Msg("Some in-flight orders are still unresolved.")
+	}
+	broker = idempotentBroker
+
+	// Проверки риска перед отправкой каждого ордера. На маржинальном счете вместо денег
+	// проверяются короткие продажи и свободное обеспечение
+	riskLimits := *account.Risk
+	riskLimits.DailyLossStatePath = accountPath(riskLimits.DailyLossStatePath, a.name)
+	if settings.Margin != nil {
+		riskLimits.CheckCash = false
+	}
+	riskEngine := order.NewRiskEngine(riskLimits, accountPath(settings.RiskAuditPath, a.name))
+	if settings.Margin != nil {
+		riskEngine.AddCheck(&order.MarginCheck{Model: a.margin})
+	}
+	if specs.bonds != nil {
+		riskEngine.AttachBonds(specs.bonds)
+	}
+	riskEngine.AttachValuation(specs.valuation)
+	riskEngine.SetPriceSource(a.quotePrice)
+	riskBroker := order.NewRiskCheckedBroker(broker, riskEngine)
+	broker = riskBroker
+
+	// Аварийная остановка: файл, сигнал SIGUSR1, HTTP, отказ проверки риска или потеря связи.
+	// Файл-триггер общий для всех счетов
+	killSwitchConfig := settings.KillSwitch
+	killSwitchConfig.StatePath = accountPath(killSwitchConfig.StatePath, a.name)
+	killSwitchConfig.AuditPath = accountPath(killSwitchConfig.AuditPath, a.name)
+	killSwitchConfig.HTTPAddr = account.KillSwitchHTTPAddr
+	a.killSwitch, err = order.NewKillSwitch(broker, killSwitchConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error loading kill switch state: %w", err)
+	}
+	a.killSwitch.WatchRiskEngine(riskEngine)
+	transaqConnector.OnDisconnect(func(reason string) {
+		a.killSwitch.Trigger(order.KillSourceDisconnect, reason)
+	})
+	a.killSwitch.Start()
+	broker = a.killSwitch
+	a.broker = broker
+
+	// Менеджер собственных ордеров: состояния ордеров и сделки
+	a.manager = order.NewOrderManager(broker)
+	a.manager.SetPriceSource(a.quotePrice)
+	a.killSwitch.AttachOrderManager(a.manager)
+	riskBroker.AttachOrderManager(a.manager)
+	throttledBroker.AttachToManager(a.manager)
+	a.manager.StartPolling(5 * time.Second)
+
+	// Учет позиций и результата по лотам FIFO. Виртуальный брокер ведет его сам,
+	// в бою он строится по сделкам с момента запуска. Издержки виртуальный брокер начисляет
+	// сам, в бою они оцениваются по тарифам, если площадка не сообщила комиссию
+	a.keeper = order.NewPositionKeeper(settings.LotSizes)
+	a.costs = settings.costModel()
+	if paperBroker != nil {
+		a.keeper = paperBroker.PositionKeeper()
+		a.costs = paperBroker.CostModel()
+	} else {
+		if a.costs == nil {
+			a.costs = order.NewCostModel(order.DefaultCostConfig(), settings.LotSizes)
+		}
+		a.manager.SetCostModel(a.costs)
+		if specs.futures != nil {
+			specs.futures.ApplyTo(a.keeper)
+		}
+		if specs.bonds != nil {
+			specs.bonds.ApplyTo(a.keeper)
+			a.costs.AttachBonds(specs.bonds)
+		}
+		a.keeper.AttachToManager(a.manager)
+		a.keeper.AttachToFeed()
+	}
+
+	// Восстановление ордеров и позиций по журналу. Виртуальный брокер начинает с чистого
+	// состояния, поэтому его прошлые ордера не восстанавливаются
+	if paperBroker == nil {
+		if err := a.journal.Replay(a.manager, a.keeper); err != nil {
+			logger.Logger.Error().Err(err).Str("account", a.name).Msg("Failed to replay order journal.")
+		}
+	}
+	a.journal.AttachToManager(a.manager)
+
+	// Сверка ордеров, позиций и денег с площадкой. Первая сверка заполняет учет позиций
+	reconcileConfig := settings.Reconcile
+	reconcileConfig.ReportPath = accountPath(reconcileConfig.ReportPath, a.name)
+	reconcileConfig.Account = a.name
+	a.reconciler = order.NewReconciler(a.manager, a.keeper, reconcileConfig)
+	a.reconciler.AttachKillSwitch(a.killSwitch)
+	a.reconciler.AttachValuation(specs.valuation)
+	a.reconciler.SubscribePortfolio(riskEngine.ObservePortfolio)
+	if _, err := a.reconciler.Run(); err != nil {
+		logger.Logger.Error().Err(err).Str("account", a.name).Msg("Initial reconciliation failed.")
+	}
+	a.reconciler.Start()
+
+	// Условные ордера (стоп, тейк-профит, bracket, OCO): нативные в Transaq, иначе эмулируются
+	a.stops, err = order.NewStopManager(a.manager, accountPath(settings.StopsStatePath, a.name))
+	if err != nil {
+		return nil, fmt.Errorf("error loading stop orders: %w", err)
+	}
+	a.stops.Recover()
+	a.stops.AttachToFeed()
+
+	// Трейлинг-стоп выставляется на позицию после первой покупки, дальше его количество следует за позицией
+	if settings.TrailingStop != nil {
+		trailing := *settings.TrailingStop
+		a.manager.SubscribeFills(func(fill order.Fill) {
+			if fill.Side != "buy" || len(a.stops.Stops(fill.Symbol)) > 0 {
+				return
+			}
+			if _, err := a.stops.TrailPosition(fill.Symbol, trailing); err != nil {
+				logger.Logger.Error().Err(err).Str("account", a.name).Str("symbol", fill.Symbol).Msg("Failed to place trailing stop")
+			}
+		})
+	}
+
+	// Алгоритмы исполнения крупных ордеров (TWAP, VWAP, айсберг)
+	a.algos = order.NewAlgoExecutor(a.manager)
+	a.algos.AttachToFeed()
+
+	// Перенос позиций по фьючерсам на следующий контракт перед исполнением
+	if specs.futures != nil {
+		rollover := settings.Futures.Rollover
+		rollover.StatePath = accountPath(rollover.StatePath, a.name)
+		a.roller, err = order.NewFuturesRoller(a.manager, a.keeper, specs.futures, rollover)
+		if err != nil {
+			return nil, fmt.Errorf("error loading futures rolls: %w", err)
+		}
+	}
+	return a, nil
+}
+
+// Функция для выплат по облигациям с датой в интервале (from, to]: виртуальный брокер
+// зачисляет купоны и погашение сам, в бою они учитываются в ожидаемых деньгах сверки
+func (a *tradingAccount) payCoupons(from, to time.Time) {
+	if a.paper != nil {
+		a.paper.PayCoupons(from, to)
+		return
+	}
+	a.reconciler.ApplyCouponPayments(order.PayBondCashflows(a.keeper, a.bonds, from, to))
+}
+
+// Функция для клиринга позиций по фьючерсам: виртуальный брокер зачисляет вариационную
+// маржу сам, в бою она учитывается в ожидаемых деньгах сверки
+func (a *tradingAccount) clearFutures(now time.Time) {
+	var margins []order.VariationMargin
+	if a.paper != nil {
+		margins = a.paper.Clear(now)
+	} else {
+		margins = order.SettleFutures(a.keeper, now)
+		a.reconciler.ApplyVariationMargin(margins)
+	}
+	for _, margin := range margins {
+		logger.Logger.Info().
+			Str("account", a.name).
+			Str("symbol", margin.Symbol).
+			Float64("price", margin.Price).
+			Float64("amount", margin.Amount).
+			Msg("Variation margin settled")
+	}
+}
+
+// Функция для остановки фоновых задач счета
+func (a *tradingAccount) close() {
+	a.reconciler.Stop()
+	a.manager.Stop()
+	a.killSwitch.Stop()
+	if err := a.journal.Close(); err != nil {
+		logger.Logger.Error().Err(err).Str("account", a.name).Msg("Failed to close order journal.")
+	}
+}
+
+// Функция возвращает последнюю цену инструмента из REST API
+func (a *tradingAccount) lastPrice(symbol string) (float64, bool) {
+	a.pricesMu.RLock()
+	defer a.pricesMu.RUnlock()
+	price, ok := a.lastPrices[symbol]
+	return price, ok
+}
+
+// Функция возвращает последнюю цену инструмента: из потока котировок, а при его отсутствии - из REST API
+func (a *tradingAccount) quotePrice(symbol string) (float64, bool) {
+	if quote, ok := data.LastQuote(symbol); ok && quote.Price > 0 {
+		return quote.Price, true
+	}
+	return a.lastPrice(symbol)
+}
+
+// Функция для обработки котировки: цена виртуального брокера, условные ордера и переоценка позиций
+func (a *tradingAccount) onQuote(quote data.Quote) {
+	//  Снимок котировки из REST API обновляет цену виртуального брокера
+	//  (исполнение лимитных ордеров идет по потоку котировок из пакета data)
+	if a.paper != nil {
+		a.paper.UpdatePrice(quote.Symbol, quote.Price, quote.Time)
+	}
+	a.pricesMu.Lock()
+	a.lastPrices[quote.Symbol] = quote.Price
+	a.pricesMu.Unlock()
+	a.stops.OnQuote(quote)
+	a.keeper.Mark(quote.Symbol, quote.Price)
+
+	pnl := a.keeper.Totals()
+	logger.Logger.Debug().
+		Str("account", a.name).
+		Float64("realized", pnl.RealizedPnL).
+		Float64("unrealized", pnl.UnrealizedPnL).
+		Float64("commission", pnl.Commission).
+		Float64("slippage", pnl.Slippage).
+		Float64("net", pnl.NetPnL).
+		Msg("PnL")
+}
+
+// Функция проверяет, торгует ли стратегия на счете
+func (a *tradingAccount) runsStrategy(name string) bool {
+	if len(a.config.Strategies) == 0 {
+		return true
+	}
+	for _, assigned := range a.config.Strategies {
+		if assigned == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Функция для исполнения сигналов стратегии на счете
+func (a *tradingAccount) handleSignals(signals []strategy.TradingSignal, strategyName string, settings *botConfig, volumeProfile *order.VolumeProfile) {
+	// 7. Логика принятия  решений  и  отправки  ордеров
+	for _, signal := range signals {
+		// 7.1. Реализация  логики  принятия  решений
+
+		//  Достаточность  средств  и  лимиты  позиции  проверяет  RiskEngine  при  отправке  ордера
+
+		//  Пока  по  инструменту  есть  открытые  ордера,  новые  не  выставляем
+		if len(a.manager.OpenOrders(signal.Symbol)) > 0 || len(a.algos.Active(signal.Symbol)) > 0 {
+			logger.Logger.Warn().
+				Str("account", a.name).
+				Str("symbol", signal.Symbol).
+				Msg("По инструменту есть открытые ордера")
+			continue
+		}
+
+		// 7.2. Создание  и  отправка  ордера
+		orderRequest := &order.OrderRequest{
+			Symbol:     signal.Symbol,
+			Side:       signal.Side,
+			Quantity:   a.config.OrderQuantity,
+			OrderType:  "market", //  Пример:  рыночный  ордер
+			ClientCode: a.config.ClientCode,
+			AccountID:  a.config.AccountID,
+			Strategy:   strategyName,
+		}
+		if signal.Side == "buy" {
+			//  Крупный  ордер  исполняется  алгоритмом  по  частям  (позицию  защищает  трейлинг-стоп)
+			if settings.Execution != nil && orderRequest.Quantity > 1 {
+				if _, err := a.algos.Start(orderRequest, settings.Execution.algoConfig(signal.Side, signal.Price, volumeProfile)); err != nil {
+					logger.Logger.Error().Err(err).Str("account", a.name).Msg("Error starting buy algo order.")
+				}
+				continue
+			}
+			//  Вход  с  защитными  ордерами  Stop-Loss  и  Take-Profit  (bracket).
+			//  С  трейлинг-стопом  позицию  защищает  он,  без  фиксированных  ног
+			stopLoss, takeProfit := signal.StopLoss, signal.TakeProfit
+			if settings.TrailingStop != nil {
+				stopLoss, takeProfit = 0, 0
+			}
+			if _, _, err := a.stops.PlaceBracket(orderRequest, stopLoss, takeProfit); err != nil {
+				logger.Logger.Error().Err(err).Str("account", a.name).Msg("Error creating buy order.")
+			}
+		} else if signal.Side == "sell" {
+			//  Защитные  ордера  по  инструменту  больше  не  нужны
+			if err := a.stops.CancelAll(signal.Symbol); err != nil {
+				logger.Logger.Error().Err(err).Str("account", a.name).Msg("Error cancelling stop orders.")
+			}
+			if settings.Execution != nil && orderRequest.Quantity > 1 {
+				if _, err := a.algos.Start(orderRequest, settings.Execution.algoConfig(signal.Side, signal.Price, volumeProfile)); err != nil {
+					logger.Logger.Error().Err(err).Str("account", a.name).Msg("Error starting sell algo order.")
+				}
+				continue
+			}
+			if _, err := a.manager.PlaceOrder(orderRequest); err != nil {
+				logger.Logger.Error().Err(err).Str("account", a.name).Msg("Error  creating  sell  order.")
+			}
+		}
+	}
+}
+
+// Функция для создания брокера счета. Для виртуального брокера также возвращается
+// *order.PaperBroker (для обновления цен)
+func newBroker(config *botConfig, account accountConfig, specs *instrumentSpecs, transaqConnector *connector.TransaqConnector) (order.Broker, *order.PaperBroker, error) {
+	switch account.Broker {
+	case "finam":
+		return order.NewFinamAccountBroker(account.AccessToken, account.AccountID), nil, nil
+	case "transaq":
+		transaqBroker := connector.NewTransaqBroker(transaqConnector, account.ClientCode, config.Board)
+		if config.Margin != nil {
+			if err := transaqBroker.RequestMarginParams(); err != nil {
+				logger.Logger.Error().Err(err).Str("account", account.Name).Msg("Failed to request margin params, using configured rates.")
+			}
+		}
+		if specs.futures != nil {
+			board := config.Futures.Board
+			if board == "" {
+				board = "FUT"
+			}
+			for _, contract := range specs.futures.Contracts() {
+				transaqBroker.SetSymbolBoard(contract.Symbol, board)
+			}
+		}
+		if specs.bonds != nil {
+			for _, bond := range specs.bonds.Bonds() {
+				board, ok := config.Bonds.Boards[bond.Symbol]
+				if !ok {
+					board = "TQOB"
+				}
+				transaqBroker.SetSymbolBoard(bond.Symbol, board)
+			}
+		}
+		return transaqBroker, nil, nil
+	case "paper":
+		paperBroker := order.NewPaperBroker(&order.PaperConfig{
+			InitialBalances: map[string]order.Balance{
+				specs.valuation.Base(): {Value: account.PaperCapital, Available: account.PaperCapital},
+			},
+			SlippagePercent:   config.PaperSlippage,
+			CommissionPercent: config.PaperCommission,
+			LotSizes:          config.LotSizes,
+			MaxBookAge:        time.Minute,
+			Costs:             config.costModel(),
+			Margin:            config.marginModel(),
+			Futures:           specs.futures,
+			Bonds:             specs.bonds,
+			Valuation:         specs.valuation,
+		})
+		paperBroker.AttachToFeed()
+		return paperBroker, paperBroker, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown broker: %q", account.Broker)
+	}
+}
+
+// Функция для создания счетов по настройкам
+func newTradingAccounts(settings *botConfig, specs *instrumentSpecs, finamConfig *api.FinamConfig, transaqConnector *connector.TransaqConnector) ([]*tradingAccount, error) {
+	var accounts []*tradingAccount
+	names := make(map[string]bool)
+	for i, accountSettings := range settings.accountConfigs() {
+		if names[accountSettings.Name] {
+			return accounts, fmt.Errorf("duplicate account name %q", accountSettings.Name)
+		}
+		names[accountSettings.Name] = true
+
+		account, err := newTradingAccount(settings, settings.resolveAccount(accountSettings, finamConfig.AccessToken, i == 0), specs, transaqConnector)
+		if err != nil {
+			return accounts, fmt.Errorf("account %q: %w", accountSettings.Name, err)
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"trading-bot/api"
+	"trading-bot/connector"
+	"trading-bot/order"
+)
+
+func TestResolveAccountInheritsGlobalSettings(t *testing.T) {
+	settings := &botConfig{
+		Broker:        "transaq",
+		ClientCode:    "C1",
+		PaperCapital:  1000000,
+		LotSizes:      map[string]int{"SBER": 10},
+		OrderQuantity: 2,
+		Risk:          order.RiskLimits{MaxPositionLots: 5, LotSizes: map[string]int{"SBER": 10}, DailyLossStatePath: "state/daily_loss.json"},
+		KillSwitch:    order.KillSwitchConfig{HTTPAddr: "127.0.0.1:8090"},
+	}
+
+	first := settings.resolveAccount(accountConfig{Name: "main"}, "token", true)
+	if first.Broker != "transaq" || first.ClientCode != "C1" || first.AccessToken != "token" || first.OrderQuantity != 2 {
+		t.Errorf("first account = %+v, want global broker, client code, token and quantity", first)
+	}
+	if first.KillSwitchHTTPAddr != "127.0.0.1:8090" {
+		t.Errorf("first account kill switch addr = %q, want global", first.KillSwitchHTTPAddr)
+	}
+	// Лимиты копируются: изменение лимитов счета не меняет общие
+	first.Risk.MaxPositionLots = 1
+	if settings.Risk.MaxPositionLots != 5 {
+		t.Errorf("global risk limits changed through account: %+v", settings.Risk)
+	}
+
+	second := settings.resolveAccount(accountConfig{
+		Name:         "hedge",
+		Broker:       "paper",
+		PaperCapital: 250000,
+		Risk:         &order.RiskLimits{MaxPositionLots: 3},
+	}, "token", false)
+	if second.Broker != "paper" || second.PaperCapital != 250000 || second.Risk.MaxPositionLots != 3 {
+		t.Errorf("second account = %+v, want own broker, capital and limits", second)
+	}
+	if second.Risk.LotSizes["SBER"] != 10 || second.Risk.DailyLossStatePath != "state/daily_loss.json" {
+		t.Errorf("second account risk = %+v, want global lot sizes and daily loss state path", second.Risk)
+	}
+	if second.KillSwitchHTTPAddr != "" {
+		t.Errorf("second account kill switch addr = %q, want none (port belongs to the first account)", second.KillSwitchHTTPAddr)
+	}
+
+	for path, want := range map[string]string{
+		"state/kill_switch.json":    "state/kill_switch.hedge.json",
+		"state/order_journal.jsonl": "state/order_journal.hedge.jsonl",
+		"state/KILL":                "state/KILL.hedge",
+		"":                          "",
+	} {
+		if got := accountPath(path, "hedge"); got != want {
+			t.Errorf("accountPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+	if got := accountPath("state/kill_switch.json", ""); got != "state/kill_switch.json" {
+		t.Errorf("accountPath without account name = %q, want unchanged", got)
+	}
+}
+
+func TestNewTradingAccountsKeepsAccountsApart(t *testing.T) {
+	dir := t.TempDir()
+	settings := &botConfig{
+		Broker:          "paper",
+		PaperCapital:    1000000,
+		PaperCommission: 0.05,
+		LotSizes:        map[string]int{"SBER": 10},
+		OrderQuantity:   1,
+		OrderStorePath:  filepath.Join(dir, "client_orders.json"),
+		Risk:            order.RiskLimits{MaxPositionLots: 10, DailyLossStatePath: filepath.Join(dir, "daily_loss.json")},
+		RiskAuditPath:   filepath.Join(dir, "risk_audit.jsonl"),
+		StopsStatePath:  filepath.Join(dir, "stop_orders.json"),
+		JournalPath:     filepath.Join(dir, "order_journal.jsonl"),
+		Throttle:        order.DefaultThrottleConfig(),
+		Reconcile:       order.DefaultReconcileConfig(),
+		KillSwitch: order.KillSwitchConfig{
+			StatePath: filepath.Join(dir, "kill_switch.json"),
+			AuditPath: filepath.Join(dir, "kill_switch_audit.jsonl"),
+		},
+		Accounts: []accountConfig{
+			{Name: "main", Strategies: []string{"ml"}},
+			{Name: "hedge", PaperCapital: 500000},
+		},
+	}
+	specs := &instrumentSpecs{valuation: order.NewValuation("", nil)}
+	transaqConnector, _ := connector.NewTransaqConnector(&connector.TransaqConfig{})
+
+	accounts, err := newTradingAccounts(settings, specs, &api.FinamConfig{}, transaqConnector)
+	for _, account := range accounts {
+		defer account.close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 2 {
+		t.Fatalf("got %d accounts, want 2", len(accounts))
+	}
+	primary, hedge := accounts[0], accounts[1]
+
+	if primary.paper == hedge.paper || primary.manager == hedge.manager || primary.killSwitch == hedge.killSwitch {
+		t.Fatal("accounts share a broker chain")
+	}
+	portfolio, err := hedge.broker.GetPortfolioInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cash := portfolio.Balances["RUB"].Value; cash != 500000 {
+		t.Errorf("hedge paper capital = %v, want own 500000", cash)
+	}
+	if !primary.runsStrategy("ml") || primary.runsStrategy("breakout") || !hedge.runsStrategy("breakout") {
+		t.Error("strategies are not assigned per account")
+	}
+
+	// Файлы состояния у каждого счета свои
+	for _, name := range []string{"order_journal.main.jsonl", "order_journal.hedge.jsonl"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("journal file: %v", err)
+		}
+	}
+
+	// Аварийная остановка одного счета не блокирует другой
+	primary.killSwitch.Trigger(order.KillSourceManual, "test")
+	if !primary.killSwitch.IsActive() || hedge.killSwitch.IsActive() {
+		t.Fatalf("kill switch active: main %v, hedge %v", primary.killSwitch.IsActive(), hedge.killSwitch.IsActive())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "kill_switch.main.json")); err != nil {
+		t.Errorf("main kill switch state: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "kill_switch.hedge.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("hedge kill switch state written: %v", err)
+	}
+
+	// Имена счетов должны различаться: от них зависят пути файлов состояния
+	settings.Accounts = append(settings.Accounts, accountConfig{Name: "main"})
+	duplicated, err := newTradingAccounts(settings, specs, &api.FinamConfig{}, transaqConnector)
+	for _, account := range duplicated {
+		account.close()
+	}
+	if err == nil {
+		t.Error("duplicate account names accepted")
+	}
+}
@@ -0,0 +1,398 @@
+package archive
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"trading-bot/data"
+	"trading-bot/logger"
+
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/apache/arrow/go/arrow/memory"
+)
+
+// Расширение файлов архива (формат Arrow IPC / Feather v2)
+const partitionExt = ".arrow"
+
+// Схема архива свечей: время в наносекундах Unix и цены/объем
+var candleSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "time", Type: arrow.PrimitiveTypes.Int64},
+	{Name: "open", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "high", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "low", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "close", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "volume", Type: arrow.PrimitiveTypes.Float64},
+}, nil)
+
+// Схема архива тиков
+var tickSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "time", Type: arrow.PrimitiveTypes.Int64},
+	{Name: "price", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "volume", Type: arrow.PrimitiveTypes.Int64},
+}, nil)
+
+// Структура для колоночного архива рыночных данных.
+// Файлы раскладываются по каталогам:
+//
+//	<root>/candles/<interval>/<symbol>/<partition>.arrow
+//	<root>/ticks/<symbol>/<YYYY-MM-DD>.arrow
+//
+// Внутридневные свечи и тики разбиваются по дням, дневные и более крупные - по годам.
+type Archive struct {
+	root string
+	mem  memory.Allocator
+}
+
+// Функция для создания (открытия) архива в каталоге root
+func NewArchive(root string) (*Archive, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("error creating archive directory: %w", err)
+	}
+	return &Archive{
+		root: root,
+		mem:  memory.NewGoAllocator(),
+	}, nil
+}
+
+// Функция для записи свечей в архив. Свечи объединяются с уже сохраненными
+// (при совпадении времени новая свеча заменяет старую)
+func (a *Archive) WriteCandles(candles *data.CandleSeries) error {
+	daily := isDailyInterval(candles.Interval)
+	dir := a.candleDir(candles.Symbol, candles.Interval)
+
+	partitions := make(map[string][]data.Candle)
+	for _, c := range candles.Candles {
+		key := partitionKey(c.Time, daily)
+		partitions[key] = append(partitions[key], c)
+	}
+
+	for key, newCandles := range partitions {
+		path := filepath.Join(dir, key+partitionExt)
+
+		existing, err := a.readCandlePartition(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		merged := mergeCandles(existing, newCandles)
+		if err := a.writeCandlePartition(path, merged); err != nil {
+			return err
+		}
+	}
+
+	logger.Logger.Info().
+		Str("symbol", candles.Symbol).
+		Str("interval", candles.Interval).
+		Int("candles", len(candles.Candles)).
+		Int("partitions", len(partitions)).
+		Msg("Candles written to archive")
+
+	return nil
+}
+
+// Функция для чтения свечей из архива за интервал [from, to]
+func (a *Archive) ReadCandles(symbol string, interval string, from, to time.Time) (*data.CandleSeries, error) {
+	daily := isDailyInterval(interval)
+	paths, err := partitionsInRange(a.candleDir(symbol, interval), from, to, daily)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &data.CandleSeries{Symbol: symbol, Interval: interval}
+	for _, path := range paths {
+		candles, err := a.readCandlePartition(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range candles {
+			if !c.Time.Before(from) && !c.Time.After(to) {
+				result.Candles = append(result.Candles, c)
+			}
+		}
+	}
+
+	logger.Logger.Info().
+		Str("symbol", symbol).
+		Str("interval", interval).
+		Int("partitions", len(paths)).
+		Int("candles", len(result.Candles)).
+		Msg("Candles read from archive")
+
+	return result, nil
+}
+
+// Функция для записи тиков (котировок) в архив
+func (a *Archive) WriteTicks(symbol string, ticks []data.Quote) error {
+	dir := a.tickDir(symbol)
+
+	partitions := make(map[string][]data.Quote)
+	for _, tick := range ticks {
+		key := partitionKey(tick.Time, false)
+		partitions[key] = append(partitions[key], tick)
+	}
+
+	for key, newTicks := range partitions {
+		path := filepath.Join(dir, key+partitionExt)
+
+		existing, err := a.readTickPartition(path, symbol)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		// Тики не схлопываются по времени: в одну наносекунду может пройти несколько сделок
+		merged := append(existing, newTicks...)
+		sort.SliceStable(merged, func(i, j int) bool { return merged[i].Time.Before(merged[j].Time) })
+
+		if err := a.writeTickPartition(path, merged); err != nil {
+			return err
+		}
+	}
+
+	logger.Logger.Info().
+		Str("symbol", symbol).
+		Int("ticks", len(ticks)).
+		Int("partitions", len(partitions)).
+		Msg("Ticks written to archive")
+
+	return nil
+}
+
+// Функция для чтения тиков из архива за интервал [from, to]
+func (a *Archive) ReadTicks(symbol string, from, to time.Time) ([]data.Quote, error) {
+	paths, err := partitionsInRange(a.tickDir(symbol), from, to, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []data.Quote
+	for _, path := range paths {
+		ticks, err := a.readTickPartition(path, symbol)
+		if err != nil {
+			return nil, err
+		}
+		for _, tick := range ticks {
+			if !tick.Time.Before(from) && !tick.Time.After(to) {
+				result = append(result, tick)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (a *Archive) candleDir(symbol, interval string) string {
+	return filepath.Join(a.root, "candles", interval, symbol)
+}
+
+func (a *Archive) tickDir(symbol string) string {
+	return filepath.Join(a.root, "ticks", symbol)
+}
+
+// Функция для записи свечей одного раздела в файл
+func (a *Archive) writeCandlePartition(path string, candles []data.Candle) error {
+	builder := array.NewRecordBuilder(a.mem, candleSchema)
+	defer builder.Release()
+
+	for _, c := range candles {
+		builder.Field(0).(*array.Int64Builder).Append(c.Time.UnixNano())
+		builder.Field(1).(*array.Float64Builder).Append(c.Open)
+		builder.Field(2).(*array.Float64Builder).Append(c.High)
+		builder.Field(3).(*array.Float64Builder).Append(c.Low)
+		builder.Field(4).(*array.Float64Builder).Append(c.Close)
+		builder.Field(5).(*array.Float64Builder).Append(c.Volume)
+	}
+
+	return a.writeRecord(path, candleSchema, builder.NewRecord())
+}
+
+// Функция для записи тиков одного раздела в файл
+func (a *Archive) writeTickPartition(path string, ticks []data.Quote) error {
+	builder := array.NewRecordBuilder(a.mem, tickSchema)
+	defer builder.Release()
+
+	for _, tick := range ticks {
+		builder.Field(0).(*array.Int64Builder).Append(tick.Time.UnixNano())
+		builder.Field(1).(*array.Float64Builder).Append(tick.Price)
+		builder.Field(2).(*array.Int64Builder).Append(tick.Volume)
+	}
+
+	return a.writeRecord(path, tickSchema, builder.NewRecord())
+}
+
+// Функция для атомарной записи файла раздела: запись во временный файл и переименование
+func (a *Archive) writeRecord(path string, schema *arrow.Schema, record array.Record) error {
+	defer record.Release()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating partition directory: %w", err)
+	}
+
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("error creating partition file: %w", err)
+	}
+
+	writer, err := ipc.NewFileWriter(file, ipc.WithSchema(schema), ipc.WithAllocator(a.mem))
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error creating arrow writer: %w", err)
+	}
+	if err := writer.Write(record); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing arrow record: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		file.Close()
+		return fmt.Errorf("error closing arrow writer: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("error syncing partition file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error closing partition file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("error renaming partition file: %w", err)
+	}
+	return nil
+}
+
+// Функция для чтения свечей одного раздела (через отображение файла в память)
+func (a *Archive) readCandlePartition(path string) ([]data.Candle, error) {
+	var candles []data.Candle
+	err := a.readRecords(path, candleSchema, func(record array.Record) {
+		times := record.Column(0).(*array.Int64).Int64Values()
+		opens := record.Column(1).(*array.Float64).Float64Values()
+		highs := record.Column(2).(*array.Float64).Float64Values()
+		lows := record.Column(3).(*array.Float64).Float64Values()
+		closes := record.Column(4).(*array.Float64).Float64Values()
+		volumes := record.Column(5).(*array.Float64).Float64Values()
+
+		for i := range times {
+			candles = append(candles, data.Candle{
+				Time:   time.Unix(0, times[i]).In(data.ExchangeLocation),
+				Open:   opens[i],
+				High:   highs[i],
+				Low:    lows[i],
+				Close:  closes[i],
+				Volume: volumes[i],
+			})
+		}
+	})
+	return candles, err
+}
+
+// Функция для чтения тиков одного раздела
+func (a *Archive) readTickPartition(path string, symbol string) ([]data.Quote, error) {
+	var ticks []data.Quote
+	err := a.readRecords(path, tickSchema, func(record array.Record) {
+		times := record.Column(0).(*array.Int64).Int64Values()
+		prices := record.Column(1).(*array.Float64).Float64Values()
+		volumes := record.Column(2).(*array.Int64).Int64Values()
+
+		for i := range times {
+			ticks = append(ticks, data.Quote{
+				Symbol: symbol,
+				Price:  prices[i],
+				Volume: volumes[i],
+				Time:   time.Unix(0, times[i]).In(data.ExchangeLocation),
+			})
+		}
+	})
+	return ticks, err
+}
+
+// Функция для чтения всех записей файла. Данные копируются в fn до освобождения отображения
+func (a *Archive) readRecords(path string, schema *arrow.Schema, fn func(record array.Record)) error {
+	mapped, err := mapFile(path)
+	if err != nil {
+		return err
+	}
+	defer mapped.Close()
+
+	reader, err := ipc.NewFileReader(mapped.Reader(), ipc.WithSchema(schema), ipc.WithAllocator(a.mem))
+	if err != nil {
+		return fmt.Errorf("error opening arrow file %s: %w", path, err)
+	}
+	defer reader.Close()
+
+	for i := 0; i < reader.NumRecords(); i++ {
+		record, err := reader.RecordAt(i)
+		if err != nil {
+			return fmt.Errorf("error reading arrow record from %s: %w", path, err)
+		}
+		fn(record)
+		record.Release()
+	}
+	return nil
+}
+
+// Функция объединяет свечи, заменяя старые новыми при совпадении времени
+func mergeCandles(existing, newCandles []data.Candle) []data.Candle {
+	byTime := make(map[int64]data.Candle, len(existing)+len(newCandles))
+	for _, c := range existing {
+		byTime[c.Time.UnixNano()] = c
+	}
+	for _, c := range newCandles {
+		byTime[c.Time.UnixNano()] = c
+	}
+
+	merged := make([]data.Candle, 0, len(byTime))
+	for _, c := range byTime {
+		merged = append(merged, c)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Time.Before(merged[j].Time) })
+	return merged
+}
+
+// Функция возвращает ключ раздела для времени (по времени биржи)
+func partitionKey(t time.Time, daily bool) string {
+	t = t.In(data.ExchangeLocation)
+	if daily {
+		return t.Format("2006")
+	}
+	return t.Format("2006-01-02")
+}
+
+// Функция возвращает отсортированный список файлов разделов, пересекающихся с [from, to]
+func partitionsInRange(dir string, from, to time.Time, daily bool) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error listing archive directory: %w", err)
+	}
+
+	fromKey := partitionKey(from, daily)
+	toKey := partitionKey(to, daily)
+
+	var paths []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, partitionExt) {
+			continue
+		}
+		// Ключи разделов сравниваются лексикографически (YYYY или YYYY-MM-DD)
+		key := strings.TrimSuffix(name, partitionExt)
+		if key >= fromKey && key <= toKey {
+			paths = append(paths, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// Функция определяет, разбиваются ли свечи интервала по годам
+func isDailyInterval(interval string) bool {
+	duration, err := data.ParseInterval(interval)
+	return err == nil && duration >= 24*time.Hour
+}
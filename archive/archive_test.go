@@ -0,0 +1,193 @@
+package archive
+
+import (
+	"testing"
+	"time"
+
+	"trading-bot/data"
+)
+
+func candle(t time.Time, close float64) data.Candle {
+	return data.Candle{Time: t, Open: close - 1, High: close + 1, Low: close - 2, Close: close, Volume: 100}
+}
+
+func TestCandlesRoundTrip(t *testing.T) {
+	at := func(year int, month time.Month, day, hour int) time.Time {
+		return time.Date(year, month, day, hour, 0, 0, 0, data.ExchangeLocation)
+	}
+
+	tests := []struct {
+		name     string
+		interval string
+		writes   [][]data.Candle
+		from, to time.Time
+		want     []data.Candle
+	}{
+		{
+			name:     "daily candles across years",
+			interval: "1d",
+			writes:   [][]data.Candle{{candle(at(2023, 12, 29, 0), 1), candle(at(2024, 1, 3, 0), 2)}},
+			from:     at(2023, 1, 1, 0),
+			to:       at(2024, 12, 31, 0),
+			want:     []data.Candle{candle(at(2023, 12, 29, 0), 1), candle(at(2024, 1, 3, 0), 2)},
+		},
+		{
+			name:     "intraday candles across days",
+			interval: "1h",
+			writes:   [][]data.Candle{{candle(at(2024, 7, 1, 10), 1), candle(at(2024, 7, 1, 11), 2), candle(at(2024, 7, 2, 10), 3)}},
+			from:     at(2024, 7, 1, 0),
+			to:       at(2024, 7, 3, 0),
+			want:     []data.Candle{candle(at(2024, 7, 1, 10), 1), candle(at(2024, 7, 1, 11), 2), candle(at(2024, 7, 2, 10), 3)},
+		},
+		{
+			name:     "range is inclusive and filters partitions",
+			interval: "1h",
+			writes:   [][]data.Candle{{candle(at(2024, 7, 1, 10), 1), candle(at(2024, 7, 1, 11), 2), candle(at(2024, 7, 2, 10), 3)}},
+			from:     at(2024, 7, 1, 11),
+			to:       at(2024, 7, 1, 23),
+			want:     []data.Candle{candle(at(2024, 7, 1, 11), 2)},
+		},
+		{
+			name:     "later write replaces candle with same time and keeps others",
+			interval: "1d",
+			writes: [][]data.Candle{
+				{candle(at(2024, 7, 1, 0), 1), candle(at(2024, 7, 2, 0), 2)},
+				{candle(at(2024, 7, 2, 0), 5), candle(at(2024, 7, 3, 0), 3)},
+			},
+			from: at(2024, 1, 1, 0),
+			to:   at(2024, 12, 31, 0),
+			want: []data.Candle{candle(at(2024, 7, 1, 0), 1), candle(at(2024, 7, 2, 0), 5), candle(at(2024, 7, 3, 0), 3)},
+		},
+		{
+			name:     "empty archive",
+			interval: "1d",
+			from:     at(2024, 1, 1, 0),
+			to:       at(2024, 12, 31, 0),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive, err := NewArchive(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, candles := range tt.writes {
+				if err := archive.WriteCandles(&data.CandleSeries{Symbol: "SBER", Interval: tt.interval, Candles: candles}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			series, err := archive.ReadCandles("SBER", tt.interval, tt.from, tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(series.Candles) != len(tt.want) {
+				t.Fatalf("read %d candles, want %d: %v", len(series.Candles), len(tt.want), series.Candles)
+			}
+			for i, want := range tt.want {
+				got := series.Candles[i]
+				if !got.Time.Equal(want.Time) || got.Open != want.Open || got.High != want.High ||
+					got.Low != want.Low || got.Close != want.Close || got.Volume != want.Volume {
+					t.Errorf("candle %d = %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTicksRoundTrip(t *testing.T) {
+	at := func(day, second int) time.Time {
+		return time.Date(2024, time.July, day, 10, 0, second, 123, data.ExchangeLocation)
+	}
+	tick := func(t time.Time, price float64, volume int64) data.Quote {
+		return data.Quote{Symbol: "SBER", Price: price, Volume: volume, Time: t}
+	}
+
+	tests := []struct {
+		name     string
+		writes   [][]data.Quote
+		from, to time.Time
+		want     []data.Quote
+	}{
+		{
+			name:   "ticks across days",
+			writes: [][]data.Quote{{tick(at(1, 0), 100, 1), tick(at(2, 0), 101, 2)}},
+			from:   at(1, 0),
+			to:     at(2, 0),
+			want:   []data.Quote{tick(at(1, 0), 100, 1), tick(at(2, 0), 101, 2)},
+		},
+		{
+			name:   "ticks with same time are kept",
+			writes: [][]data.Quote{{tick(at(1, 0), 100, 1)}, {tick(at(1, 0), 100.5, 3)}},
+			from:   at(1, 0),
+			to:     at(1, 59),
+			want:   []data.Quote{tick(at(1, 0), 100, 1), tick(at(1, 0), 100.5, 3)},
+		},
+		{
+			name:   "appended ticks are sorted by time",
+			writes: [][]data.Quote{{tick(at(1, 5), 101, 1)}, {tick(at(1, 1), 100, 2)}},
+			from:   at(1, 0),
+			to:     at(1, 59),
+			want:   []data.Quote{tick(at(1, 1), 100, 2), tick(at(1, 5), 101, 1)},
+		},
+		{
+			name:   "range filter",
+			writes: [][]data.Quote{{tick(at(1, 1), 100, 1), tick(at(1, 2), 101, 1), tick(at(1, 3), 102, 1)}},
+			from:   at(1, 2),
+			to:     at(1, 2),
+			want:   []data.Quote{tick(at(1, 2), 101, 1)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive, err := NewArchive(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, ticks := range tt.writes {
+				if err := archive.WriteTicks("SBER", ticks); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ticks, err := archive.ReadTicks("SBER", tt.from, tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ticks) != len(tt.want) {
+				t.Fatalf("read %d ticks, want %d: %v", len(ticks), len(tt.want), ticks)
+			}
+			for i, want := range tt.want {
+				got := ticks[i]
+				if !got.Time.Equal(want.Time) || got.Price != want.Price || got.Volume != want.Volume || got.Symbol != want.Symbol {
+					t.Errorf("tick %d = %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPartitionKey(t *testing.T) {
+	// Полночь по Москве - это еще предыдущий день по UTC
+	moscowMidnight := time.Date(2024, time.January, 1, 0, 30, 0, 0, data.ExchangeLocation).UTC()
+
+	tests := []struct {
+		name  string
+		t     time.Time
+		daily bool
+		want  string
+	}{
+		{"intraday partition by exchange day", moscowMidnight, false, "2024-01-01"},
+		{"daily partition by exchange year", moscowMidnight, true, "2024"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := partitionKey(tt.t, tt.daily); got != tt.want {
+				t.Errorf("partitionKey = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
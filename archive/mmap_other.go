@@ -0,0 +1,33 @@
+//go:build !unix
+
+package archive
+
+import (
+	"bytes"
+	"os"
+)
+
+// Структура для содержимого файла. На платформах без mmap файл читается целиком
+type mappedFile struct {
+	data []byte
+}
+
+// Функция для чтения файла в память
+func mapFile(path string) (*mappedFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &mappedFile{data: data}, nil
+}
+
+// Функция возвращает Reader по данным файла
+func (m *mappedFile) Reader() *bytes.Reader {
+	return bytes.NewReader(m.data)
+}
+
+// Функция для освобождения данных
+func (m *mappedFile) Close() error {
+	m.data = nil
+	return nil
+}
@@ -0,0 +1,53 @@
+//go:build unix
+
+package archive
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Структура для файла, отображенного в память
+type mappedFile struct {
+	data []byte
+}
+
+// Функция для отображения файла в память только для чтения
+func mapFile(path string) (*mappedFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("error reading partition file info: %w", err)
+	}
+	if info.Size() == 0 {
+		return &mappedFile{}, nil
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("error mapping partition file: %w", err)
+	}
+	return &mappedFile{data: data}, nil
+}
+
+// Функция возвращает Reader по отображенным данным
+func (m *mappedFile) Reader() *bytes.Reader {
+	return bytes.NewReader(m.data)
+}
+
+// Функция для освобождения отображения
+func (m *mappedFile) Close() error {
+	if m.data == nil {
+		return nil
+	}
+	err := syscall.Munmap(m.data)
+	m.data = nil
+	return err
+}
@@ -3,30 +3,35 @@ package backtest
 import (
 	"fmt"
 	"math"
+	"sort"
 	"time"
 
 	"trading-bot/api"
+	"trading-bot/archive"
 	"trading-bot/data"
 	"trading-bot/logger"
 	"trading-bot/order"
 	"trading-bot/strategy"
-
-	"github.com/go-gota/gota/dataframe"
-	"github.com/go-gota/gota/series"
 )
 
 // Структура для хранения результатов бэктеста
 type BacktestResult struct {
-	TotalTrades        int         // Общее количество сделок
-	ProfitableTrades   int         // Количество прибыльных сделок
-	UnprofitableTrades int         // Количество убыточных сделок
-	TotalProfit        float64     // Общая прибыль (в рублях)
-	AverageProfit      float64     // Средняя прибыль/убыток по сделкам
-	MaxDrawdown        float64     // Максимальная просадка (в процентах)
-	SharpeRatio        float64     // Коэффициент Шарпа
-	StartDate          time.Time   // Дата начала бэктеста
-	EndDate            time.Time   // Дата окончания бэктеста
-	TradingLog         []TradeInfo // Лог сделок
+	TotalTrades        int                 // Общее количество сделок
+	ProfitableTrades   int                 // Количество прибыльных сделок
+	UnprofitableTrades int                 // Количество убыточных сделок
+	TotalProfit        float64             // Общая прибыль (в базовой валюте, за вычетом комиссий)
+	TotalCommission    float64             // Комиссии брокера и биржевые сборы
+	TotalSlippage      float64             // Потери на проскальзывании (уже учтены в прибыли)
+	AverageProfit      float64             // Средняя прибыль/убыток по сделкам
+	MaxDrawdown        float64             // Максимальная просадка (в процентах)
+	SharpeRatio        float64             // Коэффициент Шарпа
+	MarginCalls        int                 // Сколько раз стоимость портфеля опускалась ниже минимальной маржи
+	VariationMargin    float64             // Сумма вариационной маржи по фьючерсам (уже в прибыли)
+	CouponIncome       float64             // Купоны по облигациям (уже в прибыли)
+	Rolls              []data.ContractRoll // Переходы непрерывного ряда фьючерсов между контрактами
+	StartDate          time.Time           // Дата начала бэктеста
+	EndDate            time.Time           // Дата окончания бэктеста
+	TradingLog         []TradeInfo         // Лог сделок
 }
 
 // Структура для хранения информации о каждой сделке
@@ -40,49 +45,164 @@ type TradeInfo struct {
 	Side       string    `json:"side"`
 }
 
+// Режим учета дивидендов в бэктесте
+type DividendMode string
+
+const (
+	DividendsIgnore DividendMode = "ignore" // Сырые цены, дивиденды не учитываются
+	DividendsAdjust DividendMode = "adjust" // Цены скорректированы назад на дивиденды и сплиты
+	DividendsCash   DividendMode = "cash"   // Цены скорректированы только на сплиты, дивиденды зачисляются деньгами
+)
+
+// Структура для настройки бэктеста
+type BacktestConfig struct {
+	StartDate        time.Time              // Дата начала бэктеста
+	EndDate          time.Time              // Дата окончания бэктеста
+	InitialCapital   float64                // Начальный капитал (в базовой валюте)
+	Currency         string                 // Валюта торгов инструмента (по умолчанию RUB)
+	LotSize          int                    // Размер лота инструмента (по умолчанию 1)
+	BaseCurrency     string                 // Валюта капитала и результатов (по умолчанию RUB)
+	DividendMode     DividendMode           // Режим учета дивидендов
+	CorporateActions []data.CorporateAction // Дивиденды и сплиты (если пусто - корректировка не выполняется)
+	Interval         string                 // Интервал свечей (по умолчанию "1d")
+	Archive          *archive.Archive       // Локальный архив свечей (если nil - данные всегда загружаются с API)
+	Broker           *order.PaperBroker     // Площадка исполнения (если nil - виртуальный брокер с InitialCapital)
+	TrailingStop     *order.TrailingConfig  // Трейлинг-стоп на открытую позицию (если nil - без стопа)
+	Costs            *order.CostConfig      // Тарифы комиссий и проскальзывания (если nil - без издержек)
+	Margin           *order.MarginConfig    // Плечо и короткие продажи (если nil - наличный счет)
+	AllowShort       bool                   // Открывать короткую позицию по сигналу на продажу
+	Futures          *data.FuturesContract  // Спецификация фьючерса (стоимость шага, ГО): клиринг с вариационной маржой
+	FuturesContracts []data.FuturesContract // Контракты для непрерывного ряда (symbol - код базового актива)
+	RollDays         int                    // За сколько рабочих дней до исполнения переходить на следующий контракт
+	Bond             *data.Bond             // Описание облигации (номинал, купоны): цены в процентах, НКД и купоны
+}
+
 // Функция для выполнения бэктеста
 func RunBacktest(finamAPI *api.FinamAPI, symbol string, strategy strategy.Strategy, startDate, endDate time.Time, initialCapital float64) (*BacktestResult, error) {
-	// 1. Загрузка исторических данных
-	historicalData, err := finamAPI.LoadHistoricalData(symbol, startDate, endDate, "1d")
+	return RunBacktestWithConfig(finamAPI, symbol, strategy, &BacktestConfig{
+		StartDate:      startDate,
+		EndDate:        endDate,
+		InitialCapital: initialCapital,
+		DividendMode:   DividendsIgnore,
+	})
+}
+
+// Функция для выполнения бэктеста с дополнительными настройками
+func RunBacktestWithConfig(finamAPI *api.FinamAPI, symbol string, strategy strategy.Strategy, config *BacktestConfig) (*BacktestResult, error) {
+	startDate, endDate, initialCapital := config.StartDate, config.EndDate, config.InitialCapital
+
+	interval := config.Interval
+	if interval == "" {
+		interval = "1d"
+	}
+
+	// 1-2. Загрузка исторических данных в виде ряда свечей (из архива или с API). Для фьючерса
+	// по списку контрактов строится непрерывный ряд
+	var (
+		candles *data.CandleSeries
+		rolls   []data.ContractRoll
+		err     error
+	)
+	if len(config.FuturesContracts) > 0 {
+		candles, rolls, err = loadContinuousCandles(finamAPI, config.Archive, symbol, config.FuturesContracts, interval, startDate, endDate, config.RollDays)
+	} else {
+		candles, err = loadCandles(finamAPI, config.Archive, symbol, interval, startDate, endDate)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("error loading historical data: %w", err)
+		return nil, err
+	}
+	if violations := data.DefaultValidator.ValidateCandles(candles); len(violations) > 0 {
+		logger.Logger.Warn().
+			Str("symbol", symbol).
+			Int("violations", len(violations)).
+			Msg("Historical data has quality issues")
 	}
 
-	// 2. Преобразование FinamData в dataframe.DataFrame
-	var records [][]string
-	for _, candle := range historicalData.C {
-		record := []string{
-			fmt.Sprintf("%f", candle.O),
-			fmt.Sprintf("%f", candle.C),
-			fmt.Sprintf("%f", candle.H),
-			fmt.Sprintf("%f", candle.L),
-			fmt.Sprintf("%f", candle.V),
-			fmt.Sprintf("%d", candle.T),
-		}
-		records = append(records, record)
+	// Корректировка цен на корпоративные действия (исходный ряд остается в rawCandles)
+	rawCandles := candles
+	switch config.DividendMode {
+	case DividendsAdjust:
+		candles = data.AdjustCandleSeries(rawCandles, config.CorporateActions, true)
+	case DividendsCash:
+		candles = data.AdjustCandleSeries(rawCandles, config.CorporateActions, false)
 	}
-	historyDf := dataframe.LoadRecords(records,
-		dataframe.WithTypes(map[string]series.Type{
-			"0": series.Float,
-			"1": series.Float,
-			"2": series.Float,
-			"3": series.Float,
-			"4": series.Float,
-			"5": series.Int,
-		}),
-	)
+	historyDf := candles.ToDataFrame()
 
 	logger.Logger.Info().
 		Str("symbol", symbol).
 		Int("rows", historyDf.Nrow()).
 		Msg("Historical data loaded")
 
-	// 3. Инициализация портфеля
-	portfolio := &order.PortfolioInfo{
-		Balances: map[string]order.Balance{
-			"RUB": {Available: initialCapital}, // Используем initialCapital
-		},
-		Positions: make([]order.Position, 0),
+	// Расчеты по инструменту ведутся в его валюте, капитал и результаты - в базовой. Курсы
+	// валют на каждом баре берутся из исторических свечей инструментов валютного рынка
+	currency := config.Currency
+	if currency == "" {
+		currency = order.DefaultCurrency
+	}
+	fx := data.NewFXRates(nil)
+	valuation := order.NewValuation(config.BaseCurrency, fx)
+	valuation.SetCurrency(symbol, currency)
+	fxCandles, err := loadFXCandles(finamAPI, config.Archive, fx, []string{currency, valuation.Base()}, interval, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	// Суммы до первой свечи курса не учитываются (Valuation предупреждает об этом в журнале),
+	// а новые позиции не открываются: покупательная способность без курса нулевая
+	toBase := func(amount float64, currency string) float64 {
+		value, _ := valuation.ToBase(amount, currency)
+		return value
+	}
+
+	// 3. Инициализация виртуального брокера - площадки исполнения бэктеста
+	broker := config.Broker
+	if broker == nil {
+		var lotSizes map[string]int
+		if config.LotSize > 0 {
+			lotSizes = map[string]int{symbol: config.LotSize}
+		}
+		paperConfig := &order.PaperConfig{
+			InitialBalances: map[string]order.Balance{
+				valuation.Base(): {Value: initialCapital, Available: initialCapital}, // Используем initialCapital
+			},
+			LotSizes:  lotSizes,
+			Valuation: valuation,
+		}
+		if config.Costs != nil {
+			paperConfig.Costs = order.NewCostModel(*config.Costs, lotSizes)
+		}
+		if config.Margin != nil {
+			paperConfig.Margin = order.NewMarginModel(*config.Margin, lotSizes)
+		}
+		if config.Futures != nil {
+			contract := *config.Futures
+			contract.Symbol = symbol
+			paperConfig.Futures = order.NewFuturesSpecs([]data.FuturesContract{contract})
+		}
+		if config.Bond != nil {
+			bond := *config.Bond
+			bond.Symbol = symbol
+			paperConfig.Bonds = order.NewBondSpecs([]data.Bond{bond})
+		}
+		broker = order.NewPaperBroker(paperConfig)
+	}
+	costs := broker.CostModel()
+	margin := broker.MarginModel()
+
+	// Позиции и результат сделок считаются по лотам FIFO тем же учетом, что и в бою и на бумаге.
+	// Результат сделки включает комиссии открытия и закрытия
+	keeper := broker.PositionKeeper()
+	realizedPnL := func(symbol string) float64 {
+		position, _ := keeper.Position(symbol)
+		return position.NetRealizedPnL()
+	}
+
+	// Трейлинг-стопы эмулируются тем же менеджером условных ордеров, что и в бою и на бумаге
+	var stops *order.StopManager
+	if config.TrailingStop != nil {
+		stops, err = order.NewStopManager(order.NewOrderManager(broker), "")
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// 4. Имитация торгов на исторических данных
@@ -94,25 +214,276 @@ func RunBacktest(finamAPI *api.FinamAPI, symbol string, strategy strategy.Strate
 		tradeLog           []TradeInfo
 		maxEquity          float64 = initialCapital
 		maxDrawdown        float64
+		marginCalls        int
+		variationMargin    float64
+		couponIncome       float64
+		marginLevel        = order.MarginOK
 	)
 
+	// Открытие позиции на 1% покупательной способности (с плечом - по маржинальной модели)
+	openPosition := func(symbol, side string, signalPrice, price float64, t time.Time) bool {
+		portfolio, err := broker.GetPortfolioInfo()
+		if err != nil {
+			logger.Logger.Warn().Err(err).Str("symbol", symbol).Msg("Error getting paper portfolio")
+			return false
+		}
+		// Проверка, достаточно ли средств для открытия позиции (цена облигации - процент от номинала)
+		buyingPower := margin.BuyingPower(margin.Status(portfolio), symbol, side, signalPrice)
+		lotValue, err := margin.LotValue(symbol, signalPrice)
+		if err != nil || lotValue <= 0 || buyingPower < lotValue {
+			return false
+		}
+		// Расчет размера позиции (в лотах)
+		positionSizeLots := int(math.Floor((buyingPower * 0.01) / lotValue)) // 0.01 как пример
+		if positionSizeLots == 0 {
+			logger.Logger.Warn().
+				Str("symbol", symbol).
+				Msg("Недостаточно средств для открытия позиции")
+			return false // Недостаточно средств даже для 1 лота
+		}
+
+		// Отправка виртуального ордера
+		if _, err := broker.PlaceOrder(&order.OrderRequest{
+			Symbol:    symbol,
+			Side:      side,
+			Quantity:  positionSizeLots,
+			OrderType: "market",
+		}); err != nil {
+			logger.Logger.Warn().Err(err).Str("symbol", symbol).Str("side", side).Msg("Virtual order rejected")
+			return false
+		}
+		totalTrades++
+
+		tradeSide := "buy"
+		if side == "sell" {
+			tradeSide = "short"
+		}
+		tradeLog = append(tradeLog, TradeInfo{
+			Symbol:    symbol,
+			Side:      tradeSide,
+			OpenPrice: price,
+			OpenTime:  t,
+		})
+		logger.Logger.Debug().
+			Str("symbol", symbol).
+			Str("side", tradeSide).
+			Float64("price", price).
+			Msg("Virtual position opened")
+		return true
+	}
+
+	// Закрытие позиции целиком: продажа длинной или покупка короткой
+	closePosition := func(position order.Position, price float64, t time.Time) bool {
+		if stops != nil {
+			stops.CancelAll(position.Symbol)
+		}
+		side, tradeSide := "sell", "sell"
+		if position.Quantity < 0 {
+			side, tradeSide = "buy", "cover"
+		}
+
+		realizedBefore := realizedPnL(position.Symbol)
+		if _, err := broker.PlaceOrder(&order.OrderRequest{
+			Symbol:    position.Symbol,
+			Side:      side,
+			Quantity:  abs(position.Quantity),
+			OrderType: "market",
+		}); err != nil {
+			logger.Logger.Warn().Err(err).Str("symbol", position.Symbol).Str("side", side).Msg("Virtual close order rejected")
+			return false
+		}
+		totalTrades++
+		profit := toBase(realizedPnL(position.Symbol)-realizedBefore, currency)
+
+		// Обновляем totalProfit и счетчики сделок
+		totalProfit += profit
+		if profit > 0 {
+			profitableTrades++
+		} else {
+			unprofitableTrades++
+		}
+
+		tradeLog = append(tradeLog, TradeInfo{
+			Symbol:     position.Symbol,
+			Side:       tradeSide,
+			OpenPrice:  position.AveragePrice,
+			OpenTime:   position.OpenDate,
+			ClosePrice: price,
+			CloseTime:  t,
+			Profit:     profit,
+		})
+		logger.Logger.Debug().
+			Str("symbol", position.Symbol).
+			Str("side", tradeSide).
+			Float64("price", price).
+			Msg("Virtual position closed")
+		return true
+	}
+
 	// Определим период для стратегии, возможно, он передается как параметр функции стратегии
 	strategyPeriod := 10 // Примерное значение, нужно задать или передать в функцию
 
-	for i := strategyPeriod; i < historyDf.Nrow(); i++ {
-		currentBar := historyDf.Subset([]int{i})
-		// Преобразуем строку времени из DataFrame в time.Time
-		timeString, err := currentBar.Col("5").Int()
-		if err != nil || len(timeString) == 0 {
-			continue
+	for i := strategyPeriod; i < candles.Len(); i++ {
+		currentBar := candles.Candles[i]
+		currentBarTime := currentBar.Time
+
+		// Курсы валют на момент бара
+		for fxCurrency, fxSeries := range fxCandles {
+			if candle, ok := candleAt(fxSeries, currentBarTime); ok {
+				fx.SetRate(fxCurrency, candle.Close, candle.Time)
+			}
+		}
+
+		// Диапазон бара - волатильность для модели проскальзывания
+		costs.SetVolatility(symbol, currentBar.High-currentBar.Low)
+
+		// Закрытие бара - сделка для виртуального брокера (объем бара ограничивает исполнение лимитных ордеров)
+		broker.OnQuote(data.Quote{
+			Symbol: symbol,
+			Price:  currentBar.Close,
+			Volume: int64(currentBar.Volume),
+			Time:   currentBarTime,
+		})
+
+		// Клиринг по фьючерсам на закрытии последнего бара дня
+		if config.Futures != nil && (i == candles.Len()-1 || !sameDay(candles.Candles[i+1].Time, currentBarTime)) {
+			for _, margin := range broker.Clear(currentBarTime) {
+				variationMargin += toBase(margin.Amount, currency)
+			}
+		}
+
+		// Купоны и погашение облигации с прошлого бара. Погашение закрывает позицию по номиналу
+		if config.Bond != nil {
+			held, _ := keeper.Position(symbol)
+			realizedBefore := realizedPnL(symbol)
+			coupons, redemption := 0.0, false
+			for _, payment := range broker.PayCoupons(candles.Candles[i-1].Time, currentBarTime) {
+				if payment.Kind == data.BondCashflowCoupon {
+					coupons += payment.Amount
+				} else {
+					redemption = true
+				}
+			}
+			couponIncome += toBase(coupons, currency)
+			totalProfit += toBase(realizedPnL(symbol)-realizedBefore, currency)
+			if redemption {
+				if stops != nil {
+					stops.CancelAll(symbol)
+				}
+				totalTrades++
+				profit := toBase(realizedPnL(symbol)-realizedBefore-coupons, currency)
+				if profit > 0 {
+					profitableTrades++
+				} else {
+					unprofitableTrades++
+				}
+				tradeLog = append(tradeLog, TradeInfo{
+					Symbol:     symbol,
+					Side:       "redemption",
+					OpenPrice:  held.AveragePrice,
+					OpenTime:   held.OpenDate,
+					ClosePrice: 100,
+					CloseTime:  config.Bond.MaturityDate,
+					Profit:     profit,
+				})
+			}
+		}
+
+		// Предупреждение о нехватке обеспечения и маржин-колл по закрытию бара
+		if portfolio, err := broker.GetPortfolioInfo(); err == nil {
+			status := margin.Evaluate(portfolio)
+			if status.Level == order.MarginCall && marginLevel != order.MarginCall {
+				marginCalls++
+			}
+			marginLevel = status.Level
+		}
+
+		// Зачисление дивидендов деньгами по открытым позициям (короткая позиция компенсирует дивиденд)
+		if config.DividendMode == DividendsCash && i > 0 {
+			positions, _ := broker.GetPositions()
+			for _, dividend := range data.DividendsBetween(config.CorporateActions, symbol, candles.Candles[i-1].Time, currentBarTime) {
+				for _, position := range positions {
+					if position.Symbol != symbol || position.Quantity == 0 {
+						continue
+					}
+					// Дивиденд объявляется на акцию, позиция ведется в лотах
+					income := dividend.Amount * float64(position.Quantity*broker.LotSize(symbol))
+					dividendCurrency := dividend.Currency
+					if dividendCurrency == "" {
+						dividendCurrency = currency
+					}
+					broker.Deposit(dividendCurrency, income)
+					totalProfit += toBase(income, dividendCurrency)
+
+					logger.Logger.Debug().
+						Str("symbol", symbol).
+						Time("exDate", dividend.ExDate).
+						Float64("income", income).
+						Msg("Dividend credited")
+				}
+			}
+		}
+
+		// Стратегия видит только историю до текущего бара включительно
+		windowDf := candles.Slice(0, i+1).ToDataFrame()
+
+		// Проверка и подтягивание трейлинг-стопа по закрытию бара
+		if stops != nil {
+			before, _ := broker.GetPositions()
+			realizedBefore := realizedPnL(symbol)
+			stops.UpdateBars(symbol, &windowDf)
+			stops.OnQuote(data.Quote{Symbol: symbol, Price: currentBar.Close, Time: currentBarTime})
+			after, _ := broker.GetPositions()
+
+			for _, position := range before {
+				if position.Symbol != symbol || position.Quantity <= 0 {
+					continue
+				}
+				closed := position.Quantity
+				for _, current := range after {
+					if current.Symbol == symbol {
+						closed -= current.Quantity
+					}
+				}
+				if closed <= 0 {
+					continue
+				}
+				totalTrades++
+				profit := toBase(realizedPnL(symbol)-realizedBefore, currency)
+				totalProfit += profit
+				if profit > 0 {
+					profitableTrades++
+				} else {
+					unprofitableTrades++
+				}
+				tradeLog = append(tradeLog, TradeInfo{
+					Symbol:     symbol,
+					Side:       "sell",
+					OpenPrice:  position.AveragePrice,
+					OpenTime:   position.OpenDate,
+					ClosePrice: currentBar.Close,
+					CloseTime:  currentBarTime,
+					Profit:     profit,
+				})
+				logger.Logger.Debug().
+					Str("symbol", symbol).
+					Float64("price", currentBar.Close).
+					Msg("Virtual trailing stop executed")
+			}
+		}
+
+		portfolio, err := broker.GetPortfolioInfo()
+		if err != nil {
+			return nil, fmt.Errorf("error getting paper portfolio: %w", err)
 		}
-		currentBarTime, _ := time.Parse("20060102", fmt.Sprintf("%d", timeString[0]))
 
 		// Получаем сигналы от стратегии
 		signals, err := strategy.GetSignals(&data.Quote{
-			Price: currentBar.Col("1").Float()[0],
-			Time:  currentBarTime,
-		}, &historyDf, portfolio) // Передаем также ссылку на портфель
+			Symbol: symbol,
+			Price:  currentBar.Close,
+			Volume: int64(currentBar.Volume),
+			Time:   currentBarTime,
+		}, &windowDf, portfolio) // Передаем также ссылку на портфель
 		if err != nil {
 			logger.Logger.Warn().Err(err).Msg("Error getting signals, skipping bar")
 			continue // Пропускаем бар, если есть ошибка в стратегии
@@ -124,86 +495,48 @@ func RunBacktest(finamAPI *api.FinamAPI, symbol string, strategy strategy.Strate
 			Interface("signals", signals).
 			Msg("Bar processed")
 
-		// Обработка сигналов
+		// Обработка сигналов: покупка закрывает короткую позицию и покупает,
+		// продажа закрывает длинную и (если разрешено) продает в короткую
 		for _, signal := range signals {
-			// Проверка, достаточно ли средств для покупки
-			if signal.Side == "buy" && portfolio.Balances["RUB"].Available >= signal.Price {
-				// Расчет размера позиции (в лотах)
-				positionSizeLots := int(math.Floor((portfolio.Balances["RUB"].Available * 0.01) / signal.Price)) // 0.01 как пример
-				if positionSizeLots == 0 {
-					logger.Logger.Warn().
-						Str("symbol", signal.Symbol).
-						Msg("Недостаточно средств для открытия позиции")
-					continue // Недостаточно средств даже для 1 лота
-				}
+			portfolio, err = broker.GetPortfolioInfo()
+			if err != nil {
+				return nil, fmt.Errorf("error getting paper portfolio: %w", err)
+			}
 
-				// Отправка виртуального ордера на покупку
-				totalTrades++
-				newPosition := order.Position{
-					Symbol:       signal.Symbol,
-					Quantity:     positionSizeLots,
-					AveragePrice: signal.Price,
-					OpenDate:     currentBarTime,
+			var position order.Position
+			for _, current := range portfolio.Positions {
+				if current.Symbol == signal.Symbol {
+					position = current
 				}
-				portfolio.Positions = append(portfolio.Positions, newPosition)
-				balance := portfolio.Balances["RUB"]
-				balance.Available -= signal.Price * float64(positionSizeLots)
-				portfolio.Balances["RUB"] = balance
-
-				tradeLog = append(tradeLog, TradeInfo{
-					Symbol:    signal.Symbol,
-					Side:      "buy",
-					OpenPrice: signal.Price,
-					OpenTime:  currentBarTime,
-				})
-				logger.Logger.Debug().
-					Str("symbol", signal.Symbol).
-					Str("side", "buy").
-					Float64("price", signal.Price).
-					Msg("Virtual buy order executed")
+			}
 
-			} else if signal.Side == "sell" {
-				// Проверяем, есть ли у нас открытая позиция по этому инструменту
-				for i, position := range portfolio.Positions {
-					if position.Symbol == signal.Symbol && position.Quantity > 0 {
-						// Отправка виртуального ордера на продажу и закрытие позиции
-						totalTrades++
-						profit := (signal.Price - position.AveragePrice) * float64(position.Quantity)
-						balance := portfolio.Balances["RUB"]
-						balance.Available += signal.Price * float64(position.Quantity)
-						portfolio.Balances["RUB"] = balance
-						portfolio.Positions = append(portfolio.Positions[:i], portfolio.Positions[i+1:]...) // Удаляем позицию из портфеля
-
-						// Обновляем totalProfit и счетчики сделок
-						totalProfit += profit
-						if profit > 0 {
-							profitableTrades++
-						} else {
-							unprofitableTrades++
-						}
-
-						tradeLog = append(tradeLog, TradeInfo{
-							Symbol:     signal.Symbol,
-							Side:       "sell",
-							OpenPrice:  position.AveragePrice,
-							OpenTime:   position.OpenDate,
-							ClosePrice: signal.Price,
-							CloseTime:  currentBarTime,
-							Profit:     profit,
-						})
-						logger.Logger.Debug().
-							Str("symbol", signal.Symbol).
-							Str("side", "sell").
-							Float64("price", signal.Price).
-							Msg("Virtual sell order executed")
-
-						break // Выходим из цикла, т.к. позиция закрыта
+			if signal.Side == "buy" {
+				if position.Quantity < 0 && !closePosition(position, currentBar.Close, currentBarTime) {
+					continue
+				}
+				if openPosition(signal.Symbol, "buy", signal.Price, currentBar.Close, currentBarTime) && stops != nil {
+					if _, err := stops.TrailPosition(signal.Symbol, *config.TrailingStop); err != nil {
+						logger.Logger.Warn().Err(err).Str("symbol", signal.Symbol).Msg("Virtual trailing stop not placed")
 					}
 				}
+			} else if signal.Side == "sell" {
+				if position.Quantity > 0 && !closePosition(position, currentBar.Close, currentBarTime) {
+					continue
+				}
+				if config.AllowShort {
+					openPosition(signal.Symbol, "sell", signal.Price, currentBar.Close, currentBarTime)
+				}
 			}
 
 			// Обновление максимальной доходности и максимальной просадки
-			currentEquity := portfolio.Balances["RUB"].Available
+			portfolio, err = broker.GetPortfolioInfo()
+			if err != nil {
+				return nil, fmt.Errorf("error getting paper portfolio: %w", err)
+			}
+			currentEquity, err := valuation.Equity(portfolio)
+			if err != nil {
+				continue
+			}
 			if currentEquity > maxEquity {
 				maxEquity = currentEquity
 			}
@@ -218,21 +551,128 @@ func RunBacktest(finamAPI *api.FinamAPI, symbol string, strategy strategy.Strate
 	averageProfit := totalProfit / float64(totalTrades)
 	sharpeRatio := calculateSharpeRatio(tradeLog, 0.02) // 0.02 - безрисковая ставка (пример)
 
+	totals := keeper.Totals()
+
 	//  6.  Возврат  результатов
 	return &BacktestResult{
 		TotalTrades:        totalTrades,
 		ProfitableTrades:   profitableTrades,
 		UnprofitableTrades: unprofitableTrades,
 		TotalProfit:        totalProfit,
+		TotalCommission:    totals.Commission,
+		TotalSlippage:      totals.Slippage,
 		AverageProfit:      averageProfit, //  Добавлен  показатель
 		MaxDrawdown:        maxDrawdown,
 		SharpeRatio:        sharpeRatio, //  Добавлен  показатель
+		MarginCalls:        marginCalls,
+		VariationMargin:    variationMargin,
+		CouponIncome:       couponIncome,
+		Rolls:              rolls,
 		StartDate:          startDate,
 		EndDate:            endDate,
 		TradingLog:         tradeLog, //  Добавлен  лог  сделок
 	}, nil
 }
 
+// Функция для загрузки свечей: сначала из архива, при его отсутствии - с API Финам с сохранением в архив
+func loadCandles(finamAPI *api.FinamAPI, store *archive.Archive, symbol, interval string, startDate, endDate time.Time) (*data.CandleSeries, error) {
+	if store != nil {
+		candles, err := store.ReadCandles(symbol, interval, startDate, endDate)
+		if err != nil {
+			logger.Logger.Warn().Err(err).Str("symbol", symbol).Msg("Error reading candles from archive, loading from API")
+		} else if candles.Len() > 0 {
+			return candles, nil
+		}
+	}
+
+	historicalData, err := finamAPI.LoadHistoricalData(symbol, startDate, endDate, interval)
+	if err != nil {
+		return nil, fmt.Errorf("error loading historical data: %w", err)
+	}
+	candles := data.NewCandleSeriesFromFinam(symbol, interval, historicalData)
+
+	if store != nil {
+		if err := store.WriteCandles(candles); err != nil {
+			logger.Logger.Warn().Err(err).Str("symbol", symbol).Msg("Error writing candles to archive")
+		}
+	}
+	return candles, nil
+}
+
+// Функция для построения непрерывного ряда фьючерса по базовому активу из рядов контрактов,
+// торговавшихся в интервале бэктеста
+func loadContinuousCandles(finamAPI *api.FinamAPI, store *archive.Archive, asset string, contracts []data.FuturesContract, interval string, startDate, endDate time.Time, rollDays int) (*data.CandleSeries, []data.ContractRoll, error) {
+	series := make(map[string]*data.CandleSeries)
+	for _, contract := range contracts {
+		if contract.Asset != asset || contract.LastTradeDate.Before(startDate) {
+			continue
+		}
+		to := endDate
+		if contract.LastTradeDate.Before(to) {
+			to = contract.LastTradeDate
+		}
+		candles, err := loadCandles(finamAPI, store, contract.Symbol, interval, startDate, to)
+		if err != nil {
+			logger.Logger.Warn().Err(err).Str("contract", contract.Symbol).Msg("Error loading futures contract candles, skipping")
+			continue
+		}
+		series[contract.Symbol] = candles
+		if contract.RollDate(rollDays).After(endDate) {
+			break
+		}
+	}
+
+	continuous, rolls := data.BuildContinuousSeries(asset, contracts, series, rollDays)
+	if continuous.Len() == 0 {
+		return nil, nil, fmt.Errorf("no candles for futures contracts on %s", asset)
+	}
+	for _, roll := range rolls {
+		logger.Logger.Info().
+			Str("from", roll.From).
+			Str("to", roll.To).
+			Time("time", roll.Time).
+			Float64("gap", roll.Gap).
+			Msg("Continuous futures series rolled")
+	}
+	return continuous, rolls, nil
+}
+
+// Функция проверяет, относятся ли моменты к одному торговому дню
+func sameDay(a, b time.Time) bool {
+	a, b = a.In(data.ExchangeLocation), b.In(data.ExchangeLocation)
+	return a.Year() == b.Year() && a.YearDay() == b.YearDay()
+}
+
+// Функция для загрузки свечей инструментов валютного рынка для пересчета валют currencies
+// в рубли (по валютам, для рубля загрузка не нужна)
+func loadFXCandles(finamAPI *api.FinamAPI, store *archive.Archive, fx *data.FXRates, currencies []string, interval string, startDate, endDate time.Time) (map[string]*data.CandleSeries, error) {
+	series := make(map[string]*data.CandleSeries)
+	for _, currency := range currencies {
+		if currency == data.RubCurrency || series[currency] != nil {
+			continue
+		}
+		fxSymbol, ok := fx.Symbol(currency)
+		if !ok {
+			return nil, fmt.Errorf("no FX instrument for currency %s", currency)
+		}
+		candles, err := loadCandles(finamAPI, store, fxSymbol, interval, startDate, endDate)
+		if err != nil {
+			return nil, fmt.Errorf("error loading FX candles for %s: %w", currency, err)
+		}
+		series[currency] = candles
+	}
+	return series, nil
+}
+
+// Функция возвращает последнюю свечу ряда, открытую не позже момента t
+func candleAt(series *data.CandleSeries, t time.Time) (data.Candle, bool) {
+	i := sort.Search(series.Len(), func(i int) bool { return series.Candles[i].Time.After(t) })
+	if i == 0 {
+		return data.Candle{}, false
+	}
+	return series.Candles[i-1], true
+}
+
 // Функция  для  расчета  коэффициента  Шарпа
 func calculateSharpeRatio(trades []TradeInfo, riskFreeRate float64) float64 {
 	if len(trades) == 0 {
@@ -273,3 +713,10 @@ func calculateMean(data []float64) float64 {
 	}
 	return sum / float64(len(data))
 }
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
@@ -0,0 +1,765 @@
+package connector
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"trading-bot/data"
+	"trading-bot/logger"
+	"trading-bot/order"
+)
+
+// Таймаут ожидания ответа на торговую команду
+const commandTimeout = 10 * time.Second
+
+// Структура для ордера из сообщения <orders>
+type transaqOrder struct {
+	TransactionID int     `xml:"transactionid,attr"`
+	OrderNo       int64   `xml:"orderno"`
+	SecCode       string  `xml:"seccode"`
+	Board         string  `xml:"board"`
+	Client        string  `xml:"client"`
+	Status        string  `xml:"status"`
+	BuySell       string  `xml:"buysell"`
+	Price         float64 `xml:"price"`
+	Quantity      int     `xml:"quantity"`
+	Balance       int     `xml:"balance"`
+	BrokerRef     string  `xml:"brokerref"`
+	Time          string  `xml:"time"`
+	Result        string  `xml:"result"`
+
+	filledQuantity int     // Исполненное количество по сообщениям <trades>
+	filledValue    float64 // Оборот исполнения по сообщениям <trades>
+	commission     float64 // Комиссия по сообщениям <trades>
+}
+
+// Структура для условного ордера из сообщения <stoporders>
+type transaqStopOrder struct {
+	TransactionID int    `xml:"transactionid,attr"`
+	ActiveOrderNo int64  `xml:"activeorderno"` // Биржевой номер ордера, выставленного при срабатывании
+	SecCode       string `xml:"seccode"`
+	Status        string `xml:"status"`
+}
+
+// Статусы условных ордеров Transaq, завершающие ордер
+var transaqStopStatuses = map[string]order.StopStatus{
+	"tp_executed": order.StopTriggered,
+	"sl_executed": order.StopTriggered,
+	"cancelled":   order.StopCancelled,
+	"denied":      order.StopCancelled,
+	"disabled":    order.StopCancelled,
+	"expired":     order.StopCancelled,
+	"failed":      order.StopCancelled,
+	"rejected":    order.StopCancelled,
+}
+
+// Структура для сделки из сообщения <trades>
+type transaqTrade struct {
+	TradeNo   int64   `xml:"tradeno"`
+	OrderNo   int64   `xml:"orderno"`
+	SecCode   string  `xml:"seccode"`
+	Client    string  `xml:"client"`
+	BuySell   string  `xml:"buysell"`
+	Price     float64 `xml:"price"`
+	Quantity  int     `xml:"quantity"`
+	Comission float64 `xml:"comission"` // Написание поля из протокола Transaq
+	Time      string  `xml:"time"`
+}
+
+// Структура для позиций из сообщения <positions>
+type transaqPositions struct {
+	SecPositions []struct {
+		SecCode string `xml:"seccode"`
+		Board   string `xml:"board"`
+		Client  string `xml:"client"`
+		Saldo   int    `xml:"saldo"`
+	} `xml:"sec_position"`
+	MoneyPositions []struct {
+		Client   string  `xml:"client"`
+		Currency string  `xml:"currency"`
+		SaldoIn  float64 `xml:"saldoin"`
+		Saldo    float64 `xml:"saldo"`
+		OrdBuy   float64 `xml:"ordbuy"`
+	} `xml:"money_position"`
+}
+
+// Структура для ставок риска из сообщения <mc_portfolio> (в процентах)
+type transaqMarginPortfolio struct {
+	Client     string `xml:"client,attr"`
+	Securities []struct {
+		SecCode       string  `xml:"seccode"`
+		RiskRateLong  float64 `xml:"riskrate_long"`  // Ставка риска длинной позиции
+		RiskRateShort float64 `xml:"riskrate_short"` // Ставка риска короткой позиции
+		ReseRateLong  float64 `xml:"reserate_long"`  // Ставка резервирования длинной позиции
+		ReseRateShort float64 `xml:"reserate_short"` // Ставка резервирования короткой позиции
+	} `xml:"security"`
+}
+
+// Реализация order.Broker поверх XML команд Transaq Connector (neworder, cancelorder, moveorder).
+// Состояние ордеров и позиций поддерживается по асинхронным сообщениям <orders> и <positions>.
+type TransaqBroker struct {
+	connector  *TransaqConnector
+	clientCode string
+	board      string
+	boards     map[string]string // Площадки отдельных инструментов (например, FUT для фьючерсов)
+
+	mu        sync.RWMutex
+	orders    map[int]*transaqOrder
+	positions map[string]order.Position
+	balances  map[string]order.Balance
+	margin    map[string]order.MarginParams // Ставки риска брокера по инструментам
+	trades    map[int64]bool                // Уже учтенные сделки (tradeno)
+	pending   map[int64][]transaqTrade      // Сделки по ордерам, еще не пришедшим в <orders> (по orderno)
+	handlers  []func(update order.OrderUpdate)
+
+	stopHandlers []func(update order.StopUpdate)
+
+	ordersLoaded     chan struct{} // Закрывается после первого сообщения <orders>
+	ordersLoadedOnce sync.Once
+}
+
+// Функция для создания брокера Transaq. board - код площадки по умолчанию (например, "TQBR")
+func NewTransaqBroker(connector *TransaqConnector, clientCode string, board string) *TransaqBroker {
+	broker := &TransaqBroker{
+		connector:  connector,
+		clientCode: clientCode,
+		board:      board,
+		orders:     make(map[int]*transaqOrder),
+		positions:  make(map[string]order.Position),
+		balances:   make(map[string]order.Balance),
+		boards:     make(map[string]string),
+		margin:     make(map[string]order.MarginParams),
+		trades:     make(map[int64]bool),
+		pending:    make(map[int64][]transaqTrade),
+
+		ordersLoaded: make(chan struct{}),
+	}
+	connector.AddMessageHandler(broker.handleMessage)
+	return broker
+}
+
+func (b *TransaqBroker) Name() string {
+	return "transaq"
+}
+
+// Функция для выбора площадки инструмента, отличной от площадки по умолчанию
+// (например, FUT для фьючерсов срочного рынка)
+func (b *TransaqBroker) SetSymbolBoard(symbol, board string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.boards[symbol] = board
+}
+
+// Функция возвращает площадку инструмента
+func (b *TransaqBroker) symbolBoard(symbol string) string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if board, ok := b.boards[symbol]; ok {
+		return board
+	}
+	return b.board
+}
+
+func (b *TransaqBroker) PlaceOrder(req *order.OrderRequest) (*order.OrderResponse, error) {
+	clientCode := req.ClientCode
+	if clientCode == "" {
+		clientCode = b.clientCode
+	}
+	board := b.symbolBoard(req.Symbol)
+
+	buySell := "B"
+	if req.Side == "sell" {
+		buySell = "S"
+	}
+
+	var command strings.Builder
+	command.WriteString(`<command id="neworder">`)
+	fmt.Fprintf(&command, "<security><board>%s</board><seccode>%s</seccode></security>", escape(board), escape(req.Symbol))
+	fmt.Fprintf(&command, "<client>%s</client>", escape(clientCode))
+	switch req.OrderType {
+	case "market":
+		command.WriteString("<bymarket/>")
+	case "limit":
+		fmt.Fprintf(&command, "<price>%s</price>", formatPrice(req.Price))
+	default:
+		return nil, fmt.Errorf("transaq: unsupported order type %q: %w", req.OrderType, order.ErrNotSupported)
+	}
+	fmt.Fprintf(&command, "<quantity>%d</quantity><buysell>%s</buysell>", req.Quantity, buySell)
+	// Клиентский ID передается в brokerref, чтобы найти ордер после потери ответа
+	brokerRef := req.Comment
+	if req.ClientOrderID != "" {
+		brokerRef = req.ClientOrderID
+	}
+	if brokerRef != "" {
+		fmt.Fprintf(&command, "<brokerref>%s</brokerref>", escape(brokerRef))
+	}
+	if req.Validity == "fill-or-kill" {
+		command.WriteString("<unfilled>FOK</unfilled>")
+	} else {
+		command.WriteString("<unfilled>PutInQueue</unfilled>")
+	}
+	command.WriteString("</command>")
+
+	logger.Logger.Info().
+		Str("symbol", req.Symbol).
+		Str("side", req.Side).
+		Int("quantity", req.Quantity).
+		Str("order_type", req.OrderType).
+		Msg("Sending Transaq neworder command")
+
+	result, err := b.connector.SendCommand(command.String(), commandTimeout)
+	if err != nil {
+		if result != nil && !result.Success {
+			return nil, fmt.Errorf("order creation failed: %v: %w", err, order.ErrOrderRejected)
+		}
+		return nil, fmt.Errorf("order creation failed: %w", err)
+	}
+
+	b.mu.Lock()
+	b.orders[result.TransactionID] = &transaqOrder{
+		TransactionID: result.TransactionID,
+		SecCode:       req.Symbol,
+		Board:         board,
+		Client:        clientCode,
+		Status:        "watching",
+		BuySell:       buySell,
+		Price:         req.Price,
+		Quantity:      req.Quantity,
+		Balance:       req.Quantity,
+		BrokerRef:     brokerRef,
+	}
+	b.mu.Unlock()
+
+	logger.Logger.Info().Int("order_id", result.TransactionID).Msg("Order created successfully")
+	return &order.OrderResponse{OrderID: result.TransactionID, Status: "success"}, nil
+}
+
+func (b *TransaqBroker) CancelOrder(orderID int) error {
+	command := fmt.Sprintf(`<command id="cancelorder"><transactionid>%d</transactionid></command>`, orderID)
+
+	logger.Logger.Info().Int("order_id", orderID).Msg("Sending Transaq cancelorder command")
+	if _, err := b.connector.SendCommand(command, commandTimeout); err != nil {
+		return fmt.Errorf("error cancelling order: %w", err)
+	}
+	return nil
+}
+
+// Функция для изменения ордера командой moveorder: площадка сама снимает ордер и выставляет новый
+// на неисполненный остаток. req.Quantity - новое общее количество, включая исполненное
+func (b *TransaqBroker) ModifyOrder(req *order.ModifyOrderRequest) (*order.OrderResponse, error) {
+	b.mu.RLock()
+	original, ok := b.orders[req.OrderID]
+	var current transaqOrder
+	if ok {
+		current = *original
+	}
+	b.mu.RUnlock()
+	if !ok {
+		return nil, &order.ModifyError{OrderID: req.OrderID, Stage: order.ErrModifyRejected, Err: order.ErrOrderNotFound}
+	}
+
+	// moveflag: 0 - количество не менять, 1 - изменить на указанное
+	moveFlag, quantity := 0, current.Balance
+	if req.Quantity > 0 {
+		filled := current.Quantity - current.Balance
+		if req.Quantity-filled <= 0 {
+			return nil, &order.ModifyError{OrderID: req.OrderID, Stage: order.ErrModifyFilled, FilledQuantity: filled}
+		}
+		moveFlag, quantity = 1, req.Quantity-filled
+	}
+	price := req.Price
+	if price <= 0 {
+		price = current.Price
+	}
+	command := fmt.Sprintf(`<command id="moveorder"><transactionid>%d</transactionid><price>%s</price><moveflag>%d</moveflag><quantity>%d</quantity></command>`,
+		req.OrderID, formatPrice(price), moveFlag, quantity)
+
+	logger.Logger.Info().Int("order_id", req.OrderID).Float64("price", price).Int("quantity", quantity).Msg("Sending Transaq moveorder command")
+	result, err := b.connector.SendCommand(command, commandTimeout)
+	if err != nil {
+		if result != nil && !result.Success {
+			return nil, &order.ModifyError{OrderID: req.OrderID, Stage: order.ErrModifyRejected, Err: fmt.Errorf("%v: %w", err, order.ErrOrderRejected)}
+		}
+		return nil, &order.ModifyError{OrderID: req.OrderID, Stage: order.ErrModifyUnconfirmed, Err: err}
+	}
+
+	// Новый ордер известен до прихода <orders>, чтобы по нему сразу работал GetOrderStatus
+	b.mu.Lock()
+	if _, exists := b.orders[result.TransactionID]; !exists {
+		b.orders[result.TransactionID] = &transaqOrder{
+			TransactionID: result.TransactionID,
+			SecCode:       current.SecCode,
+			Board:         current.Board,
+			Client:        current.Client,
+			Status:        "watching",
+			BuySell:       current.BuySell,
+			Price:         price,
+			Quantity:      quantity,
+			Balance:       quantity,
+			BrokerRef:     current.BrokerRef,
+		}
+	}
+	b.mu.Unlock()
+
+	return &order.OrderResponse{OrderID: result.TransactionID, Status: "success"}, nil
+}
+
+func (b *TransaqBroker) GetOrderStatus(orderID int) (*order.OrderResponse, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	o, ok := b.orders[orderID]
+	if !ok {
+		return nil, fmt.Errorf("order %d: %w", orderID, order.ErrOrderNotFound)
+	}
+	return &order.OrderResponse{
+		OrderID:        o.TransactionID,
+		Status:         o.Status,
+		Message:        o.Result,
+		FilledQuantity: o.filledQuantity,
+		AveragePrice:   o.averagePrice(),
+	}, nil
+}
+
+// Ордера приходят сообщениями <orders> после подключения: до первого из них поиск по
+// клиентскому ID не достоверен (см. order.OrderSnapshotSource)
+func (b *TransaqBroker) OrdersLoaded() <-chan struct{} {
+	return b.ordersLoaded
+}
+
+func (b *TransaqBroker) FindOrderByClientID(clientOrderID string) (*order.OrderHistory, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, o := range b.orders {
+		if o.BrokerRef == clientOrderID {
+			history := o.toHistory()
+			return &history, nil
+		}
+	}
+	return nil, fmt.Errorf("client order %s: %w", clientOrderID, order.ErrOrderNotFound)
+}
+
+// Функция для выставления нативного условного ордера (стоп-лосс и/или тейк-профит одной командой)
+func (b *TransaqBroker) PlaceStopOrder(req *order.StopOrderRequest) (*order.OrderResponse, error) {
+	buySell := "B"
+	if req.Side == "sell" {
+		buySell = "S"
+	}
+
+	var command strings.Builder
+	command.WriteString(`<command id="newstoporder">`)
+	fmt.Fprintf(&command, "<security><board>%s</board><seccode>%s</seccode></security>", escape(b.symbolBoard(req.Symbol)), escape(req.Symbol))
+	fmt.Fprintf(&command, "<client>%s</client><buysell>%s</buysell>", escape(b.clientCode), buySell)
+	if leg := req.StopLoss; leg != nil {
+		fmt.Fprintf(&command, "<stoploss><activationprice>%s</activationprice>", formatPrice(leg.StopPrice))
+		if leg.Kind == order.StopLimit {
+			fmt.Fprintf(&command, "<orderprice>%s</orderprice>", formatPrice(leg.LimitPrice))
+		} else {
+			command.WriteString("<bymarket/>")
+		}
+		fmt.Fprintf(&command, "<quantity>%d</quantity><brokerref>%s</brokerref></stoploss>", req.Quantity, escape(req.ClientOrderID))
+	}
+	if leg := req.TakeProfit; leg != nil {
+		fmt.Fprintf(&command, "<takeprofit><activationprice>%s</activationprice><quantity>%d</quantity>", formatPrice(leg.StopPrice), req.Quantity)
+		fmt.Fprintf(&command, "<brokerref>%s</brokerref><bymarket/></takeprofit>", escape(req.ClientOrderID))
+	}
+	command.WriteString("</command>")
+
+	logger.Logger.Info().
+		Str("symbol", req.Symbol).
+		Str("side", req.Side).
+		Int("quantity", req.Quantity).
+		Bool("stop_loss", req.StopLoss != nil).
+		Bool("take_profit", req.TakeProfit != nil).
+		Msg("Sending Transaq newstoporder command")
+
+	result, err := b.connector.SendCommand(command.String(), commandTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("stop order creation failed: %w", err)
+	}
+	return &order.OrderResponse{OrderID: result.TransactionID, Status: "success"}, nil
+}
+
+// Функция для снятия нативного условного ордера
+func (b *TransaqBroker) CancelStopOrder(stopOrderID int) error {
+	command := fmt.Sprintf(`<command id="cancelstoporder"><transactionid>%d</transactionid></command>`, stopOrderID)
+
+	logger.Logger.Info().Int("stop_order_id", stopOrderID).Msg("Sending Transaq cancelstoporder command")
+	if _, err := b.connector.SendCommand(command, commandTimeout); err != nil {
+		return fmt.Errorf("error cancelling stop order: %w", err)
+	}
+	return nil
+}
+
+// Функция для подписки на завершение нативных условных ордеров (реализация order.NativeStopBroker)
+func (b *TransaqBroker) SubscribeStopUpdates(handler func(update order.StopUpdate)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stopHandlers = append(b.stopHandlers, handler)
+}
+
+// Функция для подписки на обновления ордеров (реализация order.OrderEventSource)
+func (b *TransaqBroker) SubscribeOrderUpdates(handler func(update order.OrderUpdate)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handler)
+}
+
+func (b *TransaqBroker) GetPositions() ([]order.Position, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	positions := make([]order.Position, 0, len(b.positions))
+	for _, position := range b.positions {
+		positions = append(positions, position)
+	}
+	sort.Slice(positions, func(i, j int) bool { return positions[i].Symbol < positions[j].Symbol })
+	return positions, nil
+}
+
+func (b *TransaqBroker) GetBalances() (map[string]order.Balance, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	balances := make(map[string]order.Balance, len(b.balances))
+	for currency, balance := range b.balances {
+		balances[currency] = balance
+	}
+	return balances, nil
+}
+
+func (b *TransaqBroker) GetPortfolioInfo() (*order.PortfolioInfo, error) {
+	positions, _ := b.GetPositions()
+	balances, _ := b.GetBalances()
+	return &order.PortfolioInfo{
+		Account: order.AccountInfo{
+			ClientCode: b.clientCode,
+		},
+		Balances:  balances,
+		Positions: positions,
+	}, nil
+}
+
+// Функция для запроса ставок риска брокера по инструментам портфеля. Ответ приходит
+// сообщением <mc_portfolio>
+func (b *TransaqBroker) RequestMarginParams() error {
+	command := fmt.Sprintf(`<command id="get_mc_portfolio" client="%s" currency="false" asset="false" money="false" depo="true" registers="false"/>`, escape(b.clientCode))
+	if _, err := b.connector.SendCommand(command, commandTimeout); err != nil {
+		return fmt.Errorf("error requesting margin params: %w", err)
+	}
+	return nil
+}
+
+// Функция возвращает ставки риска брокера (реализация order.MarginSource). Начальная маржа -
+// ставка резервирования, минимальная - ставка риска. Бумаги без ставки для коротких позиций
+// недоступны для займа
+func (b *TransaqBroker) MarginParams() map[string]order.MarginParams {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	params := make(map[string]order.MarginParams, len(b.margin))
+	for symbol, margin := range b.margin {
+		params[symbol] = margin
+	}
+	return params
+}
+
+// Transaq не хранит историю ордеров, поэтому возвращаются ордера текущей сессии
+func (b *TransaqBroker) GetOrdersHistory(req *order.OrdersHistoryRequest) (*order.OrdersHistoryResponse, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var response order.OrdersHistoryResponse
+	for _, o := range b.orders {
+		history := o.toHistory()
+		if req.Symbol != "" && history.Symbol != req.Symbol {
+			continue
+		}
+		if req.Status != "" && history.Status != req.Status {
+			continue
+		}
+		if !history.ExecutionTime.IsZero() &&
+			((!req.From.IsZero() && history.ExecutionTime.Before(req.From)) || (!req.To.IsZero() && history.ExecutionTime.After(req.To))) {
+			continue
+		}
+		response.Orders = append(response.Orders, history)
+	}
+	sort.Slice(response.Orders, func(i, j int) bool { return response.Orders[i].OrderID < response.Orders[j].OrderID })
+	return &response, nil
+}
+
+// Функция для обработки сообщений <orders>, <trades>, <positions> и <mc_portfolio> от сервера
+func (b *TransaqBroker) handleMessage(message string) {
+	switch {
+	case strings.HasPrefix(message, "<orders"):
+		var orders struct {
+			Orders []transaqOrder `xml:"order"`
+		}
+		if err := xml.Unmarshal([]byte(message), &orders); err != nil {
+			logger.Logger.Error().Err(err).Msg("Failed to parse orders message")
+			return
+		}
+		var updates []order.OrderUpdate
+		b.mu.Lock()
+		for i := range orders.Orders {
+			o := orders.Orders[i]
+			if !b.ownClient(o.Client) {
+				continue
+			}
+			if previous, ok := b.orders[o.TransactionID]; ok {
+				o.filledQuantity = previous.filledQuantity
+				o.filledValue = previous.filledValue
+				o.commission = previous.commission
+				if o.Quantity == 0 {
+					o.Quantity = previous.Quantity
+				}
+			}
+			b.orders[o.TransactionID] = &o
+			// Сделки могут прийти раньше ордера: они учитываются, как только ордер известен
+			if trades := b.pending[o.OrderNo]; o.OrderNo != 0 && len(trades) > 0 {
+				delete(b.pending, o.OrderNo)
+				for _, trade := range trades {
+					b.applyTrade(&o, trade)
+				}
+			}
+			updates = append(updates, o.toUpdate())
+		}
+		handlers := b.handlers
+		b.mu.Unlock()
+		b.ordersLoadedOnce.Do(func() { close(b.ordersLoaded) })
+		notifyUpdates(handlers, updates)
+
+	case strings.HasPrefix(message, "<trades"):
+		var trades struct {
+			Trades []transaqTrade `xml:"trade"`
+		}
+		if err := xml.Unmarshal([]byte(message), &trades); err != nil {
+			logger.Logger.Error().Err(err).Msg("Failed to parse trades message")
+			return
+		}
+		var updates []order.OrderUpdate
+		b.mu.Lock()
+		for _, trade := range trades.Trades {
+			if !b.ownClient(trade.Client) || b.trades[trade.TradeNo] {
+				continue
+			}
+			o := b.findByOrderNo(trade.OrderNo)
+			if o == nil {
+				b.deferTrade(trade)
+				continue
+			}
+			if b.applyTrade(o, trade) {
+				updates = append(updates, o.toUpdate())
+			}
+		}
+		handlers := b.handlers
+		b.mu.Unlock()
+		notifyUpdates(handlers, updates)
+
+	case strings.HasPrefix(message, "<stoporders"):
+		var stopOrders struct {
+			StopOrders []transaqStopOrder `xml:"stoporder"`
+		}
+		if err := xml.Unmarshal([]byte(message), &stopOrders); err != nil {
+			logger.Logger.Error().Err(err).Msg("Failed to parse stoporders message")
+			return
+		}
+		var updates []order.StopUpdate
+		b.mu.RLock()
+		for _, stop := range stopOrders.StopOrders {
+			status, ok := transaqStopStatuses[stop.Status]
+			if !ok {
+				continue
+			}
+			update := order.StopUpdate{NativeID: stop.TransactionID, Status: status, Leg: order.StopMarket}
+			if stop.Status == "tp_executed" {
+				update.Leg = order.TakeProfit
+			}
+			if o := b.findByOrderNo(stop.ActiveOrderNo); o != nil && stop.ActiveOrderNo != 0 {
+				update.OrderID = o.TransactionID
+			}
+			updates = append(updates, update)
+		}
+		handlers := b.stopHandlers
+		b.mu.RUnlock()
+		for _, update := range updates {
+			for _, handler := range handlers {
+				handler(update)
+			}
+		}
+
+	case strings.HasPrefix(message, "<positions"):
+		var positions transaqPositions
+		if err := xml.Unmarshal([]byte(message), &positions); err != nil {
+			logger.Logger.Error().Err(err).Msg("Failed to parse positions message")
+			return
+		}
+		b.mu.Lock()
+		for _, p := range positions.SecPositions {
+			if !b.ownClient(p.Client) {
+				continue
+			}
+			if p.Saldo == 0 {
+				delete(b.positions, p.SecCode)
+				continue
+			}
+			position := b.positions[p.SecCode]
+			position.Symbol = p.SecCode
+			position.Quantity = p.Saldo
+			b.positions[p.SecCode] = position
+		}
+		for _, m := range positions.MoneyPositions {
+			if !b.ownClient(m.Client) {
+				continue
+			}
+			currency := m.Currency
+			if currency == "" {
+				currency = "RUB"
+			}
+			b.balances[currency] = order.Balance{
+				Value:     m.Saldo,
+				Available: m.Saldo - m.OrdBuy,
+				Blocked:   m.OrdBuy,
+			}
+		}
+		b.mu.Unlock()
+
+	case strings.HasPrefix(message, "<mc_portfolio"):
+		var portfolio transaqMarginPortfolio
+		if err := xml.Unmarshal([]byte(message), &portfolio); err != nil {
+			logger.Logger.Error().Err(err).Msg("Failed to parse mc_portfolio message")
+			return
+		}
+		if !b.ownClient(portfolio.Client) {
+			return
+		}
+		b.mu.Lock()
+		for _, s := range portfolio.Securities {
+			b.margin[s.SecCode] = order.MarginParams{
+				Shortable:        s.ReseRateShort > 0,
+				InitialLong:      s.ReseRateLong / 100,
+				InitialShort:     s.ReseRateShort / 100,
+				MaintenanceLong:  s.RiskRateLong / 100,
+				MaintenanceShort: s.RiskRateShort / 100,
+			}
+		}
+		b.mu.Unlock()
+	}
+}
+
+// Функция проверяет, относится ли сообщение к клиенту брокера. Через одно подключение
+// могут работать несколько счетов, каждый со своим брокером
+func (b *TransaqBroker) ownClient(client string) bool {
+	return b.clientCode == "" || client == "" || client == b.clientCode
+}
+
+// Функция для учета сделки по ордеру (вызывается под b.mu). false - сделка уже учтена
+func (b *TransaqBroker) applyTrade(o *transaqOrder, trade transaqTrade) bool {
+	if b.trades[trade.TradeNo] {
+		return false
+	}
+	b.trades[trade.TradeNo] = true
+	o.filledQuantity += trade.Quantity
+	o.filledValue += trade.Price * float64(trade.Quantity)
+	o.commission += trade.Comission
+	return true
+}
+
+// Функция для отложенного учета сделки по ордеру, о котором еще не было <orders>
+// (вызывается под b.mu). Transaq не гарантирует порядок сообщений <orders> и <trades>
+func (b *TransaqBroker) deferTrade(trade transaqTrade) {
+	for _, pending := range b.pending[trade.OrderNo] {
+		if pending.TradeNo == trade.TradeNo {
+			return
+		}
+	}
+	b.pending[trade.OrderNo] = append(b.pending[trade.OrderNo], trade)
+	logger.Logger.Debug().
+		Int64("orderno", trade.OrderNo).
+		Int64("tradeno", trade.TradeNo).
+		Msg("Trade for unknown order deferred until the order arrives")
+}
+
+// Функция для поиска ордера по биржевому номеру (вызывается под b.mu)
+func (b *TransaqBroker) findByOrderNo(orderNo int64) *transaqOrder {
+	for _, o := range b.orders {
+		if o.OrderNo == orderNo {
+			return o
+		}
+	}
+	return nil
+}
+
+// Функция для отправки обновлений подписчикам (вызывается без блокировки b.mu)
+func notifyUpdates(handlers []func(update order.OrderUpdate), updates []order.OrderUpdate) {
+	for _, update := range updates {
+		for _, handler := range handlers {
+			handler(update)
+		}
+	}
+}
+
+// Функция возвращает среднюю цену исполнения по сделкам
+func (o *transaqOrder) averagePrice() float64 {
+	if o.filledQuantity == 0 {
+		return 0
+	}
+	return o.filledValue / float64(o.filledQuantity)
+}
+
+// Функция для преобразования ордера Transaq в обновление для order.OrderManager
+func (o *transaqOrder) toUpdate() order.OrderUpdate {
+	history := o.toHistory()
+	return order.OrderUpdate{
+		OrderID:        o.TransactionID,
+		Symbol:         o.SecCode,
+		Side:           history.Side,
+		Quantity:       o.Quantity,
+		Status:         o.Status,
+		FilledQuantity: o.filledQuantity,
+		AveragePrice:   o.averagePrice(),
+		Commission:     o.commission,
+		Message:        o.Result,
+		Time:           history.ExecutionTime,
+	}
+}
+
+// Функция для преобразования ордера Transaq в запись истории
+func (o *transaqOrder) toHistory() order.OrderHistory {
+	side := "buy"
+	if o.BuySell == "S" {
+		side = "sell"
+	}
+	orderType := "limit"
+	if o.Price == 0 {
+		orderType = "market"
+	}
+	executionTime, _ := time.ParseInLocation("02.01.2006 15:04:05", o.Time, data.ExchangeLocation)
+	return order.OrderHistory{
+		OrderID:       o.TransactionID,
+		Symbol:        o.SecCode,
+		Side:          side,
+		Quantity:      o.Quantity,
+		OrderType:     orderType,
+		Price:         o.Price,
+		Status:        o.Status,
+		ExecutionTime: executionTime,
+		ClientOrderID: o.BrokerRef,
+	}
+}
+
+// Функция для экранирования значений в XML команде
+func escape(value string) string {
+	return html.EscapeString(value)
+}
+
+// Функция для форматирования цены в XML команде
+func formatPrice(price float64) string {
+	return strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.6f", price), "0"), ".")
+}
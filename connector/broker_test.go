@@ -0,0 +1,150 @@
+package connector
+
+import (
+	"math"
+	"testing"
+
+	"trading-bot/order"
+)
+
+// Функция создает брокера Transaq без подключения и собирает его обновления ордеров
+func newTestTransaqBroker(t *testing.T, clientCode string) (*TransaqBroker, *[]order.OrderUpdate) {
+	t.Helper()
+	connector, err := NewTransaqConnector(&TransaqConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := NewTransaqBroker(connector, clientCode, "TQBR")
+	var updates []order.OrderUpdate
+	broker.SubscribeOrderUpdates(func(update order.OrderUpdate) {
+		updates = append(updates, update)
+	})
+	return broker, &updates
+}
+
+const (
+	ordersMessage = `<orders>` +
+		`<order transactionid="7"><orderno>1001</orderno><seccode>SBER</seccode><board>TQBR</board><client>C1</client>` +
+		`<status>active</status><buysell>B</buysell><price>250.5</price><quantity>10</quantity><balance>10</balance>` +
+		`<brokerref>cid-7</brokerref><time>15.07.2024 10:30:00</time></order>` +
+		`<order transactionid="8"><orderno>1002</orderno><seccode>GAZP</seccode><client>C2</client>` +
+		`<status>active</status><buysell>S</buysell><price>150</price><quantity>5</quantity><balance>5</balance></order>` +
+		`</orders>`
+	tradesMessage = `<trades>` +
+		`<trade><tradeno>501</tradeno><orderno>1001</orderno><seccode>SBER</seccode><client>C1</client><buysell>B</buysell>` +
+		`<price>250</price><quantity>4</quantity><comission>1.5</comission></trade>` +
+		`<trade><tradeno>502</tradeno><orderno>1001</orderno><seccode>SBER</seccode><client>C1</client><buysell>B</buysell>` +
+		`<price>251</price><quantity>6</quantity><comission>2</comission></trade>` +
+		`</trades>`
+)
+
+func TestTransaqBrokerOrders(t *testing.T) {
+	broker, updates := newTestTransaqBroker(t, "C1")
+
+	select {
+	case <-broker.OrdersLoaded():
+		t.Fatal("orders loaded before the first <orders> message")
+	default:
+	}
+
+	broker.handleMessage(ordersMessage)
+
+	select {
+	case <-broker.OrdersLoaded():
+	default:
+		t.Fatal("orders not loaded after <orders> message")
+	}
+	if len(*updates) != 1 {
+		t.Fatalf("updates = %+v, want one update for own client", *updates)
+	}
+	update := (*updates)[0]
+	if update.OrderID != 7 || update.Symbol != "SBER" || update.Side != "buy" || update.Quantity != 10 || update.Status != "active" {
+		t.Errorf("update = %+v", update)
+	}
+
+	if _, err := broker.GetOrderStatus(8); err == nil {
+		t.Error("order of another client must be ignored")
+	}
+	history, err := broker.FindOrderByClientID("cid-7")
+	if err != nil {
+		t.Fatalf("FindOrderByClientID error = %v", err)
+	}
+	if history.OrderID != 7 || history.OrderType != "limit" || history.Price != 250.5 || history.ExecutionTime.IsZero() {
+		t.Errorf("history = %+v", history)
+	}
+}
+
+func TestTransaqBrokerTrades(t *testing.T) {
+	broker, updates := newTestTransaqBroker(t, "C1")
+	broker.handleMessage(ordersMessage)
+	broker.handleMessage(tradesMessage)
+	// Повторная доставка тех же сделок не удваивает исполнение
+	broker.handleMessage(tradesMessage)
+
+	status, err := broker.GetOrderStatus(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.FilledQuantity != 10 || math.Abs(status.AveragePrice-250.6) > 1e-9 {
+		t.Errorf("filled = %d @ %v, want 10 @ 250.6", status.FilledQuantity, status.AveragePrice)
+	}
+	// Обновление по ордеру и по каждой новой сделке
+	if len(*updates) != 3 {
+		t.Fatalf("updates = %d, want 3", len(*updates))
+	}
+	if last := (*updates)[2]; last.FilledQuantity != 10 || math.Abs(last.Commission-3.5) > 1e-9 {
+		t.Errorf("last update = %+v, want 10 filled with commission 3.5", last)
+	}
+}
+
+func TestTransaqBrokerTradesBeforeOrder(t *testing.T) {
+	broker, updates := newTestTransaqBroker(t, "C1")
+
+	// Сделки пришли раньше ордера (в том числе дважды): до <orders> их некуда отнести
+	broker.handleMessage(tradesMessage)
+	broker.handleMessage(tradesMessage)
+	if len(*updates) != 0 {
+		t.Fatalf("updates before order = %+v, want none", *updates)
+	}
+
+	broker.handleMessage(ordersMessage)
+	if len(*updates) != 1 {
+		t.Fatalf("updates = %+v, want one", *updates)
+	}
+	if update := (*updates)[0]; update.OrderID != 7 || update.FilledQuantity != 10 || math.Abs(update.AveragePrice-250.6) > 1e-9 {
+		t.Errorf("update = %+v, want order 7 filled 10 @ 250.6", update)
+	}
+
+	// Следующее сообщение по ордеру сохраняет учтенное исполнение, а сделки не учитываются повторно
+	broker.handleMessage(tradesMessage)
+	broker.handleMessage(ordersMessage)
+	if status, _ := broker.GetOrderStatus(7); status.FilledQuantity != 10 {
+		t.Errorf("filled = %d, want 10", status.FilledQuantity)
+	}
+}
+
+func TestTransaqBrokerPositions(t *testing.T) {
+	broker, _ := newTestTransaqBroker(t, "C1")
+
+	broker.handleMessage(`<positions>` +
+		`<sec_position><seccode>SBER</seccode><client>C1</client><saldo>30</saldo></sec_position>` +
+		`<sec_position><seccode>GAZP</seccode><client>C1</client><saldo>-5</saldo></sec_position>` +
+		`<sec_position><seccode>LKOH</seccode><client>C2</client><saldo>1</saldo></sec_position>` +
+		`<money_position><client>C1</client><saldo>100000</saldo><ordbuy>2500</ordbuy></money_position>` +
+		`<money_position><client>C1</client><currency>USD</currency><saldo>10</saldo></money_position>` +
+		`</positions>`)
+	broker.handleMessage(`<positions><sec_position><seccode>GAZP</seccode><client>C1</client><saldo>0</saldo></sec_position></positions>`)
+
+	positions, _ := broker.GetPositions()
+	if len(positions) != 1 || positions[0].Symbol != "SBER" || positions[0].Quantity != 30 {
+		t.Errorf("positions = %+v, want SBER 30", positions)
+	}
+
+	balances, _ := broker.GetBalances()
+	if rub := balances["RUB"]; rub.Value != 100000 || rub.Available != 97500 || rub.Blocked != 2500 {
+		t.Errorf("RUB balance = %+v", rub)
+	}
+	if usd := balances["USD"]; usd.Value != 10 {
+		t.Errorf("USD balance = %+v", usd)
+	}
+}
@@ -0,0 +1,127 @@
+package connector
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"trading-bot/data"
+	"trading-bot/logger"
+)
+
+// Структура для строки стакана из сообщения <quotes>. Transaq присылает стакан
+// инкрементально: buy/sell - новый объем на уровне, -1 - уровень удален.
+type transaqQuote struct {
+	SecCode string  `xml:"seccode"`
+	Board   string  `xml:"board"`
+	Price   float64 `xml:"price"`
+	Buy     int64   `xml:"buy"`
+	Sell    int64   `xml:"sell"`
+}
+
+// Структура для накопления инкрементальных обновлений стаканов
+type orderBookBuilder struct {
+	mu     sync.Mutex
+	boards map[string]string
+	bids   map[string]map[float64]int64
+	asks   map[string]map[float64]int64
+}
+
+// Функция для создания накопителя стаканов
+func newOrderBookBuilder() *orderBookBuilder {
+	return &orderBookBuilder{
+		boards: make(map[string]string),
+		bids:   make(map[string]map[float64]int64),
+		asks:   make(map[string]map[float64]int64),
+	}
+}
+
+// Функция для подписки на стакан по инструменту
+func (t *TransaqConnector) SubscribeOrderBook(board string, seccode string) error {
+	command := fmt.Sprintf(`<command id="subscribe"><quotes><security><board>%s</board><seccode>%s</seccode></security></quotes></command>`,
+		escape(board), escape(seccode))
+	if err := t.SendMessage(command); err != nil {
+		return fmt.Errorf("error subscribing to order book %s: %w", seccode, err)
+	}
+	logger.Logger.Info().Str("board", board).Str("seccode", seccode).Msg("Subscribed to order book")
+	return nil
+}
+
+// Функция для обработки сообщений <quotes> (обновлений стакана)
+func (t *TransaqConnector) handleQuotes(message string) {
+	if !strings.HasPrefix(message, "<quotes") {
+		return
+	}
+
+	var quotes struct {
+		Quotes []transaqQuote `xml:"quote"`
+	}
+	if err := xml.Unmarshal([]byte(message), &quotes); err != nil {
+		logger.Logger.Error().Err(err).Msg("Failed to parse quotes message")
+		return
+	}
+
+	for _, book := range t.books.apply(quotes.Quotes, time.Now()) {
+		if err := data.UpdateOrderBook(book); err != nil {
+			logger.Logger.Error().Err(err).Str("symbol", book.Symbol).Msg("Failed to update order book")
+		}
+	}
+}
+
+// Функция применяет обновления и возвращает измененные стаканы
+func (b *orderBookBuilder) apply(quotes []transaqQuote, now time.Time) []*data.OrderBook {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	changed := make(map[string]bool)
+	for _, quote := range quotes {
+		if b.bids[quote.SecCode] == nil {
+			b.bids[quote.SecCode] = make(map[float64]int64)
+			b.asks[quote.SecCode] = make(map[float64]int64)
+		}
+		b.boards[quote.SecCode] = quote.Board
+		applyLevel(b.bids[quote.SecCode], quote.Price, quote.Buy)
+		applyLevel(b.asks[quote.SecCode], quote.Price, quote.Sell)
+		changed[quote.SecCode] = true
+	}
+
+	books := make([]*data.OrderBook, 0, len(changed))
+	for symbol := range changed {
+		books = append(books, &data.OrderBook{
+			Symbol: symbol,
+			Board:  b.boards[symbol],
+			Bids:   sortedLevels(b.bids[symbol], true),
+			Asks:   sortedLevels(b.asks[symbol], false),
+			Time:   now,
+		})
+	}
+	return books
+}
+
+// Функция для изменения уровня стакана: 0 - без изменений, -1 - удаление
+func applyLevel(levels map[float64]int64, price float64, quantity int64) {
+	switch {
+	case quantity < 0:
+		delete(levels, price)
+	case quantity > 0:
+		levels[price] = quantity
+	}
+}
+
+// Функция возвращает уровни стакана, отсортированные от лучшей цены
+func sortedLevels(levels map[float64]int64, descending bool) []data.OrderBookLevel {
+	result := make([]data.OrderBookLevel, 0, len(levels))
+	for price, quantity := range levels {
+		result = append(result, data.OrderBookLevel{Price: price, Quantity: quantity})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if descending {
+			return result[i].Price > result[j].Price
+		}
+		return result[i].Price < result[j].Price
+	})
+	return result
+}
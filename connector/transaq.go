@@ -9,6 +9,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"trading-bot/data"
@@ -24,26 +25,47 @@ type TransaqConfig struct {
 
 // Структура для представления подключения к Transaq Connector
 type TransaqConnector struct {
-	config   *TransaqConfig
-	conn     net.Conn
-	messages chan string
-	stop     chan bool
+	config    *TransaqConfig
+	conn      net.Conn
+	messages  chan string // Сообщения для основного цикла (при переполнении старые вытесняются)
+	dropped   int         // Вытесненные из messages сообщения с последнего успешного добавления
+	results   chan string // Ответы <result> на команды, отправленные через SendCommand
+	stop      chan bool
+	commandMu sync.Mutex // Команды с ожиданием ответа выполняются по одной
+	handlerMu sync.RWMutex
+	handlers  []func(message string)
+	events    *messageQueue // Сообщения для обработчиков: обрабатываются вне горутины чтения
+	books     *orderBookBuilder
+
+	disconnectHandlers []func(reason string)
+}
+
+// Структура для ответа Transaq на команду
+type CommandResult struct {
+	Success       bool   `xml:"success,attr"`
+	TransactionID int    `xml:"transactionid,attr"`
+	Message       string `xml:"message"`
 }
 
 // Функция для создания нового объекта TransaqConnector
 func NewTransaqConnector(config *TransaqConfig) (*TransaqConnector, error) {
-	return &TransaqConnector{
+	connector := &TransaqConnector{
 		config:   config,
 		messages: make(chan string, 100), // Буферизованный канал для сообщений
+		results:  make(chan string, 10),
 		stop:     make(chan bool),
-	}, nil
+		books:    newOrderBookBuilder(),
+		events:   newMessageQueue(),
+	}
+	connector.AddMessageHandler(connector.handleQuotes)
+	return connector, nil
 }
 
 // Функция для подключения к серверу Transaq
 func (t *TransaqConnector) Connect() error {
 	// Логирование попытки подключения
 	logger.Logger.Info().Str("host", t.config.Host).Int("port", t.config.Port).Msg("Connecting to Transaq Connector...")
-	address := fmt.Sprintf("%s:%d", t.config.Host, t.config.Port)
+	address := net.JoinHostPort(t.config.Host, strconv.Itoa(t.config.Port))
 	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
 	if err != nil {
 		// Логирование ошибки при подключении
@@ -74,6 +96,78 @@ func (t *TransaqConnector) SendMessage(message string) error {
 	return nil
 }
 
+// Функция для отправки команды и ожидания ответа <result>
+func (t *TransaqConnector) SendCommand(command string, timeout time.Duration) (*CommandResult, error) {
+	t.commandMu.Lock()
+	defer t.commandMu.Unlock()
+
+	// Ответ на предыдущую команду, пришедший после таймаута, не должен считаться ответом на эту
+	for drained := false; !drained; {
+		select {
+		case message := <-t.results:
+			logger.Logger.Warn().Str("message", message).Msg("Late command result dropped")
+		default:
+			drained = true
+		}
+	}
+
+	if err := t.SendMessage(command); err != nil {
+		return nil, err
+	}
+
+	select {
+	case message := <-t.results:
+		var result CommandResult
+		if err := xml.Unmarshal([]byte(message), &result); err != nil {
+			logger.Logger.Error().Err(err).Str("message", message).Msg("Failed to parse command result")
+			return nil, fmt.Errorf("error parsing command result: %w", err)
+		}
+		if !result.Success {
+			logger.Logger.Error().Str("message", result.Message).Msg("Transaq command failed")
+			return &result, fmt.Errorf("transaq command failed: %s", result.Message)
+		}
+		return &result, nil
+	case <-t.stop:
+		return nil, fmt.Errorf("connector stopped")
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("timeout waiting for command result from Transaq Connector")
+	}
+}
+
+// Функция для подписки на все сообщения от сервера. Обработчики вызываются по порядку из
+// отдельной горутины, поэтому могут отправлять команды через SendCommand
+func (t *TransaqConnector) AddMessageHandler(handler func(message string)) {
+	t.handlerMu.Lock()
+	defer t.handlerMu.Unlock()
+	t.handlers = append(t.handlers, handler)
+}
+
+// Функция для подписки на потерю связи с сервером (обрыв соединения или server_status connected="false")
+func (t *TransaqConnector) OnDisconnect(handler func(reason string)) {
+	t.handlerMu.Lock()
+	defer t.handlerMu.Unlock()
+	t.disconnectHandlers = append(t.disconnectHandlers, handler)
+}
+
+// Функция для вызова обработчиков потери связи
+func (t *TransaqConnector) notifyDisconnect(reason string) {
+	// Закрытие подключения через Close - не потеря связи
+	select {
+	case <-t.stop:
+		return
+	default:
+	}
+
+	logger.Logger.Error().Str("reason", reason).Msg("Transaq Connector disconnected")
+
+	t.handlerMu.RLock()
+	handlers := t.disconnectHandlers
+	t.handlerMu.RUnlock()
+	for _, handler := range handlers {
+		handler(reason)
+	}
+}
+
 // Функция для получения сообщения с сервера с таймаутом
 func (t *TransaqConnector) GetMessageWithTimeout(timeout time.Duration) (string, error) {
 	select {
@@ -123,10 +217,15 @@ func (t *TransaqConnector) Authorize() error {
 		return err
 	}
 
-	// Чтение ответа на авторизацию
-	authResponse, err := t.GetMessageWithTimeout(10 * time.Second)
-	if err != nil {
-		return err
+	// Чтение ответа на авторизацию (ответ может прийти как <result> или как статус сервера)
+	var authResponse string
+	select {
+	case authResponse = <-t.messages:
+	case authResponse = <-t.results:
+	case <-t.stop:
+		return fmt.Errorf("connector stopped")
+	case <-time.After(10 * time.Second):
+		return fmt.Errorf("timeout reading message from Transaq Connector")
 	}
 
 	// Проверка успешной авторизации
@@ -208,17 +307,141 @@ func LoadConfigFromEnv() (*TransaqConfig, error) {
 
 // Функция для запуска цикла чтения сообщений с сервера
 func (t *TransaqConnector) StartReading() {
+	go t.dispatchEvents()
+
 	go func() {
 		defer close(t.messages)
+		defer t.events.close()
 		scanner := bufio.NewScanner(t.conn)
 		for scanner.Scan() {
 			message := scanner.Text()
-			t.messages <- message
 			logger.Logger.Debug().Str("message", message).Msg("Message received from Transaq Connector")
+
+			// Обработчики не выполняются в горутине чтения: команды, которые они отправляют,
+			// ждут <result>, а его может доставить только эта горутина
+			t.events.push(message)
+
+			if strings.HasPrefix(message, "<server_status") &&
+				(strings.Contains(message, `connected="false"`) || strings.Contains(message, `connected="error"`)) {
+				t.notifyDisconnect("server status: " + message)
+			}
+
+			// Ответы на команды направляются в SendCommand, остальное - в основной цикл
+			if strings.HasPrefix(message, "<result") {
+				select {
+				case t.results <- message:
+				default:
+					logger.Logger.Warn().Str("message", message).Msg("Unexpected command result dropped")
+				}
+				continue
+			}
+			t.deliver(message)
 		}
 		if err := scanner.Err(); err != nil {
 			// Логирование ошибки при чтении
 			logger.Logger.Error().Err(err).Msg("Error reading message from Transaq Connector")
+			t.notifyDisconnect(err.Error())
+			return
 		}
+		t.notifyDisconnect("connection closed by server")
 	}()
 }
+
+// Функция для передачи сообщения основному циклу без ожидания: горутина чтения не должна
+// блокироваться, иначе SendCommand не получит <result>. Если основной цикл не успевает,
+// самое старое сообщение вытесняется (рыночные данные доходят до обработчиков через events)
+func (t *TransaqConnector) deliver(message string) {
+	for {
+		select {
+		case t.messages <- message:
+			if t.dropped > 0 {
+				logger.Logger.Warn().Int("dropped", t.dropped).Msg("Main loop caught up with Transaq messages")
+				t.dropped = 0
+			}
+			return
+		default:
+		}
+
+		select {
+		case <-t.messages:
+			t.dropped++
+			if t.dropped == 1 {
+				logger.Logger.Warn().Msg("Main loop is behind Transaq messages, dropping the oldest")
+			}
+		default:
+		}
+	}
+}
+
+// Функция для вызова обработчиков сообщений в порядке поступления (до закрытия очереди)
+func (t *TransaqConnector) dispatchEvents() {
+	for {
+		message, ok := t.events.pop()
+		if !ok {
+			return
+		}
+		t.handlerMu.RLock()
+		handlers := t.handlers
+		t.handlerMu.RUnlock()
+		for _, handler := range handlers {
+			handler(message)
+		}
+	}
+}
+
+// Неограниченная очередь сообщений: горутина чтения никогда не ждет обработчиков
+type messageQueue struct {
+	mu     sync.Mutex
+	items  []string
+	ready  chan struct{} // Сигнал о новых сообщениях или закрытии
+	closed bool
+}
+
+// Функция для создания очереди сообщений
+func newMessageQueue() *messageQueue {
+	return &messageQueue{ready: make(chan struct{}, 1)}
+}
+
+// Функция для добавления сообщения в очередь
+func (q *messageQueue) push(message string) {
+	q.mu.Lock()
+	q.items = append(q.items, message)
+	q.mu.Unlock()
+	q.signal()
+}
+
+// Функция для закрытия очереди: оставшиеся сообщения еще будут выданы
+func (q *messageQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.signal()
+}
+
+// Функция для ожидания следующего сообщения (false - очередь закрыта и пуста)
+func (q *messageQueue) pop() (string, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.items) > 0 {
+			message := q.items[0]
+			q.items[0] = ""
+			q.items = q.items[1:]
+			q.mu.Unlock()
+			return message, true
+		}
+		closed := q.closed
+		q.mu.Unlock()
+		if closed {
+			return "", false
+		}
+		<-q.ready
+	}
+}
+
+// Функция для пробуждения ожидающего pop
+func (q *messageQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
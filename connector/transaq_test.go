@@ -0,0 +1,94 @@
+package connector
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Функция создает подключение к имитации Transaq Connector: server - сторона сервера
+func newPipeConnector(t *testing.T) (*TransaqConnector, net.Conn) {
+	t.Helper()
+	client, server := net.Pipe()
+	connector, err := NewTransaqConnector(&TransaqConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	connector.conn = client
+	t.Cleanup(func() {
+		connector.Close()
+		server.Close()
+	})
+	return connector, server
+}
+
+func TestSendCommandWhileMainLoopIsFlooded(t *testing.T) {
+	connector, server := newPipeConnector(t)
+	connector.StartReading()
+
+	const flood = 1000
+	go func() {
+		// Ответ на команду приходит после потока рыночных данных, которые никто не читает
+		if _, err := bufio.NewReader(server).ReadString('\n'); err != nil {
+			return
+		}
+		for i := 0; i < flood; i++ {
+			fmt.Fprintf(server, "<alltrades><trade><tradeno>%d</tradeno></trade></alltrades>\n", i)
+		}
+		fmt.Fprintln(server, `<result success="true" transactionid="42"/>`)
+	}()
+
+	result, err := connector.SendCommand(`<command id="neworder"/>`, 2*time.Second)
+	if err != nil {
+		t.Fatalf("SendCommand error = %v", err)
+	}
+	if result.TransactionID != 42 {
+		t.Errorf("transaction id = %d, want 42", result.TransactionID)
+	}
+
+	// Основной цикл получает самые свежие сообщения, старые вытеснены
+	if got := len(connector.messages); got != cap(connector.messages) {
+		t.Fatalf("buffered messages = %d, want %d", got, cap(connector.messages))
+	}
+	var last string
+	for len(connector.messages) > 0 {
+		last = <-connector.messages
+	}
+	if want := fmt.Sprintf("<tradeno>%d</tradeno>", flood-1); !strings.Contains(last, want) {
+		t.Errorf("last message = %q, want the newest trade", last)
+	}
+}
+
+func TestHandlersSeeEveryMessageWhenMainLoopIsFlooded(t *testing.T) {
+	connector, server := newPipeConnector(t)
+
+	const flood = 500
+	received := make(chan string, flood)
+	connector.AddMessageHandler(func(message string) {
+		if strings.HasPrefix(message, "<alltrades") {
+			received <- message
+		}
+	})
+	connector.StartReading()
+
+	go func() {
+		for i := 0; i < flood; i++ {
+			fmt.Fprintf(server, "<alltrades><trade><tradeno>%d</tradeno></trade></alltrades>\n", i)
+		}
+	}()
+
+	timeout := time.After(2 * time.Second)
+	for i := 0; i < flood; i++ {
+		select {
+		case message := <-received:
+			if want := fmt.Sprintf("<tradeno>%d</tradeno>", i); !strings.Contains(message, want) {
+				t.Fatalf("message %d = %q, want %s", i, message, want)
+			}
+		case <-timeout:
+			t.Fatalf("handlers received %d of %d messages", i, flood)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"trading-bot/logger"
+)
+
+// Структура для купона облигации
+type Coupon struct {
+	StartDate time.Time `json:"start_date"` // Начало купонного периода (с него начисляется НКД)
+	Date      time.Time `json:"date"`       // Дата выплаты купона
+	Value     float64   `json:"value"`      // Размер купона на одну облигацию (в валюте номинала)
+	Percent   float64   `json:"percent"`    // Ставка купона (в процентах годовых)
+}
+
+// Структура для оферты (досрочного выкупа облигации эмитентом по требованию держателя)
+type Offer struct {
+	Date  time.Time `json:"date"`  // Дата выкупа
+	Price float64   `json:"price"` // Цена выкупа (в процентах от номинала, 0 - по номиналу)
+	Type  string    `json:"type"`  // Тип оферты
+}
+
+// Структура для облигации (ОФЗ, корпоративные). Цена облигации - процент от номинала, при
+// расчетах по сделке покупатель дополнительно платит продавцу накопленный купонный доход (НКД)
+type Bond struct {
+	Symbol       string    `json:"symbol"`        // Код облигации (например, SU26238RMFS4)
+	ShortName    string    `json:"short_name"`    // Краткое наименование
+	FaceValue    float64   `json:"face_value"`    // Номинал одной облигации
+	Currency     string    `json:"currency"`      // Валюта номинала
+	MaturityDate time.Time `json:"maturity_date"` // Дата погашения (пустая - бессрочная облигация)
+	Coupons      []Coupon  `json:"coupons"`       // Купоны по возрастанию даты выплаты
+	Offers       []Offer   `json:"offers"`        // Оферты по возрастанию даты
+}
+
+// Тип денежного потока по облигации
+type BondCashflowKind string
+
+const (
+	BondCashflowCoupon     BondCashflowKind = "coupon"     // Выплата купона
+	BondCashflowRedemption BondCashflowKind = "redemption" // Погашение номинала
+)
+
+// Структура для денежного потока по одной облигации
+type BondCashflow struct {
+	Date   time.Time        `json:"date"`
+	Kind   BondCashflowKind `json:"kind"`
+	Amount float64          `json:"amount"`
+}
+
+// Функция возвращает цену облигации в деньгах (в валюте номинала) по цене в процентах от номинала
+func (b *Bond) CleanValue(price float64) float64 {
+	return price / 100 * b.FaceValue
+}
+
+// Функция возвращает дату расчетов по сделке: облигации торгуются в режиме T+1
+// (до перехода Московской биржи на T+1 - T+0)
+func (b *Bond) SettlementDate(t time.Time) time.Time {
+	t = t.In(ExchangeLocation)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, ExchangeLocation)
+	if day.Before(moexT1Date) {
+		return day
+	}
+	return AddTradingDays(day, 1)
+}
+
+// Функция возвращает НКД одной облигации на дату расчетов: купон текущего периода,
+// пропорциональный числу прошедших дней периода, с округлением до копейки
+func (b *Bond) AccruedInterest(settlement time.Time) float64 {
+	coupon, ok := b.currentCoupon(settlement)
+	if !ok {
+		return 0
+	}
+	period := coupon.Date.Sub(coupon.StartDate).Hours() / 24
+	if period <= 0 {
+		return 0
+	}
+	elapsed := math.Round(settlement.Sub(coupon.StartDate).Hours() / 24)
+	return math.Round(coupon.Value*elapsed/math.Round(period)*100) / 100
+}
+
+// Функция возвращает купон периода, в который попадает дата
+func (b *Bond) currentCoupon(t time.Time) (Coupon, bool) {
+	for _, coupon := range b.Coupons {
+		if !t.Before(coupon.StartDate) && t.Before(coupon.Date) {
+			return coupon, true
+		}
+	}
+	return Coupon{}, false
+}
+
+// Функция возвращает цену облигации с НКД (в деньгах) для сделки в момент t
+func (b *Bond) DirtyValue(price float64, t time.Time) float64 {
+	return b.CleanValue(price) + b.AccruedInterest(b.SettlementDate(t))
+}
+
+// Функция возвращает денежные потоки по одной облигации с датой в интервале (from, to]:
+// купоны и погашение номинала
+func (b *Bond) CashflowsBetween(from, to time.Time) []BondCashflow {
+	var cashflows []BondCashflow
+	for _, coupon := range b.Coupons {
+		if coupon.Date.After(from) && !coupon.Date.After(to) {
+			cashflows = append(cashflows, BondCashflow{Date: coupon.Date, Kind: BondCashflowCoupon, Amount: coupon.Value})
+		}
+	}
+	if !b.MaturityDate.IsZero() && b.MaturityDate.After(from) && !b.MaturityDate.After(to) {
+		cashflows = append(cashflows, BondCashflow{Date: b.MaturityDate, Kind: BondCashflowRedemption, Amount: b.FaceValue})
+	}
+	return cashflows
+}
+
+// Функция возвращает ближайшую оферту после момента t
+func (b *Bond) NextOffer(t time.Time) (Offer, bool) {
+	for _, offer := range b.Offers {
+		if offer.Date.After(t) {
+			return offer, true
+		}
+	}
+	return Offer{}, false
+}
+
+// Функция возвращает эффективную доходность к погашению (в процентах годовых) при покупке
+// по цене price (в процентах от номинала) в момент t
+func (b *Bond) YieldToMaturity(price float64, t time.Time) (float64, error) {
+	if b.MaturityDate.IsZero() {
+		return 0, fmt.Errorf("%s: bond has no maturity date", b.Symbol)
+	}
+	return b.yieldTo(price, t, b.MaturityDate, b.FaceValue)
+}
+
+// Функция возвращает эффективную доходность к ближайшей оферте (в процентах годовых)
+func (b *Bond) YieldToOffer(price float64, t time.Time) (float64, error) {
+	offer, ok := b.NextOffer(b.SettlementDate(t))
+	if !ok {
+		return 0, fmt.Errorf("%s: bond has no upcoming offer", b.Symbol)
+	}
+	offerPrice := offer.Price
+	if offerPrice <= 0 {
+		offerPrice = 100
+	}
+	return b.yieldTo(price, t, offer.Date, b.CleanValue(offerPrice))
+}
+
+// Функция для расчета эффективной доходности: ставка, при которой дисконтированные
+// (по сроку в днях / 365) купоны до даты end и выплата redemption в дату end равны цене с НКД
+func (b *Bond) yieldTo(price float64, t, end time.Time, redemption float64) (float64, error) {
+	settlement := b.SettlementDate(t)
+	if !end.After(settlement) {
+		return 0, fmt.Errorf("%s: bond is already redeemed", b.Symbol)
+	}
+	dirty := b.CleanValue(price) + b.AccruedInterest(settlement)
+	if dirty <= 0 {
+		return 0, fmt.Errorf("%s: invalid price %g", b.Symbol, price)
+	}
+
+	type cashflow struct {
+		years  float64
+		amount float64
+	}
+	var cashflows []cashflow
+	for _, coupon := range b.Coupons {
+		if coupon.Date.After(settlement) && !coupon.Date.After(end) {
+			cashflows = append(cashflows, cashflow{coupon.Date.Sub(settlement).Hours() / 24 / 365, coupon.Value})
+		}
+	}
+	cashflows = append(cashflows, cashflow{end.Sub(settlement).Hours() / 24 / 365, redemption})
+
+	presentValue := func(rate float64) float64 {
+		value := 0.0
+		for _, cf := range cashflows {
+			value += cf.amount / math.Pow(1+rate, cf.years)
+		}
+		return value
+	}
+
+	// Приведенная стоимость убывает по ставке: поиск делением отрезка пополам
+	low, high := -0.99, 10.0
+	if presentValue(low) < dirty || presentValue(high) > dirty {
+		return 0, fmt.Errorf("%s: yield is out of range for price %g", b.Symbol, price)
+	}
+	for i := 0; i < 200 && high-low > 1e-10; i++ {
+		middle := (low + high) / 2
+		if presentValue(middle) > dirty {
+			low = middle
+		} else {
+			high = middle
+		}
+	}
+	return (low + high) / 2 * 100, nil
+}
+
+// Функция для загрузки облигаций из локального JSON файла
+func LoadBondsFromFile(filename string) ([]Bond, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading bonds file: %w", err)
+	}
+
+	var bonds []Bond
+	if err := json.Unmarshal(content, &bonds); err != nil {
+		return nil, fmt.Errorf("error decoding bonds file: %w", err)
+	}
+	for i := range bonds {
+		bonds[i].normalize()
+	}
+	return bonds, nil
+}
+
+// Функция для загрузки параметров облигации, купонов и оферт с ISS API Московской биржи
+func LoadBondFromMOEX(symbol string) (*Bond, error) {
+	var description struct {
+		Securities issTable `json:"securities"`
+	}
+	url := fmt.Sprintf("https://iss.moex.com/iss/engines/stock/markets/bonds/securities/%s.json?iss.meta=off&iss.only=securities", symbol)
+	if err := fetchISS(url, &description); err != nil {
+		return nil, err
+	}
+	rows := description.Securities.Rows()
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("bond %s not found", symbol)
+	}
+
+	row := rows[0]
+	bond := &Bond{
+		Symbol:    symbol,
+		ShortName: issString(row, "SHORTNAME"),
+		Currency:  issCurrency(issString(row, "FACEUNIT")),
+	}
+	var err error
+	if bond.FaceValue, err = issFloat(row, "FACEVALUE"); err != nil {
+		return nil, fmt.Errorf("error parsing bond face value: %w", err)
+	}
+	if matDate := issString(row, "MATDATE"); matDate != "" && matDate != "0000-00-00" {
+		if bond.MaturityDate, err = issDate(row, "MATDATE"); err != nil {
+			return nil, fmt.Errorf("error parsing bond maturity date: %w", err)
+		}
+	}
+
+	var bondization struct {
+		Coupons issTable `json:"coupons"`
+		Offers  issTable `json:"offers"`
+	}
+	url = fmt.Sprintf("https://iss.moex.com/iss/securities/%s/bondization.json?iss.meta=off&iss.only=coupons,offers&limit=unlimited", symbol)
+	if err := fetchISS(url, &bondization); err != nil {
+		return nil, err
+	}
+
+	for _, row := range bondization.Coupons.Rows() {
+		coupon := Coupon{}
+		if coupon.Date, err = issDate(row, "coupondate"); err != nil {
+			return nil, fmt.Errorf("error parsing coupon date: %w", err)
+		}
+		// Даты начала периода может не быть - тогда период начинается с прошлого купона
+		coupon.StartDate, _ = issDate(row, "startdate")
+		// Размер будущих купонов плавающей ставки еще не известен
+		coupon.Value, _ = issFloat(row, "value")
+		coupon.Percent, _ = issFloat(row, "valueprc")
+		bond.Coupons = append(bond.Coupons, coupon)
+	}
+	for _, row := range bondization.Offers.Rows() {
+		offer := Offer{Type: issString(row, "offertype")}
+		if offer.Date, err = issDate(row, "offerdate"); err != nil {
+			return nil, fmt.Errorf("error parsing offer date: %w", err)
+		}
+		offer.Price, _ = issFloat(row, "price")
+		bond.Offers = append(bond.Offers, offer)
+	}
+	bond.normalize()
+
+	logger.Logger.Info().
+		Str("symbol", symbol).
+		Float64("face_value", bond.FaceValue).
+		Time("maturity", bond.MaturityDate).
+		Int("coupons", len(bond.Coupons)).
+		Int("offers", len(bond.Offers)).
+		Msg("Bond description received successfully")
+
+	return bond, nil
+}
+
+// Функция для упорядочивания купонов и оферт и заполнения пропусков: начало периода - дата
+// прошлого купона (для первого - по длительности следующего периода), неизвестный размер
+// купона - по ставке, иначе как у прошлого купона
+func (b *Bond) normalize() {
+	sort.SliceStable(b.Coupons, func(i, j int) bool { return b.Coupons[i].Date.Before(b.Coupons[j].Date) })
+	sort.SliceStable(b.Offers, func(i, j int) bool { return b.Offers[i].Date.Before(b.Offers[j].Date) })
+
+	for i := range b.Coupons {
+		coupon := &b.Coupons[i]
+		if coupon.StartDate.IsZero() {
+			if i > 0 {
+				coupon.StartDate = b.Coupons[i-1].Date
+			} else if len(b.Coupons) > 1 {
+				coupon.StartDate = coupon.Date.Add(-b.Coupons[1].Date.Sub(coupon.Date))
+			}
+		}
+		if coupon.Value <= 0 && coupon.Percent > 0 && !coupon.StartDate.IsZero() {
+			days := math.Round(coupon.Date.Sub(coupon.StartDate).Hours() / 24)
+			coupon.Value = math.Round(b.FaceValue*coupon.Percent/100*days/365*100) / 100
+		}
+		if coupon.Value <= 0 && i > 0 {
+			coupon.Value = b.Coupons[i-1].Value
+		}
+	}
+}
+
+// Функция для приведения кода валюты ISS к коду валюты счета (SUR - рубль)
+func issCurrency(code string) string {
+	if code == "" || code == "SUR" || code == "RUR" {
+		return RubCurrency
+	}
+	return code
+}
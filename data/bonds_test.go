@@ -0,0 +1,192 @@
+package data
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBondSettlementDate(t *testing.T) {
+	at := func(year int, month time.Month, day, hour int) time.Time {
+		return time.Date(year, month, day, hour, 0, 0, 0, ExchangeLocation)
+	}
+
+	tests := []struct {
+		name string
+		t    time.Time
+		want time.Time
+	}{
+		{name: "T+0 before switch", t: at(2023, time.July, 27, 15), want: exchangeDate(2023, time.July, 27)},
+		{name: "T+1", t: at(2025, time.March, 3, 15), want: exchangeDate(2025, time.March, 4)},
+		{name: "T+1 over weekend", t: at(2025, time.March, 7, 15), want: exchangeDate(2025, time.March, 10)},
+		{name: "T+1 over holiday", t: at(2025, time.June, 11, 15), want: exchangeDate(2025, time.June, 13)},
+		{name: "UTC evening is next exchange day", t: time.Date(2025, time.March, 3, 22, 0, 0, 0, time.UTC), want: exchangeDate(2025, time.March, 5)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bond := Bond{Symbol: "SU26238"}
+			if got := bond.SettlementDate(tt.t); !got.Equal(tt.want) {
+				t.Errorf("SettlementDate = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBondAccruedInterest(t *testing.T) {
+	// Полугодовые купоны по 40 на номинал 1000 (182 дня в первом периоде)
+	bond := Bond{
+		Symbol:    "SU26238",
+		FaceValue: 1000,
+		Coupons: []Coupon{
+			{StartDate: exchangeDate(2025, time.March, 4), Date: exchangeDate(2025, time.September, 2), Value: 40},
+			{StartDate: exchangeDate(2025, time.September, 2), Date: exchangeDate(2026, time.March, 3), Value: 40},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		settlement time.Time
+		want       float64
+	}{
+		{name: "start of period", settlement: exchangeDate(2025, time.March, 4), want: 0},
+		{name: "middle of period", settlement: exchangeDate(2025, time.June, 2), want: 19.78},
+		{name: "day before payment", settlement: exchangeDate(2025, time.September, 1), want: 39.78},
+		{name: "payment date starts next period", settlement: exchangeDate(2025, time.September, 2), want: 0},
+		{name: "before first coupon", settlement: exchangeDate(2025, time.March, 1), want: 0},
+		{name: "after last coupon", settlement: exchangeDate(2026, time.March, 3), want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bond.AccruedInterest(tt.settlement); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("AccruedInterest = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Цена с НКД: сделка 30.05.2025 (пятница), расчеты 02.06.2025
+	trade := time.Date(2025, time.May, 30, 12, 0, 0, 0, ExchangeLocation)
+	if got, want := bond.DirtyValue(98.5, trade), 985+19.78; math.Abs(got-want) > 1e-9 {
+		t.Errorf("DirtyValue = %v, want %v", got, want)
+	}
+}
+
+func TestBondYieldToMaturity(t *testing.T) {
+	// Сделка 03.03.2025, расчеты 04.03.2025; годовые периоды по 365 дней
+	trade := time.Date(2025, time.March, 3, 12, 0, 0, 0, ExchangeLocation)
+	settlement := exchangeDate(2025, time.March, 4)
+	oneYear := exchangeDate(2026, time.March, 4)
+	twoYears := exchangeDate(2027, time.March, 4)
+	annual := []Coupon{
+		{StartDate: settlement, Date: oneYear, Value: 100},
+		{StartDate: oneYear, Date: twoYears, Value: 100},
+	}
+
+	tests := []struct {
+		name    string
+		bond    Bond
+		price   float64
+		want    float64
+		wantErr bool
+	}{
+		{
+			name:  "zero coupon",
+			bond:  Bond{FaceValue: 1000, MaturityDate: oneYear},
+			price: 90,
+			want:  (1000.0/900 - 1) * 100,
+		},
+		{
+			name:  "coupon bond at par",
+			bond:  Bond{FaceValue: 1000, MaturityDate: twoYears, Coupons: annual},
+			price: 100,
+			want:  10,
+		},
+		{
+			name:  "coupon bond below par",
+			bond:  Bond{FaceValue: 1000, MaturityDate: oneYear, Coupons: annual[:1]},
+			price: 95,
+			want:  (1100.0/950 - 1) * 100,
+		},
+		{
+			name:  "accrued interest is paid by buyer",
+			bond:  Bond{FaceValue: 1000, MaturityDate: oneYear, Coupons: []Coupon{{StartDate: exchangeDate(2024, time.March, 4), Date: oneYear, Value: 200}}},
+			price: 100,
+			// НКД 365 из 730 дней периода - 100, цена с НКД 1100, выплата 1200
+			want: (1200.0/1100 - 1) * 100,
+		},
+		{name: "no maturity", bond: Bond{FaceValue: 1000}, price: 100, wantErr: true},
+		{name: "already redeemed", bond: Bond{FaceValue: 1000, MaturityDate: settlement}, price: 100, wantErr: true},
+		{name: "invalid price", bond: Bond{FaceValue: 1000, MaturityDate: oneYear}, price: 0, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.bond.YieldToMaturity(tt.price, trade)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("YieldToMaturity error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && math.Abs(got-tt.want) > 1e-6 {
+				t.Errorf("YieldToMaturity = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBondYieldToOffer(t *testing.T) {
+	trade := time.Date(2025, time.March, 3, 12, 0, 0, 0, ExchangeLocation)
+	offerDate := exchangeDate(2026, time.March, 4)
+	bond := Bond{
+		FaceValue:    1000,
+		MaturityDate: exchangeDate(2030, time.March, 4),
+		Offers:       []Offer{{Date: exchangeDate(2024, time.March, 4)}, {Date: offerDate, Price: 101}},
+	}
+
+	got, err := bond.YieldToOffer(90, trade)
+	if err != nil {
+		t.Fatalf("YieldToOffer error = %v", err)
+	}
+	if want := (1010.0/900 - 1) * 100; math.Abs(got-want) > 1e-6 {
+		t.Errorf("YieldToOffer = %v, want %v", got, want)
+	}
+
+	bond.Offers = bond.Offers[:1]
+	if _, err := bond.YieldToOffer(90, trade); err == nil {
+		t.Error("YieldToOffer without upcoming offer must fail")
+	}
+}
+
+func TestBondCashflowsBetween(t *testing.T) {
+	bond := Bond{
+		FaceValue:    1000,
+		MaturityDate: exchangeDate(2026, time.March, 3),
+		Coupons: []Coupon{
+			{Date: exchangeDate(2025, time.September, 2), Value: 40},
+			{Date: exchangeDate(2026, time.March, 3), Value: 40},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     []BondCashflowKind
+	}{
+		{name: "first coupon", from: exchangeDate(2025, time.September, 1), to: exchangeDate(2025, time.September, 2), want: []BondCashflowKind{BondCashflowCoupon}},
+		{name: "excludes from date", from: exchangeDate(2025, time.September, 2), to: exchangeDate(2025, time.December, 1)},
+		{name: "last coupon and redemption", from: exchangeDate(2025, time.December, 1), to: exchangeDate(2026, time.March, 3), want: []BondCashflowKind{BondCashflowCoupon, BondCashflowRedemption}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cashflows := bond.CashflowsBetween(tt.from, tt.to)
+			if len(cashflows) != len(tt.want) {
+				t.Fatalf("cashflows = %+v, want kinds %v", cashflows, tt.want)
+			}
+			for i, kind := range tt.want {
+				if cashflows[i].Kind != kind {
+					t.Errorf("cashflow[%d] kind = %s, want %s", i, cashflows[i].Kind, kind)
+				}
+			}
+		})
+	}
+}
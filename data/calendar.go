@@ -0,0 +1,100 @@
+package data
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Ежегодные нерабочие дни Московской биржи (месяц, день): праздники, в которые торги
+// не проводятся при любом переносе выходных
+var moexFixedHolidays = []struct {
+	month time.Month
+	day   int
+}{
+	{time.January, 1},
+	{time.January, 2},
+	{time.January, 7},
+	{time.February, 23},
+	{time.March, 8},
+	{time.May, 1},
+	{time.May, 9},
+	{time.June, 12},
+	{time.November, 4},
+	{time.December, 31},
+}
+
+// Календарь торговых дней: дополнительные нерабочие дни (переносы праздников) и рабочие выходные
+var calendar = struct {
+	mu       sync.RWMutex
+	holidays map[string]bool
+	workdays map[string]bool
+}{
+	holidays: make(map[string]bool),
+	workdays: make(map[string]bool),
+}
+
+// Функция для добавления нерабочих дней биржи в формате "2006-01-02" (переносы праздников)
+func AddExchangeHolidays(dates ...string) error {
+	return addCalendarDates(calendar.holidays, dates)
+}
+
+// Функция для добавления рабочих выходных биржи в формате "2006-01-02"
+func AddExchangeWorkdays(dates ...string) error {
+	return addCalendarDates(calendar.workdays, dates)
+}
+
+func addCalendarDates(target map[string]bool, dates []string) error {
+	calendar.mu.Lock()
+	defer calendar.mu.Unlock()
+
+	for _, date := range dates {
+		day, err := time.ParseInLocation("2006-01-02", date, ExchangeLocation)
+		if err != nil {
+			return fmt.Errorf("invalid exchange calendar date %q: %w", date, err)
+		}
+		target[day.Format("2006-01-02")] = true
+	}
+	return nil
+}
+
+// Функция проверяет, проводятся ли торги на бирже в день t (по времени биржи)
+func IsTradingDay(t time.Time) bool {
+	t = t.In(ExchangeLocation)
+	key := t.Format("2006-01-02")
+
+	calendar.mu.RLock()
+	holiday, workday := calendar.holidays[key], calendar.workdays[key]
+	calendar.mu.RUnlock()
+
+	if holiday {
+		return false
+	}
+	if workday {
+		return true
+	}
+	if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		return false
+	}
+	for _, fixed := range moexFixedHolidays {
+		if t.Month() == fixed.month && t.Day() == fixed.day {
+			return false
+		}
+	}
+	return true
+}
+
+// Функция сдвигает дату на days торговых дней биржи
+func AddTradingDays(t time.Time, days int) time.Time {
+	step := 1
+	if days < 0 {
+		step, days = -1, -days
+	}
+	for days > 0 {
+		t = t.AddDate(0, 0, step)
+		if IsTradingDay(t) {
+			days--
+		}
+	}
+	return t
+}
@@ -0,0 +1,70 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsTradingDay(t *testing.T) {
+	if err := AddExchangeHolidays("2030-03-11"); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddExchangeWorkdays("2030-03-16"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		date string
+		want bool
+	}{
+		{"2024-07-05", true},  // Пятница
+		{"2024-07-06", false}, // Суббота
+		{"2024-07-07", false}, // Воскресенье
+		{"2024-01-02", false}, // Новогодние каникулы
+		{"2024-05-09", false}, // День Победы
+		{"2024-06-12", false}, // День России
+		{"2024-12-31", false},
+		{"2030-03-11", false}, // Добавленный неторговый день
+		{"2030-03-16", true},  // Добавленная торговая суббота
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.date, func(t *testing.T) {
+			day, _ := time.ParseInLocation("2006-01-02", tt.date, ExchangeLocation)
+			if got := IsTradingDay(day); got != tt.want {
+				t.Errorf("IsTradingDay(%s) = %v, want %v", tt.date, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddExchangeHolidaysRejectsInvalidDate(t *testing.T) {
+	if err := AddExchangeHolidays("11.03.2030"); err == nil {
+		t.Error("expected error for invalid date")
+	}
+}
+
+func TestAddTradingDays(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		days int
+		want string
+	}{
+		{"next day", "2024-07-03", 1, "2024-07-04"},
+		{"over weekend", "2024-07-05", 1, "2024-07-08"},
+		{"back over weekend", "2024-07-08", -1, "2024-07-05"},
+		{"back over holiday", "2024-05-10", -1, "2024-05-08"},
+		{"back over new year", "2024-01-03", -1, "2023-12-29"},
+		{"zero days", "2024-07-06", 0, "2024-07-06"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, _ := time.ParseInLocation("2006-01-02", tt.from, ExchangeLocation)
+			if got := AddTradingDays(from, tt.days).Format("2006-01-02"); got != tt.want {
+				t.Errorf("AddTradingDays(%s, %d) = %s, want %s", tt.from, tt.days, got, tt.want)
+			}
+		})
+	}
+}
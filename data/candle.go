@@ -0,0 +1,126 @@
+package data
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-gota/gota/dataframe"
+	"github.com/go-gota/gota/series"
+)
+
+// Имена столбцов DataFrame со свечами. Одна и та же схема используется
+// в боевом цикле и в бэктесте, поэтому стратегии могут на неё полагаться.
+const (
+	ColumnTime   = "Time"
+	ColumnOpen   = "Open"
+	ColumnHigh   = "High"
+	ColumnLow    = "Low"
+	ColumnClose  = "Close"
+	ColumnVolume = "Volume"
+)
+
+// Часовой пояс Московской биржи. Если база часовых поясов недоступна,
+// используем фиксированное смещение UTC+3.
+var ExchangeLocation = loadExchangeLocation()
+
+func loadExchangeLocation() *time.Location {
+	location, err := time.LoadLocation("Europe/Moscow")
+	if err != nil {
+		return time.FixedZone("MSK", 3*60*60)
+	}
+	return location
+}
+
+// Структура для одной свечи. Время свечи хранится во времени биржи
+type Candle struct {
+	Time   time.Time `json:"time"`   // Время открытия свечи (MSK)
+	Open   float64   `json:"open"`   // Цена открытия
+	High   float64   `json:"high"`   // Максимальная цена
+	Low    float64   `json:"low"`    // Минимальная цена
+	Close  float64   `json:"close"`  // Цена закрытия
+	Volume float64   `json:"volume"` // Объем
+}
+
+// Структура для ряда свечей по одному инструменту
+type CandleSeries struct {
+	Symbol   string   `json:"symbol"`   // Тикер инструмента
+	Interval string   `json:"interval"` // Интервал свечей ("1d", "1h" и т.д.)
+	Candles  []Candle `json:"candles"`  // Свечи в порядке возрастания времени
+}
+
+// Функция для преобразования ответа Finam в ряд свечей
+func NewCandleSeriesFromFinam(symbol string, interval string, finamData *FinamData) *CandleSeries {
+	candles := make([]Candle, 0, len(finamData.C))
+	for _, c := range finamData.C {
+		candles = append(candles, Candle{
+			Time:   time.Unix(c.T, 0).In(ExchangeLocation),
+			Open:   c.O,
+			High:   c.H,
+			Low:    c.L,
+			Close:  c.C,
+			Volume: c.V,
+		})
+	}
+
+	return &CandleSeries{
+		Symbol:   symbol,
+		Interval: interval,
+		Candles:  candles,
+	}
+}
+
+// Функция возвращает количество свечей в ряду
+func (s *CandleSeries) Len() int {
+	return len(s.Candles)
+}
+
+// Функция возвращает последнюю свечу ряда
+func (s *CandleSeries) Last() (Candle, error) {
+	if len(s.Candles) == 0 {
+		return Candle{}, fmt.Errorf("candle series for %s is empty", s.Symbol)
+	}
+	return s.Candles[len(s.Candles)-1], nil
+}
+
+// Функция возвращает подряд свечей [from, to). Свечи не копируются
+func (s *CandleSeries) Slice(from, to int) *CandleSeries {
+	return &CandleSeries{
+		Symbol:   s.Symbol,
+		Interval: s.Interval,
+		Candles:  s.Candles[from:to],
+	}
+}
+
+// Функция для преобразования ряда свечей в DataFrame со столбцами
+// Time, Open, High, Low, Close, Volume. Время записывается в формате RFC3339 (MSK)
+func (s *CandleSeries) ToDataFrame() dataframe.DataFrame {
+	times := make([]string, len(s.Candles))
+	opens := make([]float64, len(s.Candles))
+	highs := make([]float64, len(s.Candles))
+	lows := make([]float64, len(s.Candles))
+	closes := make([]float64, len(s.Candles))
+	volumes := make([]float64, len(s.Candles))
+
+	for i, c := range s.Candles {
+		times[i] = c.Time.In(ExchangeLocation).Format(time.RFC3339)
+		opens[i] = c.Open
+		highs[i] = c.High
+		lows[i] = c.Low
+		closes[i] = c.Close
+		volumes[i] = c.Volume
+	}
+
+	return dataframe.New(
+		series.New(times, series.String, ColumnTime),
+		series.New(opens, series.Float, ColumnOpen),
+		series.New(highs, series.Float, ColumnHigh),
+		series.New(lows, series.Float, ColumnLow),
+		series.New(closes, series.Float, ColumnClose),
+		series.New(volumes, series.Float, ColumnVolume),
+	)
+}
+
+// Функция для преобразования ответа Finam сразу в DataFrame со стандартной схемой
+func FinamDataToDataFrame(symbol string, interval string, finamData *FinamData) dataframe.DataFrame {
+	return NewCandleSeriesFromFinam(symbol, interval, finamData).ToDataFrame()
+}
@@ -0,0 +1,256 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"trading-bot/logger"
+)
+
+// Тип корпоративного действия
+type CorporateActionKind string
+
+const (
+	CorporateActionDividend CorporateActionKind = "dividend" // Выплата дивиденда
+	CorporateActionSplit    CorporateActionKind = "split"    // Сплит или консолидация акций
+)
+
+// Структура для корпоративного действия по инструменту
+type CorporateAction struct {
+	Symbol   string              `json:"symbol"`   // Тикер инструмента
+	Kind     CorporateActionKind `json:"kind"`     // Тип действия
+	ExDate   time.Time           `json:"ex_date"`  // Первый день торгов без права на дивиденд / после сплита
+	Amount   float64             `json:"amount"`   // Дивиденд на одну акцию
+	Currency string              `json:"currency"` // Валюта дивиденда
+	Ratio    float64             `json:"ratio"`    // Количество новых акций за одну старую (для сплита)
+}
+
+// Дата перехода Московской биржи на режим расчетов T+1
+var moexT1Date = time.Date(2023, time.July, 31, 0, 0, 0, 0, ExchangeLocation)
+
+// Функция для загрузки корпоративных действий из локального JSON файла
+func LoadCorporateActionsFromFile(filename string) ([]CorporateAction, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading corporate actions file: %w", err)
+	}
+
+	var actions []CorporateAction
+	if err := json.Unmarshal(content, &actions); err != nil {
+		return nil, fmt.Errorf("error decoding corporate actions file: %w", err)
+	}
+
+	sortCorporateActions(actions)
+	return actions, nil
+}
+
+// Функция для загрузки дивидендов и сплитов по инструменту с ISS API Московской биржи
+func LoadCorporateActionsFromMOEX(symbol string) ([]CorporateAction, error) {
+	dividends, err := loadDividendsFromMOEX(symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	splits, err := loadSplitsFromMOEX(symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	actions := append(dividends, splits...)
+	sortCorporateActions(actions)
+
+	logger.Logger.Info().
+		Str("symbol", symbol).
+		Int("dividends", len(dividends)).
+		Int("splits", len(splits)).
+		Msg("Corporate actions received successfully")
+
+	return actions, nil
+}
+
+// Функция для загрузки дивидендов с ISS API
+func loadDividendsFromMOEX(symbol string) ([]CorporateAction, error) {
+	var response struct {
+		Dividends issTable `json:"dividends"`
+	}
+	url := fmt.Sprintf("https://iss.moex.com/iss/securities/%s/dividends.json", symbol)
+	if err := fetchISS(url, &response); err != nil {
+		return nil, err
+	}
+
+	var actions []CorporateAction
+	for _, row := range response.Dividends.Rows() {
+		registryDate, err := issDate(row, "registryclosedate")
+		if err != nil {
+			return nil, fmt.Errorf("error parsing dividend registry date: %w", err)
+		}
+		amount, err := issFloat(row, "value")
+		if err != nil {
+			return nil, fmt.Errorf("error parsing dividend value: %w", err)
+		}
+		actions = append(actions, CorporateAction{
+			Symbol:   symbol,
+			Kind:     CorporateActionDividend,
+			ExDate:   exDateFromRegistryDate(registryDate),
+			Amount:   amount,
+			Currency: issString(row, "currencyid"),
+		})
+	}
+	return actions, nil
+}
+
+// Функция для загрузки сплитов с ISS API
+func loadSplitsFromMOEX(symbol string) ([]CorporateAction, error) {
+	var response struct {
+		Splits issTable `json:"splits"`
+	}
+	url := fmt.Sprintf("https://iss.moex.com/iss/statistics/engines/stock/splits/%s.json", symbol)
+	if err := fetchISS(url, &response); err != nil {
+		return nil, err
+	}
+
+	var actions []CorporateAction
+	for _, row := range response.Splits.Rows() {
+		tradeDate, err := issDate(row, "tradedate")
+		if err != nil {
+			return nil, fmt.Errorf("error parsing split trade date: %w", err)
+		}
+		before, err := issFloat(row, "before")
+		if err != nil {
+			return nil, fmt.Errorf("error parsing split ratio: %w", err)
+		}
+		after, err := issFloat(row, "after")
+		if err != nil {
+			return nil, fmt.Errorf("error parsing split ratio: %w", err)
+		}
+		if before <= 0 || after <= 0 {
+			continue
+		}
+		actions = append(actions, CorporateAction{
+			Symbol: symbol,
+			Kind:   CorporateActionSplit,
+			ExDate: tradeDate,
+			Ratio:  after / before,
+		})
+	}
+	return actions, nil
+}
+
+// Функция рассчитывает дату отсечки (первый день торгов без дивиденда) по дате закрытия реестра.
+// В реестр попадают покупки, расчеты по которым прошли не позже даты реестра: после перехода
+// на T+1 последний день с дивидендом - торговый день перед реестром, до перехода (T+2) - за два
+// торговых дня до реестра. Отсечка - следующий за ним торговый день.
+func exDateFromRegistryDate(registryDate time.Time) time.Time {
+	settlementDays := 2
+	if !registryDate.Before(moexT1Date) {
+		settlementDays = 1
+	}
+	// Последний день расчетов не позже даты реестра
+	lastSettlement := registryDate
+	for !IsTradingDay(lastSettlement) {
+		lastSettlement = lastSettlement.AddDate(0, 0, -1)
+	}
+	lastWithDividend := AddTradingDays(lastSettlement, -settlementDays)
+	return AddTradingDays(lastWithDividend, 1)
+}
+
+// Функция для сортировки корпоративных действий по дате
+func sortCorporateActions(actions []CorporateAction) {
+	sort.SliceStable(actions, func(i, j int) bool {
+		return actions[i].ExDate.Before(actions[j].ExDate)
+	})
+}
+
+// Функция для обратной корректировки ряда свечей на корпоративные действия.
+// Цены до даты отсечки умножаются на накопленный коэффициент, последняя цена остается реальной.
+// Исходный ряд не изменяется. Если includeDividends = false, корректируются только сплиты
+// (дивиденды в этом случае можно зачислять деньгами, см. backtest).
+func AdjustCandleSeries(raw *CandleSeries, actions []CorporateAction, includeDividends bool) *CandleSeries {
+	adjusted := &CandleSeries{
+		Symbol:   raw.Symbol,
+		Interval: raw.Interval,
+		Candles:  make([]Candle, len(raw.Candles)),
+	}
+	copy(adjusted.Candles, raw.Candles)
+
+	// Коэффициенты цен и объемов для каждой свечи
+	priceFactors := make([]float64, len(raw.Candles))
+	volumeFactors := make([]float64, len(raw.Candles))
+	for i := range priceFactors {
+		priceFactors[i] = 1
+		volumeFactors[i] = 1
+	}
+
+	for _, action := range actions {
+		if action.Symbol != "" && action.Symbol != raw.Symbol {
+			continue
+		}
+
+		// Индекс первой свечи на дату отсечки или позже
+		exIndex := sort.Search(len(raw.Candles), func(i int) bool {
+			return !raw.Candles[i].Time.Before(action.ExDate)
+		})
+		if exIndex == 0 || exIndex == len(raw.Candles) {
+			continue // Действие вне диапазона ряда
+		}
+
+		priceFactor, volumeFactor := 1.0, 1.0
+		switch action.Kind {
+		case CorporateActionDividend:
+			if !includeDividends {
+				continue
+			}
+			prevClose := raw.Candles[exIndex-1].Close
+			if prevClose <= 0 || action.Amount >= prevClose {
+				logger.Logger.Warn().
+					Str("symbol", raw.Symbol).
+					Time("exDate", action.ExDate).
+					Float64("amount", action.Amount).
+					Msg("Dividend can't be applied to price series, skipping")
+				continue
+			}
+			priceFactor = 1 - action.Amount/prevClose
+		case CorporateActionSplit:
+			if action.Ratio <= 0 {
+				continue
+			}
+			priceFactor = 1 / action.Ratio
+			volumeFactor = action.Ratio
+		default:
+			continue
+		}
+
+		for i := 0; i < exIndex; i++ {
+			priceFactors[i] *= priceFactor
+			volumeFactors[i] *= volumeFactor
+		}
+	}
+
+	for i := range adjusted.Candles {
+		c := &adjusted.Candles[i]
+		c.Open *= priceFactors[i]
+		c.High *= priceFactors[i]
+		c.Low *= priceFactors[i]
+		c.Close *= priceFactors[i]
+		c.Volume *= volumeFactors[i]
+	}
+
+	return adjusted
+}
+
+// Функция возвращает дивиденды с датой отсечки в интервале (from, to]
+func DividendsBetween(actions []CorporateAction, symbol string, from, to time.Time) []CorporateAction {
+	var result []CorporateAction
+	for _, action := range actions {
+		if action.Kind != CorporateActionDividend || (action.Symbol != "" && action.Symbol != symbol) {
+			continue
+		}
+		if action.ExDate.After(from) && !action.ExDate.After(to) {
+			result = append(result, action)
+		}
+	}
+	return result
+}
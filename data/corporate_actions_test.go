@@ -0,0 +1,150 @@
+package data
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestExDateFromRegistryDate(t *testing.T) {
+	tests := []struct {
+		name     string
+		registry string
+		want     string
+	}{
+		{"T+1 midweek", "2024-07-10", "2024-07-10"},
+		{"T+1 registry on monday", "2024-07-08", "2024-07-08"},
+		{"T+1 registry on saturday", "2024-07-13", "2024-07-12"},
+		{"T+1 registry on holiday", "2024-06-12", "2024-06-11"},
+		{"T+1 switch date", "2023-07-31", "2023-07-31"},
+		{"T+2 midweek", "2023-07-12", "2023-07-11"},
+		{"T+2 over holiday", "2023-03-09", "2023-03-07"},
+		{"T+2 registry on saturday", "2023-07-15", "2023-07-13"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry, _ := time.ParseInLocation("2006-01-02", tt.registry, ExchangeLocation)
+			if got := exDateFromRegistryDate(registry).Format("2006-01-02"); got != tt.want {
+				t.Errorf("exDateFromRegistryDate(%s) = %s, want %s", tt.registry, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdjustCandleSeries(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, time.July, d, 0, 0, 0, 0, ExchangeLocation) }
+	raw := &CandleSeries{
+		Symbol:   "SBER",
+		Interval: "1d",
+		Candles: []Candle{
+			{Time: day(1), Open: 100, High: 100, Low: 100, Close: 100, Volume: 10},
+			{Time: day(2), Open: 100, High: 100, Low: 100, Close: 100, Volume: 10},
+			{Time: day(3), Open: 50, High: 50, Low: 50, Close: 50, Volume: 20},
+		},
+	}
+
+	tests := []struct {
+		name             string
+		actions          []CorporateAction
+		includeDividends bool
+		wantClose        []float64
+		wantVolume       []float64
+	}{
+		{
+			name:       "no actions",
+			wantClose:  []float64{100, 100, 50},
+			wantVolume: []float64{10, 10, 20},
+		},
+		{
+			name:       "split",
+			actions:    []CorporateAction{{Symbol: "SBER", Kind: CorporateActionSplit, ExDate: day(3), Ratio: 2}},
+			wantClose:  []float64{50, 50, 50},
+			wantVolume: []float64{20, 20, 20},
+		},
+		{
+			name:             "dividend",
+			actions:          []CorporateAction{{Symbol: "SBER", Kind: CorporateActionDividend, ExDate: day(2), Amount: 10}},
+			includeDividends: true,
+			wantClose:        []float64{90, 100, 50},
+			wantVolume:       []float64{10, 10, 20},
+		},
+		{
+			name:       "dividend excluded",
+			actions:    []CorporateAction{{Symbol: "SBER", Kind: CorporateActionDividend, ExDate: day(2), Amount: 10}},
+			wantClose:  []float64{100, 100, 50},
+			wantVolume: []float64{10, 10, 20},
+		},
+		{
+			name:             "dividend larger than price is skipped",
+			actions:          []CorporateAction{{Symbol: "SBER", Kind: CorporateActionDividend, ExDate: day(2), Amount: 150}},
+			includeDividends: true,
+			wantClose:        []float64{100, 100, 50},
+			wantVolume:       []float64{10, 10, 20},
+		},
+		{
+			name:       "other symbol",
+			actions:    []CorporateAction{{Symbol: "GAZP", Kind: CorporateActionSplit, ExDate: day(3), Ratio: 2}},
+			wantClose:  []float64{100, 100, 50},
+			wantVolume: []float64{10, 10, 20},
+		},
+		{
+			name:       "outside of series",
+			actions:    []CorporateAction{{Symbol: "SBER", Kind: CorporateActionSplit, ExDate: day(1), Ratio: 2}},
+			wantClose:  []float64{100, 100, 50},
+			wantVolume: []float64{10, 10, 20},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adjusted := AdjustCandleSeries(raw, tt.actions, tt.includeDividends)
+			for i, candle := range adjusted.Candles {
+				if math.Abs(candle.Close-tt.wantClose[i]) > 1e-9 || math.Abs(candle.Volume-tt.wantVolume[i]) > 1e-9 {
+					t.Errorf("candle %d: close=%v volume=%v, want close=%v volume=%v", i, candle.Close, candle.Volume, tt.wantClose[i], tt.wantVolume[i])
+				}
+			}
+			if raw.Candles[0].Close != 100 {
+				t.Fatal("raw series was modified")
+			}
+		})
+	}
+}
+
+func TestDividendsBetween(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, time.July, d, 0, 0, 0, 0, ExchangeLocation) }
+	actions := []CorporateAction{
+		{Symbol: "SBER", Kind: CorporateActionDividend, ExDate: day(2), Amount: 1},
+		{Symbol: "SBER", Kind: CorporateActionSplit, ExDate: day(3), Ratio: 2},
+		{Symbol: "GAZP", Kind: CorporateActionDividend, ExDate: day(3), Amount: 2},
+		{Kind: CorporateActionDividend, ExDate: day(4), Amount: 3},
+	}
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     []float64
+	}{
+		{"from is exclusive", day(2), day(4), []float64{3}},
+		{"to is inclusive", day(1), day(2), []float64{1}},
+		{"whole range", day(1), day(5), []float64{1, 3}},
+		{"empty range", day(5), day(6), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []float64
+			for _, dividend := range DividendsBetween(actions, "SBER", tt.from, tt.to) {
+				got = append(got, dividend.Amount)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("dividends = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("dividends = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -11,10 +11,6 @@ import (
 	"time"
 
 	"trading-bot/logger" // Импортируем пакет logger
-
-	"github.com/go-gota/gota/dataframe"
-	"github.com/go-gota/gota/series" // Для FloatType
-	"gonum.org/v1/gonum/floats"      // Для Min и Max
 )
 
 // Структура для хранения данных о текущих котировках
@@ -61,28 +57,14 @@ func UpdateQuotes(newQuote *Quote) error {
 
 	// --- Проверка валидности данных ---
 
-	if newQuote.Price <= 0 {
-		logger.Logger.Warn().
-			Str("symbol", symbol).
-			Float64("price", newQuote.Price).
-			Msg("Invalid quote price, ignoring")
-		return nil // Не фатальная ошибка, можно продолжить
+	// Цена и объем, порядок времени, дубликаты и выбросы проверяются валидатором.
+	// Нарушения логируются и подсчитываются внутри него.
+	if violations := DefaultValidator.ValidateQuote(newQuote); len(violations) > 0 {
+		return nil // Не фатальная ошибка, котировка отброшена
 	}
 
-	if newQuote.Volume <= 0 {
-		logger.Logger.Warn().
-			Str("symbol", symbol).
-			Int64("volume", newQuote.Volume).
-			Msg("Invalid quote volume, ignoring")
-		return nil // Не фатальная ошибка
-	}
-
-	// ... (добавьте другие проверки, например, на валидность
-	//       торговой сессии, кода площадки и т.д.)
-
 	// --- Блокировка мьютекса для записи ---
 	quotesMutex.Lock()
-	defer quotesMutex.Unlock()
 
 	// --- Обработка торговых сессий ---
 
@@ -113,6 +95,8 @@ func UpdateQuotes(newQuote *Quote) error {
 	history.Value = *newQuote
 	history.Ring = history.Next()
 
+	quotesMutex.Unlock()
+
 	logger.Logger.Info().
 		Str("symbol", symbol).
 		Str("tradingSession", tradingSession).
@@ -120,6 +104,9 @@ func UpdateQuotes(newQuote *Quote) error {
 		Time("time", newQuote.Time).
 		Msg("Quote updated")
 
+	// --- Уведомление подписчиков (вне блокировки) ---
+	notifyQuote(*newQuote)
+
 	return nil
 }
 
@@ -144,26 +131,20 @@ func GetQuoteHistory(symbol string) ([]Quote, error) {
 	return quotes, nil
 }
 
-// Функция для нормализации DataFrame
-func normalizeDataframe(df *dataframe.DataFrame) *dataframe.DataFrame {
-	for _, colName := range df.Names() {
-		if df.Col(colName).Type() != series.Float {
-			continue // Пропускаем нечисловые столбцы
-		}
-		colData := df.Col(colName).Float()
-		min := floats.Min(colData)
-		max := floats.Max(colData)
-		for i := 0; i < len(colData); i++ {
-			colData[i] = (colData[i] - min) / (max - min)
-		}
-
-		// Создаём новый объект series.Series
-		newSeries := series.New(colData, series.Float, colName)
+// Функция возвращает последнюю принятую котировку по тикеру (по всем торговым сессиям)
+func LastQuote(symbol string) (Quote, bool) {
+	quotesMutex.RLock()
+	defer quotesMutex.RUnlock()
 
-		// Используем newSeries в Mutate
-		*df = df.Mutate(newSeries)
+	var last Quote
+	found := false
+	for _, quotes := range CurrentQuotes {
+		if quote, ok := quotes[symbol]; ok && (!found || quote.Time.After(last.Time)) {
+			last = quote
+			found = true
+		}
 	}
-	return df
+	return last, found
 }
 
 // --- Новые функции ---
@@ -253,6 +234,7 @@ type FinamInstrumentInfo struct {
 	LotSize   int     `json:"lot_size"`
 	PriceStep float64 `json:"price_step"`
 	Decimals  int     `json:"decimals"`
+	Currency  string  `json:"currency"` // Валюта торгов инструмента
 	// ... другие поля
 }
 
@@ -337,12 +319,16 @@ func GetInstrumentInfo(symbol string) (*FinamInstrumentInfo, error) {
 		return nil, fmt.Errorf("error parsing DECIMALS from ISS API response: %w", err)
 	}
 
+	// Валюта торгов (SUR - рубль)
+	currency, _ := security["CURRENCYID"].(string)
+
 	// Создание структуры с информацией об инструменте
 	info := &FinamInstrumentInfo{
 		Symbol:    symbol,
 		LotSize:   int(lotSize),
 		PriceStep: priceStep,
 		Decimals:  decimals,
+		Currency:  issCurrency(currency),
 		// ... другие поля, если необходимо
 	}
 
@@ -352,6 +338,7 @@ func GetInstrumentInfo(symbol string) (*FinamInstrumentInfo, error) {
 		Int("lotSize", info.LotSize).
 		Float64("priceStep", info.PriceStep).
 		Int("decimals", info.Decimals).
+		Str("currency", info.Currency).
 		Msg("Instrument info received successfully")
 
 	return info, nil
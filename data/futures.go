@@ -0,0 +1,233 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"trading-bot/logger"
+)
+
+// Структура для спецификации фьючерсного контракта срочного рынка Московской биржи (FORTS)
+type FuturesContract struct {
+	Symbol        string    `json:"symbol"`          // Код контракта (например, SiZ4)
+	ShortName     string    `json:"short_name"`      // Краткое наименование (например, Si-12.24)
+	Asset         string    `json:"asset"`           // Код базового актива (например, Si)
+	TickSize      float64   `json:"tick_size"`       // Шаг цены
+	TickValue     float64   `json:"tick_value"`      // Стоимость шага цены (в рублях)
+	InitialMargin float64   `json:"initial_margin"`  // Гарантийное обеспечение (ГО) на один контракт
+	LotVolume     float64   `json:"lot_volume"`      // Количество базового актива в контракте
+	LastTradeDate time.Time `json:"last_trade_date"` // Последний день торгов (дата исполнения)
+}
+
+// Функция возвращает стоимость пункта цены одного контракта (в рублях)
+func (c *FuturesContract) PointValue() float64 {
+	if c.TickSize <= 0 {
+		return 1
+	}
+	return c.TickValue / c.TickSize
+}
+
+// Функция возвращает дату перехода на следующий контракт: за daysBefore торговых дней
+// до последнего дня торгов
+func (c *FuturesContract) RollDate(daysBefore int) time.Time {
+	return AddTradingDays(c.LastTradeDate, -daysBefore)
+}
+
+// Структура для перехода непрерывного ряда с одного контракта на другой
+type ContractRoll struct {
+	Time time.Time `json:"time"` // Первая свеча следующего контракта
+	From string    `json:"from"`
+	To   string    `json:"to"`
+	Gap  float64   `json:"gap"` // Разница цен закрытия следующего и текущего контракта на день перехода
+}
+
+// Функция для загрузки спецификаций фьючерсных контрактов из локального JSON файла
+// (например, истекших контрактов для непрерывного ряда)
+func LoadFuturesContractsFromFile(filename string) ([]FuturesContract, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading futures contracts file: %w", err)
+	}
+
+	var contracts []FuturesContract
+	if err := json.Unmarshal(content, &contracts); err != nil {
+		return nil, fmt.Errorf("error decoding futures contracts file: %w", err)
+	}
+
+	sortFuturesContracts(contracts)
+	return contracts, nil
+}
+
+// Функция для загрузки спецификаций торгуемых контрактов по базовому активу с ISS API
+// Московской биржи (пустой asset - все контракты)
+func LoadFuturesContractsFromMOEX(asset string) ([]FuturesContract, error) {
+	var response struct {
+		Securities issTable `json:"securities"`
+	}
+	url := "https://iss.moex.com/iss/engines/futures/markets/forts/securities.json?iss.meta=off&iss.only=securities"
+	if err := fetchISS(url, &response); err != nil {
+		return nil, err
+	}
+
+	var contracts []FuturesContract
+	for _, row := range response.Securities.Rows() {
+		if asset != "" && issString(row, "ASSETCODE") != asset {
+			continue
+		}
+		contract, err := futuresContractFromISS(row)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing futures contract %s: %w", issString(row, "SECID"), err)
+		}
+		contracts = append(contracts, contract)
+	}
+	sortFuturesContracts(contracts)
+
+	logger.Logger.Info().
+		Str("asset", asset).
+		Int("contracts", len(contracts)).
+		Msg("Futures contracts received successfully")
+
+	return contracts, nil
+}
+
+// Функция для разбора строки таблицы securities срочного рынка
+func futuresContractFromISS(row map[string]interface{}) (FuturesContract, error) {
+	contract := FuturesContract{
+		Symbol:    issString(row, "SECID"),
+		ShortName: issString(row, "SHORTNAME"),
+		Asset:     issString(row, "ASSETCODE"),
+	}
+	var err error
+	if contract.TickSize, err = issFloat(row, "MINSTEP"); err != nil {
+		return contract, err
+	}
+	if contract.TickValue, err = issFloat(row, "STEPPRICE"); err != nil {
+		return contract, err
+	}
+	if contract.InitialMargin, err = issFloat(row, "INITIALMARGIN"); err != nil {
+		return contract, err
+	}
+	if contract.LotVolume, err = issFloat(row, "LOTVOLUME"); err != nil {
+		return contract, err
+	}
+	if contract.LastTradeDate, err = issDate(row, "LASTTRADEDATE"); err != nil {
+		return contract, err
+	}
+	return contract, nil
+}
+
+// Функция для сортировки контрактов по дате исполнения
+func sortFuturesContracts(contracts []FuturesContract) {
+	sort.SliceStable(contracts, func(i, j int) bool {
+		return contracts[i].LastTradeDate.Before(contracts[j].LastTradeDate)
+	})
+}
+
+// Функция возвращает текущий (ближний) контракт по базовому активу: первый контракт, дата
+// перехода с которого еще не наступила
+func FrontContract(contracts []FuturesContract, asset string, t time.Time, daysBefore int) (FuturesContract, bool) {
+	sorted := append([]FuturesContract(nil), contracts...)
+	sortFuturesContracts(sorted)
+	for _, contract := range sorted {
+		if contract.Asset == asset && t.Before(contract.RollDate(daysBefore)) {
+			return contract, true
+		}
+	}
+	return FuturesContract{}, false
+}
+
+// Функция возвращает следующий за symbol контракт на тот же базовый актив
+func NextContract(contracts []FuturesContract, symbol string) (FuturesContract, bool) {
+	sorted := append([]FuturesContract(nil), contracts...)
+	sortFuturesContracts(sorted)
+	for i, contract := range sorted {
+		if contract.Symbol != symbol {
+			continue
+		}
+		for _, next := range sorted[i+1:] {
+			if next.Asset == contract.Asset && next.LastTradeDate.After(contract.LastTradeDate) {
+				return next, true
+			}
+		}
+		break
+	}
+	return FuturesContract{}, false
+}
+
+// Функция для построения непрерывного ряда по базовому активу из рядов истекающих контрактов.
+// Каждый контракт используется до даты перехода на следующий (за daysBefore торговых дней до
+// исполнения). Цены до перехода сдвигаются назад на разницу цен контрактов в день перехода
+// (обратная корректировка разностью), поэтому последние цены ряда реальные, а изменения цен -
+// без скачков на переходах. series - ряды контрактов по кодам
+func BuildContinuousSeries(asset string, contracts []FuturesContract, series map[string]*CandleSeries, daysBefore int) (*CandleSeries, []ContractRoll) {
+	sorted := make([]FuturesContract, 0, len(contracts))
+	for _, contract := range contracts {
+		if contract.Asset == asset && series[contract.Symbol] != nil && series[contract.Symbol].Len() > 0 {
+			sorted = append(sorted, contract)
+		}
+	}
+	sortFuturesContracts(sorted)
+
+	continuous := &CandleSeries{Symbol: asset}
+	var rolls []ContractRoll
+	var start time.Time
+	var previousSymbol string
+	for i, contract := range sorted {
+		candles := series[contract.Symbol]
+		continuous.Interval = candles.Interval
+		end := contract.RollDate(daysBefore)
+		last := i == len(sorted)-1
+
+		var segment []Candle
+		for _, candle := range candles.Candles {
+			if candle.Time.Before(start) || (!last && !candle.Time.Before(end)) {
+				continue
+			}
+			segment = append(segment, candle)
+		}
+		if len(segment) == 0 {
+			continue
+		}
+
+		// Разница цен на переход: закрытие следующего контракта на последнюю свечу текущего
+		if len(continuous.Candles) > 0 {
+			previous := continuous.Candles[len(continuous.Candles)-1]
+			gap := 0.0
+			if candle, ok := candleAt(candles, previous.Time); ok {
+				gap = candle.Close - previous.Close
+			} else {
+				logger.Logger.Warn().
+					Str("from", previousSymbol).
+					Str("to", contract.Symbol).
+					Time("time", previous.Time).
+					Msg("No overlapping candle for futures roll, series is not adjusted")
+			}
+			for j := range continuous.Candles {
+				c := &continuous.Candles[j]
+				c.Open += gap
+				c.High += gap
+				c.Low += gap
+				c.Close += gap
+			}
+			rolls = append(rolls, ContractRoll{Time: segment[0].Time, From: previousSymbol, To: contract.Symbol, Gap: gap})
+		}
+		continuous.Candles = append(continuous.Candles, segment...)
+		previousSymbol = contract.Symbol
+		start = end
+	}
+	return continuous, rolls
+}
+
+// Функция возвращает свечу ряда с временем t
+func candleAt(series *CandleSeries, t time.Time) (Candle, bool) {
+	index := sort.Search(len(series.Candles), func(i int) bool {
+		return !series.Candles[i].Time.Before(t)
+	})
+	if index < len(series.Candles) && series.Candles[index].Time.Equal(t) {
+		return series.Candles[index], true
+	}
+	return Candle{}, false
+}
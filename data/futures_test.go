@@ -0,0 +1,171 @@
+package data
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// Функция возвращает дневные свечи контракта с закрытиями closes начиная с даты start
+func dailySeries(symbol string, start time.Time, closes ...float64) *CandleSeries {
+	series := &CandleSeries{Symbol: symbol, Interval: "1d"}
+	for i, price := range closes {
+		series.Candles = append(series.Candles, Candle{
+			Time:  start.AddDate(0, 0, i),
+			Open:  price,
+			High:  price,
+			Low:   price,
+			Close: price,
+		})
+	}
+	return series
+}
+
+// Функция возвращает дату по времени биржи
+func exchangeDate(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, ExchangeLocation)
+}
+
+func TestFuturesContractPointValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		contract FuturesContract
+		want     float64
+	}{
+		{name: "Si", contract: FuturesContract{TickSize: 1, TickValue: 1}, want: 1},
+		{name: "RTS", contract: FuturesContract{TickSize: 10, TickValue: 13.5}, want: 1.35},
+		{name: "no tick size", contract: FuturesContract{TickValue: 5}, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.contract.PointValue(); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("PointValue = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFuturesContractRollDate(t *testing.T) {
+	tests := []struct {
+		name       string
+		lastTrade  time.Time
+		daysBefore int
+		want       time.Time
+	}{
+		{name: "same week", lastTrade: exchangeDate(2024, time.September, 19), daysBefore: 2, want: exchangeDate(2024, time.September, 17)},
+		{name: "over weekend", lastTrade: exchangeDate(2024, time.December, 19), daysBefore: 5, want: exchangeDate(2024, time.December, 12)},
+		{name: "over holiday", lastTrade: exchangeDate(2024, time.June, 20), daysBefore: 6, want: exchangeDate(2024, time.June, 11)},
+		{name: "no offset", lastTrade: exchangeDate(2024, time.March, 21), want: exchangeDate(2024, time.March, 21)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contract := FuturesContract{LastTradeDate: tt.lastTrade}
+			if got := contract.RollDate(tt.daysBefore); !got.Equal(tt.want) {
+				t.Errorf("RollDate = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFrontAndNextContract(t *testing.T) {
+	contracts := []FuturesContract{
+		{Symbol: "SiZ4", Asset: "Si", LastTradeDate: exchangeDate(2024, time.December, 19)},
+		{Symbol: "RIU4", Asset: "RTS", LastTradeDate: exchangeDate(2024, time.September, 19)},
+		{Symbol: "SiU4", Asset: "Si", LastTradeDate: exchangeDate(2024, time.September, 19)},
+	}
+
+	tests := []struct {
+		name      string
+		t         time.Time
+		wantFront string
+	}{
+		{name: "before roll", t: exchangeDate(2024, time.September, 16), wantFront: "SiU4"},
+		{name: "on roll date", t: exchangeDate(2024, time.September, 17), wantFront: "SiZ4"},
+		{name: "after all contracts", t: exchangeDate(2025, time.January, 9)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			front, ok := FrontContract(contracts, "Si", tt.t, 2)
+			if front.Symbol != tt.wantFront || ok != (tt.wantFront != "") {
+				t.Errorf("FrontContract = %q, %v, want %q", front.Symbol, ok, tt.wantFront)
+			}
+		})
+	}
+
+	if next, ok := NextContract(contracts, "SiU4"); !ok || next.Symbol != "SiZ4" {
+		t.Errorf("NextContract(SiU4) = %q, %v, want SiZ4", next.Symbol, ok)
+	}
+	if next, ok := NextContract(contracts, "SiZ4"); ok {
+		t.Errorf("NextContract(SiZ4) = %q, want none", next.Symbol)
+	}
+}
+
+func TestBuildContinuousSeries(t *testing.T) {
+	// Переход с SiU4 на SiZ4 - 17.09.2024 (за 2 торговых дня до 19.09)
+	contracts := []FuturesContract{
+		{Symbol: "SiU4", Asset: "Si", LastTradeDate: exchangeDate(2024, time.September, 19)},
+		{Symbol: "SiZ4", Asset: "Si", LastTradeDate: exchangeDate(2024, time.December, 19)},
+	}
+	start := exchangeDate(2024, time.September, 14)
+
+	tests := []struct {
+		name       string
+		series     map[string]*CandleSeries
+		wantCloses []float64
+		wantRolls  []ContractRoll
+	}{
+		{
+			name: "back adjusted by roll gap",
+			series: map[string]*CandleSeries{
+				"SiU4": dailySeries("SiU4", start, 100, 101, 102, 103, 104),
+				"SiZ4": dailySeries("SiZ4", start, 110, 111, 113, 114, 115),
+			},
+			// SiU4 до 16.09 включительно, разница на 16.09: 113 - 102 = 11
+			wantCloses: []float64{111, 112, 113, 114, 115},
+			wantRolls:  []ContractRoll{{Time: exchangeDate(2024, time.September, 17), From: "SiU4", To: "SiZ4", Gap: 11}},
+		},
+		{
+			name: "no overlapping candle",
+			series: map[string]*CandleSeries{
+				"SiU4": dailySeries("SiU4", start, 100, 101, 102),
+				"SiZ4": dailySeries("SiZ4", exchangeDate(2024, time.September, 17), 114, 115),
+			},
+			wantCloses: []float64{100, 101, 102, 114, 115},
+			wantRolls:  []ContractRoll{{Time: exchangeDate(2024, time.September, 17), From: "SiU4", To: "SiZ4"}},
+		},
+		{
+			name: "single contract",
+			series: map[string]*CandleSeries{
+				"SiZ4": dailySeries("SiZ4", start, 110, 111),
+			},
+			wantCloses: []float64{110, 111},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			continuous, rolls := BuildContinuousSeries("Si", contracts, tt.series, 2)
+
+			if continuous.Len() != len(tt.wantCloses) {
+				t.Fatalf("candles = %d, want %d", continuous.Len(), len(tt.wantCloses))
+			}
+			for i, want := range tt.wantCloses {
+				if got := continuous.Candles[i].Close; math.Abs(got-want) > 1e-9 {
+					t.Errorf("close[%d] = %v, want %v", i, got, want)
+				}
+			}
+			if len(rolls) != len(tt.wantRolls) {
+				t.Fatalf("rolls = %+v, want %+v", rolls, tt.wantRolls)
+			}
+			for i, want := range tt.wantRolls {
+				got := rolls[i]
+				if !got.Time.Equal(want.Time) || got.From != want.From || got.To != want.To || math.Abs(got.Gap-want.Gap) > 1e-9 {
+					t.Errorf("roll[%d] = %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"trading-bot/logger"
+)
+
+// Ошибка для пересчета в валюту, курс которой еще не известен
+var ErrNoFXRate = errors.New("no FX rate")
+
+// Валюта, к которой приводятся курсы валютного рынка Московской биржи
+const RubCurrency = "RUB"
+
+// Инструменты валютного рынка Московской биржи (режим CETS, расчеты завтра), по котировкам
+// которых обновляются курсы валют к рублю
+var DefaultFXInstruments = map[string]string{
+	"CNYRUB_TOM":   "CNY",
+	"USD000UTSTOM": "USD",
+}
+
+// Структура для курса валюты к рублю
+type FXRate struct {
+	Currency string    `json:"currency"`
+	Rate     float64   `json:"rate"` // Рублей за единицу валюты
+	Time     time.Time `json:"time"`
+}
+
+// Курсы валют к рублю по котировкам валютных инструментов (последняя сделка или середина
+// стакана). Пересчет между двумя валютами - через рубль
+type FXRates struct {
+	mu          sync.RWMutex
+	instruments map[string]string // Инструмент валютного рынка -> валюта
+	rates       map[string]FXRate
+}
+
+// Функция для создания курсов валют. instruments - инструменты валютного рынка и их валюты
+// (пустой - DefaultFXInstruments)
+func NewFXRates(instruments map[string]string) *FXRates {
+	if len(instruments) == 0 {
+		instruments = DefaultFXInstruments
+	}
+	return &FXRates{instruments: instruments, rates: make(map[string]FXRate)}
+}
+
+// Функция возвращает инструменты валютного рынка, по которым обновляются курсы
+func (r *FXRates) Symbols() []string {
+	symbols := make([]string, 0, len(r.instruments))
+	for symbol := range r.instruments {
+		symbols = append(symbols, symbol)
+	}
+	return symbols
+}
+
+// Функция возвращает инструмент валютного рынка для валюты
+func (r *FXRates) Symbol(currency string) (string, bool) {
+	for symbol, instrumentCurrency := range r.instruments {
+		if instrumentCurrency == currency {
+			return symbol, true
+		}
+	}
+	return "", false
+}
+
+// Функция для установки курса валюты к рублю
+func (r *FXRates) SetRate(currency string, rate float64, t time.Time) {
+	if rate <= 0 || currency == RubCurrency {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.rates[currency] = FXRate{Currency: currency, Rate: rate, Time: t}
+}
+
+// Функция возвращает курс валюты к рублю (для рубля - 1)
+func (r *FXRates) Rate(currency string) (FXRate, bool) {
+	if currency == RubCurrency || currency == "" {
+		return FXRate{Currency: RubCurrency, Rate: 1}, true
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rate, ok := r.rates[currency]
+	return rate, ok
+}
+
+// Функция для пересчета суммы из одной валюты в другую
+func (r *FXRates) Convert(amount float64, from, to string) (float64, error) {
+	if from == to {
+		return amount, nil
+	}
+	fromRate, ok := r.Rate(from)
+	if !ok {
+		return 0, fmt.Errorf("%s: %w", from, ErrNoFXRate)
+	}
+	toRate, ok := r.Rate(to)
+	if !ok {
+		return 0, fmt.Errorf("%s: %w", to, ErrNoFXRate)
+	}
+	return amount * fromRate.Rate / toRate.Rate, nil
+}
+
+// Функция для обработки сделки по инструменту валютного рынка
+func (r *FXRates) OnQuote(quote Quote) {
+	if currency, ok := r.instruments[quote.Symbol]; ok {
+		r.SetRate(currency, quote.Price, quote.Time)
+	}
+}
+
+// Функция для обработки стакана инструмента валютного рынка: курс - середина спреда
+func (r *FXRates) OnOrderBook(book OrderBook) {
+	currency, ok := r.instruments[book.Symbol]
+	if !ok {
+		return
+	}
+	bid, okBid := book.BestBid()
+	ask, okAsk := book.BestAsk()
+	if okBid && okAsk {
+		r.SetRate(currency, (bid.Price+ask.Price)/2, book.Time)
+	}
+}
+
+// Функция для подписки на котировки и стаканы из пакета data
+func (r *FXRates) AttachToFeed() {
+	SubscribeQuotes(r.OnQuote)
+	SubscribeOrderBooks(r.OnOrderBook)
+}
+
+// Функция для загрузки последних курсов с ISS API Московской биржи (начальные значения
+// до первых котировок)
+func (r *FXRates) LoadFromMOEX() error {
+	var errs []error
+	for symbol, currency := range r.instruments {
+		var response struct {
+			Marketdata issTable `json:"marketdata"`
+		}
+		url := fmt.Sprintf("https://iss.moex.com/iss/engines/currency/markets/selt/boards/CETS/securities/%s.json?iss.meta=off&iss.only=marketdata", symbol)
+		if err := fetchISS(url, &response); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", symbol, err))
+			continue
+		}
+		rows := response.Marketdata.Rows()
+		if len(rows) == 0 {
+			errs = append(errs, fmt.Errorf("%s: no market data", symbol))
+			continue
+		}
+		rate, err := issFloat(rows[0], "LAST")
+		if err != nil {
+			// Торгов еще не было: курс по средневзвешенной цене прошлого дня
+			if rate, err = issFloat(rows[0], "WAPRICE"); err != nil {
+				errs = append(errs, fmt.Errorf("%s: error parsing rate: %w", symbol, err))
+				continue
+			}
+		}
+		r.SetRate(currency, rate, time.Now())
+
+		logger.Logger.Info().
+			Str("symbol", symbol).
+			Str("currency", currency).
+			Float64("rate", rate).
+			Msg("FX rate received successfully")
+	}
+	return errors.Join(errs...)
+}
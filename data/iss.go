@@ -0,0 +1,98 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"trading-bot/logger"
+)
+
+// Структура для таблицы в ответе ISS API Московской биржи
+type issTable struct {
+	Columns []string        `json:"columns"`
+	Data    [][]interface{} `json:"data"`
+}
+
+// Функция возвращает строки таблицы в виде map "столбец -> значение"
+func (t *issTable) Rows() []map[string]interface{} {
+	rows := make([]map[string]interface{}, 0, len(t.Data))
+	for _, values := range t.Data {
+		row := make(map[string]interface{}, len(t.Columns))
+		for i, column := range t.Columns {
+			if i < len(values) {
+				row[column] = values[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// Функция для запроса к ISS API и разбора JSON ответа в v
+func fetchISS(url string, v interface{}) error {
+	logger.Logger.Info().Str("url", url).Msg("Sending request to ISS API")
+
+	resp, err := http.Get(url)
+	if err != nil {
+		logger.Logger.Error().Err(err).Msg("Error making request to ISS API")
+		return fmt.Errorf("error making request to ISS API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		logger.Logger.Error().Int("statusCode", resp.StatusCode).Str("url", url).Msg("Moscow Exchange API error")
+		switch resp.StatusCode {
+		case http.StatusTooManyRequests:
+			return fmt.Errorf("too many requests to ISS API, try again later")
+		default:
+			return fmt.Errorf("ISS API request failed with status code: %d", resp.StatusCode)
+		}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.Logger.Error().Err(err).Msg("Error reading response body from ISS API")
+		return fmt.Errorf("error reading response body from ISS API: %w", err)
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		logger.Logger.Error().Err(err).Msg("Error parsing JSON response from ISS API")
+		return fmt.Errorf("error parsing JSON response from ISS API: %w", err)
+	}
+	return nil
+}
+
+// Функция для чтения числового значения из строки ISS
+func issFloat(row map[string]interface{}, column string) (float64, error) {
+	switch value := row[column].(type) {
+	case float64:
+		return value, nil
+	case string:
+		return strconv.ParseFloat(value, 64)
+	case nil:
+		return 0, fmt.Errorf("column %s is empty", column)
+	default:
+		return 0, fmt.Errorf("column %s has unexpected type %T", column, value)
+	}
+}
+
+// Функция для чтения строкового значения из строки ISS
+func issString(row map[string]interface{}, column string) string {
+	if value, ok := row[column].(string); ok {
+		return value
+	}
+	return ""
+}
+
+// Функция для чтения даты (YYYY-MM-DD) из строки ISS во времени биржи
+func issDate(row map[string]interface{}, column string) (time.Time, error) {
+	value := issString(row, column)
+	if value == "" {
+		return time.Time{}, fmt.Errorf("column %s is empty", column)
+	}
+	return time.ParseInLocation("2006-01-02", value, ExchangeLocation)
+}
@@ -0,0 +1,76 @@
+package data
+
+import (
+	"fmt"
+	"time"
+
+	"trading-bot/logger"
+)
+
+// Структура для одного уровня стакана
+type OrderBookLevel struct {
+	Price    float64 `json:"price"`    // Цена уровня
+	Quantity int64   `json:"quantity"` // Объем на уровне (в лотах)
+}
+
+// Структура для стакана заявок по инструменту
+type OrderBook struct {
+	Symbol string           `json:"symbol"` // Тикер инструмента
+	Board  string           `json:"board"`  // Код площадки
+	Bids   []OrderBookLevel `json:"bids"`   // Покупки, по убыванию цены
+	Asks   []OrderBookLevel `json:"asks"`   // Продажи, по возрастанию цены
+	Time   time.Time        `json:"time"`   // Время снимка
+}
+
+// Функция возвращает лучшую цену покупки
+func (b *OrderBook) BestBid() (OrderBookLevel, bool) {
+	if len(b.Bids) == 0 {
+		return OrderBookLevel{}, false
+	}
+	return b.Bids[0], true
+}
+
+// Функция возвращает лучшую цену продажи
+func (b *OrderBook) BestAsk() (OrderBookLevel, bool) {
+	if len(b.Asks) == 0 {
+		return OrderBookLevel{}, false
+	}
+	return b.Asks[0], true
+}
+
+// Хранилище для текущих стаканов по тикеру
+var CurrentOrderBooks = make(map[string]OrderBook)
+
+// Функция для обновления стакана
+func UpdateOrderBook(book *OrderBook) error {
+	// Проверка стакана (например, на пересечение лучших цен)
+	if violations := DefaultValidator.ValidateOrderBook(book); len(violations) > 0 {
+		return nil // Не фатальная ошибка, стакан отброшен
+	}
+
+	quotesMutex.Lock()
+	CurrentOrderBooks[book.Symbol] = *book
+	quotesMutex.Unlock()
+
+	logger.Logger.Debug().
+		Str("symbol", book.Symbol).
+		Int("bids", len(book.Bids)).
+		Int("asks", len(book.Asks)).
+		Msg("Order book updated")
+
+	notifyOrderBook(*book)
+
+	return nil
+}
+
+// Функция для получения текущего стакана по тикеру
+func GetOrderBook(symbol string) (*OrderBook, error) {
+	quotesMutex.RLock()
+	defer quotesMutex.RUnlock()
+
+	book, ok := CurrentOrderBooks[symbol]
+	if !ok {
+		return nil, fmt.Errorf("no order book found for symbol: %s", symbol)
+	}
+	return &book, nil
+}
@@ -0,0 +1,295 @@
+package data
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/go-gota/gota/dataframe"
+	"github.com/go-gota/gota/series"
+)
+
+// Тип масштабирования признаков
+type ScalerKind string
+
+const (
+	ScalerMinMax    ScalerKind = "minmax"     // (x - min) / (max - min)
+	ScalerZScore    ScalerKind = "zscore"     // (x - mean) / std
+	ScalerRobust    ScalerKind = "robust"     // (x - median) / IQR
+	ScalerLogReturn ScalerKind = "log_return" // ln(x[t] / x[t-1]), первая строка = 0
+)
+
+// Интерфейс для масштабирования признаков. Scaler обучается (Fit) на тренировочном окне,
+// а затем применяется (Transform) к более поздним данным с теми же параметрами.
+type Scaler interface {
+	// Fit вычисляет параметры масштабирования по указанным столбцам
+	Fit(df *dataframe.DataFrame, columns []string) error
+	// Transform возвращает новый DataFrame с масштабированными столбцами
+	Transform(df *dataframe.DataFrame) (*dataframe.DataFrame, error)
+	// Params возвращает сериализуемые параметры масштабирования
+	Params() *ScalerParams
+}
+
+// Структура для сериализации обученных параметров масштабирования
+type ScalerParams struct {
+	Kind    ScalerKind         `json:"kind"`
+	Columns []string           `json:"columns"`
+	Center  map[string]float64 `json:"center,omitempty"` // Вычитаемое значение по столбцу
+	Scale   map[string]float64 `json:"scale,omitempty"`  // Делитель по столбцу
+}
+
+// Функция для создания масштабирования нужного типа
+func NewScaler(kind ScalerKind) (Scaler, error) {
+	switch kind {
+	case ScalerMinMax, ScalerZScore, ScalerRobust:
+		return &affineScaler{params: ScalerParams{Kind: kind}}, nil
+	case ScalerLogReturn:
+		return &logReturnScaler{params: ScalerParams{Kind: kind}}, nil
+	default:
+		return nil, fmt.Errorf("unknown scaler kind: %s", kind)
+	}
+}
+
+// Функция для восстановления обученного масштабирования из параметров
+func NewScalerFromParams(params *ScalerParams) (Scaler, error) {
+	scaler, err := NewScaler(params.Kind)
+	if err != nil {
+		return nil, err
+	}
+	switch s := scaler.(type) {
+	case *affineScaler:
+		if len(params.Center) != len(params.Columns) || len(params.Scale) != len(params.Columns) {
+			return nil, fmt.Errorf("scaler params are incomplete for kind %s", params.Kind)
+		}
+		s.params = *params
+		s.fitted = true
+	case *logReturnScaler:
+		s.params = *params
+		s.fitted = true
+	}
+	return scaler, nil
+}
+
+// Функция для сохранения параметров масштабирования в JSON файл
+func SaveScalerParams(filename string, params *ScalerParams) error {
+	content, err := json.MarshalIndent(params, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling scaler params: %w", err)
+	}
+	if err := os.WriteFile(filename, content, 0644); err != nil {
+		return fmt.Errorf("error writing scaler params file: %w", err)
+	}
+	return nil
+}
+
+// Функция для загрузки параметров масштабирования из JSON файла
+func LoadScalerParams(filename string) (*ScalerParams, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading scaler params file: %w", err)
+	}
+	var params ScalerParams
+	if err := json.Unmarshal(content, &params); err != nil {
+		return nil, fmt.Errorf("error decoding scaler params file: %w", err)
+	}
+	return &params, nil
+}
+
+// Функция для записи масштабирования в gob-поток (например, в файл модели после весов).
+// nil записывается как параметры с пустым Kind
+func EncodeScaler(encoder *gob.Encoder, scaler Scaler) error {
+	var params ScalerParams
+	if scaler != nil {
+		params = *scaler.Params()
+	}
+	if err := encoder.Encode(params); err != nil {
+		return fmt.Errorf("error encoding scaler params: %w", err)
+	}
+	return nil
+}
+
+// Функция для чтения масштабирования, записанного EncodeScaler. Поток, который закончился
+// раньше (файл модели, сохраненный до появления масштабирования), означает модель без
+// масштабирования: возвращается nil без ошибки
+func DecodeScaler(decoder *gob.Decoder) (Scaler, error) {
+	var params ScalerParams
+	if err := decoder.Decode(&params); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error decoding scaler params: %w", err)
+	}
+	if params.Kind == "" {
+		return nil, nil
+	}
+	return NewScalerFromParams(&params)
+}
+
+// Масштабирование вида (x - center) / scale: min-max, z-score и robust
+type affineScaler struct {
+	params ScalerParams
+	fitted bool
+}
+
+func (s *affineScaler) Fit(df *dataframe.DataFrame, columns []string) error {
+	center := make(map[string]float64, len(columns))
+	scale := make(map[string]float64, len(columns))
+
+	for _, column := range columns {
+		values, err := floatColumn(df, column)
+		if err != nil {
+			return err
+		}
+		if len(values) == 0 {
+			return fmt.Errorf("column %s is empty, can't fit scaler", column)
+		}
+
+		var c, d float64
+		switch s.params.Kind {
+		case ScalerMinMax:
+			min, max := values[0], values[0]
+			for _, x := range values {
+				min = math.Min(min, x)
+				max = math.Max(max, x)
+			}
+			c, d = min, max-min
+		case ScalerZScore:
+			c, d = mean(values), standardDeviation(values)
+		case ScalerRobust:
+			c, d = quantile(values, 0.5), quantile(values, 0.75)-quantile(values, 0.25)
+		}
+
+		// Для постоянного столбца делитель равен нулю - оставляем только сдвиг
+		if d == 0 || math.IsNaN(d) {
+			d = 1
+		}
+		center[column] = c
+		scale[column] = d
+	}
+
+	s.params.Columns = append([]string(nil), columns...)
+	s.params.Center = center
+	s.params.Scale = scale
+	s.fitted = true
+	return nil
+}
+
+func (s *affineScaler) Transform(df *dataframe.DataFrame) (*dataframe.DataFrame, error) {
+	if !s.fitted {
+		return nil, fmt.Errorf("scaler %s is not fitted", s.params.Kind)
+	}
+
+	result := df.Copy()
+	for _, column := range s.params.Columns {
+		values, err := floatColumn(&result, column)
+		if err != nil {
+			return nil, err
+		}
+		scaled := make([]float64, len(values))
+		for i, x := range values {
+			scaled[i] = (x - s.params.Center[column]) / s.params.Scale[column]
+		}
+		result = result.Mutate(series.New(scaled, series.Float, column))
+		if result.Err != nil {
+			return nil, fmt.Errorf("error updating column %s: %w", column, result.Err)
+		}
+	}
+	return &result, nil
+}
+
+func (s *affineScaler) Params() *ScalerParams {
+	params := s.params
+	return &params
+}
+
+// Преобразование цен в логарифмические доходности. Не требует обучения,
+// но Fit запоминает столбцы, чтобы одинаково применять его при обучении и прогнозе.
+type logReturnScaler struct {
+	params ScalerParams
+	fitted bool
+}
+
+func (s *logReturnScaler) Fit(df *dataframe.DataFrame, columns []string) error {
+	for _, column := range columns {
+		if _, err := floatColumn(df, column); err != nil {
+			return err
+		}
+	}
+	s.params.Columns = append([]string(nil), columns...)
+	s.fitted = true
+	return nil
+}
+
+func (s *logReturnScaler) Transform(df *dataframe.DataFrame) (*dataframe.DataFrame, error) {
+	if !s.fitted {
+		return nil, fmt.Errorf("scaler %s is not fitted", s.params.Kind)
+	}
+
+	result := df.Copy()
+	for _, column := range s.params.Columns {
+		values, err := floatColumn(&result, column)
+		if err != nil {
+			return nil, err
+		}
+		returns := make([]float64, len(values))
+		for i := 1; i < len(values); i++ {
+			if values[i] <= 0 || values[i-1] <= 0 {
+				return nil, fmt.Errorf("column %s has non-positive value at row %d, log return undefined", column, i)
+			}
+			returns[i] = math.Log(values[i] / values[i-1])
+		}
+		result = result.Mutate(series.New(returns, series.Float, column))
+		if result.Err != nil {
+			return nil, fmt.Errorf("error updating column %s: %w", column, result.Err)
+		}
+	}
+	return &result, nil
+}
+
+func (s *logReturnScaler) Params() *ScalerParams {
+	params := s.params
+	return &params
+}
+
+// Функция возвращает значения числового столбца
+func floatColumn(df *dataframe.DataFrame, column string) ([]float64, error) {
+	col := df.Col(column)
+	if col.Err != nil {
+		return nil, fmt.Errorf("column %s not found: %w", column, col.Err)
+	}
+	if col.Type() != series.Float && col.Type() != series.Int {
+		return nil, fmt.Errorf("column %s is not numeric", column)
+	}
+	return col.Float(), nil
+}
+
+// Функция для расчета среднего значения
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, x := range values {
+		sum += x
+	}
+	return sum / float64(len(values))
+}
+
+// Функция для расчета квантиля с линейной интерполяцией
+func quantile(values []float64, q float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	position := q * float64(len(sorted)-1)
+	lower := int(math.Floor(position))
+	upper := int(math.Ceil(position))
+	if lower == upper {
+		return sorted[lower]
+	}
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(position-float64(lower))
+}
@@ -0,0 +1,181 @@
+package data
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-gota/gota/dataframe"
+	"github.com/go-gota/gota/series"
+)
+
+// Функция возвращает DataFrame с одним столбцом Close
+func closeFrame(values ...float64) *dataframe.DataFrame {
+	df := dataframe.New(series.New(values, series.Float, ColumnClose))
+	return &df
+}
+
+func assertColumn(t *testing.T, df *dataframe.DataFrame, column string, want []float64) {
+	t.Helper()
+	got := df.Col(column).Float()
+	if len(got) != len(want) {
+		t.Fatalf("%s = %v, want %v", column, got, want)
+	}
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > 1e-4 {
+			t.Fatalf("%s = %v, want %v", column, got, want)
+		}
+	}
+}
+
+func TestScalers(t *testing.T) {
+	tests := []struct {
+		name  string
+		kind  ScalerKind
+		train []float64
+		apply []float64
+		want  []float64
+	}{
+		{"minmax", ScalerMinMax, []float64{1, 2, 3, 4, 5}, []float64{1, 2, 3, 4, 5}, []float64{0, 0.25, 0.5, 0.75, 1}},
+		{"minmax uses train range", ScalerMinMax, []float64{1, 5}, []float64{9}, []float64{2}},
+		{"minmax constant column", ScalerMinMax, []float64{2, 2, 2}, []float64{2, 3}, []float64{0, 1}},
+		{"zscore", ScalerZScore, []float64{1, 2, 3, 4, 5}, []float64{1, 3, 5}, []float64{-1.2649, 0, 1.2649}},
+		{"robust", ScalerRobust, []float64{1, 2, 3, 4, 5}, []float64{1, 2, 3, 4, 5}, []float64{-1, -0.5, 0, 0.5, 1}},
+		{"robust ignores outlier", ScalerRobust, []float64{1, 2, 3, 4, 1000}, []float64{3, 5}, []float64{0, 1}},
+		{"log return", ScalerLogReturn, []float64{1}, []float64{1, 2, 3, 4, 5}, []float64{0, math.Log(2), math.Log(1.5), math.Log(4.0 / 3), math.Log(1.25)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scaler, err := NewScaler(tt.kind)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := scaler.Fit(closeFrame(tt.train...), []string{ColumnClose}); err != nil {
+				t.Fatal(err)
+			}
+			scaled, err := scaler.Transform(closeFrame(tt.apply...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertColumn(t, scaled, ColumnClose, tt.want)
+
+			// Восстановленное из параметров масштабирование дает тот же результат
+			path := filepath.Join(t.TempDir(), "scaler.json")
+			if err := SaveScalerParams(path, scaler.Params()); err != nil {
+				t.Fatal(err)
+			}
+			params, err := LoadScalerParams(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			restored, err := NewScalerFromParams(params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rescaled, err := restored.Transform(closeFrame(tt.apply...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertColumn(t, rescaled, ColumnClose, tt.want)
+		})
+	}
+}
+
+func TestScalerErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func() error
+	}{
+		{"unknown kind", func() error {
+			_, err := NewScaler("quantile")
+			return err
+		}},
+		{"transform before fit", func() error {
+			scaler, _ := NewScaler(ScalerZScore)
+			_, err := scaler.Transform(closeFrame(1, 2))
+			return err
+		}},
+		{"missing column", func() error {
+			scaler, _ := NewScaler(ScalerMinMax)
+			return scaler.Fit(closeFrame(1, 2), []string{ColumnVolume})
+		}},
+		{"non-positive price for log return", func() error {
+			scaler, _ := NewScaler(ScalerLogReturn)
+			if err := scaler.Fit(closeFrame(1), []string{ColumnClose}); err != nil {
+				return nil
+			}
+			_, err := scaler.Transform(closeFrame(1, 0, 2))
+			return err
+		}},
+		{"incomplete params", func() error {
+			_, err := NewScalerFromParams(&ScalerParams{Kind: ScalerMinMax, Columns: []string{ColumnClose}})
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.run(); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestDecodeScalerFromModelFile(t *testing.T) {
+	// Файл модели: граф и веса, затем (в новом формате) параметры масштабирования
+	type weights map[string][]float32
+	writeModel := func(scaler Scaler, withScaler bool) *gob.Decoder {
+		var buffer bytes.Buffer
+		encoder := gob.NewEncoder(&buffer)
+		if err := encoder.Encode(weights{"hidden": {0.5, -0.25}}); err != nil {
+			t.Fatal(err)
+		}
+		if withScaler {
+			if err := EncodeScaler(encoder, scaler); err != nil {
+				t.Fatal(err)
+			}
+		}
+		decoder := gob.NewDecoder(&buffer)
+		var loaded weights
+		if err := decoder.Decode(&loaded); err != nil {
+			t.Fatal(err)
+		}
+		return decoder
+	}
+
+	// Файл, сохраненный до появления масштабирования, загружается без него
+	if scaler, err := DecodeScaler(writeModel(nil, false)); err != nil || scaler != nil {
+		t.Errorf("old model file: scaler = %v, error = %v, want no scaler", scaler, err)
+	}
+	if scaler, err := DecodeScaler(writeModel(nil, true)); err != nil || scaler != nil {
+		t.Errorf("model without scaler: scaler = %v, error = %v, want no scaler", scaler, err)
+	}
+
+	fitted, _ := NewScaler(ScalerMinMax)
+	if err := fitted.Fit(closeFrame(10, 20, 30), []string{ColumnClose}); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := DecodeScaler(writeModel(fitted, true))
+	if err != nil || restored == nil {
+		t.Fatalf("restored scaler = %v, error = %v", restored, err)
+	}
+	scaled, err := restored.Transform(closeFrame(15, 30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertColumn(t, scaled, ColumnClose, []float64{0.25, 1})
+
+	// Оборванная запись параметров - ошибка, а не модель без масштабирования
+	var buffer bytes.Buffer
+	if err := EncodeScaler(gob.NewEncoder(&buffer), fitted); err != nil {
+		t.Fatal(err)
+	}
+	truncated := gob.NewDecoder(bytes.NewReader(buffer.Bytes()[:buffer.Len()-3]))
+	if _, err := DecodeScaler(truncated); err == nil {
+		t.Error("truncated scaler params decoded without error")
+	}
+}
@@ -0,0 +1,46 @@
+package data
+
+import "sync"
+
+// Подписчики на обновления котировок и стаканов
+var (
+	subscribersMutex     sync.RWMutex
+	quoteSubscribers     []func(quote Quote)
+	orderBookSubscribers []func(book OrderBook)
+)
+
+// Функция для подписки на принятые котировки (вызывается после UpdateQuotes)
+func SubscribeQuotes(handler func(quote Quote)) {
+	subscribersMutex.Lock()
+	defer subscribersMutex.Unlock()
+	quoteSubscribers = append(quoteSubscribers, handler)
+}
+
+// Функция для подписки на обновления стаканов (вызывается после UpdateOrderBook)
+func SubscribeOrderBooks(handler func(book OrderBook)) {
+	subscribersMutex.Lock()
+	defer subscribersMutex.Unlock()
+	orderBookSubscribers = append(orderBookSubscribers, handler)
+}
+
+// Функция для уведомления подписчиков о котировке
+func notifyQuote(quote Quote) {
+	subscribersMutex.RLock()
+	handlers := quoteSubscribers
+	subscribersMutex.RUnlock()
+
+	for _, handler := range handlers {
+		handler(quote)
+	}
+}
+
+// Функция для уведомления подписчиков о стакане
+func notifyOrderBook(book OrderBook) {
+	subscribersMutex.RLock()
+	handlers := orderBookSubscribers
+	subscribersMutex.RUnlock()
+
+	for _, handler := range handlers {
+		handler(book)
+	}
+}
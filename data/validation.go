@@ -0,0 +1,454 @@
+package data
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"trading-bot/logger"
+)
+
+// Тип нарушения качества рыночных данных
+type ViolationKind string
+
+const (
+	ViolationInvalidPrice  ViolationKind = "invalid_price"  // Неположительная цена
+	ViolationInvalidVolume ViolationKind = "invalid_volume" // Неположительный объем
+	ViolationStaleFeed     ViolationKind = "stale_feed"     // Котировки давно не обновлялись
+	ViolationPriceSpike    ViolationKind = "price_spike"    // Выброс цены относительно волатильности
+	ViolationOutOfOrder    ViolationKind = "out_of_order"   // Время котировки меньше предыдущей
+	ViolationDuplicateTick ViolationKind = "duplicate_tick" // Повтор предыдущей котировки
+	ViolationCrossedBook   ViolationKind = "crossed_book"   // Лучшая покупка выше лучшей продажи
+	ViolationCandleGap     ViolationKind = "candle_gap"     // Пропуск свечей в истории
+	ViolationInvalidCandle ViolationKind = "invalid_candle" // Некорректная свеча (High < Low и т.п.)
+)
+
+// Структура для описания нарушения
+type Violation struct {
+	Kind    ViolationKind `json:"kind"`
+	Symbol  string        `json:"symbol"`
+	Time    time.Time     `json:"time"`
+	Message string        `json:"message"`
+}
+
+// Структура для настройки проверок качества данных
+type ValidationConfig struct {
+	StaleAfter         time.Duration          // Через сколько без котировок поток считается устаревшим
+	SpikeWindow        int                    // Количество последних доходностей для оценки волатильности
+	SpikeMinSamples    int                    // Минимум доходностей, после которого включается фильтр выбросов
+	SpikeThreshold     float64                // Порог выброса в стандартных отклонениях
+	SpikeMinMove       float64                // Минимальное относительное движение, считающееся выбросом
+	SpikeConfirmations int                    // Сколько выбросов подряд, согласованных между собой, подтверждают новый уровень цены (гэп)
+	GapTolerance       float64                // Допустимое отношение интервала между свечами к ожидаемому
+	HaltOn             map[ViolationKind]bool // Нарушения, после которых торговля по инструменту останавливается
+	ResumeOnFreshFeed  bool                   // Снимать остановку по устаревшему потоку при новой котировке
+}
+
+// Функция возвращает настройки проверок по умолчанию
+func DefaultValidationConfig() *ValidationConfig {
+	return &ValidationConfig{
+		StaleAfter:         time.Minute,
+		SpikeWindow:        100,
+		SpikeMinSamples:    20,
+		SpikeThreshold:     8,
+		SpikeMinMove:       0.005,
+		SpikeConfirmations: 3,
+		GapTolerance:       1.5,
+		HaltOn: map[ViolationKind]bool{
+			ViolationStaleFeed:   true,
+			ViolationCrossedBook: true,
+		},
+		ResumeOnFreshFeed: true,
+	}
+}
+
+// Состояние проверок по одному инструменту
+type symbolValidationState struct {
+	lastQuote    *Quote
+	lastReceived time.Time
+	returns      []float64
+	stale        bool
+	spikes       []Quote // Отклоненные подряд выбросы, согласованные между собой
+}
+
+// Структура для проверки качества рыночных данных
+type QuoteValidator struct {
+	config *ValidationConfig
+	mu     sync.Mutex
+	states map[string]*symbolValidationState
+	counts map[string]map[ViolationKind]int
+	halted map[string]Violation
+	stop   chan bool
+}
+
+// Валидатор, используемый UpdateQuotes и UpdateOrderBook
+var DefaultValidator = NewQuoteValidator(DefaultValidationConfig())
+
+// Функция для создания нового валидатора
+func NewQuoteValidator(config *ValidationConfig) *QuoteValidator {
+	return &QuoteValidator{
+		config: config,
+		states: make(map[string]*symbolValidationState),
+		counts: make(map[string]map[ViolationKind]int),
+		halted: make(map[string]Violation),
+		stop:   make(chan bool),
+	}
+}
+
+// Функция для проверки новой котировки. Если нарушения найдены, котировку следует отбросить
+func (v *QuoteValidator) ValidateQuote(quote *Quote) []Violation {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	var violations []Violation
+	violation := func(kind ViolationKind, format string, args ...interface{}) {
+		violations = append(violations, Violation{
+			Kind:    kind,
+			Symbol:  quote.Symbol,
+			Time:    quote.Time,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	if quote.Price <= 0 {
+		violation(ViolationInvalidPrice, "invalid quote price %f", quote.Price)
+	}
+	if quote.Volume <= 0 {
+		violation(ViolationInvalidVolume, "invalid quote volume %d", quote.Volume)
+	}
+
+	state := v.state(quote.Symbol)
+	if len(violations) == 0 && state.lastQuote != nil {
+		last := state.lastQuote
+		switch {
+		case quote.Time.Before(last.Time):
+			violation(ViolationOutOfOrder, "quote time %s is before previous %s",
+				quote.Time.Format(time.RFC3339Nano), last.Time.Format(time.RFC3339Nano))
+		case quote.Time.Equal(last.Time) && quote.Price == last.Price && quote.Volume == last.Volume:
+			violation(ViolationDuplicateTick, "duplicate tick at %s", quote.Time.Format(time.RFC3339Nano))
+		default:
+			ret := math.Log(quote.Price / last.Price)
+			switch {
+			case !v.isSpike(state, ret):
+				state.spikes = nil
+				state.returns = append(state.returns, ret)
+				if len(state.returns) > v.config.SpikeWindow {
+					state.returns = state.returns[len(state.returns)-v.config.SpikeWindow:]
+				}
+			case v.confirmsLevel(state, *quote):
+				// Цена действительно ушла на новый уровень (гэп на открытии, новости): котировка
+				// принимается и становится новой опорной, иначе все следующие котировки отклонялись бы
+				logger.Logger.Warn().
+					Str("symbol", quote.Symbol).
+					Float64("from", last.Price).
+					Float64("to", quote.Price).
+					Int("confirmations", len(state.spikes)).
+					Msg("Price level shift confirmed, spike filter re-anchored")
+				state.spikes = nil
+			default:
+				violation(ViolationPriceSpike, "price moved from %f to %f (log return %.5f)", last.Price, quote.Price, ret)
+			}
+		}
+	}
+
+	if len(violations) == 0 {
+		accepted := *quote
+		state.lastQuote = &accepted
+		state.lastReceived = time.Now()
+		if state.stale {
+			state.stale = false
+			if halt, ok := v.halted[quote.Symbol]; ok && halt.Kind == ViolationStaleFeed && v.config.ResumeOnFreshFeed {
+				delete(v.halted, quote.Symbol)
+				logger.Logger.Info().Str("symbol", quote.Symbol).Msg("Quote feed is fresh again, trading resumed")
+			}
+		}
+	}
+
+	v.report(violations)
+	return violations
+}
+
+// Функция для учета выброса: возвращает true, когда SpikeConfirmations выбросов подряд
+// согласованы между собой (между соседними нет выброса) и подтверждают новый уровень цены
+func (v *QuoteValidator) confirmsLevel(state *symbolValidationState, quote Quote) bool {
+	if v.config.SpikeConfirmations <= 0 {
+		return false
+	}
+	if n := len(state.spikes); n > 0 && v.isSpike(state, math.Log(quote.Price/state.spikes[n-1].Price)) {
+		state.spikes = nil
+	}
+	state.spikes = append(state.spikes, quote)
+	return len(state.spikes) >= v.config.SpikeConfirmations
+}
+
+// Функция проверяет, является ли доходность выбросом относительно недавней волатильности
+func (v *QuoteValidator) isSpike(state *symbolValidationState, ret float64) bool {
+	if len(state.returns) < v.config.SpikeMinSamples {
+		return false
+	}
+	move := math.Abs(ret)
+	if move < v.config.SpikeMinMove {
+		return false
+	}
+	stdDev := standardDeviation(state.returns)
+	if stdDev == 0 {
+		return true // Цена стояла на месте, а затем резко сдвинулась
+	}
+	return move > v.config.SpikeThreshold*stdDev
+}
+
+// Функция для проверки стакана на пересечение лучших цен
+func (v *QuoteValidator) ValidateOrderBook(book *OrderBook) []Violation {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	var violations []Violation
+	bid, hasBid := book.BestBid()
+	ask, hasAsk := book.BestAsk()
+	if hasBid && hasAsk && bid.Price >= ask.Price {
+		violations = append(violations, Violation{
+			Kind:    ViolationCrossedBook,
+			Symbol:  book.Symbol,
+			Time:    book.Time,
+			Message: fmt.Sprintf("best bid %f is not below best ask %f", bid.Price, ask.Price),
+		})
+	}
+
+	v.report(violations)
+	return violations
+}
+
+// Функция для поиска инструментов, по которым котировки не приходили дольше StaleAfter
+func (v *QuoteValidator) CheckStale(now time.Time) []Violation {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	var violations []Violation
+	for symbol, state := range v.states {
+		if state.stale || state.lastReceived.IsZero() {
+			continue
+		}
+		silence := now.Sub(state.lastReceived)
+		if silence > v.config.StaleAfter {
+			state.stale = true
+			violations = append(violations, Violation{
+				Kind:    ViolationStaleFeed,
+				Symbol:  symbol,
+				Time:    now,
+				Message: fmt.Sprintf("no quotes for %s", silence.Round(time.Second)),
+			})
+		}
+	}
+
+	v.report(violations)
+	return violations
+}
+
+// Функция для проверки исторических свечей: порядок, дубликаты, пропуски и корректность цен
+func (v *QuoteValidator) ValidateCandles(candles *CandleSeries) []Violation {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	var violations []Violation
+	violation := func(kind ViolationKind, t time.Time, format string, args ...interface{}) {
+		violations = append(violations, Violation{
+			Kind:    kind,
+			Symbol:  candles.Symbol,
+			Time:    t,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	interval, err := ParseInterval(candles.Interval)
+	if err != nil {
+		logger.Logger.Warn().Err(err).Str("symbol", candles.Symbol).Msg("Unknown candle interval, gap check skipped")
+	}
+
+	for i, c := range candles.Candles {
+		if c.Low > c.High || c.Open <= 0 || c.Close <= 0 || c.Low <= 0 || c.Volume < 0 ||
+			c.Open > c.High || c.Open < c.Low || c.Close > c.High || c.Close < c.Low {
+			violation(ViolationInvalidCandle, c.Time, "invalid candle O=%f H=%f L=%f C=%f V=%f", c.Open, c.High, c.Low, c.Close, c.Volume)
+		}
+		if i == 0 {
+			continue
+		}
+
+		prev := candles.Candles[i-1]
+		switch {
+		case c.Time.Before(prev.Time):
+			violation(ViolationOutOfOrder, c.Time, "candle at %s follows %s", c.Time.Format(time.RFC3339), prev.Time.Format(time.RFC3339))
+		case c.Time.Equal(prev.Time):
+			violation(ViolationDuplicateTick, c.Time, "duplicate candle at %s", c.Time.Format(time.RFC3339))
+		case interval > 0 && isCandleGap(prev.Time, c.Time, interval, v.config.GapTolerance):
+			violation(ViolationCandleGap, c.Time, "gap between %s and %s", prev.Time.Format(time.RFC3339), c.Time.Format(time.RFC3339))
+		}
+	}
+
+	v.report(violations)
+	return violations
+}
+
+// Функция определяет, есть ли пропуск между соседними свечами.
+// Для дневных свечей не учитываются неторговые дни, для внутридневных - переход через ночь.
+func isCandleGap(prev, next time.Time, interval time.Duration, tolerance float64) bool {
+	prev = prev.In(ExchangeLocation)
+	next = next.In(ExchangeLocation)
+
+	if interval >= 24*time.Hour {
+		tradingDays := 0
+		for d := prev.AddDate(0, 0, 1); !d.After(next); d = d.AddDate(0, 0, 1) {
+			if IsTradingDay(d) {
+				tradingDays++
+			}
+		}
+		return float64(tradingDays) > tolerance*float64(interval/(24*time.Hour))
+	}
+
+	if prev.YearDay() != next.YearDay() || prev.Year() != next.Year() {
+		return false
+	}
+	return float64(next.Sub(prev)) > tolerance*float64(interval)
+}
+
+// Функция для разбора интервала свечей ("1m", "15m", "1h", "1d", "1w")
+func ParseInterval(interval string) (time.Duration, error) {
+	interval = strings.TrimSpace(interval)
+	if len(interval) < 2 {
+		return 0, fmt.Errorf("invalid interval: %q", interval)
+	}
+
+	count, err := strconv.Atoi(interval[:len(interval)-1])
+	if err != nil || count <= 0 {
+		return 0, fmt.Errorf("invalid interval: %q", interval)
+	}
+
+	switch interval[len(interval)-1] {
+	case 'm':
+		return time.Duration(count) * time.Minute, nil
+	case 'h':
+		return time.Duration(count) * time.Hour, nil
+	case 'd':
+		return time.Duration(count) * 24 * time.Hour, nil
+	case 'w':
+		return time.Duration(count) * 7 * 24 * time.Hour, nil
+	default:
+		return 0, fmt.Errorf("invalid interval unit: %q", interval)
+	}
+}
+
+// Функция для логирования, подсчета нарушений и остановки торговли (вызывается под v.mu)
+func (v *QuoteValidator) report(violations []Violation) {
+	for _, violation := range violations {
+		counts, ok := v.counts[violation.Symbol]
+		if !ok {
+			counts = make(map[ViolationKind]int)
+			v.counts[violation.Symbol] = counts
+		}
+		counts[violation.Kind]++
+
+		logger.Logger.Warn().
+			Str("symbol", violation.Symbol).
+			Str("kind", string(violation.Kind)).
+			Time("time", violation.Time).
+			Int("count", counts[violation.Kind]).
+			Msg(violation.Message)
+
+		if v.config.HaltOn[violation.Kind] {
+			if _, already := v.halted[violation.Symbol]; !already {
+				v.halted[violation.Symbol] = violation
+				logger.Logger.Error().
+					Str("symbol", violation.Symbol).
+					Str("kind", string(violation.Kind)).
+					Msg("Trading halted for symbol due to market data violation")
+			}
+		}
+	}
+}
+
+// Функция возвращает состояние проверок для инструмента (вызывается под v.mu)
+func (v *QuoteValidator) state(symbol string) *symbolValidationState {
+	state, ok := v.states[symbol]
+	if !ok {
+		state = &symbolValidationState{}
+		v.states[symbol] = state
+	}
+	return state
+}
+
+// Функция проверяет, остановлена ли торговля по инструменту
+func (v *QuoteValidator) IsHalted(symbol string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	_, halted := v.halted[symbol]
+	return halted
+}
+
+// Функция для ручного возобновления торговли по инструменту
+func (v *QuoteValidator) ResumeTrading(symbol string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if _, halted := v.halted[symbol]; halted {
+		delete(v.halted, symbol)
+		logger.Logger.Info().Str("symbol", symbol).Msg("Trading resumed for symbol")
+	}
+}
+
+// Функция возвращает копию счетчиков нарушений по инструментам
+func (v *QuoteValidator) Counts() map[string]map[ViolationKind]int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	result := make(map[string]map[ViolationKind]int, len(v.counts))
+	for symbol, counts := range v.counts {
+		copied := make(map[ViolationKind]int, len(counts))
+		for kind, count := range counts {
+			copied[kind] = count
+		}
+		result[symbol] = copied
+	}
+	return result
+}
+
+// Запуск периодической проверки устаревших котировок
+func (v *QuoteValidator) StartStaleMonitor(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-v.stop:
+				return
+			case now := <-ticker.C:
+				v.CheckStale(now)
+			}
+		}
+	}()
+}
+
+// Функция для остановки фоновой проверки
+func (v *QuoteValidator) Stop() {
+	close(v.stop)
+}
+
+// Функция проверяет, остановлена ли торговля по инструменту валидатором по умолчанию
+func IsTradingHalted(symbol string) bool {
+	return DefaultValidator.IsHalted(symbol)
+}
+
+// Функция для расчета стандартного отклонения
+func standardDeviation(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	m := mean(values)
+	sum := 0.0
+	for _, x := range values {
+		sum += (x - m) * (x - m)
+	}
+	return math.Sqrt(sum / float64(len(values)-1))
+}
@@ -0,0 +1,149 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Функция возвращает котировки инструмента с шагом в секунду
+func quoteSeries(start time.Time, prices ...float64) []Quote {
+	quotes := make([]Quote, len(prices))
+	for i, price := range prices {
+		quotes[i] = Quote{Symbol: "SBER", Price: price, Volume: 10, Time: start.Add(time.Duration(i) * time.Second)}
+	}
+	return quotes
+}
+
+func TestValidateQuote(t *testing.T) {
+	start := time.Date(2024, time.March, 1, 10, 0, 0, 0, ExchangeLocation)
+	baseline := []float64{100, 100.1, 100, 100.1, 100}
+
+	tests := []struct {
+		name          string
+		confirmations int
+		quotes        []Quote
+		want          []ViolationKind // Нарушения последней котировки
+	}{
+		{
+			name:   "valid first quote",
+			quotes: quoteSeries(start, 100),
+		},
+		{
+			name:   "non-positive price",
+			quotes: []Quote{{Symbol: "SBER", Price: 0, Volume: 10, Time: start}},
+			want:   []ViolationKind{ViolationInvalidPrice},
+		},
+		{
+			name:   "non-positive price and volume",
+			quotes: []Quote{{Symbol: "SBER", Price: -1, Volume: 0, Time: start}},
+			want:   []ViolationKind{ViolationInvalidPrice, ViolationInvalidVolume},
+		},
+		{
+			name: "out of order",
+			quotes: []Quote{
+				{Symbol: "SBER", Price: 100, Volume: 10, Time: start},
+				{Symbol: "SBER", Price: 100.1, Volume: 10, Time: start.Add(-time.Second)},
+			},
+			want: []ViolationKind{ViolationOutOfOrder},
+		},
+		{
+			name: "duplicate tick",
+			quotes: []Quote{
+				{Symbol: "SBER", Price: 100, Volume: 10, Time: start},
+				{Symbol: "SBER", Price: 100, Volume: 10, Time: start},
+			},
+			want: []ViolationKind{ViolationDuplicateTick},
+		},
+		{
+			name:   "ordinary move",
+			quotes: quoteSeries(start, append(baseline, 100.05)...),
+		},
+		{
+			name:   "spike",
+			quotes: quoteSeries(start, append(baseline, 110)...),
+			want:   []ViolationKind{ViolationPriceSpike},
+		},
+		{
+			name:   "spike before filter warms up",
+			quotes: quoteSeries(start, 100, 100.1, 110),
+		},
+		{
+			name:   "unconfirmed level shift",
+			quotes: quoteSeries(start, append(baseline, 110, 110.1)...),
+			want:   []ViolationKind{ViolationPriceSpike},
+		},
+		{
+			name:   "confirmed level shift",
+			quotes: quoteSeries(start, append(baseline, 110, 110.1, 110)...),
+		},
+		{
+			name:   "quote after confirmed level shift",
+			quotes: quoteSeries(start, append(baseline, 110, 110.1, 110, 110.05)...),
+		},
+		{
+			name:   "inconsistent spikes do not confirm",
+			quotes: quoteSeries(start, append(baseline, 110, 90, 110)...),
+			want:   []ViolationKind{ViolationPriceSpike},
+		},
+		{
+			name:          "confirmation disabled",
+			confirmations: -1,
+			quotes:        quoteSeries(start, append(baseline, 110, 110.1, 110, 110.05)...),
+			want:          []ViolationKind{ViolationPriceSpike},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultValidationConfig()
+			config.SpikeMinSamples = 4
+			config.SpikeWindow = 10
+			if tt.confirmations != 0 {
+				config.SpikeConfirmations = tt.confirmations
+			}
+			validator := NewQuoteValidator(config)
+
+			var violations []Violation
+			for i := range tt.quotes {
+				violations = validator.ValidateQuote(&tt.quotes[i])
+			}
+
+			var got []ViolationKind
+			for _, violation := range violations {
+				got = append(got, violation.Kind)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("violations = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateOrderBookHaltsTrading(t *testing.T) {
+	tests := []struct {
+		name      string
+		bids      []OrderBookLevel
+		asks      []OrderBookLevel
+		wantHalt  bool
+		wantCount int
+	}{
+		{name: "normal book", bids: []OrderBookLevel{{Price: 99, Quantity: 1}}, asks: []OrderBookLevel{{Price: 100, Quantity: 1}}},
+		{name: "one-sided book", bids: []OrderBookLevel{{Price: 99, Quantity: 1}}},
+		{name: "locked book", bids: []OrderBookLevel{{Price: 100, Quantity: 1}}, asks: []OrderBookLevel{{Price: 100, Quantity: 1}}, wantHalt: true, wantCount: 1},
+		{name: "crossed book", bids: []OrderBookLevel{{Price: 101, Quantity: 1}}, asks: []OrderBookLevel{{Price: 100, Quantity: 1}}, wantHalt: true, wantCount: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validator := NewQuoteValidator(DefaultValidationConfig())
+			violations := validator.ValidateOrderBook(&OrderBook{Symbol: "SBER", Bids: tt.bids, Asks: tt.asks})
+			if len(violations) != tt.wantCount {
+				t.Errorf("violations = %v, want %d", violations, tt.wantCount)
+			}
+			if halted := validator.IsHalted("SBER"); halted != tt.wantHalt {
+				t.Errorf("IsHalted = %v, want %v", halted, tt.wantHalt)
+			}
+		})
+	}
+}
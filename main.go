@@ -15,8 +15,6 @@ import (
 	"trading-bot/monitoring"
 	"trading-bot/order"
 	"trading-bot/strategy"
-
-	"github.com/go-gota/gota/dataframe"
 )
 
 func main() {
@@ -58,20 +56,63 @@ func main() {
 	}
 	defer transaqConnector.Close()
 
+	// Запуск чтения сообщений (до авторизации, иначе ответ на connect некому прочитать)
+	transaqConnector.StartReading()
+
 	// Авторизация
 	if err := transaqConnector.Authorize(); err != nil {
 		logger.Logger.Fatal().Err(err).Msg("Failed to authorize on Transaq Connector.")
 	}
 
-	// Запуск чтения сообщений
-	transaqConnector.StartReading()
-
 	// Запуск heartbeat
 	transaqConnector.StartHeartbeat(30 * time.Second)
 
+	// Выбор площадки исполнения ордеров
+	botSettings, err := loadBotConfig("connector/transaq.json")
+	if err != nil {
+		logger.Logger.Fatal().Err(err).Msg("Failed to load bot config.")
+	}
+	// Справочники фьючерсов, облигаций и валют общие для всех счетов
+	specs, err := botSettings.instrumentSpecs(tradingSymbol)
+	if err != nil {
+		logger.Logger.Fatal().Err(err).Msg("Failed to load instrument specs.")
+	}
+	// Начальные курсы валют до первых котировок валютного рынка
+	if err := specs.fx.LoadFromMOEX(); err != nil {
+		logger.Logger.Error().Err(err).Msg("Failed to load FX rates.")
+	}
+	specs.fx.AttachToFeed()
+
+	// Торговые счета: у каждого своя цепочка площадки, лимиты риска, журнал и стратегии
+	accounts, err := newTradingAccounts(botSettings, specs, finamConfig, transaqConnector)
+	for _, account := range accounts {
+		defer account.close()
+	}
+	if err != nil {
+		logger.Logger.Fatal().Err(err).Msg("Failed to create trading accounts.")
+	}
+
+	var volumeProfile *order.VolumeProfile
+	if execution := botSettings.Execution; execution != nil && execution.Algo == string(order.AlgoVWAP) {
+		days := execution.ProfileDays
+		if days <= 0 {
+			days = 20
+		}
+		intraday, err := finamAPI.LoadHistoricalData(tradingSymbol, time.Now().AddDate(0, 0, -days), time.Now(), "5m")
+		if err != nil {
+			logger.Logger.Error().Err(err).Msg("Failed to load intraday history for VWAP profile.")
+		} else {
+			volumeProfile = order.NewVolumeProfile(data.NewCandleSeriesFromFinam(tradingSymbol, "5m", intraday), 5*time.Minute)
+		}
+	}
+
 	// Запускаем мониторинг в отдельной горутине
 	go monitoring.MonitorServerStatus(1 * time.Minute)
 
+	// Запускаем проверку устаревших котировок
+	data.DefaultValidator.StartStaleMonitor(10 * time.Second)
+	defer data.DefaultValidator.Stop()
+
 	// --- Trading Robot Logic ---
 
 	// 1. Получение списка инструментов
@@ -97,7 +138,7 @@ func main() {
 	var simpleTrendStrategy *strategy.SimpleTrendStrategy //  Добавлена  переменная
 	switch chosenStrategy {
 	case "simple_trend":
-		simpleTrendStrategy = &strategy.SimpleTrendStrategy{Period: 14} //  Присваиваем  конкретную  стратегию  интерфейсу
+		simpleTrendStrategy = &strategy.SimpleTrendStrategy{Period: 14, StopLossPercent: 2, TakeProfitPercent: 4} //  Присваиваем  конкретную  стратегию  интерфейсу
 		chosenStrategyName = simpleTrendStrategy
 	// case "другая_стратегия":
 	//     strategy = &strategy.ДругаяСтратегия{ /* ...  параметры  ...  */ }
@@ -111,6 +152,21 @@ func main() {
 		logger.Logger.Error().Err(err).Msg("Failed to send get_securities command")
 	}
 
+	// Подписка на стакан по торгуемому инструменту (нужен виртуальному брокеру для исполнения по лучшей цене)
+	if err := transaqConnector.SubscribeOrderBook(botSettings.Board, tradingSymbol); err != nil {
+		logger.Logger.Error().Err(err).Msg("Failed to subscribe to order book")
+	}
+	// Подписка на стаканы валютного рынка для курсов валют
+	for _, symbol := range specs.fx.Symbols() {
+		if err := transaqConnector.SubscribeOrderBook("CETS", symbol); err != nil {
+			logger.Logger.Error().Err(err).Str("symbol", symbol).Msg("Failed to subscribe to FX order book")
+		}
+	}
+
+	// Расписание клирингов срочного рынка и время последней проверки выплат по облигациям
+	clearing := order.NewClearingSchedule(nil)
+	couponsCheckedAt := time.Now()
+
 	// Цикл работы робота
 	for {
 		// 1. Получаем сообщение от Transaq Connector
@@ -159,107 +215,94 @@ func main() {
 			continue //  Пропускаем  текущую  итерацию  и  переходим  к  следующей
 		}
 
-		// 3.  Получение  исторических  данных  для  выбранного  инструмента
-		if simpleTrendStrategy != nil { //  Проверка  на nil
-			startDate := time.Now().AddDate(0, 0, -simpleTrendStrategy.Period)
-			endDate := time.Now()
-			historicalData, err := finamAPI.LoadHistoricalData(tradingSymbol, startDate, endDate, "1d")
-			if err != nil {
-				logger.Logger.Error().Err(err).Msg("Error loading historical data.")
+		//  Торговля  по  инструменту  остановлена  из-за  проблем  с  рыночными  данными
+		if data.IsTradingHalted(tradingSymbol) {
+			logger.Logger.Warn().Str("symbol", tradingSymbol).Msg("Trading halted by market data checks, skipping iteration")
+			continue
+		}
+
+		//  Счета  с  включенной  аварийной  остановкой  до  ручного  сброса  не  торгуют
+		active := make([]*tradingAccount, 0, len(accounts))
+		for _, account := range accounts {
+			if account.killSwitch.IsActive() {
 				continue
 			}
+			account.onQuote(*quote)
+			if account.runsStrategy(chosenStrategy) {
+				active = append(active, account)
+			}
+		}
 
-			//  4.  Преобразование  FinamData  в  dataframe.DataFrame  для  стратегии
-			// Создаём пустой слайс для хранения данных в формате [][]string
-			var records [][]string
-
-			// Преобразуем каждую структуру из historicalData.C в слайс строк
-			for _, candle := range historicalData.C {
-				record := []string{
-					fmt.Sprintf("%f", candle.O),
-					fmt.Sprintf("%f", candle.C),
-					fmt.Sprintf("%f", candle.H),
-					fmt.Sprintf("%f", candle.L),
-					fmt.Sprintf("%f", candle.V),
-					fmt.Sprintf("%d", candle.T),
+		//  Клиринг  по  фьючерсам  и  перенос  позиций  перед  исполнением  контрактов
+		if botSettings.Futures != nil {
+			now := time.Now()
+			cleared := clearing.Due(now)
+			for _, account := range accounts {
+				if cleared {
+					account.clearFutures(now)
+				}
+				if account.killSwitch.IsActive() {
+					continue
+				}
+				if err := account.roller.Check(now); err != nil {
+					logger.Logger.Error().Err(err).Str("account", account.name).Msg("Futures rollover failed.")
 				}
-				records = append(records, record)
 			}
+		}
 
-			// Создаём DataFrame из преобразованных данных
-			historyDf := dataframe.LoadRecords(records)
-
-			// 5. Получение информации о  портфеле
-			portfolio, err := order.GetPortfolioInfo(finamConfig.AccessToken)
-			if err != nil {
-				logger.Logger.Error().Err(err).Msg("Error getting portfolio info.")
-				continue
+		//  Купоны  и  погашение  облигаций
+		if botSettings.Bonds != nil {
+			now := time.Now()
+			for _, account := range accounts {
+				account.payCoupons(couponsCheckedAt, now)
 			}
+			couponsCheckedAt = now
+		}
 
-			// 6. Генерация торговых сигналов на основе стратегии
-			signals, err := chosenStrategyName.GetSignals(quote, &historyDf, portfolio)
+		if len(active) == 0 {
+			continue
+		}
+
+		// 3.  Получение  исторических  данных  для  выбранного  инструмента
+		if simpleTrendStrategy != nil { //  Проверка  на nil
+			startDate := time.Now().AddDate(0, 0, -simpleTrendStrategy.Period)
+			endDate := time.Now()
+			historicalData, err := finamAPI.LoadHistoricalData(tradingSymbol, startDate, endDate, "1d")
 			if err != nil {
-				logger.Logger.Error().Err(err).Msg("Error getting trading signals.")
+				logger.Logger.Error().Err(err).Msg("Error loading historical data.")
 				continue
 			}
 
-			// 7. Логика принятия  решений  и  отправки  ордеров
-			for _, signal := range signals {
-				// 7.1. Реализация  логики  принятия  решений
-
-				//  Проверка  наличия  достаточных  средств
-				if signal.Side == "buy" && portfolio.Balances["RUB"].Available < signal.Price {
-					logger.Logger.Warn().
-						Str("symbol", signal.Symbol).
-						Msg("Недостаточно средств для покупки")
-					continue //  Пропускаем  ордер
+			//  4.  Преобразование  FinamData  в  dataframe.DataFrame  для  стратегии
+			candles := data.NewCandleSeriesFromFinam(tradingSymbol, "1d", historicalData)
+			data.DefaultValidator.ValidateCandles(candles)
+			historyDf := candles.ToDataFrame()
+
+			for _, account := range active {
+				account.stops.UpdateBars(tradingSymbol, &historyDf)
+				if candles.Len() > 0 {
+					lastBar := candles.Candles[candles.Len()-1]
+					account.costs.SetVolatility(tradingSymbol, lastBar.High-lastBar.Low)
 				}
 
-				//  Проверка,  что  у  нас  нет  уже  открытой  позиции  по  этому  инструменту
-				hasOpenPosition := false
-				for _, position := range portfolio.Positions {
-					if position.Symbol == signal.Symbol && position.Quantity > 0 {
-						hasOpenPosition = true
-						break
-					}
-				}
-				if hasOpenPosition {
-					logger.Logger.Warn().
-						Str("symbol", signal.Symbol).
-						Msg("Позиция по инструменту уже открыта")
-					continue // Пропускаем ордер
+				// 5. Получение информации о  портфеле счета
+				portfolio, err := account.broker.GetPortfolioInfo()
+				if err != nil {
+					logger.Logger.Error().Err(err).Str("account", account.name).Msg("Error getting portfolio info.")
+					continue
 				}
 
-				// 7.2. Создание  и  отправка  ордера
-				if signal.Side == "buy" {
-					//  Создание  ордера  на  покупку
-					orderRequest := &order.OrderRequest{
-						Symbol:      signal.Symbol,
-						Side:        signal.Side,
-						Quantity:    1,        //  Пример:  покупаем  1  лот
-						OrderType:   "market", //  Пример:  рыночный  ордер
-						AccessToken: finamConfig.AccessToken,
-						// ... (добавьте  другие  поля,  если  необходимо)
-					}
-					_, err := order.CreateOrder(orderRequest)
-					if err != nil {
-						logger.Logger.Error().Err(err).Msg("Error creating buy order.")
-					}
-				} else if signal.Side == "sell" {
-					//  Создание  ордера  на  продажу
-					orderRequest := &order.OrderRequest{
-						Symbol:      signal.Symbol,
-						Side:        signal.Side,
-						Quantity:    1,        //  Пример:  продаём  1  лот
-						OrderType:   "market", //  Пример:  рыночный  ордер
-						AccessToken: finamConfig.AccessToken,
-						// ...  (добавьте  другие  поля,  если  необходимо)
-					}
-					_, err := order.CreateOrder(orderRequest)
-					if err != nil {
-						logger.Logger.Error().Err(err).Msg("Error  creating  sell  order.")
-					}
+				//  Предупреждение  о  нехватке  обеспечения  и  маржин-колле
+				account.margin.Evaluate(portfolio)
+
+				// 6. Генерация торговых сигналов на основе стратегии
+				signals, err := chosenStrategyName.GetSignals(quote, &historyDf, portfolio)
+				if err != nil {
+					logger.Logger.Error().Err(err).Str("account", account.name).Msg("Error getting trading signals.")
+					continue
 				}
+
+				account.handleSignals(signals, chosenStrategy, botSettings, volumeProfile)
 			}
 		}
 	}
@@ -281,3 +324,243 @@ func loadFinamConfig(filename string, config *api.FinamConfig) error {
 
 	return nil
 }
+
+// Структура для общих настроек бота из connector/transaq.json
+type botConfig struct {
+	Broker          string                 `json:"broker"`           // Площадка исполнения: "finam", "transaq" или "paper"
+	ClientCode      string                 `json:"client_code"`      // Код клиента для Transaq
+	Board           string                 `json:"board"`            // Код площадки для Transaq (по умолчанию TQBR)
+	PaperCapital    float64                `json:"paper_capital"`    // Начальный капитал виртуального брокера (в базовой валюте)
+	PaperSlippage   float64                `json:"paper_slippage"`   // Проскальзывание рыночных ордеров виртуального брокера (в процентах)
+	PaperCommission float64                `json:"paper_commission"` // Комиссия виртуального брокера (в процентах от оборота)
+	Costs           *order.CostConfig      `json:"costs"`            // Тарифы комиссий, сборов и проскальзывания по рынкам (если nil - для бумаги paper_commission и paper_slippage)
+	LotSizes        map[string]int         `json:"lot_sizes"`        // Размеры лотов по инструментам
+	Holidays        []string               `json:"holidays"`         // Дополнительные неторговые дни биржи ("2006-01-02"), например переносы праздников
+	Workdays        []string               `json:"workdays"`         // Торговые выходные дни биржи ("2006-01-02")
+	OrderStorePath  string                 `json:"order_store_path"` // Файл с клиентскими ID отправленных ордеров
+	Risk            order.RiskLimits       `json:"risk"`             // Лимиты проверок риска
+	RiskAuditPath   string                 `json:"risk_audit_path"`  // Журнал отказов проверок риска (JSONL)
+	KillSwitch      order.KillSwitchConfig `json:"kill_switch"`      // Настройки аварийной остановки
+	StopsStatePath  string                 `json:"stops_state_path"` // Файл эмулируемых условных ордеров
+	JournalPath     string                 `json:"journal_path"`     // Журнал ордеров и сделок (JSONL)
+	Throttle        order.ThrottleConfig   `json:"throttle"`         // Лимиты частоты сообщений площадке
+	Reconcile       order.ReconcileConfig  `json:"reconcile"`        // Сверка с площадкой
+	TrailingStop    *order.TrailingConfig  `json:"trailing_stop"`    // Трейлинг-стоп на позицию (если задан - вместо фиксированного стоп-лосса)
+	OrderQuantity   int                    `json:"order_quantity"`   // Размер ордера по сигналу (в лотах)
+	Accounts        []accountConfig        `json:"accounts"`         // Торговые счета (если не заданы - один счет по общим настройкам)
+	Execution       *executionConfig       `json:"execution"`        // Алгоритм исполнения ордеров больше одного лота (если nil - один рыночный ордер)
+	Margin          *order.MarginConfig    `json:"margin"`           // Маржинальная торговля и короткие продажи (если nil - наличный счет)
+	Futures         *futuresConfig         `json:"futures"`          // Фьючерсы срочного рынка FORTS (если nil - только акции)
+	Bonds           *bondsConfig           `json:"bonds"`            // Облигации: НКД, купоны и погашение (если nil - облигации не торгуются)
+	BaseCurrency    string                 `json:"base_currency"`    // Валюта оценки счета и денежных лимитов риска (по умолчанию RUB)
+	Currencies      map[string]string      `json:"currencies"`       // Валюты торгов инструментов (дополняют справочные данные)
+	FXInstruments   map[string]string      `json:"fx_instruments"`   // Инструменты валютного рынка для курсов и их валюты (по умолчанию CNYRUB_TOM, USD000UTSTOM)
+}
+
+// Структура для настройки торговли фьючерсами
+type futuresConfig struct {
+	Assets        []string             `json:"assets"`         // Базовые активы, контракты которых загружаются с ISS API (например, Si, RTS)
+	ContractsFile string               `json:"contracts_file"` // Локальный файл со спецификациями (дополняет ISS API)
+	Board         string               `json:"board"`          // Код площадки для Transaq (по умолчанию FUT)
+	Rollover      order.RolloverConfig `json:"rollover"`       // Перенос позиций на следующий контракт
+}
+
+// Структура для настройки алгоритма исполнения
+type executionConfig struct {
+	Algo               string  `json:"algo"`                 // "twap", "vwap" или "iceberg"
+	DurationMinutes    int     `json:"duration_minutes"`     // Длительность TWAP/VWAP
+	Slices             int     `json:"slices"`               // Количество частей TWAP/VWAP
+	VisibleQuantity    int     `json:"visible_quantity"`     // Видимая часть айсберга (в лотах)
+	LimitOffsetPercent float64 `json:"limit_offset_percent"` // Предельная цена: отступ от цены сигнала в худшую сторону (0 - без ограничения)
+	MaxParticipation   float64 `json:"max_participation"`    // Максимальная доля рыночного объема (0..1)
+	ProfileDays        int     `json:"profile_days"`         // За сколько дней строить профиль объема для VWAP
+}
+
+// Функция возвращает настройки алгоритма для ордера по цене сигнала
+func (c *executionConfig) algoConfig(side string, price float64, profile *order.VolumeProfile) order.AlgoConfig {
+	config := order.AlgoConfig{
+		Type:             order.AlgoType(c.Algo),
+		Duration:         time.Duration(c.DurationMinutes) * time.Minute,
+		Slices:           c.Slices,
+		VisibleQuantity:  c.VisibleQuantity,
+		MaxParticipation: c.MaxParticipation,
+		Profile:          profile,
+	}
+	if c.LimitOffsetPercent > 0 && price > 0 {
+		offset := price * c.LimitOffsetPercent / 100
+		if side == "sell" {
+			offset = -offset
+		}
+		config.LimitPrice = price + offset
+	}
+	return config
+}
+
+// Функция для загрузки общих настроек бота
+func loadBotConfig(filename string) (*botConfig, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	config := &botConfig{
+		Broker:          "finam",
+		Board:           "TQBR",
+		PaperCapital:    1000000,
+		PaperCommission: 0.05,
+		OrderStorePath:  "state/client_orders.json",
+		Risk: order.RiskLimits{
+			MaxPositionLots:    1,
+			CheckCash:          true,
+			DailyLossStatePath: "state/daily_loss.json",
+		},
+		OrderQuantity:  1,
+		Reconcile:      order.DefaultReconcileConfig(),
+		Throttle:       order.DefaultThrottleConfig(),
+		RiskAuditPath:  "logs/risk_audit.jsonl",
+		StopsStatePath: "state/stop_orders.json",
+		JournalPath:    "state/order_journal.jsonl",
+		KillSwitch: order.KillSwitchConfig{
+			StatePath:   "state/kill_switch.json",
+			TriggerFile: "state/KILL",
+			AuditPath:   "logs/kill_switch_audit.jsonl",
+			RiskChecks:  []string{"daily_loss"},
+		},
+	}
+	if err := json.Unmarshal(content, config); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+	if config.Risk.LotSizes == nil {
+		config.Risk.LotSizes = config.LotSizes
+	}
+	if config.Futures != nil && config.Futures.Rollover.StatePath == "" {
+		config.Futures.Rollover.StatePath = "state/futures_rolls.json"
+	}
+	if err := data.AddExchangeHolidays(config.Holidays...); err != nil {
+		return nil, err
+	}
+	if err := data.AddExchangeWorkdays(config.Workdays...); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Функция возвращает маржинальную модель из настроек (nil для наличного счета)
+func (c *botConfig) marginModel() *order.MarginModel {
+	if c.Margin == nil {
+		return nil
+	}
+	return order.NewMarginModel(*c.Margin, c.LotSizes)
+}
+
+// Структура для настройки торговли облигациями
+type bondsConfig struct {
+	Symbols []string          `json:"symbols"` // Облигации, описание которых загружается с ISS API
+	File    string            `json:"file"`    // Локальный файл с описанием облигаций (дополняет ISS API)
+	Boards  map[string]string `json:"boards"`  // Коды площадок Transaq по облигациям (по умолчанию TQOB, для корпоративных - TQCB)
+}
+
+// Справочники инструментов, общие для всех счетов
+type instrumentSpecs struct {
+	futures   *order.FuturesSpecs // nil, если фьючерсы не торгуются
+	bonds     *order.BondSpecs    // nil, если облигации не торгуются
+	fx        *data.FXRates       // Курсы валют к рублю
+	valuation *order.Valuation    // Валюты торгов инструментов и оценка в базовой валюте
+}
+
+// Функция для загрузки справочников инструментов из настроек. symbols - торгуемые акции,
+// валюта торгов которых запрашивается с ISS API
+func (c *botConfig) instrumentSpecs(symbols ...string) (*instrumentSpecs, error) {
+	futures, err := c.futuresSpecs()
+	if err != nil {
+		return nil, fmt.Errorf("error loading futures contracts: %w", err)
+	}
+	bonds, err := c.bondSpecs()
+	if err != nil {
+		return nil, fmt.Errorf("error loading bonds: %w", err)
+	}
+	fx := data.NewFXRates(c.FXInstruments)
+	return &instrumentSpecs{
+		futures:   futures,
+		bonds:     bonds,
+		fx:        fx,
+		valuation: c.valuation(fx, bonds, symbols),
+	}, nil
+}
+
+// Функция возвращает оценку в базовой валюте: валюты торгов берутся из справочных данных,
+// настройки currencies имеют приоритет. Фьючерсы FORTS торгуются в рублях
+func (c *botConfig) valuation(fx *data.FXRates, bonds *order.BondSpecs, symbols []string) *order.Valuation {
+	valuation := order.NewValuation(c.BaseCurrency, fx)
+	for _, symbol := range symbols {
+		info, err := data.GetInstrumentInfo(symbol)
+		if err != nil {
+			logger.Logger.Warn().Err(err).Str("symbol", symbol).Msg("Failed to get instrument currency, using default")
+			continue
+		}
+		valuation.SetCurrency(symbol, info.Currency)
+	}
+	if bonds != nil {
+		for _, bond := range bonds.Bonds() {
+			valuation.SetCurrency(bond.Symbol, bond.Currency)
+		}
+	}
+	for symbol, currency := range c.Currencies {
+		valuation.SetCurrency(symbol, currency)
+	}
+	return valuation
+}
+
+// Функция возвращает справочник облигаций из настроек (nil, если облигации не торгуются)
+func (c *botConfig) bondSpecs() (*order.BondSpecs, error) {
+	if c.Bonds == nil {
+		return nil, nil
+	}
+	specs := order.NewBondSpecs(nil)
+	if c.Bonds.File != "" {
+		bonds, err := data.LoadBondsFromFile(c.Bonds.File)
+		if err != nil {
+			return nil, err
+		}
+		specs.Update(bonds)
+	}
+	for _, symbol := range c.Bonds.Symbols {
+		bond, err := data.LoadBondFromMOEX(symbol)
+		if err != nil {
+			return nil, fmt.Errorf("error loading bond %s: %w", symbol, err)
+		}
+		specs.Update([]data.Bond{*bond})
+	}
+	return specs, nil
+}
+
+// Функция возвращает справочник фьючерсных контрактов из настроек (nil, если фьючерсы не торгуются)
+func (c *botConfig) futuresSpecs() (*order.FuturesSpecs, error) {
+	if c.Futures == nil {
+		return nil, nil
+	}
+	specs := order.NewFuturesSpecs(nil)
+	if c.Futures.ContractsFile != "" {
+		contracts, err := data.LoadFuturesContractsFromFile(c.Futures.ContractsFile)
+		if err != nil {
+			return nil, err
+		}
+		specs.Update(contracts)
+	}
+	for _, asset := range c.Futures.Assets {
+		contracts, err := data.LoadFuturesContractsFromMOEX(asset)
+		if err != nil {
+			return nil, fmt.Errorf("error loading futures contracts for %s: %w", asset, err)
+		}
+		specs.Update(contracts)
+	}
+	return specs, nil
+}
+
+// Функция возвращает модель издержек по тарифам из настроек (nil, если тарифы не заданы)
+func (c *botConfig) costModel() *order.CostModel {
+	if c.Costs == nil {
+		return nil
+	}
+	return order.NewCostModel(*c.Costs, c.LotSizes)
+}
@@ -6,6 +6,8 @@ import (
 
 	"encoding/gob"
 
+	"trading-bot/data"
+
 	"github.com/go-gota/gota/dataframe"
 	"gorgonia.org/gorgonia"
 	"gorgonia.org/tensor"
@@ -18,6 +20,7 @@ type Model struct {
 	input   *gorgonia.Node
 	output  *gorgonia.Node
 	weights map[string]*gorgonia.Node
+	scaler  data.Scaler // Масштабирование признаков, обученное на тренировочном окне (может быть nil)
 }
 
 // Функция для создания новой модели.
@@ -55,8 +58,37 @@ func NewModel() (*Model, error) {
 	return model, nil
 }
 
+// Функция для установки масштабирования признаков. Scaler должен быть обучен (Fit) на тренировочном окне,
+// после чего одни и те же параметры применяются в Train и Predict и сохраняются вместе с моделью.
+func (m *Model) SetScaler(scaler data.Scaler) {
+	m.scaler = scaler
+}
+
+// Функция возвращает масштабирование признаков модели
+func (m *Model) Scaler() data.Scaler {
+	return m.scaler
+}
+
+// Функция для применения масштабирования к входным данным
+func (m *Model) scaleInput(inputData *dataframe.DataFrame) (*dataframe.DataFrame, error) {
+	if m.scaler == nil {
+		return inputData, nil
+	}
+	scaled, err := m.scaler.Transform(inputData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scale input data: %w", err)
+	}
+	return scaled, nil
+}
+
 // Функция для обучения модели Train должна выполнять следующие действия: 1. Подготовить входные и выходные данные. 2. Определить функцию потерь (например, среднеквадратичную ошибку). 3. Выполнить оптимизацию (например, градиентный спуск) для обновления весов модели. 4. Выполнить несколько эпох для улучшения модели.
 func (m *Model) Train(inputData, outputData *dataframe.DataFrame, epochs int, learningRate float64) error {
+	// Масштабирование признаков теми же параметрами, что будут использованы при прогнозе
+	inputData, err := m.scaleInput(inputData)
+	if err != nil {
+		return err
+	}
+
 	// Преобразуем входные данные в тензор. Подготовка данных: Преобразуем входные и выходные данные из dataframe.DataFrame в тензоры Gorgonia, используя tensor.New и gorgonia.WithValue.
 	xTensor := tensor.New(tensor.Of(tensor.Float32), tensor.WithShape(inputData.Nrow(), inputData.Ncol()), tensor.WithBacking(inputData.Records()))
 	yTensor := tensor.New(tensor.Of(tensor.Float32), tensor.WithShape(outputData.Nrow(), 1), tensor.WithBacking(outputData.Records()))
@@ -108,6 +140,12 @@ func (m *Model) Train(inputData, outputData *dataframe.DataFrame, epochs int, le
 
 // Функция для прогнозирования Predict будет принимать новые входные данные и выполнять прогноз на основе обученной модели. Она должна: 1. Подготовить входные данные. 2. Выполнить прогноз (прямой проход). 3. Вернуть результат в формате DataFrame.
 func (m *Model) Predict(inputData *dataframe.DataFrame) (*dataframe.DataFrame, error) {
+	// Масштабирование признаков параметрами, обученными на тренировочном окне
+	inputData, err := m.scaleInput(inputData)
+	if err != nil {
+		return nil, err
+	}
+
 	// Подготовка входных данных: Входные данные преобразуются в тензор Gorgonia.
 	xTensor := tensor.New(tensor.Of(tensor.Float32), tensor.WithShape(inputData.Nrow(), inputData.Ncol()), tensor.WithBacking(inputData.Records()))
 
@@ -164,6 +202,11 @@ func (m *Model) Save(filename string) error {
 		return fmt.Errorf("failed to encode weights: %w", err)
 	}
 
+	// Кодируем параметры масштабирования (пустой Kind означает отсутствие масштабирования)
+	if err := data.EncodeScaler(encoder, m.scaler); err != nil {
+		return fmt.Errorf("failed to encode scaler: %w", err)
+	}
+
 	return nil
 }
 
@@ -187,6 +230,13 @@ func (m *Model) Load(filename string) error {
 		return fmt.Errorf("failed to decode weights: %w", err)
 	}
 
+	// Декодируем параметры масштабирования (в файлах старого формата их нет)
+	scaler, err := data.DecodeScaler(decoder)
+	if err != nil {
+		return fmt.Errorf("failed to restore scaler: %w", err)
+	}
+	m.scaler = scaler
+
 	// Создает новую виртуальную машину (m.vm) для загруженного графа
 	m.vm = gorgonia.NewTapeMachine(m.graph)
 
@@ -0,0 +1,612 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"trading-bot/data"
+	"trading-bot/logger"
+)
+
+// Типы алгоритмов исполнения
+type AlgoType string
+
+const (
+	AlgoTWAP    AlgoType = "twap"    // Равные части через равные интервалы
+	AlgoVWAP    AlgoType = "vwap"    // Части пропорционально историческому внутридневному объему
+	AlgoIceberg AlgoType = "iceberg" // Лимитные ордера с небольшой видимой частью
+)
+
+// Состояния родительского ордера алгоритма
+type AlgoState string
+
+const (
+	AlgoWorking   AlgoState = "working"
+	AlgoCompleted AlgoState = "completed" // Исполнен полностью
+	AlgoCancelled AlgoState = "cancelled" // Снят вручную или из-за отклонения дочернего ордера
+	AlgoHalted    AlgoState = "halted"    // Снят из-за остановки торгов по инструменту
+	AlgoExpired   AlgoState = "expired"   // Время вышло, исполнен частично
+)
+
+// Структура для настройки алгоритма исполнения
+type AlgoConfig struct {
+	Type             AlgoType
+	Duration         time.Duration  // Длительность TWAP/VWAP
+	Slices           int            // Количество частей TWAP/VWAP (по умолчанию 10)
+	VisibleQuantity  int            // Видимая часть айсберга (в лотах)
+	LimitPrice       float64        // Предельная цена: дочерние ордера лимитные, при худшей цене не отправляются (0 - рыночные)
+	MaxParticipation float64        // Максимальная доля рыночного объема с начала исполнения, 0..1 (0 - без ограничения)
+	Profile          *VolumeProfile // Профиль объема для VWAP
+	CheckInterval    time.Duration  // Период проверки (по умолчанию 1 секунда)
+}
+
+// Структура для родительского ордера алгоритма. Сделки дочерних ордеров агрегируются в нем
+type AlgoOrder struct {
+	ID             string       `json:"id"`
+	Request        OrderRequest `json:"request"`
+	Type           AlgoType     `json:"type"`
+	State          AlgoState    `json:"state"`
+	FilledQuantity int          `json:"filled_quantity"`
+	AveragePrice   float64      `json:"average_price"`
+	Commission     float64      `json:"commission"`
+	ChildOrderIDs  []int        `json:"child_order_ids"`
+	Message        string       `json:"message,omitempty"`
+	Started        time.Time    `json:"started"`
+	Updated        time.Time    `json:"updated"`
+}
+
+// Функция возвращает неисполненный остаток родительского ордера
+func (a *AlgoOrder) RemainingQuantity() int {
+	return a.Request.Quantity - a.FilledQuantity
+}
+
+// Профиль внутридневного объема: средняя доля дневного объема по интервалам торгового дня (MSK)
+type VolumeProfile struct {
+	Bucket time.Duration
+	Shares map[int]float64 // Номер интервала от начала суток -> доля объема
+}
+
+// Функция для построения профиля объема по историческим внутридневным свечам
+func NewVolumeProfile(candles *data.CandleSeries, bucket time.Duration) *VolumeProfile {
+	profile := &VolumeProfile{Bucket: bucket, Shares: make(map[int]float64)}
+	if bucket <= 0 || candles == nil {
+		return profile
+	}
+
+	total := 0.0
+	for _, candle := range candles.Candles {
+		profile.Shares[profile.index(candle.Time)] += candle.Volume
+		total += candle.Volume
+	}
+	if total > 0 {
+		for index := range profile.Shares {
+			profile.Shares[index] /= total
+		}
+	}
+	return profile
+}
+
+// Функция возвращает номер интервала для момента времени
+func (v *VolumeProfile) index(t time.Time) int {
+	local := t.In(data.ExchangeLocation)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, data.ExchangeLocation)
+	return int(local.Sub(midnight) / v.Bucket)
+}
+
+// Функция возвращает долю объема за период [from, to). Неполные интервалы учитываются пропорционально
+func (v *VolumeProfile) Share(from, to time.Time) float64 {
+	if v == nil || v.Bucket <= 0 || !to.After(from) {
+		return 0
+	}
+	share := 0.0
+	for t := from; t.Before(to); {
+		local := t.In(data.ExchangeLocation)
+		midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, data.ExchangeLocation)
+		bucketEnd := midnight.Add(time.Duration(v.index(t)+1) * v.Bucket)
+		end := bucketEnd
+		if to.Before(end) {
+			end = to
+		}
+		share += v.Shares[v.index(t)] * float64(end.Sub(t)) / float64(v.Bucket)
+		t = end
+	}
+	return share
+}
+
+// Исполнитель алгоритмических ордеров поверх OrderManager
+type AlgoExecutor struct {
+	manager *OrderManager
+
+	mu       sync.Mutex
+	runs     map[string]*algoRun
+	children map[int]string // Дочерний ордер -> родительский
+
+	handlerMu sync.Mutex
+	handlers  []func(order AlgoOrder)
+}
+
+// Структура для состояния исполняемого алгоритма
+type algoRun struct {
+	order        AlgoOrder
+	config       AlgoConfig
+	schedule     []float64   // Накопленная доля количества к концу каждой части
+	childSlice   map[int]int // Дочерний ордер -> номер части, в которой он выставлен
+	marketVolume int64       // Рыночный объем с начала исполнения
+	stop         chan struct{}
+	wake         chan struct{}
+}
+
+// Функция для создания исполнителя алгоритмов
+func NewAlgoExecutor(manager *OrderManager) *AlgoExecutor {
+	e := &AlgoExecutor{
+		manager:  manager,
+		runs:     make(map[string]*algoRun),
+		children: make(map[int]string),
+	}
+	manager.SubscribeUpdates(e.onOrderUpdate)
+	return e
+}
+
+// Функция для подписки на котировки из пакета data (рыночный объем для ограничения доли участия)
+func (e *AlgoExecutor) AttachToFeed() {
+	data.SubscribeQuotes(e.OnQuote)
+}
+
+// Функция для учета рыночного объема по котировке
+func (e *AlgoExecutor) OnQuote(quote data.Quote) {
+	if quote.Volume <= 0 {
+		return
+	}
+	e.mu.Lock()
+	for _, run := range e.runs {
+		if run.order.State == AlgoWorking && run.order.Request.Symbol == quote.Symbol {
+			run.marketVolume += quote.Volume
+		}
+	}
+	e.mu.Unlock()
+}
+
+// Функция для запуска алгоритма исполнения родительского ордера
+func (e *AlgoExecutor) Start(req *OrderRequest, config AlgoConfig) (*AlgoOrder, error) {
+	if req.Quantity <= 0 || (req.Side != "buy" && req.Side != "sell") {
+		return nil, fmt.Errorf("invalid algo order: side=%q quantity=%d", req.Side, req.Quantity)
+	}
+	if config.Slices <= 0 {
+		config.Slices = 10
+	}
+	if config.CheckInterval <= 0 {
+		config.CheckInterval = time.Second
+	}
+	if config.MaxParticipation < 0 || config.MaxParticipation > 1 {
+		return nil, fmt.Errorf("max participation must be within [0, 1], got %g", config.MaxParticipation)
+	}
+
+	now := time.Now()
+	run := &algoRun{
+		order: AlgoOrder{
+			ID:      NewClientOrderID(),
+			Request: *req,
+			Type:    config.Type,
+			State:   AlgoWorking,
+			Started: now,
+			Updated: now,
+		},
+		config:     config,
+		childSlice: make(map[int]int),
+		stop:       make(chan struct{}),
+		wake:       make(chan struct{}, 1),
+	}
+
+	switch config.Type {
+	case AlgoTWAP, AlgoVWAP:
+		if config.Duration <= 0 {
+			return nil, fmt.Errorf("%s requires a positive duration", config.Type)
+		}
+		run.schedule = buildSchedule(config, now)
+	case AlgoIceberg:
+		if config.LimitPrice <= 0 {
+			return nil, fmt.Errorf("iceberg requires a limit price")
+		}
+		if config.VisibleQuantity <= 0 {
+			return nil, fmt.Errorf("iceberg requires a positive visible quantity")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported algo type: %q", config.Type)
+	}
+
+	e.mu.Lock()
+	e.runs[run.order.ID] = run
+	snapshot := run.order
+	e.mu.Unlock()
+
+	logger.Logger.Info().
+		Str("algo_id", snapshot.ID).
+		Str("type", string(config.Type)).
+		Str("symbol", req.Symbol).
+		Str("side", req.Side).
+		Int("quantity", req.Quantity).
+		Dur("duration", config.Duration).
+		Msg("Algo order started")
+
+	go e.loop(run)
+	return &snapshot, nil
+}
+
+// Функция для снятия алгоритма: дочерние ордера снимаются, родитель переходит в cancelled
+func (e *AlgoExecutor) Cancel(id string) error {
+	e.mu.Lock()
+	run, ok := e.runs[id]
+	if !ok {
+		e.mu.Unlock()
+		return fmt.Errorf("algo order %s not found", id)
+	}
+	if run.order.State != AlgoWorking {
+		e.mu.Unlock()
+		return fmt.Errorf("algo order %s is already %s", id, run.order.State)
+	}
+	e.mu.Unlock()
+
+	e.finish(run, AlgoCancelled, "cancelled by user")
+	return nil
+}
+
+// Функция возвращает родительский ордер алгоритма по ID
+func (e *AlgoExecutor) GetAlgo(id string) (AlgoOrder, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	run, ok := e.runs[id]
+	if !ok {
+		return AlgoOrder{}, false
+	}
+	return snapshotAlgo(run), true
+}
+
+// Функция возвращает исполняемые алгоритмы по инструменту (или все, если symbol пустой)
+func (e *AlgoExecutor) Active(symbol string) []AlgoOrder {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var orders []AlgoOrder
+	for _, run := range e.runs {
+		if run.order.State == AlgoWorking && (symbol == "" || run.order.Request.Symbol == symbol) {
+			orders = append(orders, snapshotAlgo(run))
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].Started.Before(orders[j].Started) })
+	return orders
+}
+
+// Функция для подписки на изменения родительских ордеров алгоритмов
+func (e *AlgoExecutor) SubscribeAlgoUpdates(handler func(order AlgoOrder)) {
+	e.handlerMu.Lock()
+	defer e.handlerMu.Unlock()
+	e.handlers = append(e.handlers, handler)
+}
+
+// Цикл исполнения алгоритма
+func (e *AlgoExecutor) loop(run *algoRun) {
+	ticker := time.NewTicker(run.config.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		if done := e.step(run); done {
+			return
+		}
+		select {
+		case <-run.stop:
+			return
+		case <-ticker.C:
+		case <-run.wake:
+		}
+	}
+}
+
+// Функция для одного шага алгоритма. Возвращает true, если алгоритм завершен
+func (e *AlgoExecutor) step(run *algoRun) bool {
+	e.aggregate(run)
+
+	e.mu.Lock()
+	if run.order.State != AlgoWorking {
+		e.mu.Unlock()
+		return true
+	}
+	req := run.order.Request
+	filled := run.order.FilledQuantity
+	config := run.config
+	started := run.order.Started
+	marketVolume := run.marketVolume
+	e.mu.Unlock()
+
+	if filled >= req.Quantity {
+		e.finish(run, AlgoCompleted, "")
+		return true
+	}
+	if data.IsTradingHalted(req.Symbol) {
+		e.finish(run, AlgoHalted, "trading halted by market data checks")
+		return true
+	}
+
+	// Открытые дочерние ордера: их остаток считается уже отправленным.
+	// Лимитные ордера прошлых частей TWAP/VWAP снимаются, остаток переходит в текущую часть
+	now := time.Now()
+	slice := currentSlice(config, started, now)
+	committed := filled
+	open := 0
+	for _, child := range e.openChildren(run) {
+		committed += child.RemainingQuantity()
+		open++
+		if config.Type != AlgoIceberg && e.childSlice(run, child.ID) < slice && child.State != StateCancelPending {
+			if err := e.manager.CancelOrder(child.ID); err != nil {
+				logger.Logger.Warn().Err(err).Int("order_id", child.ID).Msg("Failed to cancel stale algo child order")
+			}
+		}
+	}
+
+	// Целевое накопленное количество к текущему моменту
+	target := req.Quantity
+	switch config.Type {
+	case AlgoTWAP, AlgoVWAP:
+		if !now.Before(started.Add(config.Duration)) {
+			if open == 0 {
+				state := AlgoCompleted
+				if filled < req.Quantity {
+					state = AlgoExpired
+				}
+				e.finish(run, state, "")
+				return true
+			}
+			return false
+		}
+		target = int(math.Round(float64(req.Quantity) * run.schedule[slice]))
+	case AlgoIceberg:
+		if open > 0 {
+			return false
+		}
+	}
+
+	quantity := target - committed
+	if config.Type == AlgoIceberg && quantity > config.VisibleQuantity {
+		quantity = config.VisibleQuantity
+	}
+	if config.MaxParticipation > 0 {
+		allowed := int(math.Floor(config.MaxParticipation*float64(marketVolume))) - committed
+		if quantity > allowed {
+			quantity = allowed
+		}
+	}
+	if quantity <= 0 {
+		return false
+	}
+
+	// Предельная цена: при худшей рыночной цене дочерний ордер не отправляется
+	child := &OrderRequest{
+		Symbol:    req.Symbol,
+		Side:      req.Side,
+		Quantity:  quantity,
+		OrderType: "market",
+		Comment:   fmt.Sprintf("%s %s", config.Type, run.order.ID),
+	}
+	if config.LimitPrice > 0 {
+		if quote, ok := data.LastQuote(req.Symbol); ok && quote.Price > 0 {
+			if (req.Side == "buy" && quote.Price > config.LimitPrice) || (req.Side == "sell" && quote.Price < config.LimitPrice) {
+				return false
+			}
+		}
+		child.OrderType = "limit"
+		child.Price = config.LimitPrice
+	}
+
+	placed, err := e.manager.PlaceOrder(child)
+	if err != nil {
+		if errors.Is(err, ErrOrderRejected) || errors.Is(err, ErrKillSwitchActive) {
+			e.finish(run, AlgoCancelled, fmt.Sprintf("child order rejected: %v", err))
+			return true
+		}
+		logger.Logger.Error().Err(err).Str("algo_id", run.order.ID).Msg("Failed to place algo child order, will retry")
+		return false
+	}
+
+	e.mu.Lock()
+	run.order.ChildOrderIDs = append(run.order.ChildOrderIDs, placed.ID)
+	run.childSlice[placed.ID] = slice
+	e.children[placed.ID] = run.order.ID
+	e.mu.Unlock()
+
+	logger.Logger.Debug().
+		Str("algo_id", run.order.ID).
+		Int("order_id", placed.ID).
+		Int("slice", slice).
+		Int("quantity", quantity).
+		Msg("Algo child order placed")
+
+	e.aggregate(run)
+	return false
+}
+
+// Функция для агрегирования сделок дочерних ордеров в родительском
+func (e *AlgoExecutor) aggregate(run *algoRun) {
+	e.mu.Lock()
+	childIDs := append([]int(nil), run.order.ChildOrderIDs...)
+	e.mu.Unlock()
+
+	filled, value, commission := 0, 0.0, 0.0
+	for _, id := range childIDs {
+		child, ok := e.manager.GetOrder(id)
+		if !ok {
+			continue
+		}
+		filled += child.FilledQuantity
+		value += float64(child.FilledQuantity) * child.AveragePrice
+		commission += child.Commission
+	}
+
+	e.mu.Lock()
+	changed := filled != run.order.FilledQuantity
+	run.order.FilledQuantity = filled
+	run.order.Commission = commission
+	if filled > 0 {
+		run.order.AveragePrice = value / float64(filled)
+	}
+	if changed {
+		run.order.Updated = time.Now()
+	}
+	snapshot := snapshotAlgo(run)
+	e.mu.Unlock()
+
+	if changed {
+		e.notify(snapshot)
+	}
+}
+
+// Функция для завершения алгоритма: открытые дочерние ордера снимаются
+func (e *AlgoExecutor) finish(run *algoRun, state AlgoState, message string) {
+	e.mu.Lock()
+	if run.order.State != AlgoWorking {
+		e.mu.Unlock()
+		return
+	}
+	run.order.State = state
+	run.order.Message = message
+	close(run.stop)
+	e.mu.Unlock()
+
+	if state != AlgoCompleted {
+		for _, child := range e.openChildren(run) {
+			if err := e.manager.CancelOrder(child.ID); err != nil {
+				logger.Logger.Error().Err(err).Int("order_id", child.ID).Msg("Failed to cancel algo child order")
+			}
+		}
+	}
+	e.aggregate(run)
+
+	e.mu.Lock()
+	run.order.Updated = time.Now()
+	snapshot := snapshotAlgo(run)
+	e.mu.Unlock()
+
+	logger.Logger.Info().
+		Str("algo_id", snapshot.ID).
+		Str("state", string(state)).
+		Int("filled", snapshot.FilledQuantity).
+		Int("quantity", snapshot.Request.Quantity).
+		Float64("average_price", snapshot.AveragePrice).
+		Str("message", message).
+		Msg("Algo order finished")
+	e.notify(snapshot)
+}
+
+// Функция возвращает открытые дочерние ордера алгоритма
+func (e *AlgoExecutor) openChildren(run *algoRun) []ManagedOrder {
+	e.mu.Lock()
+	childIDs := append([]int(nil), run.order.ChildOrderIDs...)
+	e.mu.Unlock()
+
+	var open []ManagedOrder
+	for _, id := range childIDs {
+		if child, ok := e.manager.GetOrder(id); ok && child.State.IsOpen() {
+			open = append(open, child)
+		}
+	}
+	return open
+}
+
+// Функция возвращает номер части, в которой выставлен дочерний ордер
+func (e *AlgoExecutor) childSlice(run *algoRun, orderID int) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return run.childSlice[orderID]
+}
+
+// Функция для обработки изменения дочернего ордера: шаг алгоритма выполняется сразу
+func (e *AlgoExecutor) onOrderUpdate(order ManagedOrder) {
+	e.mu.Lock()
+	id, ok := e.children[order.ID]
+	var run *algoRun
+	if ok {
+		run = e.runs[id]
+	}
+	e.mu.Unlock()
+
+	if run == nil {
+		return
+	}
+	select {
+	case run.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Функция для оповещения подписчиков (вызывается вне блокировок)
+func (e *AlgoExecutor) notify(order AlgoOrder) {
+	e.handlerMu.Lock()
+	handlers := e.handlers
+	e.handlerMu.Unlock()
+
+	for _, handler := range handlers {
+		handler(order)
+	}
+}
+
+// Функция возвращает копию родительского ордера (вызывается под e.mu)
+func snapshotAlgo(run *algoRun) AlgoOrder {
+	snapshot := run.order
+	snapshot.ChildOrderIDs = append([]int(nil), run.order.ChildOrderIDs...)
+	return snapshot
+}
+
+// Функция для расчета графика TWAP/VWAP: накопленная доля количества к концу каждой части.
+// Если профиль объема не покрывает период исполнения, VWAP исполняется как TWAP
+func buildSchedule(config AlgoConfig, start time.Time) []float64 {
+	weights := make([]float64, config.Slices)
+	sliceDuration := config.Duration / time.Duration(config.Slices)
+	total := 0.0
+	if config.Type == AlgoVWAP {
+		for i := range weights {
+			from := start.Add(time.Duration(i) * sliceDuration)
+			weights[i] = config.Profile.Share(from, from.Add(sliceDuration))
+			total += weights[i]
+		}
+		if total == 0 {
+			logger.Logger.Warn().Msg("Volume profile does not cover VWAP period, falling back to TWAP")
+		}
+	}
+	if total == 0 {
+		for i := range weights {
+			weights[i] = 1
+		}
+		total = float64(config.Slices)
+	}
+
+	schedule := make([]float64, config.Slices)
+	cumulative := 0.0
+	for i, weight := range weights {
+		cumulative += weight
+		schedule[i] = cumulative / total
+	}
+	schedule[len(schedule)-1] = 1
+	return schedule
+}
+
+// Функция возвращает номер текущей части TWAP/VWAP
+func currentSlice(config AlgoConfig, start time.Time, now time.Time) int {
+	if config.Type == AlgoIceberg || config.Slices <= 0 {
+		return 0
+	}
+	sliceDuration := config.Duration / time.Duration(config.Slices)
+	if sliceDuration <= 0 {
+		return config.Slices - 1
+	}
+	slice := int(now.Sub(start) / sliceDuration)
+	if slice >= config.Slices {
+		slice = config.Slices - 1
+	}
+	if slice < 0 {
+		slice = 0
+	}
+	return slice
+}
@@ -0,0 +1,103 @@
+package order
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"trading-bot/data"
+)
+
+func TestBuildSchedule(t *testing.T) {
+	start := time.Date(2024, time.July, 1, 10, 0, 0, 0, data.ExchangeLocation)
+	hourly := &VolumeProfile{Bucket: time.Hour, Shares: map[int]float64{10: 0.3, 11: 0.1}}
+
+	tests := []struct {
+		name   string
+		config AlgoConfig
+		want   []float64
+	}{
+		{"TWAP", AlgoConfig{Type: AlgoTWAP, Duration: time.Hour, Slices: 4}, []float64{0.25, 0.5, 0.75, 1}},
+		{"single slice", AlgoConfig{Type: AlgoTWAP, Duration: time.Hour, Slices: 1}, []float64{1}},
+		{"VWAP by buckets", AlgoConfig{Type: AlgoVWAP, Duration: 2 * time.Hour, Slices: 2, Profile: hourly}, []float64{0.75, 1}},
+		{"VWAP within buckets", AlgoConfig{Type: AlgoVWAP, Duration: 2 * time.Hour, Slices: 4, Profile: hourly}, []float64{0.375, 0.75, 0.875, 1}},
+		{"VWAP outside profile falls back to TWAP", AlgoConfig{Type: AlgoVWAP, Duration: time.Hour, Slices: 2, Profile: &VolumeProfile{Bucket: time.Hour, Shares: map[int]float64{15: 1}}}, []float64{0.5, 1}},
+		{"VWAP without profile falls back to TWAP", AlgoConfig{Type: AlgoVWAP, Duration: time.Hour, Slices: 2}, []float64{0.5, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := buildSchedule(tt.config, start)
+			if len(got) != len(tt.want) {
+				t.Fatalf("schedule = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if math.Abs(got[i]-tt.want[i]) > 1e-9 {
+					t.Fatalf("schedule = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCurrentSlice(t *testing.T) {
+	start := time.Date(2024, time.July, 1, 10, 0, 0, 0, data.ExchangeLocation)
+	twap := AlgoConfig{Type: AlgoTWAP, Duration: time.Hour, Slices: 4}
+
+	tests := []struct {
+		name   string
+		config AlgoConfig
+		now    time.Time
+		want   int
+	}{
+		{"start", twap, start, 0},
+		{"inside second slice", twap, start.Add(20 * time.Minute), 1},
+		{"slice boundary", twap, start.Add(30 * time.Minute), 2},
+		{"after end", twap, start.Add(2 * time.Hour), 3},
+		{"before start", twap, start.Add(-time.Minute), 0},
+		{"iceberg has no slices", AlgoConfig{Type: AlgoIceberg}, start.Add(time.Hour), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := currentSlice(tt.config, start, tt.now); got != tt.want {
+				t.Errorf("currentSlice = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVolumeProfile(t *testing.T) {
+	day := func(hour, minute int) time.Time {
+		return time.Date(2024, time.July, 1, hour, minute, 0, 0, data.ExchangeLocation)
+	}
+	profile := NewVolumeProfile(&data.CandleSeries{
+		Symbol:   "SBER",
+		Interval: "1h",
+		Candles: []data.Candle{
+			{Time: day(10, 0), Volume: 300},
+			{Time: day(11, 0), Volume: 100},
+			{Time: day(10, 0).AddDate(0, 0, 1), Volume: 600},
+		},
+	}, time.Hour)
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     float64
+	}{
+		{"whole bucket", day(10, 0), day(11, 0), 0.9},
+		{"half bucket", day(11, 0), day(11, 30), 0.05},
+		{"across buckets", day(10, 30), day(11, 30), 0.5},
+		{"bucket without volume", day(12, 0), day(13, 0), 0},
+		{"empty period", day(10, 0), day(10, 0), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := profile.Share(tt.from, tt.to); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Share = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package order
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"trading-bot/data"
+	"trading-bot/logger"
+)
+
+// Справочник облигаций: номинал для перевода цены в деньги, купоны для НКД и выплат
+type BondSpecs struct {
+	mu    sync.RWMutex
+	bonds map[string]data.Bond
+}
+
+// Функция для создания справочника облигаций
+func NewBondSpecs(bonds []data.Bond) *BondSpecs {
+	specs := &BondSpecs{bonds: make(map[string]data.Bond)}
+	specs.Update(bonds)
+	return specs
+}
+
+// Функция для добавления или обновления облигаций (например, новых купонов плавающей ставки)
+func (s *BondSpecs) Update(bonds []data.Bond) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, bond := range bonds {
+		s.bonds[bond.Symbol] = bond
+	}
+}
+
+// Функция возвращает описание облигации
+func (s *BondSpecs) Bond(symbol string) (data.Bond, bool) {
+	if s == nil {
+		return data.Bond{}, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	bond, ok := s.bonds[symbol]
+	return bond, ok
+}
+
+// Функция возвращает все облигации по коду
+func (s *BondSpecs) Bonds() []data.Bond {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	bonds := make([]data.Bond, 0, len(s.bonds))
+	for _, bond := range s.bonds {
+		bonds = append(bonds, bond)
+	}
+	sort.Slice(bonds, func(i, j int) bool { return bonds[i].Symbol < bonds[j].Symbol })
+	return bonds
+}
+
+// Функция для регистрации облигаций в учете позиций (номинал и НКД)
+func (s *BondSpecs) ApplyTo(keeper *PositionKeeper) {
+	for _, bond := range s.Bonds() {
+		keeper.SetBond(bond)
+	}
+}
+
+// Функция возвращает цену в деньгах за одну бумагу: для облигаций цена - процент от номинала,
+// для остальных инструментов - без изменений (справочник может быть nil)
+func (s *BondSpecs) unitPrice(symbol string, price float64) float64 {
+	if bond, ok := s.Bond(symbol); ok {
+		return bond.CleanValue(price)
+	}
+	return price
+}
+
+// Структура для выплаты по позиции в облигациях (купон или погашение)
+type CouponPayment struct {
+	Symbol   string                `json:"symbol"`
+	Time     time.Time             `json:"time"`
+	Kind     data.BondCashflowKind `json:"kind"`
+	Quantity int                   `json:"quantity"` // Позиция на дату выплаты (в лотах, со знаком)
+	Amount   float64               `json:"amount"`   // Зачисление (положительное) или компенсация по короткой позиции
+}
+
+// Функция для выплат по открытым позициям в облигациях с датой в интервале (from, to]:
+// купоны зачисляются в процентный доход, при погашении позиция закрывается по номиналу
+func PayBondCashflows(keeper *PositionKeeper, specs *BondSpecs, from, to time.Time) []CouponPayment {
+	var payments []CouponPayment
+	for _, position := range keeper.Positions() {
+		if !position.Bond || position.Quantity == 0 {
+			continue
+		}
+		bond, ok := specs.Bond(position.Symbol)
+		if !ok {
+			continue
+		}
+		for _, cashflow := range bond.CashflowsBetween(from, to) {
+			payment := CouponPayment{Symbol: position.Symbol, Time: cashflow.Date, Kind: cashflow.Kind, Quantity: position.Quantity}
+			switch cashflow.Kind {
+			case data.BondCashflowCoupon:
+				payment.Amount = keeper.ReceiveCoupon(position.Symbol, cashflow.Amount)
+			case data.BondCashflowRedemption:
+				payment.Amount = cashflow.Amount * float64(position.Quantity*position.LotSize)
+				side, quantity := "sell", position.Quantity
+				if quantity < 0 {
+					side, quantity = "buy", -quantity
+				}
+				keeper.ApplyFill(Fill{Symbol: position.Symbol, Side: side, Quantity: quantity, Price: 100, Time: cashflow.Date})
+			}
+			payments = append(payments, payment)
+
+			logger.Logger.Info().
+				Str("symbol", payment.Symbol).
+				Str("kind", string(payment.Kind)).
+				Time("date", payment.Time).
+				Int("position", payment.Quantity).
+				Float64("amount", payment.Amount).
+				Msg("Bond payment")
+		}
+	}
+	return payments
+}
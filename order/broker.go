@@ -0,0 +1,189 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"trading-bot/logger"
+)
+
+// Ошибка для операций, которые площадка не поддерживает
+var ErrNotSupported = errors.New("operation not supported by broker")
+
+// Ошибка для ордера, который площадка точно отклонила. Остальные ошибки PlaceOrder
+// (таймаут, обрыв связи, ошибка сервера) не говорят, принят ордер или нет.
+var ErrOrderRejected = errors.New("order rejected by broker")
+
+// Ошибка для ордера, не найденного на площадке
+var ErrOrderNotFound = errors.New("order not found")
+
+// Ошибка для снятия, отклоненного площадкой (ордер остался в прежнем состоянии)
+var ErrCancelRejected = errors.New("cancel rejected by broker")
+
+// Интерфейс площадки исполнения ордеров. Реализации: FinamBroker (Trade API),
+// connector.TransaqBroker (XML команды Transaq) и PaperBroker (виртуальное исполнение).
+type Broker interface {
+	// Name возвращает название площадки (для логов)
+	Name() string
+	// PlaceOrder выставляет новый ордер
+	PlaceOrder(req *OrderRequest) (*OrderResponse, error)
+	// CancelOrder отменяет ордер
+	CancelOrder(orderID int) error
+	// ModifyOrder изменяет цену и/или количество ордера
+	ModifyOrder(req *ModifyOrderRequest) (*OrderResponse, error)
+	// GetOrderStatus возвращает текущий статус ордера
+	GetOrderStatus(orderID int) (*OrderResponse, error)
+	// GetPositions возвращает открытые позиции
+	GetPositions() ([]Position, error)
+	// GetBalances возвращает денежные остатки по валютам
+	GetBalances() (map[string]Balance, error)
+	// GetPortfolioInfo возвращает портфель целиком
+	GetPortfolioInfo() (*PortfolioInfo, error)
+	// GetOrdersHistory возвращает историю ордеров
+	GetOrdersHistory(req *OrdersHistoryRequest) (*OrdersHistoryResponse, error)
+	// FindOrderByClientID ищет ордер по клиентскому ID (ErrOrderNotFound, если ордера нет)
+	FindOrderByClientID(clientOrderID string) (*OrderHistory, error)
+}
+
+// Интерфейс декоратора площадки (например, IdempotentBroker)
+type brokerWrapper interface {
+	Unwrap() Broker
+}
+
+// Функция для поиска площадки с событиями ордеров под цепочкой декораторов
+func findEventSource(broker Broker) (OrderEventSource, bool) {
+	for broker != nil {
+		if source, ok := broker.(OrderEventSource); ok {
+			return source, true
+		}
+		wrapper, ok := broker.(brokerWrapper)
+		if !ok {
+			break
+		}
+		broker = wrapper.Unwrap()
+	}
+	return nil, false
+}
+
+// Реализация Broker поверх Trade API Финам
+type FinamBroker struct {
+	accessToken string
+	accountID   string // Счет (пустой - счет по умолчанию для токена)
+}
+
+// Функция для создания брокера Finam Trade API
+func NewFinamBroker(accessToken string) *FinamBroker {
+	return &FinamBroker{accessToken: accessToken}
+}
+
+// Функция для создания брокера Finam Trade API для отдельного счета
+func NewFinamAccountBroker(accessToken, accountID string) *FinamBroker {
+	return &FinamBroker{accessToken: accessToken, accountID: accountID}
+}
+
+func (f *FinamBroker) Name() string {
+	return "finam"
+}
+
+func (f *FinamBroker) PlaceOrder(req *OrderRequest) (*OrderResponse, error) {
+	order := *req
+	order.AccessToken = f.accessToken
+	if order.AccountID == "" {
+		order.AccountID = f.accountID
+	}
+	return CreateOrder(&order)
+}
+
+func (f *FinamBroker) CancelOrder(orderID int) error {
+	return CancelOrder(orderID, f.accessToken)
+}
+
+// Trade API не поддерживает изменение ордера: OrderManager изменяет ордера снятием и повторным
+// выставлением через всю цепочку декораторов
+func (f *FinamBroker) ModifiesByCancelReplace() bool {
+	return true
+}
+
+// Прямое изменение (без менеджера): ордер снимается и выставляется заново с параметрами
+// исходного ордера из истории (см. cancelReplace).
+func (f *FinamBroker) ModifyOrder(req *ModifyOrderRequest) (*OrderResponse, error) {
+	original, err := f.findOrder(req.OrderID)
+	if err != nil {
+		return nil, &ModifyError{OrderID: req.OrderID, Stage: ErrModifyRejected, Err: err}
+	}
+
+	return cancelReplace(f, req, OrderRequest{
+		Symbol:      original.Symbol,
+		Side:        original.Side,
+		Quantity:    original.Quantity,
+		OrderType:   original.OrderType,
+		Price:       original.Price,
+		StopPrice:   original.StopPrice,
+		AccountID:   f.accountID,
+		AccessToken: f.accessToken,
+	})
+}
+
+func (f *FinamBroker) GetOrderStatus(orderID int) (*OrderResponse, error) {
+	return GetOrderStatus(orderID, f.accessToken)
+}
+
+func (f *FinamBroker) GetPositions() ([]Position, error) {
+	portfolio, err := f.GetPortfolioInfo()
+	if err != nil {
+		return nil, err
+	}
+	return portfolio.Positions, nil
+}
+
+func (f *FinamBroker) GetBalances() (map[string]Balance, error) {
+	portfolio, err := f.GetPortfolioInfo()
+	if err != nil {
+		return nil, err
+	}
+	return portfolio.Balances, nil
+}
+
+func (f *FinamBroker) GetPortfolioInfo() (*PortfolioInfo, error) {
+	return GetAccountPortfolioInfo(f.accessToken, f.accountID)
+}
+
+func (f *FinamBroker) GetOrdersHistory(req *OrdersHistoryRequest) (*OrdersHistoryResponse, error) {
+	return GetOrdersHistory(req, f.accessToken)
+}
+
+func (f *FinamBroker) FindOrderByClientID(clientOrderID string) (*OrderHistory, error) {
+	history, err := f.GetOrdersHistory(&OrdersHistoryRequest{
+		From: time.Now().AddDate(0, 0, -1),
+		To:   time.Now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error loading orders history: %w", err)
+	}
+	for i := range history.Orders {
+		if history.Orders[i].ClientOrderID == clientOrderID {
+			return &history.Orders[i], nil
+		}
+	}
+	return nil, fmt.Errorf("client order %s: %w", clientOrderID, ErrOrderNotFound)
+}
+
+// Функция для поиска ордера в истории за последние сутки
+func (f *FinamBroker) findOrder(orderID int) (*OrderHistory, error) {
+	history, err := f.GetOrdersHistory(&OrdersHistoryRequest{
+		From: time.Now().AddDate(0, 0, -1),
+		To:   time.Now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error loading orders history: %w", err)
+	}
+	for i := range history.Orders {
+		if history.Orders[i].OrderID == orderID {
+			return &history.Orders[i], nil
+		}
+	}
+
+	logger.Logger.Error().Int("order_id", orderID).Msg("Order not found in history")
+	return nil, fmt.Errorf("order %d not found in history", orderID)
+}
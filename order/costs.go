@@ -0,0 +1,318 @@
+package order
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// Тип тарифа комиссии
+type FeeType string
+
+const (
+	FeePercent FeeType = "percent" // Процент от оборота
+	FeePerLot  FeeType = "per_lot" // Фиксированная сумма за лот
+	FeeTiered  FeeType = "tiered"  // Процент от оборота, зависящий от оборота за месяц
+)
+
+// Структура для ступени тарифа: ставка действует, начиная с месячного оборота Turnover
+type FeeTier struct {
+	Turnover float64 `json:"turnover"`
+	Percent  float64 `json:"percent"`
+}
+
+// Структура для тарифа комиссии (брокерской или биржевой)
+type FeeSchedule struct {
+	Type        FeeType   `json:"type"`
+	Percent     float64   `json:"percent"`       // Для percent - процент от оборота
+	PerLot      float64   `json:"per_lot"`       // Для per_lot - сумма за лот
+	Tiers       []FeeTier `json:"tiers"`         // Для tiered - ступени по месячному обороту
+	MinPerOrder float64   `json:"min_per_order"` // Минимальная комиссия за ордер (0 - без минимума)
+}
+
+// Функция возвращает комиссию за сделку без учета минимума. turnover - оборот за месяц до сделки
+func (s FeeSchedule) fee(amount float64, lots int, turnover float64) float64 {
+	switch s.Type {
+	case FeePerLot:
+		return s.PerLot * float64(lots)
+	case FeeTiered:
+		percent := 0.0
+		for _, tier := range s.sortedTiers() {
+			if turnover >= tier.Turnover {
+				percent = tier.Percent
+			}
+		}
+		return amount * percent / 100
+	default:
+		return amount * s.Percent / 100
+	}
+}
+
+// Функция возвращает ступени тарифа по возрастанию оборота
+func (s FeeSchedule) sortedTiers() []FeeTier {
+	tiers := append([]FeeTier(nil), s.Tiers...)
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].Turnover < tiers[j].Turnover })
+	return tiers
+}
+
+// Тип модели проскальзывания
+type SlippageType string
+
+const (
+	SlippageNone       SlippageType = "none"
+	SlippagePercent    SlippageType = "percent"    // Процент от цены
+	SlippageTicks      SlippageType = "ticks"      // Фиксированное количество шагов цены
+	SlippageVolatility SlippageType = "volatility" // Доля текущей волатильности (например, ATR)
+)
+
+// Структура для модели проскальзывания рыночных ордеров
+type SlippageModel struct {
+	Type     SlippageType `json:"type"`
+	Percent  float64      `json:"percent"`   // Для percent
+	Ticks    int          `json:"ticks"`     // Для ticks
+	TickSize float64      `json:"tick_size"` // Шаг цены по умолчанию для ticks
+	Factor   float64      `json:"factor"`    // Для volatility - доля волатильности
+}
+
+// Структура для издержек торговли на рынке
+type MarketCosts struct {
+	Commission  FeeSchedule   `json:"commission"`   // Комиссия брокера
+	ExchangeFee FeeSchedule   `json:"exchange_fee"` // Биржевой сбор
+	Slippage    SlippageModel `json:"slippage"`
+}
+
+// Структура для настройки издержек: тарифы по рынкам и рынок каждого инструмента
+type CostConfig struct {
+	Markets       map[string]MarketCosts `json:"markets"`
+	Symbols       map[string]string      `json:"symbols"`        // Рынок по инструментам
+	DefaultMarket string                 `json:"default_market"` // Рынок для остальных инструментов
+	PriceSteps    map[string]float64     `json:"price_steps"`    // Шаг цены по инструментам (для ticks)
+}
+
+// Рынки в тарифах по умолчанию
+const (
+	MarketMoexStocks  = "moex_stocks"
+	MarketMoexFutures = "moex_futures"
+)
+
+// Функция возвращает тарифы по умолчанию: акции Московской биржи (процент от оборота)
+// и срочный рынок (за контракт)
+func DefaultCostConfig() CostConfig {
+	return CostConfig{
+		Markets: map[string]MarketCosts{
+			MarketMoexStocks: {
+				Commission:  FeeSchedule{Type: FeePercent, Percent: 0.04},
+				ExchangeFee: FeeSchedule{Type: FeePercent, Percent: 0.01},
+				Slippage:    SlippageModel{Type: SlippageTicks, Ticks: 1, TickSize: 0.01},
+			},
+			MarketMoexFutures: {
+				Commission:  FeeSchedule{Type: FeePerLot, PerLot: 0.45},
+				ExchangeFee: FeeSchedule{Type: FeePerLot, PerLot: 1},
+				Slippage:    SlippageModel{Type: SlippageTicks, Ticks: 1, TickSize: 1},
+			},
+		},
+		DefaultMarket: MarketMoexStocks,
+	}
+}
+
+// Функция возвращает тарифы, совместимые с процентными настройками виртуального брокера
+func PercentCostConfig(commissionPercent, slippagePercent float64) CostConfig {
+	return CostConfig{
+		Markets: map[string]MarketCosts{
+			MarketMoexStocks: {
+				Commission: FeeSchedule{Type: FeePercent, Percent: commissionPercent},
+				Slippage:   SlippageModel{Type: SlippagePercent, Percent: slippagePercent},
+			},
+		},
+		DefaultMarket: MarketMoexStocks,
+	}
+}
+
+// Структура для издержек по сделке. Проскальзывание уже учтено в цене сделки
+type FillCosts struct {
+	Commission  float64 `json:"commission"`
+	ExchangeFee float64 `json:"exchange_fee"`
+	Slippage    float64 `json:"slippage"`
+}
+
+// Функция возвращает списываемые издержки (комиссия брокера и биржевой сбор)
+func (c FillCosts) Total() float64 {
+	return c.Commission + c.ExchangeFee
+}
+
+// Структура для начисленных по ордеру комиссий (для минимума за ордер)
+type orderCharges struct {
+	commission, exchangeFee       float64 // Начислено
+	rawCommission, rawExchangeFee float64 // По тарифу без учета минимума
+}
+
+// Модель издержек: комиссия брокера, биржевой сбор и проскальзывание по тарифам рынка
+// инструмента. Помнит оборот за месяц (для ступенчатых тарифов) и начисленное по
+// ордерам (для минимальной комиссии за ордер)
+type CostModel struct {
+	config   CostConfig
+	lotSizes map[string]int
+	bonds    *BondSpecs // Облигации: оборот считается от номинала
+
+	mu         sync.Mutex
+	volatility map[string]float64
+	month      int // Год*12 + месяц текущего оборота
+	turnover   float64
+	orders     map[int]*orderCharges
+}
+
+// Функция для создания модели издержек
+func NewCostModel(config CostConfig, lotSizes map[string]int) *CostModel {
+	return &CostModel{
+		config:     config,
+		lotSizes:   lotSizes,
+		volatility: make(map[string]float64),
+		orders:     make(map[int]*orderCharges),
+	}
+}
+
+// Функция для подключения справочника облигаций (вызывается до начала торговли)
+func (m *CostModel) AttachBonds(bonds *BondSpecs) {
+	m.bonds = bonds
+}
+
+// Функция возвращает тарифы рынка инструмента
+func (m *CostModel) Market(symbol string) MarketCosts {
+	market, ok := m.config.Symbols[symbol]
+	if !ok {
+		market = m.config.DefaultMarket
+	}
+	return m.config.Markets[market]
+}
+
+// Функция для обновления волатильности инструмента (в единицах цены, например ATR)
+func (m *CostModel) SetVolatility(symbol string, volatility float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.volatility[symbol] = volatility
+}
+
+// Функция возвращает цену рыночного ордера с учетом проскальзывания против направления ордера
+func (m *CostModel) SlippagePrice(symbol, side string, price float64) float64 {
+	slippage := m.slippage(symbol, price)
+	if side == "buy" {
+		return price + slippage
+	}
+	return math.Max(price-slippage, 0)
+}
+
+// Функция возвращает издержки по сделке, не изменяя состояние модели.
+// reference - цена до проскальзывания (0 - проскальзывания не было)
+func (m *CostModel) Costs(fill Fill, reference float64) FillCosts {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	costs, _ := m.costs(fill, reference)
+	return costs
+}
+
+// Функция для учета сделки: оборот за месяц и начисленное по ордеру
+func (m *CostModel) Record(fill Fill, reference float64) FillCosts {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	costs, charges := m.costs(fill, reference)
+	if m.newMonth(fill) {
+		m.month = fill.Time.Year()*12 + int(fill.Time.Month())
+		m.turnover = 0
+		m.orders = make(map[int]*orderCharges)
+	}
+	m.orders[fill.OrderID] = charges
+	m.turnover += m.amount(fill)
+	return costs
+}
+
+// Функция для начисления издержек сделке: Commission заполняется суммой комиссий,
+// ExchangeFee и Slippage - составляющими
+func (m *CostModel) Charge(fill *Fill, reference float64) {
+	costs := m.Record(*fill, reference)
+	fill.Commission = costs.Total()
+	fill.ExchangeFee = costs.ExchangeFee
+	fill.Slippage = costs.Slippage
+}
+
+// Функция возвращает оборот за текущий месяц
+func (m *CostModel) MonthlyTurnover() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.turnover
+}
+
+// Функция для расчета издержек и начислений по ордеру после сделки (вызывается под m.mu)
+func (m *CostModel) costs(fill Fill, reference float64) (FillCosts, *orderCharges) {
+	// Оборот и начисления по ордерам считаются с начала месяца
+	turnover, orders := m.turnover, m.orders
+	if m.newMonth(fill) {
+		turnover, orders = 0, nil
+	}
+
+	market := m.Market(fill.Symbol)
+	amount := m.amount(fill)
+
+	charges := &orderCharges{}
+	if previous, ok := orders[fill.OrderID]; ok && fill.OrderID != 0 {
+		*charges = *previous
+	}
+	charges.rawCommission += market.Commission.fee(amount, fill.Quantity, turnover)
+	charges.rawExchangeFee += market.ExchangeFee.fee(amount, fill.Quantity, turnover)
+
+	var costs FillCosts
+	commission := math.Max(charges.rawCommission, market.Commission.MinPerOrder)
+	costs.Commission = commission - charges.commission
+	charges.commission = commission
+	exchangeFee := math.Max(charges.rawExchangeFee, market.ExchangeFee.MinPerOrder)
+	costs.ExchangeFee = exchangeFee - charges.exchangeFee
+	charges.exchangeFee = exchangeFee
+
+	if reference > 0 {
+		costs.Slippage = m.bonds.unitPrice(fill.Symbol, math.Abs(fill.Price-reference)) * float64(fill.Quantity*m.lotSize(fill.Symbol))
+	}
+	return costs, charges
+}
+
+// Функция проверяет, начинает ли сделка новый месяц оборота (вызывается под m.mu)
+func (m *CostModel) newMonth(fill Fill) bool {
+	return !fill.Time.IsZero() && fill.Time.Year()*12+int(fill.Time.Month()) != m.month
+}
+
+// Функция возвращает оборот по сделке
+func (m *CostModel) amount(fill Fill) float64 {
+	return m.bonds.unitPrice(fill.Symbol, fill.Price) * float64(fill.Quantity*m.lotSize(fill.Symbol))
+}
+
+// Функция возвращает проскальзывание в единицах цены (вызывается без m.mu)
+func (m *CostModel) slippage(symbol string, price float64) float64 {
+	model := m.Market(symbol).Slippage
+	switch model.Type {
+	case SlippagePercent:
+		return price * model.Percent / 100
+	case SlippageTicks:
+		tickSize := model.TickSize
+		if step, ok := m.config.PriceSteps[symbol]; ok && step > 0 {
+			tickSize = step
+		}
+		return tickSize * float64(model.Ticks)
+	case SlippageVolatility:
+		m.mu.Lock()
+		volatility := m.volatility[symbol]
+		m.mu.Unlock()
+		return volatility * model.Factor
+	default:
+		return 0
+	}
+}
+
+// Функция возвращает размер лота инструмента
+func (m *CostModel) lotSize(symbol string) int {
+	if lotSize, ok := m.lotSizes[symbol]; ok && lotSize > 0 {
+		return lotSize
+	}
+	return 1
+}
@@ -0,0 +1,87 @@
+package order
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCostModelMinPerOrderAcrossPartialFills(t *testing.T) {
+	config := CostConfig{
+		Markets: map[string]MarketCosts{
+			MarketMoexStocks: {Commission: FeeSchedule{Type: FeePercent, Percent: 0.05, MinPerOrder: 10}},
+		},
+		DefaultMarket: MarketMoexStocks,
+	}
+	model := NewCostModel(config, map[string]int{"SBER": 10})
+	day := time.Date(2024, 3, 5, 11, 0, 0, 0, time.UTC)
+
+	// Первая сделка ордера платит минимум, следующие - только превышение тарифа над ним
+	steps := []struct {
+		orderID, lots int
+		want          float64
+	}{
+		{1, 2, 10},    // 2.5 по тарифу, начислен минимум
+		{1, 4, 0},     // 7.5 накоплено, минимум уже покрывает
+		{1, 20, 22.5}, // 32.5 накоплено, доплата сверх минимума
+		{2, 1, 10},    // новый ордер - снова минимум
+	}
+	total := 0.0
+	for i, step := range steps {
+		fill := Fill{OrderID: step.orderID, Symbol: "SBER", Side: "buy", Quantity: step.lots, Price: 250, Time: day}
+		if preview := model.Costs(fill, 0); math.Abs(preview.Commission-step.want) > 1e-9 {
+			t.Errorf("step %d: Costs commission = %v, want %v", i, preview.Commission, step.want)
+		}
+		model.Charge(&fill, 0)
+		if math.Abs(fill.Commission-step.want) > 1e-9 {
+			t.Errorf("step %d: charged commission = %v, want %v", i, fill.Commission, step.want)
+		}
+		total += fill.Commission
+	}
+	if math.Abs(total-42.5) > 1e-9 {
+		t.Errorf("total commission = %v, want 42.5", total)
+	}
+}
+
+func TestCostModelTieredFeeByMonthlyTurnover(t *testing.T) {
+	config := CostConfig{
+		Markets: map[string]MarketCosts{
+			"stocks": {
+				// Ступени заданы не по порядку - модель сортирует их сама
+				Commission:  FeeSchedule{Type: FeeTiered, Tiers: []FeeTier{{Turnover: 5_000_000, Percent: 0.01}, {Turnover: 0, Percent: 0.05}, {Turnover: 1_000_000, Percent: 0.03}}},
+				ExchangeFee: FeeSchedule{Type: FeePerLot, PerLot: 0.5, MinPerOrder: 1},
+			},
+		},
+		DefaultMarket: "stocks",
+	}
+	model := NewCostModel(config, nil)
+	january := time.Date(2024, 1, 30, 12, 0, 0, 0, time.UTC)
+
+	record := func(orderID, lots int, at time.Time) FillCosts {
+		return model.Record(Fill{OrderID: orderID, Symbol: "LKOH", Side: "sell", Quantity: lots, Price: 1000, Time: at}, 0)
+	}
+
+	if costs := record(1, 800, january); math.Abs(costs.Commission-400) > 1e-9 || costs.ExchangeFee != 400 {
+		t.Errorf("first fill costs = %+v, want commission 400 (0.05%%), exchange fee 400", costs)
+	}
+	// Ставка определяется оборотом до сделки: 800 000 - еще первая ступень
+	if costs := record(2, 300, january); math.Abs(costs.Commission-150) > 1e-9 {
+		t.Errorf("second fill commission = %v, want 150", costs.Commission)
+	}
+	if costs := record(3, 100, january.Add(time.Hour)); math.Abs(costs.Commission-30) > 1e-9 {
+		t.Errorf("third fill commission = %v, want 30 at 0.03%%", costs.Commission)
+	}
+	if turnover := model.MonthlyTurnover(); turnover != 1_200_000 {
+		t.Errorf("January turnover = %v, want 1200000", turnover)
+	}
+
+	// С началом месяца оборот обнуляется, ставка возвращается к первой ступени
+	february := time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC)
+	costs := record(4, 1, february)
+	if math.Abs(costs.Commission-0.5) > 1e-9 || costs.ExchangeFee != 1 {
+		t.Errorf("February fill costs = %+v, want commission 0.5, exchange fee minimum 1", costs)
+	}
+	if turnover := model.MonthlyTurnover(); turnover != 1000 {
+		t.Errorf("February turnover = %v, want 1000", turnover)
+	}
+}
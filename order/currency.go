@@ -0,0 +1,125 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"trading-bot/data"
+	"trading-bot/logger"
+)
+
+// Валюта по умолчанию: валюта торгов инструментов без справочных данных и базовая валюта оценки
+const DefaultCurrency = data.RubCurrency
+
+// Оценка портфеля в базовой валюте: валюта торгов каждого инструмента и курсы валют.
+// Денежные остатки и позиции пересчитываются в базовую валюту по текущему курсу.
+// Методы допускают nil-оценку: тогда все суммы считаются в одной валюте без пересчета
+type Valuation struct {
+	base string
+	fx   *data.FXRates
+
+	mu         sync.RWMutex
+	currencies map[string]string // Валюта торгов по инструментам
+	missing    map[string]bool   // Валюты без курса, о которых уже предупреждали
+}
+
+// Функция для создания оценки портфеля в базовой валюте base (пустая - DefaultCurrency)
+func NewValuation(base string, fx *data.FXRates) *Valuation {
+	if base == "" {
+		base = DefaultCurrency
+	}
+	return &Valuation{
+		base:       base,
+		fx:         fx,
+		currencies: make(map[string]string),
+		missing:    make(map[string]bool),
+	}
+}
+
+// Функция возвращает базовую валюту
+func (v *Valuation) Base() string {
+	if v == nil {
+		return DefaultCurrency
+	}
+	return v.base
+}
+
+// Функция для установки валюты торгов инструмента (из справочных данных или настроек)
+func (v *Valuation) SetCurrency(symbol, currency string) {
+	if currency == "" {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.currencies[symbol] = currency
+}
+
+// Функция возвращает валюту торгов инструмента (по умолчанию DefaultCurrency)
+func (v *Valuation) Currency(symbol string) string {
+	if v == nil {
+		return DefaultCurrency
+	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if currency, ok := v.currencies[symbol]; ok {
+		return currency
+	}
+	return DefaultCurrency
+}
+
+// Функция для пересчета суммы в базовую валюту. Пока курс валюты неизвестен, возвращает
+// ошибку data.ErrNoFXRate (с однократным предупреждением в журнале)
+func (v *Valuation) ToBase(amount float64, currency string) (float64, error) {
+	if v == nil || currency == v.base || amount == 0 {
+		return amount, nil
+	}
+	converted, err := 0.0, fmt.Errorf("%s: %w", currency, data.ErrNoFXRate)
+	if v.fx != nil {
+		converted, err = v.fx.Convert(amount, currency, v.base)
+	}
+	if err != nil {
+		v.mu.Lock()
+		warned := v.missing[currency]
+		v.missing[currency] = true
+		v.mu.Unlock()
+		if !warned {
+			logger.Logger.Warn().Err(err).Str("currency", currency).Str("base", v.base).Msg("FX rate is unknown, amounts in currency are not valued")
+		}
+		return 0, err
+	}
+	return converted, nil
+}
+
+// Функция возвращает денежные остатки во всех валютах в базовой валюте. Если курс какой-либо
+// валюты неизвестен, возвращает сумму остальных остатков и ошибку
+func (v *Valuation) Cash(balances map[string]Balance) (float64, error) {
+	var (
+		cash float64
+		errs []error
+	)
+	for currency, balance := range balances {
+		value, err := v.ToBase(balance.Value, currency)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		cash += value
+	}
+	return cash, errors.Join(errs...)
+}
+
+// Функция возвращает стоимость портфеля в базовой валюте: деньги и позиции (см. Position.EquityValue).
+// Если курс какой-либо валюты неизвестен, возвращает заниженную оценку и ошибку
+func (v *Valuation) Equity(portfolio *PortfolioInfo) (float64, error) {
+	equity, err := v.Cash(portfolio.Balances)
+	errs := []error{err}
+	for _, position := range portfolio.Positions {
+		value, err := v.ToBase(position.EquityValue(), v.Currency(position.Symbol))
+		if err != nil {
+			errs = append(errs, err)
+		}
+		equity += value
+	}
+	return equity, errors.Join(errs...)
+}
@@ -0,0 +1,114 @@
+package order
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"trading-bot/data"
+)
+
+func TestValuationEquity(t *testing.T) {
+	portfolio := &PortfolioInfo{
+		Balances: map[string]Balance{
+			"RUB": {Value: 1000},
+			"USD": {Value: 10},
+		},
+		Positions: []Position{
+			{Symbol: "SBER", MarketValue: 3000},
+			{Symbol: "BABA", MarketValue: 20},
+			{Symbol: "SiU4", Futures: true, MarketValue: 90000, VariationMargin: -150},
+		},
+	}
+	currencies := map[string]string{"BABA": "CNY"}
+
+	tests := []struct {
+		name    string
+		base    string
+		rates   map[string]float64
+		want    float64
+		wantErr bool
+	}{
+		{
+			name:  "all rates known",
+			rates: map[string]float64{"USD": 90, "CNY": 12},
+			want:  1000 + 10*90 + 3000 + 20*12 - 150,
+		},
+		{
+			name:  "base currency other than ruble",
+			base:  "USD",
+			rates: map[string]float64{"USD": 90, "CNY": 12},
+			want:  (1000+3000+20*12-150)/90.0 + 10,
+		},
+		{
+			// Оценка без курса занижена и сопровождается ошибкой, а не считается по курсу 1
+			name:    "missing rate fails closed",
+			rates:   map[string]float64{"USD": 90},
+			want:    1000 + 10*90 + 3000 - 150,
+			wantErr: true,
+		},
+		{
+			name:    "no rates",
+			want:    1000 + 3000 - 150,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fx := data.NewFXRates(nil)
+			for currency, rate := range tt.rates {
+				fx.SetRate(currency, rate, time.Now())
+			}
+			valuation := NewValuation(tt.base, fx)
+			for symbol, currency := range currencies {
+				valuation.SetCurrency(symbol, currency)
+			}
+
+			got, err := valuation.Equity(portfolio)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Equity error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, data.ErrNoFXRate) {
+				t.Errorf("Equity error = %v, want %v", err, data.ErrNoFXRate)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Equity = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValuationToBase(t *testing.T) {
+	fx := data.NewFXRates(nil)
+	fx.SetRate("USD", 90, time.Now())
+
+	tests := []struct {
+		name      string
+		valuation *Valuation
+		amount    float64
+		currency  string
+		want      float64
+		wantErr   bool
+	}{
+		{name: "base currency", valuation: NewValuation("", fx), amount: 100, currency: "RUB", want: 100},
+		{name: "converted", valuation: NewValuation("", fx), amount: 2, currency: "USD", want: 180},
+		{name: "zero amount needs no rate", valuation: NewValuation("", fx), amount: 0, currency: "CNY", want: 0},
+		{name: "missing rate", valuation: NewValuation("", fx), amount: 5, currency: "CNY", wantErr: true},
+		{name: "no rates source", valuation: NewValuation("", nil), amount: 5, currency: "USD", wantErr: true},
+		{name: "nil valuation", amount: 5, currency: "USD", want: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.valuation.ToBase(tt.amount, tt.currency)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ToBase error = %v, want error %v", err, tt.wantErr)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("ToBase = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,407 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"trading-bot/data"
+	"trading-bot/logger"
+)
+
+// Справочник фьючерсных контрактов: стоимость пункта цены для учета позиций
+// и ГО для маржинальной модели (реализация MarginSource)
+type FuturesSpecs struct {
+	mu        sync.RWMutex
+	contracts map[string]data.FuturesContract
+}
+
+// Функция для создания справочника фьючерсных контрактов
+func NewFuturesSpecs(contracts []data.FuturesContract) *FuturesSpecs {
+	specs := &FuturesSpecs{contracts: make(map[string]data.FuturesContract)}
+	specs.Update(contracts)
+	return specs
+}
+
+// Функция для добавления или обновления спецификаций (например, нового ГО)
+func (s *FuturesSpecs) Update(contracts []data.FuturesContract) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, contract := range contracts {
+		s.contracts[contract.Symbol] = contract
+	}
+}
+
+// Функция возвращает спецификацию контракта
+func (s *FuturesSpecs) Contract(symbol string) (data.FuturesContract, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	contract, ok := s.contracts[symbol]
+	return contract, ok
+}
+
+// Функция возвращает все контракты по возрастанию даты исполнения
+func (s *FuturesSpecs) Contracts() []data.FuturesContract {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	contracts := make([]data.FuturesContract, 0, len(s.contracts))
+	for _, contract := range s.contracts {
+		contracts = append(contracts, contract)
+	}
+	sort.Slice(contracts, func(i, j int) bool { return contracts[i].LastTradeDate.Before(contracts[j].LastTradeDate) })
+	return contracts
+}
+
+// Функция возвращает ГО контрактов как маржинальные параметры (реализация MarginSource).
+// Короткая позиция по фьючерсу не требует займа
+func (s *FuturesSpecs) MarginParams() map[string]MarginParams {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	params := make(map[string]MarginParams, len(s.contracts))
+	for symbol, contract := range s.contracts {
+		params[symbol] = MarginParams{Shortable: true, InitialPerLot: contract.InitialMargin}
+	}
+	return params
+}
+
+// Функция для регистрации контрактов в учете позиций (стоимость пункта цены и клиринг)
+func (s *FuturesSpecs) ApplyTo(keeper *PositionKeeper) {
+	for _, contract := range s.Contracts() {
+		keeper.SetFutures(contract.Symbol, contract.PointValue())
+	}
+}
+
+// Структура для вариационной маржи по позиции на клиринге
+type VariationMargin struct {
+	Symbol string    `json:"symbol"`
+	Time   time.Time `json:"time"`
+	Price  float64   `json:"price"`  // Расчетная цена
+	Amount float64   `json:"amount"` // Зачисление (положительное) или списание
+}
+
+// Время клирингов срочного рынка Московской биржи (от начала дня, время биржи):
+// промежуточный в 14:00 и вечерний в 18:50
+var DefaultClearingTimes = []time.Duration{14 * time.Hour, 18*time.Hour + 50*time.Minute}
+
+// Расписание клирингов: определяет, прошел ли клиринг с прошлой проверки
+type ClearingSchedule struct {
+	times []time.Duration
+	last  time.Time
+}
+
+// Функция для создания расписания клирингов (пустое - DefaultClearingTimes)
+func NewClearingSchedule(times []time.Duration) *ClearingSchedule {
+	if len(times) == 0 {
+		times = DefaultClearingTimes
+	}
+	return &ClearingSchedule{times: times}
+}
+
+// Функция проверяет, был ли клиринг в интервале (прошлая проверка, now]. Первая проверка
+// только запоминает время
+func (c *ClearingSchedule) Due(now time.Time) bool {
+	last := c.last
+	c.last = now
+	if last.IsZero() || !now.After(last) {
+		return false
+	}
+	for day := startOfDay(last); !day.After(now); day = day.AddDate(0, 0, 1) {
+		if !data.IsTradingDay(day) {
+			continue
+		}
+		for _, offset := range c.times {
+			clearing := day.Add(offset)
+			if clearing.After(last) && !clearing.After(now) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Функция возвращает начало дня во времени биржи
+func startOfDay(t time.Time) time.Time {
+	t = t.In(data.ExchangeLocation)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, data.ExchangeLocation)
+}
+
+// Функция для клиринга всех позиций по фьючерсам учета по последним ценам
+func SettleFutures(keeper *PositionKeeper, t time.Time) []VariationMargin {
+	var margins []VariationMargin
+	for _, position := range keeper.Positions() {
+		if !position.Futures || (position.Quantity == 0 && position.PendingMargin == 0) {
+			continue
+		}
+		amount := keeper.Settle(position.Symbol, position.LastPrice)
+		margins = append(margins, VariationMargin{Symbol: position.Symbol, Time: t, Price: position.LastPrice, Amount: amount})
+	}
+	return margins
+}
+
+// Структура для настройки перехода на следующий контракт
+type RolloverConfig struct {
+	DaysBefore  int    `json:"days_before"`  // За сколько рабочих дней до последнего дня торгов переходить
+	MaxAttempts int    `json:"max_attempts"` // Попыток открыть позицию в следующем контракте (по умолчанию 3)
+	StatePath   string `json:"state_path"`   // Файл незавершенных переносов (переживает перезапуск)
+}
+
+// Пауза между попытками открыть позицию в следующем контракте
+const rollRetryDelay = time.Minute
+
+// Структура для незавершенного переноса: позиция открывается в следующем контракте только
+// после завершения ордера закрытия и только на исполненное количество
+type pendingRoll struct {
+	From        string    `json:"from"`
+	To          string    `json:"to"`
+	OpenSide    string    `json:"open_side"`
+	CloseOrder  int       `json:"close_order"`  // Ордер закрытия в истекающем контракте
+	Quantity    int       `json:"quantity"`     // Исполненное закрытие (0 - закрытие еще идет)
+	Opened      int       `json:"opened"`       // Исполнено в следующем контракте
+	OpenOrder   int       `json:"open_order"`   // Рабочий ордер открытия (0 - нет)
+	Attempts    int       `json:"attempts"`     // Неудачные попытки открытия
+	LastAttempt time.Time `json:"last_attempt"` // Время последней попытки открытия
+	Failed      bool      `json:"failed"`       // Попытки исчерпаны: позицию нужно открыть вручную
+}
+
+// Перенос позиций по фьючерсам с ближнего контракта на следующий перед исполнением:
+// позиция закрывается рыночным ордером, а после его исполнения открывается в следующем
+// контракте. Незавершенные переносы сохраняются в файл, неудачное открытие повторяется
+type FuturesRoller struct {
+	manager *OrderManager
+	keeper  *PositionKeeper
+	specs   *FuturesSpecs
+	config  RolloverConfig
+
+	mu    sync.Mutex
+	rolls map[string]*pendingRoll // По истекающему контракту
+}
+
+// Функция для создания переноса позиций по фьючерсам. Загружает незавершенные переносы
+// из config.StatePath
+func NewFuturesRoller(manager *OrderManager, keeper *PositionKeeper, specs *FuturesSpecs, config RolloverConfig) (*FuturesRoller, error) {
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 3
+	}
+	r := &FuturesRoller{
+		manager: manager,
+		keeper:  keeper,
+		specs:   specs,
+		config:  config,
+		rolls:   make(map[string]*pendingRoll),
+	}
+	if config.StatePath != "" {
+		var stored []*pendingRoll
+		if _, err := readJSON(config.StatePath, &stored); err != nil {
+			return nil, err
+		}
+		for _, roll := range stored {
+			r.rolls[roll.From] = roll
+		}
+	}
+	for _, roll := range r.rolls {
+		if roll.Failed {
+			logger.Logger.Error().
+				Str("from", roll.From).
+				Str("to", roll.To).
+				Int("quantity", roll.Quantity-roll.Opened).
+				Msg("Futures roll failed earlier, position in next contract must be opened manually")
+		}
+	}
+	return r, nil
+}
+
+// Функция для переноса позиций, дата перехода которых наступила, и продолжения начатых переносов
+func (r *FuturesRoller) Check(now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var errs []error
+	changed := false
+	for from, roll := range r.rolls {
+		before := *roll
+		done, err := r.advance(roll, now)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if done {
+			delete(r.rolls, from)
+		}
+		changed = changed || done || *roll != before
+	}
+
+	for _, position := range r.keeper.Positions() {
+		if !position.Futures || position.Quantity == 0 {
+			continue
+		}
+		if _, pending := r.rolls[position.Symbol]; pending {
+			continue
+		}
+		contract, ok := r.specs.Contract(position.Symbol)
+		if !ok || now.Before(contract.RollDate(r.config.DaysBefore)) {
+			continue
+		}
+		next, ok := data.NextContract(r.specs.Contracts(), position.Symbol)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: no next contract to roll into", position.Symbol))
+			continue
+		}
+		// Ордера по контрактам выставлены в обход переноса
+		if len(r.manager.OpenOrders(position.Symbol)) > 0 || len(r.manager.OpenOrders(next.Symbol)) > 0 {
+			continue
+		}
+		roll, err := r.roll(position, next.Symbol)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		r.rolls[roll.From] = roll
+		changed = true
+		// Закрытие могло исполниться сразу (например, у виртуального брокера)
+		done, err := r.advance(roll, now)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if done {
+			delete(r.rolls, roll.From)
+		}
+	}
+
+	if changed {
+		r.save()
+	}
+	return errors.Join(errs...)
+}
+
+// Функция для закрытия позиции в истекающем контракте (первая нога переноса)
+func (r *FuturesRoller) roll(position PositionPnL, next string) (*pendingRoll, error) {
+	closeSide, openSide := "sell", "buy"
+	quantity := position.Quantity
+	if quantity < 0 {
+		closeSide, openSide = "buy", "sell"
+		quantity = -quantity
+	}
+
+	logger.Logger.Info().
+		Str("from", position.Symbol).
+		Str("to", next).
+		Int("quantity", position.Quantity).
+		Msg("Rolling futures position")
+
+	order, err := r.manager.PlaceOrder(&OrderRequest{
+		Symbol:    position.Symbol,
+		Side:      closeSide,
+		Quantity:  quantity,
+		OrderType: "market",
+		Strategy:  "rollover",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: error closing expiring contract: %w", position.Symbol, err)
+	}
+	return &pendingRoll{From: position.Symbol, To: next, OpenSide: openSide, CloseOrder: order.ID}, nil
+}
+
+// Функция для продолжения переноса (вызывается под r.mu). Возвращает true, если перенос
+// завершен: позиция открыта в следующем контракте или закрытие ничего не исполнило
+func (r *FuturesRoller) advance(roll *pendingRoll, now time.Time) (bool, error) {
+	if roll.Failed {
+		return false, nil
+	}
+
+	if roll.Quantity == 0 {
+		closeOrder, ok := r.manager.GetOrder(roll.CloseOrder)
+		if !ok {
+			return false, r.fail(roll, fmt.Errorf("close order %d is unknown", roll.CloseOrder))
+		}
+		if !closeOrder.State.IsTerminal() {
+			return false, nil
+		}
+		if closeOrder.FilledQuantity == 0 {
+			// Позиция осталась в истекающем контракте: перенос начнется заново при следующей проверке
+			logger.Logger.Warn().
+				Str("from", roll.From).
+				Str("state", string(closeOrder.State)).
+				Msg("Futures roll close order was not filled")
+			return true, nil
+		}
+		roll.Quantity = closeOrder.FilledQuantity
+	}
+
+	if roll.OpenOrder != 0 {
+		openOrder, ok := r.manager.GetOrder(roll.OpenOrder)
+		if ok && !openOrder.State.IsTerminal() {
+			return false, nil
+		}
+		if ok {
+			roll.Opened += openOrder.FilledQuantity
+		}
+		if !ok || openOrder.FilledQuantity < openOrder.Request.Quantity {
+			roll.Attempts++
+		}
+		roll.OpenOrder = 0
+	}
+
+	remaining := roll.Quantity - roll.Opened
+	if remaining <= 0 {
+		logger.Logger.Info().Str("from", roll.From).Str("to", roll.To).Int("quantity", roll.Quantity).Msg("Futures position rolled")
+		return true, nil
+	}
+	if roll.Attempts >= r.config.MaxAttempts {
+		return false, r.fail(roll, fmt.Errorf("%d lots not opened after %d attempts", remaining, roll.Attempts))
+	}
+	if roll.Attempts > 0 && now.Sub(roll.LastAttempt) < rollRetryDelay {
+		return false, nil
+	}
+
+	roll.LastAttempt = now
+	order, err := r.manager.PlaceOrder(&OrderRequest{
+		Symbol:    roll.To,
+		Side:      roll.OpenSide,
+		Quantity:  remaining,
+		OrderType: "market",
+		Strategy:  "rollover",
+	})
+	if err != nil {
+		roll.Attempts++
+		err = fmt.Errorf("%s: error opening next contract %s: %w", roll.From, roll.To, err)
+		if roll.Attempts >= r.config.MaxAttempts {
+			return false, r.fail(roll, err)
+		}
+		return false, err
+	}
+	roll.OpenOrder = order.ID
+	return false, nil
+}
+
+// Функция для остановки переноса, который не удалось завершить: позиция в следующем
+// контракте открывается вручную (вызывается под r.mu)
+func (r *FuturesRoller) fail(roll *pendingRoll, err error) error {
+	roll.Failed = true
+	logger.Logger.Error().
+		Err(err).
+		Str("from", roll.From).
+		Str("to", roll.To).
+		Int("quantity", roll.Quantity-roll.Opened).
+		Msg("Futures roll failed, position in next contract must be opened manually")
+	return fmt.Errorf("%s: roll to %s failed: %w", roll.From, roll.To, err)
+}
+
+// Функция для сохранения незавершенных переносов (вызывается под r.mu)
+func (r *FuturesRoller) save() {
+	if r.config.StatePath == "" {
+		return
+	}
+	rolls := make([]*pendingRoll, 0, len(r.rolls))
+	for _, roll := range r.rolls {
+		rolls = append(rolls, roll)
+	}
+	sort.Slice(rolls, func(i, j int) bool { return rolls[i].From < rolls[j].From })
+	if err := writeJSONAtomic(r.config.StatePath, rolls); err != nil {
+		logger.Logger.Error().Err(err).Str("path", r.config.StatePath).Msg("Failed to save futures rolls")
+	}
+}
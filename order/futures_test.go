@@ -0,0 +1,287 @@
+package order
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"trading-bot/data"
+)
+
+func TestPositionKeeperSettleVariationMargin(t *testing.T) {
+	type step struct {
+		fill   *Fill   // Сделка (nil - клиринг)
+		settle float64 // Расчетная цена клиринга
+		want   float64 // Вариационная маржа клиринга
+	}
+	buy := func(quantity int, price float64) step {
+		return step{fill: &Fill{Symbol: "SiU4", Side: "buy", Quantity: quantity, Price: price}}
+	}
+	sell := func(quantity int, price float64) step {
+		return step{fill: &Fill{Symbol: "SiU4", Side: "sell", Quantity: quantity, Price: price}}
+	}
+	settle := func(price, want float64) step {
+		return step{settle: price, want: want}
+	}
+
+	tests := []struct {
+		name       string
+		pointValue float64
+		steps      []step
+	}{
+		{
+			name:       "long between clearings",
+			pointValue: 1,
+			steps:      []step{buy(2, 100), settle(105, 10), settle(103, -4)},
+		},
+		{
+			name:       "point value",
+			pointValue: 1.35,
+			steps:      []step{buy(1, 100), settle(110, 13.5)},
+		},
+		{
+			name:       "short",
+			pointValue: 1,
+			steps:      []step{sell(3, 100), settle(98, 6), settle(99, -3)},
+		},
+		{
+			name:       "closed lots settle on next clearing",
+			pointValue: 1,
+			steps:      []step{buy(2, 100), sell(1, 104), settle(102, 4+2)},
+		},
+		{
+			name:       "lots opened after clearing use fill price",
+			pointValue: 1,
+			steps:      []step{buy(1, 100), settle(105, 5), buy(1, 106), settle(107, 2+1)},
+		},
+		{
+			name:       "closed position settles pending margin once",
+			pointValue: 1,
+			steps:      []step{buy(1, 100), settle(101, 1), sell(1, 104), settle(104, 3), settle(110, 0)},
+		},
+		{
+			name:       "zero price is ignored",
+			pointValue: 1,
+			steps:      []step{buy(1, 100), settle(0, 0), settle(101, 1)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keeper := NewPositionKeeper(nil)
+			keeper.SetFutures("SiU4", tt.pointValue)
+			for i, s := range tt.steps {
+				if s.fill != nil {
+					keeper.ApplyFill(*s.fill)
+					continue
+				}
+				if got := keeper.Settle("SiU4", s.settle); math.Abs(got-s.want) > 1e-9 {
+					t.Errorf("step %d: variation margin = %v, want %v", i, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSettleFutures(t *testing.T) {
+	keeper := NewPositionKeeper(nil)
+	keeper.SetFutures("SiU4", 1)
+	keeper.ApplyFill(Fill{Symbol: "SiU4", Side: "buy", Quantity: 2, Price: 100})
+	keeper.ApplyFill(Fill{Symbol: "SBER", Side: "buy", Quantity: 2, Price: 300})
+	keeper.Mark("SiU4", 104)
+	keeper.Mark("SBER", 310)
+
+	now := time.Date(2024, time.September, 16, 18, 50, 0, 0, data.ExchangeLocation)
+	margins := SettleFutures(keeper, now)
+	if len(margins) != 1 {
+		t.Fatalf("margins = %+v, want one futures position", margins)
+	}
+	want := VariationMargin{Symbol: "SiU4", Time: now, Price: 104, Amount: 8}
+	if got := margins[0]; got.Symbol != want.Symbol || !got.Time.Equal(want.Time) || got.Price != want.Price || got.Amount != want.Amount {
+		t.Errorf("margin = %+v, want %+v", got, want)
+	}
+	if margins = SettleFutures(keeper, now.Add(time.Hour)); len(margins) != 1 || margins[0].Amount != 0 {
+		t.Errorf("second clearing margins = %+v, want zero amount", margins)
+	}
+}
+
+func TestClearingScheduleDue(t *testing.T) {
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, data.ExchangeLocation)
+	}
+
+	tests := []struct {
+		name     string
+		previous time.Time
+		now      time.Time
+		want     bool
+	}{
+		{name: "intraday clearing", previous: at(time.September, 16, 13, 59), now: at(time.September, 16, 14, 0), want: true},
+		{name: "evening clearing", previous: at(time.September, 16, 18, 0), now: at(time.September, 16, 19, 0), want: true},
+		{name: "between clearings", previous: at(time.September, 16, 14, 30), now: at(time.September, 16, 15, 0)},
+		{name: "overnight", previous: at(time.September, 16, 19, 0), now: at(time.September, 17, 10, 0)},
+		{name: "over weekend", previous: at(time.September, 13, 19, 0), now: at(time.September, 16, 10, 0)},
+		{name: "missed clearing", previous: at(time.September, 13, 10, 0), now: at(time.September, 16, 10, 0), want: true},
+		{name: "exchange holiday", previous: at(time.June, 12, 10, 0), now: at(time.June, 12, 20, 0)},
+		{name: "time went back", previous: at(time.September, 16, 15, 0), now: at(time.September, 16, 13, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule := NewClearingSchedule(nil)
+			if schedule.Due(tt.previous) {
+				t.Fatal("first check must not be due")
+			}
+			if got := schedule.Due(tt.now); got != tt.want {
+				t.Errorf("Due = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFuturesRollerCheck(t *testing.T) {
+	contracts := []data.FuturesContract{
+		{Symbol: "SiU4", Asset: "Si", TickSize: 1, TickValue: 1, LastTradeDate: time.Date(2024, time.September, 19, 0, 0, 0, 0, data.ExchangeLocation)},
+		{Symbol: "SiZ4", Asset: "Si", TickSize: 1, TickValue: 1, LastTradeDate: time.Date(2024, time.December, 19, 0, 0, 0, 0, data.ExchangeLocation)},
+	}
+	beforeRoll := time.Date(2024, time.September, 16, 12, 0, 0, 0, data.ExchangeLocation)
+	afterRoll := time.Date(2024, time.September, 17, 12, 0, 0, 0, data.ExchangeLocation)
+
+	tests := []struct {
+		name       string
+		symbol     string
+		side       string
+		now        time.Time
+		wantPlaced []OrderRequest
+		wantErr    bool
+	}{
+		{name: "before roll date", symbol: "SiU4", side: "buy", now: beforeRoll},
+		{
+			name: "long", symbol: "SiU4", side: "buy", now: afterRoll,
+			wantPlaced: []OrderRequest{
+				{Symbol: "SiU4", Side: "sell", Quantity: 3},
+				{Symbol: "SiZ4", Side: "buy", Quantity: 3},
+			},
+		},
+		{
+			name: "short", symbol: "SiU4", side: "sell", now: afterRoll,
+			wantPlaced: []OrderRequest{
+				{Symbol: "SiU4", Side: "buy", Quantity: 3},
+				{Symbol: "SiZ4", Side: "sell", Quantity: 3},
+			},
+		},
+		{name: "no next contract", symbol: "SiZ4", side: "buy", now: time.Date(2024, time.December, 18, 12, 0, 0, 0, data.ExchangeLocation), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &stubBroker{}
+			manager := NewOrderManager(stub)
+			specs := NewFuturesSpecs(contracts)
+			keeper := NewPositionKeeper(nil)
+			specs.ApplyTo(keeper)
+			keeper.ApplyFill(Fill{Symbol: tt.symbol, Side: tt.side, Quantity: 3, Price: 100})
+
+			roller, err := NewFuturesRoller(manager, keeper, specs, RolloverConfig{DaysBefore: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := roller.Check(tt.now); (err != nil) != tt.wantErr {
+				t.Fatalf("Check error = %v, want error %v", err, tt.wantErr)
+			}
+			// Пока закрытие не исполнено, позиция в следующем контракте не открывается
+			if err := roller.Check(tt.now); (err != nil) != tt.wantErr {
+				t.Fatalf("second Check error = %v, want error %v", err, tt.wantErr)
+			}
+			if len(tt.wantPlaced) > 0 {
+				if len(stub.placed) != 1 {
+					t.Fatalf("placed before close fill = %+v, want close order only", stub.placed)
+				}
+				if err := manager.ApplyUpdate(OrderUpdate{OrderID: 1, Status: "filled", FilledQuantity: 3, AveragePrice: 100}); err != nil {
+					t.Fatal(err)
+				}
+				if err := roller.Check(tt.now); err != nil {
+					t.Fatalf("Check after close fill error = %v", err)
+				}
+			}
+
+			if len(stub.placed) != len(tt.wantPlaced) {
+				t.Fatalf("placed = %+v, want %+v", stub.placed, tt.wantPlaced)
+			}
+			for i, want := range tt.wantPlaced {
+				got := stub.placed[i]
+				if got.Symbol != want.Symbol || got.Side != want.Side || got.Quantity != want.Quantity || got.OrderType != "market" {
+					t.Errorf("placed[%d] = %+v, want %+v market", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFuturesRollerOpensFilledQuantityAndRetries(t *testing.T) {
+	contracts := []data.FuturesContract{
+		{Symbol: "RIU4", Asset: "RTS", TickSize: 10, TickValue: 20, LastTradeDate: time.Date(2024, time.September, 19, 0, 0, 0, 0, data.ExchangeLocation)},
+		{Symbol: "RIZ4", Asset: "RTS", TickSize: 10, TickValue: 20, LastTradeDate: time.Date(2024, time.December, 19, 0, 0, 0, 0, data.ExchangeLocation)},
+	}
+	specs := NewFuturesSpecs(contracts)
+	keeper := NewPositionKeeper(nil)
+	specs.ApplyTo(keeper)
+	keeper.ApplyFill(Fill{Symbol: "RIU4", Side: "sell", Quantity: 5, Price: 110000})
+
+	stub := &stubBroker{}
+	manager := NewOrderManager(stub)
+	config := RolloverConfig{DaysBefore: 1, MaxAttempts: 2, StatePath: filepath.Join(t.TempDir(), "rolls.json")}
+	roller, err := NewFuturesRoller(manager, keeper, specs, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, time.September, 18, 12, 0, 0, 0, data.ExchangeLocation)
+	if err := roller.Check(now); err != nil {
+		t.Fatal(err)
+	}
+	// Закрытие исполнено частично, остаток снят площадкой: открывается только исполненное
+	if err := manager.ApplyUpdate(OrderUpdate{OrderID: 1, Status: "cancelled", FilledQuantity: 4, AveragePrice: 110000}); err != nil {
+		t.Fatal(err)
+	}
+	stub.placeErr = errors.New("connection reset by peer")
+	if err := roller.Check(now); err == nil {
+		t.Fatal("Check with failed open leg returned no error")
+	}
+
+	// Перезапуск: незавершенный перенос загружается из файла, повтор - после паузы
+	roller, err = NewFuturesRoller(manager, keeper, specs, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.placeErr = nil
+	if err := roller.Check(now.Add(10 * time.Second)); err != nil || len(stub.placed) != 1 {
+		t.Fatalf("retry before delay: error = %v, placed = %+v", err, stub.placed)
+	}
+	if err := roller.Check(now.Add(rollRetryDelay)); err != nil {
+		t.Fatal(err)
+	}
+	if len(stub.placed) != 2 {
+		t.Fatalf("placed = %+v, want close and open legs", stub.placed)
+	}
+	if open := stub.placed[1]; open.Symbol != "RIZ4" || open.Side != "sell" || open.Quantity != 4 {
+		t.Errorf("open leg = %+v, want sell 4 RIZ4", open)
+	}
+
+	// Открытие тоже исполнилось не полностью: попытки исчерпаны, перенос остановлен
+	if err := manager.ApplyUpdate(OrderUpdate{OrderID: 2, Status: "cancelled", FilledQuantity: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := roller.Check(now.Add(2 * rollRetryDelay)); err == nil {
+		t.Fatal("Check after exhausted attempts returned no error")
+	}
+	if err := roller.Check(now.Add(3 * rollRetryDelay)); err != nil || len(stub.placed) != 2 {
+		t.Errorf("failed roll retried: error = %v, placed = %d", err, len(stub.placed))
+	}
+	var stored []pendingRoll
+	if _, err := readJSON(config.StatePath, &stored); err != nil || len(stored) != 1 || !stored[0].Failed || stored[0].Opened != 3 {
+		t.Errorf("stored rolls = %+v, %v, want one failed roll with 3 opened", stored, err)
+	}
+}
@@ -0,0 +1,422 @@
+package order
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"trading-bot/logger"
+)
+
+// Ошибка для ордера, результат отправки которого неизвестен (ответ площадки потерян)
+var ErrOrderInFlight = errors.New("order is in flight, result unknown")
+
+// Структура для записи об отправленном ордере
+type InFlightOrder struct {
+	ClientOrderID string       `json:"client_order_id"`
+	Request       OrderRequest `json:"request"`
+	OrderID       int          `json:"order_id"` // 0, пока площадка не подтвердила ордер
+	Submitted     time.Time    `json:"submitted"`
+	LastAttempt   time.Time    `json:"last_attempt"`
+	Attempts      int          `json:"attempts"`
+
+	sending bool // Ордер отправляется прямо сейчас: результат еще ожидается, а не потерян
+}
+
+// Функция возвращает время последней отправки (для записей без него - время первой)
+func (o *InFlightOrder) lastAttempt() time.Time {
+	if o.LastAttempt.IsZero() {
+		return o.Submitted
+	}
+	return o.LastAttempt
+}
+
+// Интерфейс площадки, которая получает список ордеров асинхронно после подключения (Transaq):
+// до первого списка поиск по клиентскому ID не находит и существующие ордера
+type OrderSnapshotSource interface {
+	OrdersLoaded() <-chan struct{}
+}
+
+// Структура для настройки защиты от повторной отправки
+type IdempotentConfig struct {
+	StorePath       string        // Файл с отправленными ордерами (переживает перезапуск)
+	MaxAttempts     int           // Максимум отправок одного ордера после неоднозначных ошибок
+	RetryDelay      time.Duration // Пауза перед поиском ордера по клиентскому ID
+	NotFoundAfter   time.Duration // Через сколько после отправки ненайденный ордер считается не дошедшим
+	SnapshotTimeout time.Duration // Сколько Recover ждет списка ордеров от площадки
+	Retention       time.Duration // Сколько хранить подтвержденные ID для отсева дублей
+}
+
+// Функция возвращает настройки по умолчанию
+func DefaultIdempotentConfig(storePath string) IdempotentConfig {
+	return IdempotentConfig{
+		StorePath:       storePath,
+		MaxAttempts:     3,
+		RetryDelay:      2 * time.Second,
+		NotFoundAfter:   30 * time.Second,
+		SnapshotTimeout: 30 * time.Second,
+		Retention:       24 * time.Hour,
+	}
+}
+
+// Декоратор Broker, который присваивает каждому ордеру клиентский ID и отсеивает
+// повторные отправки. После неоднозначной ошибки (таймаут, обрыв связи) ордер ищется
+// на площадке по клиентскому ID и отправляется повторно, только когда его отсутствие подтверждено.
+// Блокировка не удерживается во время обращений к площадке
+type IdempotentBroker struct {
+	Broker
+	config IdempotentConfig
+
+	mu       sync.Mutex
+	orders   map[string]*InFlightOrder
+	confirms []func(order InFlightOrder)
+}
+
+// Функция для создания декоратора. Загружает отправленные ордера из config.StorePath
+func NewIdempotentBroker(broker Broker, config IdempotentConfig) (*IdempotentBroker, error) {
+	b := &IdempotentBroker{
+		Broker: broker,
+		config: config,
+		orders: make(map[string]*InFlightOrder),
+	}
+
+	var stored []*InFlightOrder
+	if _, err := readJSON(config.StorePath, &stored); err != nil {
+		return nil, err
+	}
+	for _, entry := range stored {
+		b.orders[entry.ClientOrderID] = entry
+	}
+	b.prune(time.Now())
+
+	logger.Logger.Info().
+		Str("path", config.StorePath).
+		Int("orders", len(b.orders)).
+		Int("in_flight", len(b.InFlight())).
+		Msg("Client order IDs loaded")
+	return b, nil
+}
+
+// Функция для генерации уникального клиентского ID ордера (не длиннее 20 символов,
+// чтобы помещаться в brokerref Transaq)
+func NewClientOrderID() string {
+	var random [3]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return fmt.Sprintf("tb%x", time.Now().UnixNano())
+	}
+	return fmt.Sprintf("tb%x%x", time.Now().UnixMilli(), random)
+}
+
+// Функция возвращает площадку под декоратором
+func (b *IdempotentBroker) Unwrap() Broker {
+	return b.Broker
+}
+
+func (b *IdempotentBroker) PlaceOrder(req *OrderRequest) (*OrderResponse, error) {
+	request := *req
+	request.AccessToken = ""
+	if request.ClientOrderID == "" {
+		request.ClientOrderID = NewClientOrderID()
+	}
+
+	// Пока результат прошлой отправки по инструменту неизвестен, новый ордер не отправляется.
+	// Поиск на площадке идет без блокировки, чтобы не задерживать остальные ордера
+	b.mu.Lock()
+	if response, err := b.duplicate(request.ClientOrderID); response != nil || err != nil {
+		b.mu.Unlock()
+		return response, err
+	}
+	var unresolved []*InFlightOrder
+	for _, other := range b.orders {
+		if other.OrderID == 0 && !other.sending && other.ClientOrderID != request.ClientOrderID && other.Request.Symbol == request.Symbol {
+			unresolved = append(unresolved, other)
+		}
+	}
+	b.mu.Unlock()
+	for _, other := range unresolved {
+		if err := b.resolve(other); err != nil {
+			return nil, err
+		}
+	}
+
+	b.mu.Lock()
+	if response, err := b.duplicate(request.ClientOrderID); response != nil || err != nil {
+		b.mu.Unlock()
+		return response, err
+	}
+	entry, exists := b.orders[request.ClientOrderID]
+	if !exists {
+		entry = &InFlightOrder{ClientOrderID: request.ClientOrderID, Request: request, Submitted: time.Now()}
+		b.orders[entry.ClientOrderID] = entry
+	}
+	entry.sending = true
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		entry.sending = false
+		b.mu.Unlock()
+	}()
+
+	// Ордер с этим ID мог дойти до площадки при прошлой отправке: повторно он отправляется,
+	// только если площадка подтвердила, что такого ордера нет
+	if exists {
+		history, err := b.await(entry)
+		if err == nil {
+			return b.confirm(entry, history.OrderID), nil
+		}
+		if !errors.Is(err, ErrOrderNotFound) {
+			return nil, fmt.Errorf("client order %s: %v: %w", entry.ClientOrderID, err, ErrOrderInFlight)
+		}
+	}
+
+	for {
+		b.mu.Lock()
+		previousAttempt := entry.LastAttempt
+		entry.Attempts++
+		entry.LastAttempt = time.Now()
+		attempts := entry.Attempts
+		if err := b.save(); err != nil {
+			// Без сохраненного клиентского ID ордер после сбоя отправился бы повторно: он не отправляется
+			entry.Attempts--
+			entry.LastAttempt = previousAttempt
+			if entry.Attempts == 0 {
+				delete(b.orders, entry.ClientOrderID)
+			}
+			b.mu.Unlock()
+			return nil, fmt.Errorf("client order %s not sent: %w", entry.ClientOrderID, err)
+		}
+		b.mu.Unlock()
+
+		response, err := b.Broker.PlaceOrder(&request)
+		if err == nil {
+			return b.confirm(entry, response.OrderID), nil
+		}
+		if errors.Is(err, ErrOrderRejected) {
+			b.mu.Lock()
+			delete(b.orders, entry.ClientOrderID)
+			b.persist()
+			b.mu.Unlock()
+			return nil, err
+		}
+
+		logger.Logger.Warn().
+			Err(err).
+			Str("client_order_id", entry.ClientOrderID).
+			Int("attempt", attempts).
+			Msg("Order submit result unknown, looking up by client ID")
+
+		if attempts >= b.config.MaxAttempts {
+			return nil, fmt.Errorf("client order %s: %v: %w", entry.ClientOrderID, err, ErrOrderInFlight)
+		}
+
+		history, lookupErr := b.await(entry)
+		if lookupErr == nil {
+			return b.confirm(entry, history.OrderID), nil
+		}
+		if !errors.Is(lookupErr, ErrOrderNotFound) {
+			return nil, fmt.Errorf("client order %s: lookup failed: %v: %w", entry.ClientOrderID, lookupErr, ErrOrderInFlight)
+		}
+	}
+}
+
+// Функция возвращает ответ для уже подтвержденного клиентского ID или ошибку, если ордер
+// с этим ID отправляется прямо сейчас (вызывается под b.mu)
+func (b *IdempotentBroker) duplicate(clientOrderID string) (*OrderResponse, error) {
+	entry, exists := b.orders[clientOrderID]
+	switch {
+	case !exists:
+		return nil, nil
+	case entry.OrderID != 0:
+		logger.Logger.Warn().
+			Str("client_order_id", clientOrderID).
+			Int("order_id", entry.OrderID).
+			Msg("Duplicate order submit ignored")
+		return &OrderResponse{OrderID: entry.OrderID, Status: "success", Message: "duplicate client order id"}, nil
+	case entry.sending:
+		return nil, fmt.Errorf("client order %s is being sent: %w", clientOrderID, ErrOrderInFlight)
+	}
+	return nil, nil
+}
+
+// Функция для выяснения судьбы всех ордеров с неизвестным результатом (вызывается после перезапуска).
+// Если площадка загружает ордера асинхронно, сначала ждет первого списка ордеров: до него
+// отсутствие ордера ничего не значит, и такие записи остаются неизвестными
+func (b *IdempotentBroker) Recover() error {
+	if loaded := b.ordersLoaded(); loaded != nil {
+		select {
+		case <-loaded:
+		case <-time.After(b.config.SnapshotTimeout):
+			logger.Logger.Warn().Dur("timeout", b.config.SnapshotTimeout).Msg("Order list not received from broker, in-flight orders stay unresolved")
+		}
+	}
+
+	b.mu.Lock()
+	var unresolved []*InFlightOrder
+	for _, entry := range b.orders {
+		if entry.OrderID == 0 && !entry.sending {
+			unresolved = append(unresolved, entry)
+		}
+	}
+	b.mu.Unlock()
+
+	var lastErr error
+	for _, entry := range unresolved {
+		if err := b.resolve(entry); err != nil {
+			logger.Logger.Error().Err(err).Str("client_order_id", entry.ClientOrderID).Msg("Failed to resolve in-flight order")
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// Функция возвращает ордера с неизвестным результатом отправки
+func (b *IdempotentBroker) InFlight() []InFlightOrder {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var orders []InFlightOrder
+	for _, entry := range b.orders {
+		if entry.OrderID == 0 {
+			orders = append(orders, *entry)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].Submitted.Before(orders[j].Submitted) })
+	return orders
+}
+
+// Функция ищет ордер на площадке: найден - подтверждается, отсутствие подтверждено (см. absent) -
+// запись удаляется (ордер до площадки не дошел), иначе результат остается неизвестным
+func (b *IdempotentBroker) resolve(entry *InFlightOrder) error {
+	history, err := b.Broker.FindOrderByClientID(entry.ClientOrderID)
+	switch {
+	case err == nil:
+		b.confirm(entry, history.OrderID)
+		return nil
+	case errors.Is(err, ErrOrderNotFound) && b.absent(entry):
+		logger.Logger.Warn().Str("client_order_id", entry.ClientOrderID).Msg("In-flight order not found on broker, dropping")
+		b.mu.Lock()
+		delete(b.orders, entry.ClientOrderID)
+		b.persist()
+		b.mu.Unlock()
+		return nil
+	default:
+		return fmt.Errorf("client order %s for %s: %v: %w", entry.ClientOrderID, entry.Request.Symbol, err, ErrOrderInFlight)
+	}
+}
+
+// Функция ищет отправленный ордер на площадке, пока он не найден или его отсутствие не подтверждено.
+// Возвращает ошибку ErrOrderNotFound только для подтвержденного отсутствия
+func (b *IdempotentBroker) await(entry *InFlightOrder) (*OrderHistory, error) {
+	for {
+		time.Sleep(b.config.RetryDelay)
+		history, err := b.Broker.FindOrderByClientID(entry.ClientOrderID)
+		if err == nil || !errors.Is(err, ErrOrderNotFound) {
+			return history, err
+		}
+		if b.absent(entry) {
+			return nil, err
+		}
+		if b.sinceLastAttempt(entry) >= b.config.NotFoundAfter {
+			return nil, errors.New("order list not received from broker yet")
+		}
+	}
+}
+
+// Функция проверяет, подтверждено ли отсутствие ордера, который не нашелся на площадке: список
+// ордеров площадки загружен и с последней отправки прошло не меньше NotFoundAfter (подтверждение
+// могло задержаться)
+func (b *IdempotentBroker) absent(entry *InFlightOrder) bool {
+	if loaded := b.ordersLoaded(); loaded != nil {
+		select {
+		case <-loaded:
+		default:
+			return false
+		}
+	}
+	return b.sinceLastAttempt(entry) >= b.config.NotFoundAfter
+}
+
+// Функция возвращает, сколько прошло с последней отправки ордера
+func (b *IdempotentBroker) sinceLastAttempt(entry *InFlightOrder) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return time.Since(entry.lastAttempt())
+}
+
+// Функция возвращает сигнал загрузки списка ордеров площадки под цепочкой декораторов
+// (nil, если площадка отвечает на поиск синхронно)
+func (b *IdempotentBroker) ordersLoaded() <-chan struct{} {
+	for broker := b.Broker; broker != nil; {
+		if source, ok := broker.(OrderSnapshotSource); ok {
+			return source.OrdersLoaded()
+		}
+		wrapper, ok := broker.(brokerWrapper)
+		if !ok {
+			break
+		}
+		broker = wrapper.Unwrap()
+	}
+	return nil
+}
+
+// Функция для подписки на подтверждение ордеров: ответом площадки или поиском по клиентскому ID
+func (b *IdempotentBroker) SubscribeConfirms(handler func(order InFlightOrder)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.confirms = append(b.confirms, handler)
+}
+
+// Функция для подтверждения ордера
+func (b *IdempotentBroker) confirm(entry *InFlightOrder, orderID int) *OrderResponse {
+	b.mu.Lock()
+	entry.OrderID = orderID
+	b.persist()
+	confirmed := *entry
+	handlers := b.confirms
+	b.mu.Unlock()
+
+	logger.Logger.Info().
+		Str("client_order_id", entry.ClientOrderID).
+		Int("order_id", orderID).
+		Msg("Order confirmed by client ID")
+	for _, handler := range handlers {
+		handler(confirmed)
+	}
+	return &OrderResponse{OrderID: orderID, Status: "success"}
+}
+
+// Функция для удаления подтвержденных ордеров старше срока хранения (вызывается под b.mu)
+func (b *IdempotentBroker) prune(now time.Time) {
+	for id, entry := range b.orders {
+		if entry.OrderID != 0 && now.Sub(entry.Submitted) > b.config.Retention {
+			delete(b.orders, id)
+		}
+	}
+}
+
+// Функция для сохранения записей на диск (вызывается под b.mu)
+func (b *IdempotentBroker) save() error {
+	b.prune(time.Now())
+
+	entries := make([]*InFlightOrder, 0, len(b.orders))
+	for _, entry := range b.orders {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Submitted.Before(entries[j].Submitted) })
+
+	if err := writeJSONAtomic(b.config.StorePath, entries); err != nil {
+		return fmt.Errorf("error saving client order IDs: %w", err)
+	}
+	return nil
+}
+
+// Функция для сохранения записей после подтверждения или удаления ордера (вызывается под b.mu).
+// Ордер уже обработан площадкой, поэтому ошибка только записывается в журнал
+func (b *IdempotentBroker) persist() {
+	if err := b.save(); err != nil {
+		logger.Logger.Error().Err(err).Str("path", b.config.StorePath).Msg("Failed to save client order IDs")
+	}
+}
@@ -0,0 +1,186 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Площадка, теряющая ответы: первые dropped отправок не доходят до площадки, следующие lost
+// доходят, но ответ на них теряется. Дошедшие ордера находятся поиском по клиентскому ID
+type lossyBroker struct {
+	*stubBroker
+	lost, dropped int
+	clients       map[string]int // ID ордеров по клиентскому ID
+}
+
+func newLossyBroker(lost, dropped int) *lossyBroker {
+	return &lossyBroker{stubBroker: &stubBroker{}, lost: lost, dropped: dropped, clients: make(map[string]int)}
+}
+
+func (b *lossyBroker) PlaceOrder(req *OrderRequest) (*OrderResponse, error) {
+	b.mu.Lock()
+	if b.dropped > 0 {
+		b.dropped--
+		b.mu.Unlock()
+		return nil, errors.New("connection reset by peer")
+	}
+	b.mu.Unlock()
+
+	response, err := b.stubBroker.PlaceOrder(req)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clients[req.ClientOrderID] = response.OrderID
+	if b.lost > 0 {
+		b.lost--
+		return nil, errors.New("timeout waiting for command result")
+	}
+	return response, nil
+}
+
+func (b *lossyBroker) FindOrderByClientID(clientOrderID string) (*OrderHistory, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if id, ok := b.clients[clientOrderID]; ok {
+		return &OrderHistory{OrderID: id, ClientOrderID: clientOrderID, Status: "working"}, nil
+	}
+	return nil, fmt.Errorf("client order %s: %w", clientOrderID, ErrOrderNotFound)
+}
+
+// Функция возвращает настройки с короткими паузами для тестов
+func testIdempotentConfig(t *testing.T) IdempotentConfig {
+	config := DefaultIdempotentConfig(filepath.Join(t.TempDir(), "orders.json"))
+	config.RetryDelay = time.Millisecond
+	config.NotFoundAfter = 20 * time.Millisecond
+	return config
+}
+
+// Функция читает сохраненные записи об отправленных ордерах
+func storedOrders(t *testing.T, path string) []InFlightOrder {
+	t.Helper()
+	var stored []InFlightOrder
+	if _, err := readJSON(path, &stored); err != nil {
+		t.Fatal(err)
+	}
+	return stored
+}
+
+func TestIdempotentBrokerConfirmsOrderWithLostResponse(t *testing.T) {
+	venue := newLossyBroker(1, 0)
+	config := testIdempotentConfig(t)
+	broker, err := NewIdempotentBroker(venue, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := broker.PlaceOrder(&OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 1, ClientOrderID: "c1"})
+	if err != nil {
+		t.Fatalf("PlaceOrder error = %v", err)
+	}
+	if response.OrderID != 1 || len(venue.placed) != 1 {
+		t.Errorf("order %d sent %d times, want order 1 sent once", response.OrderID, len(venue.placed))
+	}
+	if stored := storedOrders(t, config.StorePath); len(stored) != 1 || stored[0].OrderID != 1 || stored[0].Attempts != 1 {
+		t.Errorf("stored = %+v, want confirmed c1 after one attempt", stored)
+	}
+
+	// Повторная отправка того же клиентского ID не доходит до площадки
+	duplicate, err := broker.PlaceOrder(&OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 1, ClientOrderID: "c1"})
+	if err != nil || duplicate.OrderID != 1 || len(venue.placed) != 1 {
+		t.Errorf("duplicate = %+v, %v, sent %d times", duplicate, err, len(venue.placed))
+	}
+}
+
+func TestIdempotentBrokerResendsOrderThatNeverArrived(t *testing.T) {
+	venue := newLossyBroker(0, 1)
+	config := testIdempotentConfig(t)
+	broker, err := NewIdempotentBroker(venue, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := broker.PlaceOrder(&OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 1})
+	if err != nil {
+		t.Fatalf("PlaceOrder error = %v", err)
+	}
+	if len(venue.placed) != 1 || response.OrderID != 1 {
+		t.Errorf("placed = %d, order = %d, want one order after the resend", len(venue.placed), response.OrderID)
+	}
+	if stored := storedOrders(t, config.StorePath); len(stored) != 1 || stored[0].Attempts != 2 {
+		t.Errorf("stored = %+v, want two attempts", stored)
+	}
+}
+
+func TestIdempotentBrokerRecoversInFlightOrderAfterRestart(t *testing.T) {
+	venue := newLossyBroker(1, 0)
+	config := testIdempotentConfig(t)
+	config.MaxAttempts = 1
+	broker, err := NewIdempotentBroker(venue, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := broker.PlaceOrder(&OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 1}); !errors.Is(err, ErrOrderInFlight) {
+		t.Fatalf("PlaceOrder error = %v, want %v", err, ErrOrderInFlight)
+	}
+
+	// Перезапуск: запись о неподтвержденном ордере читается с диска
+	restarted, err := NewIdempotentBroker(venue, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inFlight := restarted.InFlight(); len(inFlight) != 1 {
+		t.Fatalf("in flight after restart = %+v, want one order", inFlight)
+	}
+	if err := restarted.Recover(); err != nil {
+		t.Fatalf("Recover error = %v", err)
+	}
+	if inFlight := restarted.InFlight(); len(inFlight) != 0 {
+		t.Errorf("in flight after recover = %+v, want none", inFlight)
+	}
+	if stored := storedOrders(t, config.StorePath); len(stored) != 1 || stored[0].OrderID != 1 {
+		t.Errorf("stored = %+v, want order 1 confirmed", stored)
+	}
+	if len(venue.placed) != 1 {
+		t.Errorf("placed = %d, want the order sent once", len(venue.placed))
+	}
+}
+
+func TestIdempotentBrokerDoesNotSendWhenStoreFails(t *testing.T) {
+	venue := newLossyBroker(0, 0)
+	config := testIdempotentConfig(t)
+	broker, err := NewIdempotentBroker(venue, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Каталог хранилища не может быть создан: на его месте обычный файл
+	blocker := filepath.Join(t.TempDir(), "blocker")
+	if err := os.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	broker.config.StorePath = filepath.Join(blocker, "orders.json")
+
+	_, err = broker.PlaceOrder(&OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 1, ClientOrderID: "c1"})
+	if err == nil || errors.Is(err, ErrOrderInFlight) {
+		t.Fatalf("PlaceOrder error = %v, want a definite failure", err)
+	}
+	if len(venue.placed) != 0 {
+		t.Errorf("placed = %d, want the order not sent", len(venue.placed))
+	}
+	if inFlight := broker.InFlight(); len(inFlight) != 0 {
+		t.Errorf("in flight = %+v, want nothing left to resolve", inFlight)
+	}
+
+	// После восстановления хранилища тот же клиентский ID отправляется обычным образом
+	broker.config.StorePath = config.StorePath
+	if response, err := broker.PlaceOrder(&OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 1, ClientOrderID: "c1"}); err != nil || response.OrderID != 1 {
+		t.Errorf("PlaceOrder after recovery = %+v, %v", response, err)
+	}
+}
@@ -0,0 +1,628 @@
+package order
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"trading-bot/logger"
+)
+
+// Тип записи журнала ордеров
+type JournalEntryType string
+
+const (
+	JournalIntent        JournalEntryType = "intent"         // Намерение выставить ордер (до отправки)
+	JournalAck           JournalEntryType = "ack"            // Площадка приняла ордер
+	JournalReject        JournalEntryType = "reject"         // Площадка отклонила ордер, снятие или изменение
+	JournalUnknown       JournalEntryType = "unknown"        // Результат отправки неизвестен (таймаут, обрыв связи): ордер мог дойти до площадки
+	JournalCancelRequest JournalEntryType = "cancel_request" // Запрос снятия ордера (до отправки)
+	JournalCancel        JournalEntryType = "cancel"         // Площадка приняла снятие
+	JournalModify        JournalEntryType = "modify"         // Запрос изменения ордера (до отправки)
+	JournalUpdate        JournalEntryType = "update"         // Изменение состояния ордера
+	JournalFill          JournalEntryType = "fill"           // Сделка по ордеру
+)
+
+// Структура для записи журнала ордеров
+type JournalEntry struct {
+	Seq            int64            `json:"seq"`
+	Time           time.Time        `json:"time"`
+	Type           JournalEntryType `json:"type"`
+	Account        string           `json:"account,omitempty"`
+	OrderID        int              `json:"order_id,omitempty"`
+	ClientOrderID  string           `json:"client_order_id,omitempty"`
+	Symbol         string           `json:"symbol,omitempty"`
+	Side           string           `json:"side,omitempty"`
+	Quantity       int              `json:"quantity,omitempty"`
+	Price          float64          `json:"price,omitempty"`
+	Strategy       string           `json:"strategy,omitempty"`
+	State          OrderState       `json:"state,omitempty"`
+	FilledQuantity int              `json:"filled_quantity,omitempty"`
+	AveragePrice   float64          `json:"average_price,omitempty"`
+	Commission     float64          `json:"commission,omitempty"`
+	Message        string           `json:"message,omitempty"`
+	Request        *OrderRequest    `json:"request,omitempty"` // Параметры ордера (для intent)
+}
+
+// Структура для отбора записей журнала. Пустые поля не ограничивают отбор
+type JournalFilter struct {
+	From     time.Time // Начало периода (включительно)
+	To       time.Time // Конец периода (не включительно)
+	Account  string
+	Symbol   string
+	Strategy string
+	Types    []JournalEntryType
+}
+
+// Функция проверяет, подходит ли запись под фильтр
+func (f JournalFilter) match(entry JournalEntry) bool {
+	if !f.From.IsZero() && entry.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !entry.Time.Before(f.To) {
+		return false
+	}
+	if f.Account != "" && entry.Account != f.Account {
+		return false
+	}
+	if f.Symbol != "" && entry.Symbol != f.Symbol {
+		return false
+	}
+	if f.Strategy != "" && entry.Strategy != f.Strategy {
+		return false
+	}
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, entryType := range f.Types {
+		if entry.Type == entryType {
+			return true
+		}
+	}
+	return false
+}
+
+// Журнал ордеров и сделок: только дописывание в JSONL, каждая запись сбрасывается
+// на диск (fsync) до того, как операция считается выполненной. По журналу после
+// перезапуска восстанавливаются ордера менеджера и позиции. Записи отмечаются счетом
+type Journal struct {
+	path    string
+	account string
+
+	mu         sync.Mutex
+	file       *os.File
+	seq        int64
+	strategies map[int]string   // Стратегия по ID ордера
+	clients    map[int]string   // Клиентский ID по ID ордера
+	symbols    map[int]string   // Инструмент по ID ордера
+	acked      map[int]bool     // Ордера с записанным подтверждением
+	last       map[int]orderKey // Последнее записанное состояние ордера (отсев повторов при опросе)
+}
+
+// Ключ состояния ордера для отсева повторных обновлений
+type orderKey struct {
+	state  OrderState
+	filled int
+}
+
+// Функция для открытия журнала счета. Существующие записи читаются для продолжения нумерации
+func OpenJournal(path, account string) (*Journal, error) {
+	j := &Journal{
+		path:       path,
+		account:    account,
+		strategies: make(map[int]string),
+		clients:    make(map[int]string),
+		symbols:    make(map[int]string),
+		acked:      make(map[int]bool),
+		last:       make(map[int]orderKey),
+	}
+
+	entries, err := j.read()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		j.index(entry)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("error creating journal directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening journal: %w", err)
+	}
+	j.file = file
+
+	logger.Logger.Info().Str("path", path).Str("account", account).Int("entries", len(entries)).Msg("Order journal opened")
+	return j, nil
+}
+
+// Функция для закрытия журнала
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+// Функция для записи в журнал. Возвращает управление только после fsync
+func (j *Journal) Append(entry JournalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return fmt.Errorf("journal %s is closed", j.path)
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	if entry.Account == "" {
+		entry.Account = j.account
+	}
+	if entry.Strategy == "" && entry.OrderID != 0 {
+		entry.Strategy = j.strategies[entry.OrderID]
+	}
+	if entry.ClientOrderID == "" && entry.OrderID != 0 {
+		entry.ClientOrderID = j.clients[entry.OrderID]
+	}
+	if entry.Symbol == "" && entry.OrderID != 0 {
+		entry.Symbol = j.symbols[entry.OrderID]
+	}
+	entry.Seq = j.seq + 1
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error marshaling journal entry: %w", err)
+	}
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("error syncing journal: %w", err)
+	}
+	j.index(entry)
+	return nil
+}
+
+// Функция для подписки на обновления ордеров и сделки менеджера
+func (j *Journal) AttachToManager(manager *OrderManager) {
+	manager.SubscribeUpdates(j.onOrderUpdate)
+	manager.SubscribeFills(j.onFill)
+}
+
+// Функция для подписки на ордера, подтвержденные защитой от повторной отправки. Ордер, ответ на
+// отправку которого потерян, находится на площадке по клиентскому ID уже после записи unknown:
+// подтверждение записывается, чтобы после перезапуска ордер связался со своим намерением
+func (j *Journal) AttachToIdempotent(broker *IdempotentBroker) {
+	broker.SubscribeConfirms(j.onConfirm)
+}
+
+// Функция для восстановления состояния по журналу: ордера принимаются под управление
+// менеджера в последнем записанном состоянии, сделки применяются к учету позиций
+// (keeper может быть nil). Вызывается до AttachToManager
+func (j *Journal) Replay(manager *OrderManager, keeper *PositionKeeper) error {
+	entries, err := j.Query(JournalFilter{})
+	if err != nil {
+		return err
+	}
+
+	requests := make(map[string]OrderRequest) // Параметры ордера по клиентскому ID
+	orders := make(map[int]*ManagedOrder)
+	var ids []int
+	fills := 0
+
+	order := func(entry JournalEntry) *ManagedOrder {
+		managed, ok := orders[entry.OrderID]
+		if !ok {
+			managed = &ManagedOrder{
+				ID:      entry.OrderID,
+				Request: OrderRequest{Symbol: entry.Symbol, Side: entry.Side, Quantity: entry.Quantity, Price: entry.Price, Strategy: entry.Strategy},
+				State:   StatePendingNew,
+				Created: entry.Time,
+			}
+			if request, ok := requests[entry.ClientOrderID]; ok && entry.ClientOrderID != "" {
+				managed.Request = request
+			}
+			orders[entry.OrderID] = managed
+			ids = append(ids, entry.OrderID)
+		}
+		managed.Updated = entry.Time
+		return managed
+	}
+
+	for _, entry := range entries {
+		switch entry.Type {
+		case JournalIntent:
+			if entry.Request != nil && entry.ClientOrderID != "" {
+				request := *entry.Request
+				request.Strategy = entry.Strategy
+				requests[entry.ClientOrderID] = request
+			}
+		case JournalAck:
+			if entry.OrderID != 0 {
+				order(entry)
+			}
+		case JournalUpdate:
+			if entry.OrderID == 0 {
+				continue
+			}
+			managed := order(entry)
+			managed.State = entry.State
+			managed.FilledQuantity = entry.FilledQuantity
+			managed.AveragePrice = entry.AveragePrice
+			managed.Commission = entry.Commission
+			if entry.Message != "" {
+				managed.Message = entry.Message
+			}
+		case JournalFill:
+			fills++
+			if keeper != nil {
+				keeper.ApplyFill(Fill{
+					OrderID:    entry.OrderID,
+					Symbol:     entry.Symbol,
+					Side:       entry.Side,
+					Quantity:   entry.Quantity,
+					Price:      entry.Price,
+					Commission: entry.Commission,
+					Time:       entry.Time,
+				})
+			}
+		}
+	}
+
+	open := 0
+	for _, id := range ids {
+		managed := orders[id]
+		if managed.State.IsOpen() {
+			open++
+		}
+		manager.adopt(*managed)
+	}
+
+	logger.Logger.Info().
+		Str("path", j.path).
+		Int("entries", len(entries)).
+		Int("orders", len(ids)).
+		Int("open_orders", open).
+		Int("fills", fills).
+		Msg("Order journal replayed")
+	return nil
+}
+
+// Функция возвращает записи журнала, подходящие под фильтр, в порядке записи. Стратегия и
+// клиентский ID подставляются по ID ордера
+func (j *Journal) Query(filter JournalFilter) ([]JournalEntry, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entries, err := j.read()
+	if err != nil {
+		return nil, err
+	}
+	result := entries[:0]
+	for _, entry := range entries {
+		// Обновления и сделки площадки с событиями могут быть записаны раньше подтверждения ордера
+		if entry.OrderID != 0 {
+			if entry.Strategy == "" {
+				entry.Strategy = j.strategies[entry.OrderID]
+			}
+			if entry.ClientOrderID == "" {
+				entry.ClientOrderID = j.clients[entry.OrderID]
+			}
+		}
+		if filter.match(entry) {
+			result = append(result, entry)
+		}
+	}
+	return result, nil
+}
+
+// Функция для выгрузки записей журнала в CSV
+func (j *Journal) ExportCSV(w io.Writer, filter JournalFilter) error {
+	entries, err := j.Query(filter)
+	if err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(w)
+	header := []string{"seq", "time", "type", "account", "order_id", "client_order_id", "symbol", "side", "quantity", "price",
+		"strategy", "state", "filled_quantity", "average_price", "commission", "message"}
+	if err := writer.Write(header); err != nil {
+		return fmt.Errorf("error writing csv: %w", err)
+	}
+	for _, entry := range entries {
+		record := []string{
+			strconv.FormatInt(entry.Seq, 10),
+			entry.Time.Format(time.RFC3339Nano),
+			string(entry.Type),
+			entry.Account,
+			strconv.Itoa(entry.OrderID),
+			entry.ClientOrderID,
+			entry.Symbol,
+			entry.Side,
+			strconv.Itoa(entry.Quantity),
+			strconv.FormatFloat(entry.Price, 'f', -1, 64),
+			entry.Strategy,
+			string(entry.State),
+			strconv.Itoa(entry.FilledQuantity),
+			strconv.FormatFloat(entry.AveragePrice, 'f', -1, 64),
+			strconv.FormatFloat(entry.Commission, 'f', -1, 64),
+			entry.Message,
+		}
+		if err := writer.Write(record); err != nil {
+			return fmt.Errorf("error writing csv: %w", err)
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("error writing csv: %w", err)
+	}
+	return nil
+}
+
+// Функция для записи обновления ордера (повторы того же состояния не пишутся)
+func (j *Journal) onOrderUpdate(order ManagedOrder) {
+	key := orderKey{state: order.State, filled: order.FilledQuantity}
+	j.mu.Lock()
+	last, ok := j.last[order.ID]
+	j.mu.Unlock()
+	if ok && last == key {
+		return
+	}
+
+	err := j.Append(JournalEntry{
+		Type:           JournalUpdate,
+		Time:           order.Updated,
+		OrderID:        order.ID,
+		ClientOrderID:  order.Request.ClientOrderID,
+		Symbol:         order.Request.Symbol,
+		Side:           order.Request.Side,
+		Quantity:       order.Request.Quantity,
+		Price:          order.Request.Price,
+		Strategy:       order.Request.Strategy,
+		State:          order.State,
+		FilledQuantity: order.FilledQuantity,
+		AveragePrice:   order.AveragePrice,
+		Commission:     order.Commission,
+		Message:        order.Message,
+	})
+	if err != nil {
+		logger.Logger.Error().Err(err).Int("order_id", order.ID).Msg("Failed to journal order update")
+	}
+}
+
+// Функция для записи подтверждения ордера, найденного по клиентскому ID (ответ площадки
+// на отправку уже записан как ack, если он был получен)
+func (j *Journal) onConfirm(order InFlightOrder) {
+	j.mu.Lock()
+	acked := j.acked[order.OrderID]
+	j.mu.Unlock()
+	if acked {
+		return
+	}
+
+	err := j.Append(JournalEntry{
+		Type:          JournalAck,
+		OrderID:       order.OrderID,
+		ClientOrderID: order.ClientOrderID,
+		Symbol:        order.Request.Symbol,
+		Side:          order.Request.Side,
+		Quantity:      order.Request.Quantity,
+		Price:         order.Request.Price,
+		Strategy:      order.Request.Strategy,
+		Message:       "confirmed by client order id",
+	})
+	if err != nil {
+		logger.Logger.Error().Err(err).Int("order_id", order.OrderID).Msg("Failed to journal order confirmation")
+	}
+}
+
+// Функция для записи сделки
+func (j *Journal) onFill(fill Fill) {
+	err := j.Append(JournalEntry{
+		Type:       JournalFill,
+		Time:       fill.Time,
+		OrderID:    fill.OrderID,
+		Symbol:     fill.Symbol,
+		Side:       fill.Side,
+		Quantity:   fill.Quantity,
+		Price:      fill.Price,
+		Commission: fill.Commission,
+	})
+	if err != nil {
+		logger.Logger.Error().Err(err).Int("order_id", fill.OrderID).Msg("Failed to journal fill")
+	}
+}
+
+// Функция для учета записи в индексах журнала (вызывается под j.mu или при открытии)
+func (j *Journal) index(entry JournalEntry) {
+	if entry.Seq > j.seq {
+		j.seq = entry.Seq
+	}
+	if entry.OrderID == 0 {
+		return
+	}
+	if entry.Strategy != "" {
+		j.strategies[entry.OrderID] = entry.Strategy
+	}
+	if entry.ClientOrderID != "" {
+		j.clients[entry.OrderID] = entry.ClientOrderID
+	}
+	if entry.Symbol != "" {
+		j.symbols[entry.OrderID] = entry.Symbol
+	}
+	if entry.Type == JournalAck {
+		j.acked[entry.OrderID] = true
+	}
+	if entry.Type == JournalUpdate {
+		j.last[entry.OrderID] = orderKey{state: entry.State, filled: entry.FilledQuantity}
+	}
+}
+
+// Функция для чтения всех записей журнала. Оборванная последняя строка (сбой во время
+// записи) пропускается
+func (j *Journal) read() ([]JournalEntry, error) {
+	file, err := os.Open(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening journal: %w", err)
+	}
+	defer file.Close()
+
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			logger.Logger.Warn().Err(err).Str("path", j.path).Int("line", line).Msg("Skipping damaged journal entry")
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading journal: %w", err)
+	}
+	sort.SliceStable(entries, func(a, b int) bool { return entries[a].Seq < entries[b].Seq })
+	return entries, nil
+}
+
+// Декоратор Broker, который записывает в журнал намерение до отправки ордера
+// и результат отправки до возврата ответа
+type JournaledBroker struct {
+	Broker
+	journal *Journal
+}
+
+// Функция для создания декоратора
+func NewJournaledBroker(broker Broker, journal *Journal) *JournaledBroker {
+	return &JournaledBroker{Broker: broker, journal: journal}
+}
+
+// Функция возвращает площадку под декоратором
+func (b *JournaledBroker) Unwrap() Broker {
+	return b.Broker
+}
+
+func (b *JournaledBroker) PlaceOrder(req *OrderRequest) (*OrderResponse, error) {
+	request := *req
+	request.AccessToken = ""
+	entry := JournalEntry{
+		Type:          JournalIntent,
+		ClientOrderID: req.ClientOrderID,
+		Symbol:        req.Symbol,
+		Side:          req.Side,
+		Quantity:      req.Quantity,
+		Price:         req.Price,
+		Strategy:      req.Strategy,
+		Request:       &request,
+	}
+	// Ордер без записанного намерения не отправляется
+	if err := b.journal.Append(entry); err != nil {
+		return nil, fmt.Errorf("order not sent: %w", err)
+	}
+
+	response, err := b.Broker.PlaceOrder(req)
+	entry.Request = nil
+	entry.Time = time.Time{}
+	if err != nil {
+		entry.Type = failureEntryType(err)
+		entry.Message = err.Error()
+		if journalErr := b.journal.Append(entry); journalErr != nil {
+			logger.Logger.Error().Err(journalErr).Str("client_order_id", req.ClientOrderID).Msg("Failed to journal order failure")
+		}
+		return nil, err
+	}
+
+	entry.Type = JournalAck
+	entry.OrderID = response.OrderID
+	entry.Message = response.Message
+	if err := b.journal.Append(entry); err != nil {
+		// Ордер уже на площадке: ответ возвращается, журнал восстановит его по обновлениям
+		logger.Logger.Error().Err(err).Int("order_id", response.OrderID).Msg("Failed to journal order ack")
+	}
+	return response, nil
+}
+
+func (b *JournaledBroker) CancelOrder(orderID int) error {
+	if err := b.journal.Append(JournalEntry{Type: JournalCancelRequest, OrderID: orderID}); err != nil {
+		return fmt.Errorf("cancel not sent: %w", err)
+	}
+
+	if err := b.Broker.CancelOrder(orderID); err != nil {
+		if journalErr := b.journal.Append(JournalEntry{Type: failureEntryType(err), OrderID: orderID, Message: "cancel: " + err.Error()}); journalErr != nil {
+			logger.Logger.Error().Err(journalErr).Int("order_id", orderID).Msg("Failed to journal cancel failure")
+		}
+		return err
+	}
+
+	if err := b.journal.Append(JournalEntry{Type: JournalCancel, OrderID: orderID}); err != nil {
+		logger.Logger.Error().Err(err).Int("order_id", orderID).Msg("Failed to journal cancel")
+	}
+	return nil
+}
+
+func (b *JournaledBroker) ModifyOrder(req *ModifyOrderRequest) (*OrderResponse, error) {
+	entry := JournalEntry{Type: JournalModify, OrderID: req.OrderID, Quantity: req.Quantity, Price: req.Price}
+	if err := b.journal.Append(entry); err != nil {
+		return nil, fmt.Errorf("modify not sent: %w", err)
+	}
+
+	response, err := b.Broker.ModifyOrder(req)
+	entry.Time = time.Time{}
+	if err != nil {
+		entry.Type = failureEntryType(err)
+		entry.Message = "modify: " + err.Error()
+		if journalErr := b.journal.Append(entry); journalErr != nil {
+			logger.Logger.Error().Err(journalErr).Int("order_id", req.OrderID).Msg("Failed to journal modify failure")
+		}
+		return nil, err
+	}
+
+	// Замена новым ордером наследует стратегию исходного
+	b.journal.mu.Lock()
+	strategy := b.journal.strategies[req.OrderID]
+	b.journal.mu.Unlock()
+
+	entry.Type = JournalAck
+	if response.OrderID != 0 {
+		entry.OrderID = response.OrderID
+	}
+	entry.Strategy = strategy
+	entry.Message = fmt.Sprintf("modify of %d", req.OrderID)
+	if err := b.journal.Append(entry); err != nil {
+		logger.Logger.Error().Err(err).Int("order_id", entry.OrderID).Msg("Failed to journal modify ack")
+	}
+	return response, nil
+}
+
+// Функция возвращает тип записи для ошибки площадки: отказ - только подтвержденное отклонение,
+// иначе (таймаут, обрыв связи) результат неизвестен и ордер может работать на площадке
+func failureEntryType(err error) JournalEntryType {
+	if errors.Is(err, ErrOrderRejected) || errors.Is(err, ErrModifyRejected) {
+		return JournalReject
+	}
+	return JournalUnknown
+}
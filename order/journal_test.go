@@ -0,0 +1,241 @@
+package order
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Функция открывает журнал во временном каталоге теста
+func openTestJournal(t *testing.T) *Journal {
+	t.Helper()
+	journal, err := OpenJournal(filepath.Join(t.TempDir(), "journal.jsonl"), "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { journal.Close() })
+	return journal
+}
+
+// Функция возвращает типы записей журнала по порядку
+func journalTypes(t *testing.T, journal *Journal) []JournalEntryType {
+	t.Helper()
+	entries, err := journal.Query(JournalFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := make([]JournalEntryType, len(entries))
+	for i, entry := range entries {
+		types[i] = entry.Type
+	}
+	return types
+}
+
+func TestJournalReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	journal, err := OpenJournal(path, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, time.July, 15, 10, 0, 0, 0, time.UTC)
+	request := OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 10, OrderType: "limit", Price: 250, ClientOrderID: "c1", Strategy: "trend"}
+	entries := []JournalEntry{
+		{Type: JournalIntent, ClientOrderID: "c1", Symbol: "SBER", Side: "buy", Quantity: 10, Price: 250, Strategy: "trend", Request: &request},
+		{Type: JournalAck, OrderID: 1, ClientOrderID: "c1", Symbol: "SBER", Side: "buy", Quantity: 10, Price: 250, Strategy: "trend"},
+		{Type: JournalUpdate, OrderID: 1, State: StateWorking},
+		{Type: JournalUpdate, OrderID: 1, State: StatePartiallyFilled, FilledQuantity: 4, AveragePrice: 249.5, Commission: 1},
+		{Type: JournalFill, OrderID: 1, Symbol: "SBER", Side: "buy", Quantity: 4, Price: 249.5, Commission: 1},
+		// Второй ордер исполнен полностью до перезапуска
+		{Type: JournalAck, OrderID: 2, Symbol: "GAZP", Side: "sell", Quantity: 3, Price: 150, Strategy: "mean"},
+		{Type: JournalUpdate, OrderID: 2, State: StateFilled, FilledQuantity: 3, AveragePrice: 150},
+		{Type: JournalFill, OrderID: 2, Symbol: "GAZP", Side: "sell", Quantity: 3, Price: 150},
+	}
+	for i, entry := range entries {
+		entry.Time = start.Add(time.Duration(i) * time.Second)
+		if err := journal.Append(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	journal.Close()
+
+	// Перезапуск: журнал открывается заново и продолжает нумерацию
+	journal, err = OpenJournal(path, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	manager := NewOrderManager(&stubBroker{})
+	keeper := NewPositionKeeper(nil)
+	if err := journal.Replay(manager, keeper); err != nil {
+		t.Fatal(err)
+	}
+
+	open := manager.OpenOrders("")
+	if len(open) != 1 {
+		t.Fatalf("open orders = %+v, want order 1 only", open)
+	}
+	first := open[0]
+	if first.ID != 1 || first.State != StatePartiallyFilled || first.FilledQuantity != 4 || first.AveragePrice != 249.5 {
+		t.Errorf("order 1 = %+v", first)
+	}
+	// Параметры ордера восстанавливаются из намерения по клиентскому ID
+	if first.Request.Strategy != "trend" || first.Request.ClientOrderID != "c1" || first.Request.OrderType != "limit" {
+		t.Errorf("order 1 request = %+v, want request from intent", first.Request)
+	}
+
+	second, ok := manager.GetOrder(2)
+	if !ok || second.State != StateFilled || second.Request.Strategy != "mean" {
+		t.Errorf("order 2 = %+v, %v", second, ok)
+	}
+
+	if position, _ := keeper.Position("SBER"); position.Quantity != 4 {
+		t.Errorf("SBER position = %d, want 4", position.Quantity)
+	}
+	if position, _ := keeper.Position("GAZP"); position.Quantity != -3 {
+		t.Errorf("GAZP position = %d, want -3", position.Quantity)
+	}
+
+	if err := journal.Append(JournalEntry{Type: JournalCancelRequest, OrderID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	last, _ := journal.Query(JournalFilter{Types: []JournalEntryType{JournalCancelRequest}})
+	if len(last) != 1 || last[0].Seq != int64(len(entries)+1) || last[0].Strategy != "trend" {
+		t.Errorf("entry after reopen = %+v, want seq %d with strategy of order", last, len(entries)+1)
+	}
+}
+
+func TestJournaledBrokerPlaceOrderFailures(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want JournalEntryType
+	}{
+		{err: fmt.Errorf("insufficient funds: %w", ErrOrderRejected), want: JournalReject},
+		{err: errors.New("timeout waiting for command result"), want: JournalUnknown},
+		{err: errors.New("connection reset by peer"), want: JournalUnknown},
+	} {
+		journal := openTestJournal(t)
+		broker := NewJournaledBroker(&stubBroker{placeErr: tt.err}, journal)
+
+		if _, err := broker.PlaceOrder(&OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 1, ClientOrderID: "c1"}); !errors.Is(err, tt.err) {
+			t.Fatalf("%v: PlaceOrder error = %v", tt.err, err)
+		}
+		types := journalTypes(t, journal)
+		if len(types) != 2 || types[0] != JournalIntent || types[1] != tt.want {
+			t.Errorf("%v: journal = %v, want [intent %s]", tt.err, types, tt.want)
+		}
+	}
+}
+
+func TestJournalAcksOrderConfirmedByClientID(t *testing.T) {
+	journal := openTestJournal(t)
+	venue := newLossyBroker(1, 0)
+	config := DefaultIdempotentConfig(filepath.Join(t.TempDir(), "orders.json"))
+	config.RetryDelay = time.Millisecond
+	idempotent, err := NewIdempotentBroker(NewJournaledBroker(venue, journal), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal.AttachToIdempotent(idempotent)
+
+	response, err := idempotent.PlaceOrder(&OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 5, OrderType: "market", Strategy: "trend"})
+	if err != nil {
+		t.Fatalf("PlaceOrder error = %v", err)
+	}
+
+	want := []JournalEntryType{JournalIntent, JournalUnknown, JournalAck}
+	if got := journalTypes(t, journal); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("journal = %v, want %v", got, want)
+	}
+	acks, _ := journal.Query(JournalFilter{Types: []JournalEntryType{JournalAck}})
+	if ack := acks[0]; ack.OrderID != response.OrderID || ack.ClientOrderID == "" || ack.Strategy != "trend" {
+		t.Errorf("ack = %+v, want order %d with client ID and strategy", ack, response.OrderID)
+	}
+
+	// Ордер, подтвержденный ответом площадки, записывается одним ack
+	if _, err := idempotent.PlaceOrder(&OrderRequest{Symbol: "SBER", Side: "sell", Quantity: 5, OrderType: "market"}); err != nil {
+		t.Fatal(err)
+	}
+	acks, _ = journal.Query(JournalFilter{Types: []JournalEntryType{JournalAck}})
+	if len(acks) != 2 {
+		t.Errorf("acks = %d, want 2", len(acks))
+	}
+
+	// После перезапуска ордер связан со своим намерением
+	manager := NewOrderManager(&stubBroker{})
+	if err := journal.Replay(manager, nil); err != nil {
+		t.Fatal(err)
+	}
+	if order, ok := manager.GetOrder(response.OrderID); !ok || order.Request.Strategy != "trend" || order.Request.OrderType != "market" {
+		t.Errorf("replayed order = %+v, %v", order, ok)
+	}
+}
+
+func TestJournalQueryAndExportCSV(t *testing.T) {
+	journal := openTestJournal(t)
+	start := time.Date(2024, time.July, 15, 10, 0, 0, 0, time.UTC)
+	for i, entry := range []JournalEntry{
+		{Type: JournalAck, OrderID: 1, Symbol: "SBER", Side: "buy", Quantity: 10, Price: 250, Strategy: "trend", ClientOrderID: "c1"},
+		{Type: JournalFill, OrderID: 1, Symbol: "SBER", Side: "buy", Quantity: 10, Price: 250.5, Commission: 1.25},
+		{Type: JournalAck, OrderID: 2, Symbol: "GAZP", Side: "sell", Quantity: 3, Price: 150, Strategy: "mean"},
+		{Type: JournalFill, OrderID: 2, Symbol: "GAZP", Side: "sell", Quantity: 3, Price: 150, Message: "partial, \"first\""},
+		{Type: JournalAck, OrderID: 3, Symbol: "SBER", Account: "other", Strategy: "trend"},
+	} {
+		entry.Time = start.Add(time.Duration(i) * time.Minute)
+		if err := journal.Append(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count := func(filter JournalFilter) int {
+		entries, err := journal.Query(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(entries)
+	}
+	checks := map[string]struct {
+		filter JournalFilter
+		want   int
+	}{
+		"all":                       {JournalFilter{}, 5},
+		"symbol":                    {JournalFilter{Symbol: "SBER"}, 3},
+		"strategy from order index": {JournalFilter{Strategy: "trend", Types: []JournalEntryType{JournalFill}}, 1},
+		"account":                   {JournalFilter{Account: "main"}, 4},
+		"period is half-open":       {JournalFilter{From: start.Add(time.Minute), To: start.Add(3 * time.Minute)}, 2},
+		"several types":             {JournalFilter{Types: []JournalEntryType{JournalAck, JournalFill}, Symbol: "GAZP"}, 2},
+	}
+	for name, check := range checks {
+		if got := count(check.filter); got != check.want {
+			t.Errorf("%s: entries = %d, want %d", name, got, check.want)
+		}
+	}
+
+	var buffer bytes.Buffer
+	if err := journal.ExportCSV(&buffer, JournalFilter{Types: []JournalEntryType{JournalFill}}); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buffer).ReadAll()
+	if err != nil {
+		t.Fatalf("exported csv is invalid: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("csv rows = %d, want header and 2 fills", len(records))
+	}
+	column := make(map[string]int)
+	for i, name := range records[0] {
+		column[name] = i
+	}
+	first, second := records[1], records[2]
+	if first[column["strategy"]] != "trend" || first[column["client_order_id"]] != "c1" || first[column["price"]] != "250.5" || first[column["commission"]] != "1.25" {
+		t.Errorf("first fill row = %v", first)
+	}
+	if second[column["message"]] != `partial, "first"` || second[column["time"]] != start.Add(3*time.Minute).Format(time.RFC3339Nano) {
+		t.Errorf("second fill row = %v", second)
+	}
+}
@@ -0,0 +1,436 @@
+package order
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"trading-bot/logger"
+)
+
+// Ошибка для ордеров, отправленных при сработавшей аварийной остановке
+var ErrKillSwitchActive = errors.New("kill switch is active, trading halted")
+
+// Источники срабатывания аварийной остановки
+const (
+	KillSourceManual     = "manual"
+	KillSourceFile       = "file"
+	KillSourceSignal     = "signal"
+	KillSourceHTTP       = "http"
+	KillSourceRisk       = "risk"
+	KillSourceDisconnect = "disconnect"
+	KillSourceReconcile  = "reconcile"
+)
+
+// Структура для настройки аварийной остановки
+type KillSwitchConfig struct {
+	StatePath        string   `json:"state_path"`        // Файл состояния: остановка сохраняется после перезапуска
+	TriggerFile      string   `json:"trigger_file"`      // Появление файла включает остановку
+	AuditPath        string   `json:"audit_path"`        // Журнал срабатываний и сбросов (JSONL)
+	HTTPAddr         string   `json:"http_addr"`         // Адрес HTTP управления (пустой - без HTTP; без http_token - только loopback)
+	HTTPToken        string   `json:"http_token"`        // Токен для POST запросов (заголовок Authorization: Bearer <token>)
+	FlattenPositions bool     `json:"flatten_positions"` // Закрывать позиции рыночными ордерами
+	RiskChecks       []string `json:"risk_checks"`       // Проверки риска, отказ которых включает остановку (например, "daily_loss")
+}
+
+// Структура для записи журнала аварийной остановки
+type KillSwitchEvent struct {
+	Time            time.Time `json:"time"`
+	Action          string    `json:"action"` // "trigger" или "reset"
+	Source          string    `json:"source"`
+	Reason          string    `json:"reason"`
+	CancelledOrders []int     `json:"cancelled_orders,omitempty"`
+	FlattenOrders   []int     `json:"flatten_orders,omitempty"`
+	Errors          []string  `json:"errors,omitempty"`
+}
+
+// Структура для сохраняемого состояния аварийной остановки
+type killSwitchState struct {
+	Active  bool            `json:"active"`
+	Trigger KillSwitchEvent `json:"trigger"`
+}
+
+// Декоратор Broker для аварийной остановки. После срабатывания новые ордера блокируются,
+// рабочие ордера снимаются, позиции (по настройке) закрываются. Остановка остается
+// включенной до ручного сброса, в том числе после перезапуска.
+type KillSwitch struct {
+	Broker
+	config KillSwitchConfig
+
+	mu      sync.Mutex
+	state   killSwitchState
+	manager *OrderManager
+
+	stop chan bool
+}
+
+// Функция для создания аварийной остановки. Загружает состояние из config.StatePath
+func NewKillSwitch(broker Broker, config KillSwitchConfig) (*KillSwitch, error) {
+	k := &KillSwitch{
+		Broker: broker,
+		config: config,
+		stop:   make(chan bool),
+	}
+	if config.StatePath != "" {
+		if _, err := readJSON(config.StatePath, &k.state); err != nil {
+			return nil, err
+		}
+	}
+	if k.state.Active {
+		logger.Logger.Error().
+			Str("source", k.state.Trigger.Source).
+			Str("reason", k.state.Trigger.Reason).
+			Time("since", k.state.Trigger.Time).
+			Msg("Kill switch is latched, trading halted until reset")
+	}
+	return k, nil
+}
+
+// Функция возвращает площадку под декоратором
+func (k *KillSwitch) Unwrap() Broker {
+	return k.Broker
+}
+
+// Функция для подключения менеджера ордеров (ордера снимаются через него, чтобы он знал об отмене)
+func (k *KillSwitch) AttachOrderManager(manager *OrderManager) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.manager = manager
+}
+
+// Функция для включения остановки по отказам проверок риска из config.RiskChecks
+func (k *KillSwitch) WatchRiskEngine(engine *RiskEngine) {
+	engine.SubscribeRejections(func(rejection *RiskRejection) {
+		for _, check := range k.config.RiskChecks {
+			if check == rejection.Check {
+				k.Trigger(KillSourceRisk, rejection.Error())
+				return
+			}
+		}
+	})
+}
+
+func (k *KillSwitch) PlaceOrder(req *OrderRequest) (*OrderResponse, error) {
+	if active, reason := k.Status(); active && !req.Emergency {
+		logger.Logger.Warn().Str("symbol", req.Symbol).Str("side", req.Side).Msg("Order blocked by kill switch")
+		return nil, fmt.Errorf("%w: %s", ErrKillSwitchActive, reason)
+	}
+	return k.Broker.PlaceOrder(req)
+}
+
+func (k *KillSwitch) ModifyOrder(req *ModifyOrderRequest) (*OrderResponse, error) {
+	if active, reason := k.Status(); active {
+		return nil, fmt.Errorf("%w: %s", ErrKillSwitchActive, reason)
+	}
+	return k.Broker.ModifyOrder(req)
+}
+
+// Функция возвращает, включена ли остановка, и ее причину
+func (k *KillSwitch) Status() (bool, string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.state.Active, k.state.Trigger.Reason
+}
+
+// Функция проверяет, включена ли остановка
+func (k *KillSwitch) IsActive() bool {
+	active, _ := k.Status()
+	return active
+}
+
+// Функция для включения остановки. Повторное срабатывание при включенной остановке игнорируется
+func (k *KillSwitch) Trigger(source string, reason string) {
+	k.mu.Lock()
+	if k.state.Active {
+		k.mu.Unlock()
+		return
+	}
+	event := KillSwitchEvent{Time: time.Now(), Action: "trigger", Source: source, Reason: reason}
+	k.state = killSwitchState{Active: true, Trigger: event}
+	k.saveState()
+	manager := k.manager
+	k.mu.Unlock()
+
+	logger.Logger.Error().Str("source", source).Str("reason", reason).Msg("KILL SWITCH TRIGGERED, trading halted")
+
+	event.CancelledOrders, event.Errors = k.cancelWorkingOrders(manager)
+	if k.config.FlattenPositions {
+		var errs []string
+		event.FlattenOrders, errs = k.flattenPositions(manager)
+		event.Errors = append(event.Errors, errs...)
+	}
+
+	k.audit(event)
+	logger.Logger.Error().
+		Ints("cancelled_orders", event.CancelledOrders).
+		Ints("flatten_orders", event.FlattenOrders).
+		Strs("errors", event.Errors).
+		Msg("Kill switch actions completed")
+}
+
+// Функция для ручного сброса остановки. Пока существует файл-триггер, сброс невозможен
+func (k *KillSwitch) Reset(operator string) error {
+	if k.config.TriggerFile != "" {
+		if _, err := os.Stat(k.config.TriggerFile); err == nil {
+			return fmt.Errorf("remove trigger file %s before reset", k.config.TriggerFile)
+		}
+	}
+
+	k.mu.Lock()
+	if !k.state.Active {
+		k.mu.Unlock()
+		return nil
+	}
+	k.state = killSwitchState{}
+	k.saveState()
+	k.mu.Unlock()
+
+	logger.Logger.Warn().Str("operator", operator).Msg("Kill switch reset, trading resumed")
+	k.audit(KillSwitchEvent{Time: time.Now(), Action: "reset", Source: KillSourceManual, Reason: "reset by " + operator})
+	return nil
+}
+
+// Функция для запуска наблюдения за файлом-триггером, сигналом и HTTP управлением
+func (k *KillSwitch) Start() {
+	if k.config.TriggerFile != "" {
+		go k.watchFile(time.Second)
+	}
+	k.watchSignals()
+
+	if k.config.HTTPAddr != "" {
+		if k.config.HTTPToken == "" && !isLoopbackAddr(k.config.HTTPAddr) {
+			logger.Logger.Error().Str("addr", k.config.HTTPAddr).Msg("Kill switch HTTP control without http_token is allowed on loopback only, not started")
+			return
+		}
+		server := &http.Server{Addr: k.config.HTTPAddr, Handler: k.Handler()}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Logger.Error().Err(err).Str("addr", k.config.HTTPAddr).Msg("Kill switch HTTP server failed")
+			}
+		}()
+		go func() {
+			<-k.stop
+			server.Close()
+		}()
+		logger.Logger.Info().Str("addr", k.config.HTTPAddr).Msg("Kill switch HTTP control started")
+	}
+}
+
+// Функция для остановки наблюдения
+func (k *KillSwitch) Stop() {
+	close(k.stop)
+}
+
+// Функция возвращает HTTP обработчик управления:
+// GET /killswitch - состояние, POST /killswitch/trigger?reason=... - включение, POST /killswitch/reset?operator=... - сброс.
+// Если задан HTTPToken, POST запросы без него отклоняются
+func (k *KillSwitch) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/killswitch", func(w http.ResponseWriter, r *http.Request) {
+		k.mu.Lock()
+		state := k.state
+		k.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(state)
+	})
+	mux.HandleFunc("/killswitch/trigger", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !k.authorized(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		reason := r.URL.Query().Get("reason")
+		if reason == "" {
+			reason = "triggered via HTTP from " + r.RemoteAddr
+		}
+		k.Trigger(KillSourceHTTP, reason)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/killswitch/reset", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !k.authorized(r) {
+			logger.Logger.Warn().Str("remote", r.RemoteAddr).Msg("Unauthorized kill switch reset attempt")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		operator := r.URL.Query().Get("operator")
+		if operator == "" {
+			operator = r.RemoteAddr
+		}
+		if err := k.Reset(operator); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+// Функция проверяет токен HTTP запроса (без настроенного токена разрешено все: сервер слушает
+// только loopback)
+func (k *KillSwitch) authorized(r *http.Request) bool {
+	if k.config.HTTPToken == "" {
+		return true
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(k.config.HTTPToken)) == 1
+}
+
+// Функция проверяет, что адрес слушает только loopback интерфейс
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Функция для периодической проверки файла-триггера
+func (k *KillSwitch) watchFile(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-k.stop:
+			return
+		case <-ticker.C:
+			if _, err := os.Stat(k.config.TriggerFile); err == nil {
+				k.Trigger(KillSourceFile, "trigger file "+k.config.TriggerFile+" exists")
+			}
+		}
+	}
+}
+
+// Функция для снятия всех рабочих ордеров: известных менеджеру и найденных в истории площадки
+func (k *KillSwitch) cancelWorkingOrders(manager *OrderManager) ([]int, []string) {
+	ids := make(map[int]bool)
+	if manager != nil {
+		for _, order := range manager.OpenOrders("") {
+			ids[order.ID] = true
+		}
+	}
+	history, err := k.Broker.GetOrdersHistory(&OrdersHistoryRequest{From: time.Now().AddDate(0, 0, -1), To: time.Now()})
+	var errs []string
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("orders history: %v", err))
+	} else {
+		for _, order := range history.Orders {
+			if state, ok := ParseOrderState(order.Status); ok && state.IsOpen() {
+				ids[order.OrderID] = true
+			}
+		}
+	}
+
+	var cancelled []int
+	for id := range ids {
+		var err error
+		if order, managed := managedOrder(manager, id); managed {
+			if order.State == StateCancelPending {
+				continue
+			}
+			err = manager.CancelOrder(id)
+		} else {
+			err = k.Broker.CancelOrder(id)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("cancel order %d: %v", id, err))
+			continue
+		}
+		cancelled = append(cancelled, id)
+	}
+	sort.Ints(cancelled)
+	return cancelled, errs
+}
+
+// Функция для закрытия всех позиций рыночными ордерами. Ордера аварийные: проходят блокировку,
+// проверки риска и лимиты частоты, а выставляются через менеджер, чтобы он отслеживал их состояние
+func (k *KillSwitch) flattenPositions(manager *OrderManager) ([]int, []string) {
+	positions, err := k.Broker.GetPositions()
+	if err != nil {
+		return nil, []string{fmt.Sprintf("positions: %v", err)}
+	}
+
+	var orders []int
+	var errs []string
+	for _, position := range positions {
+		if position.Quantity == 0 {
+			continue
+		}
+		req := &OrderRequest{
+			Symbol:    position.Symbol,
+			Side:      "sell",
+			Quantity:  position.Quantity,
+			OrderType: "market",
+			Comment:   "kill switch flatten",
+			Emergency: true,
+		}
+		if position.Quantity < 0 {
+			req.Side = "buy"
+			req.Quantity = -position.Quantity
+		}
+		var orderID int
+		if manager != nil {
+			order, err := manager.PlaceOrder(req)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("flatten %s: %v", position.Symbol, err))
+				continue
+			}
+			orderID = order.ID
+		} else {
+			response, err := k.PlaceOrder(req)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("flatten %s: %v", position.Symbol, err))
+				continue
+			}
+			orderID = response.OrderID
+		}
+		orders = append(orders, orderID)
+	}
+	return orders, errs
+}
+
+// Функция для сохранения состояния (вызывается под k.mu)
+func (k *KillSwitch) saveState() {
+	if k.config.StatePath == "" {
+		return
+	}
+	if err := writeJSONAtomic(k.config.StatePath, k.state); err != nil {
+		logger.Logger.Error().Err(err).Str("path", k.config.StatePath).Msg("Failed to save kill switch state")
+	}
+}
+
+// Функция для записи события в журнал
+func (k *KillSwitch) audit(event KillSwitchEvent) {
+	if k.config.AuditPath == "" {
+		return
+	}
+	if err := appendJSONLine(k.config.AuditPath, event); err != nil {
+		logger.Logger.Error().Err(err).Str("path", k.config.AuditPath).Msg("Failed to write kill switch audit")
+	}
+}
+
+// Функция проверяет, знает ли менеджер об ордере
+func managedOrder(manager *OrderManager, orderID int) (ManagedOrder, bool) {
+	if manager == nil {
+		return ManagedOrder{}, false
+	}
+	return manager.GetOrder(orderID)
+}
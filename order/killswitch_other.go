@@ -0,0 +1,6 @@
+//go:build !unix
+
+package order
+
+// На платформах без SIGUSR1 остановка по сигналу недоступна (остаются файл и HTTP)
+func (k *KillSwitch) watchSignals() {}
@@ -0,0 +1,150 @@
+package order
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// Площадка с историей ордеров, в которой есть ордера, выставленные в обход менеджера
+type historyBroker struct {
+	*stubBroker
+	history []OrderHistory
+}
+
+func (b *historyBroker) GetOrdersHistory(req *OrdersHistoryRequest) (*OrdersHistoryResponse, error) {
+	return &OrdersHistoryResponse{Orders: b.history}, nil
+}
+
+func TestKillSwitchLatchesUntilReset(t *testing.T) {
+	dir := t.TempDir()
+	config := KillSwitchConfig{
+		StatePath:   filepath.Join(dir, "kill_switch.json"),
+		TriggerFile: filepath.Join(dir, "KILL"),
+		HTTPToken:   "secret",
+	}
+	stub := &stubBroker{}
+	killSwitch, err := NewKillSwitch(stub, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	killSwitch.Trigger(KillSourceManual, "operator request")
+	killSwitch.Trigger(KillSourceRisk, "daily loss")
+	if active, reason := killSwitch.Status(); !active || reason != "operator request" {
+		t.Fatalf("status = %v %q, want first trigger latched", active, reason)
+	}
+	if _, err := killSwitch.PlaceOrder(&OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 1}); !errors.Is(err, ErrKillSwitchActive) {
+		t.Errorf("PlaceOrder error = %v, want ErrKillSwitchActive", err)
+	}
+	if _, err := killSwitch.ModifyOrder(&ModifyOrderRequest{OrderID: 1, Quantity: 2}); !errors.Is(err, ErrKillSwitchActive) {
+		t.Errorf("ModifyOrder error = %v, want ErrKillSwitchActive", err)
+	}
+	if _, err := killSwitch.PlaceOrder(&OrderRequest{Symbol: "SBER", Side: "sell", Quantity: 1, Emergency: true}); err != nil {
+		t.Errorf("emergency order error = %v, want it to pass", err)
+	}
+
+	// Остановка переживает перезапуск
+	killSwitch, err = NewKillSwitch(stub, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !killSwitch.IsActive() {
+		t.Fatal("kill switch is not active after restart")
+	}
+
+	// Сброс по HTTP без токена отклоняется, при файле-триггере сброс невозможен
+	handler := killSwitch.Handler()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/killswitch/reset?operator=ivan", nil))
+	if recorder.Code != http.StatusUnauthorized || !killSwitch.IsActive() {
+		t.Errorf("reset without token: code %d, active %v", recorder.Code, killSwitch.IsActive())
+	}
+	if err := os.WriteFile(config.TriggerFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	request := httptest.NewRequest(http.MethodPost, "/killswitch/reset?operator=ivan", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusConflict || !killSwitch.IsActive() {
+		t.Errorf("reset with trigger file: code %d, active %v", recorder.Code, killSwitch.IsActive())
+	}
+
+	os.Remove(config.TriggerFile)
+	if err := killSwitch.Reset("ivan"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := killSwitch.PlaceOrder(&OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 1}); err != nil {
+		t.Errorf("PlaceOrder after reset error = %v", err)
+	}
+	if restarted, _ := NewKillSwitch(stub, config); restarted.IsActive() {
+		t.Error("reset is not persisted")
+	}
+}
+
+func TestKillSwitchCancelsAllWorkingOrders(t *testing.T) {
+	broker := &historyBroker{
+		stubBroker: &stubBroker{positions: []Position{
+			{Symbol: "SBER", Quantity: 5},
+			{Symbol: "GAZP", Quantity: -2},
+			{Symbol: "LKOH", Quantity: 0},
+		}},
+		history: []OrderHistory{
+			{OrderID: 99, Symbol: "VTBR", Side: "buy", Quantity: 100, Status: "working"}, // Выставлен в другом терминале
+			{OrderID: 98, Symbol: "VTBR", Side: "buy", Quantity: 100, Status: "filled"},
+		},
+	}
+	auditPath := filepath.Join(t.TempDir(), "kill_switch_audit.jsonl")
+	killSwitch, err := NewKillSwitch(broker, KillSwitchConfig{AuditPath: auditPath, FlattenPositions: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := NewOrderManager(killSwitch)
+	killSwitch.AttachOrderManager(manager)
+	for _, symbol := range []string{"SBER", "GAZP"} {
+		if _, err := manager.PlaceOrder(&OrderRequest{Symbol: symbol, Side: "buy", Quantity: 1, OrderType: "limit", Price: 100}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	killSwitch.Trigger(KillSourceDisconnect, "connection lost")
+
+	cancelled := append([]int(nil), broker.cancelled...)
+	sort.Ints(cancelled)
+	if fmt.Sprint(cancelled) != "[1 2 99]" {
+		t.Errorf("cancelled = %v, want managed orders 1, 2 and external working order 99", cancelled)
+	}
+
+	// Позиции закрываются аварийными рыночными ордерами в обратную сторону
+	flatten := broker.placed[2:]
+	if len(flatten) != 2 || flatten[0].Side != "sell" || flatten[0].Quantity != 5 || flatten[1].Side != "buy" || flatten[1].Quantity != 2 {
+		t.Fatalf("flatten orders = %+v, want sell 5 SBER and buy 2 GAZP", flatten)
+	}
+	for _, req := range flatten {
+		if !req.Emergency || req.OrderType != "market" {
+			t.Errorf("flatten order = %+v, want emergency market order", req)
+		}
+	}
+
+	file, err := os.Open(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var event KillSwitchEvent
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() || json.Unmarshal(scanner.Bytes(), &event) != nil {
+		t.Fatal("no trigger event in audit")
+	}
+	if event.Source != KillSourceDisconnect || len(event.CancelledOrders) != 3 || len(event.FlattenOrders) != 2 || len(event.Errors) != 0 {
+		t.Errorf("audit event = %+v", event)
+	}
+}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package order
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Функция для включения остановки по сигналу SIGUSR1 (kill -USR1 <pid>)
+func (k *KillSwitch) watchSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-k.stop:
+				return
+			case <-signals:
+				k.Trigger(KillSourceSignal, "SIGUSR1 received")
+			}
+		}
+	}()
+}
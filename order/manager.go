@@ -0,0 +1,528 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"trading-bot/logger"
+)
+
+// Структура для сделки (исполнения части ордера). Commission - все списанные комиссии
+// (брокера и биржи), ExchangeFee - биржевой сбор в их составе, Slippage - потери на
+// проскальзывании (уже учтены в цене)
+type Fill struct {
+	OrderID     int       `json:"order_id"`
+	Symbol      string    `json:"symbol"`
+	Side        string    `json:"side"`
+	Quantity    int       `json:"quantity"` // Количество лотов
+	Price       float64   `json:"price"`
+	Commission  float64   `json:"commission"`
+	ExchangeFee float64   `json:"exchange_fee"`
+	Slippage    float64   `json:"slippage"`
+	Time        time.Time `json:"time"`
+}
+
+// Структура для обновления ордера от площадки (событие коннектора или результат опроса).
+// FilledQuantity, AveragePrice и Commission - накопленные значения по ордеру.
+type OrderUpdate struct {
+	OrderID        int       `json:"order_id"`
+	Symbol         string    `json:"symbol"`
+	Side           string    `json:"side"`
+	Quantity       int       `json:"quantity"`
+	Status         string    `json:"status"`
+	FilledQuantity int       `json:"filled_quantity"`
+	AveragePrice   float64   `json:"average_price"`
+	Commission     float64   `json:"commission"`
+	Message        string    `json:"message"`
+	Time           time.Time `json:"time"`
+}
+
+// Интерфейс площадки, которая сама присылает обновления ордеров (Transaq, PaperBroker).
+// Для остальных площадок OrderManager опрашивает GetOrderStatus.
+type OrderEventSource interface {
+	SubscribeOrderUpdates(handler func(update OrderUpdate))
+}
+
+// Структура для ордера под управлением OrderManager
+type ManagedOrder struct {
+	ID             int          `json:"id"`
+	Request        OrderRequest `json:"request"`
+	State          OrderState   `json:"state"`
+	FilledQuantity int          `json:"filled_quantity"`
+	AveragePrice   float64      `json:"average_price"`
+	Commission     float64      `json:"commission"`
+	Message        string       `json:"message"`
+	Created        time.Time    `json:"created"`
+	Updated        time.Time    `json:"updated"`
+}
+
+// Функция возвращает неисполненный остаток ордера
+func (o *ManagedOrder) RemainingQuantity() int {
+	return o.Request.Quantity - o.FilledQuantity
+}
+
+// Менеджер собственных ордеров: хранит состояние каждого ордера, отклоняет недопустимые
+// переходы и выделяет сделки по приросту исполненного количества.
+type OrderManager struct {
+	broker Broker
+	events bool       // Площадка присылает обновления сама
+	costs  *CostModel // Оценка издержек для сделок, по которым площадка не сообщила комиссию
+
+	priceSource func(symbol string) (float64, bool) // Цена для сделок, по которым площадка не сообщила цену
+
+	mu     sync.RWMutex
+	orders map[int]*ManagedOrder
+	fills  []Fill
+
+	handlerMu      sync.RWMutex
+	updateHandlers []func(order ManagedOrder)
+	fillHandlers   []func(fill Fill)
+
+	stop chan bool
+}
+
+// Функция для создания менеджера ордеров поверх площадки
+func NewOrderManager(broker Broker) *OrderManager {
+	manager := &OrderManager{
+		broker: broker,
+		orders: make(map[int]*ManagedOrder),
+		stop:   make(chan bool),
+
+		priceSource: lastQuotePrice,
+	}
+	if source, ok := findEventSource(broker); ok {
+		manager.events = true
+		source.SubscribeOrderUpdates(func(update OrderUpdate) {
+			if err := manager.ApplyUpdate(update); err != nil {
+				logger.Logger.Warn().Err(err).Msg("Order update rejected")
+			}
+		})
+	}
+	return manager
+}
+
+// Функция для оценки издержек по сделкам, для которых площадка не сообщает комиссию
+func (m *OrderManager) SetCostModel(costs *CostModel) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.costs = costs
+}
+
+// Функция для замены источника последних цен (по умолчанию - котировки из пакета data).
+// Цена нужна для рыночных ордеров, по которым площадка не сообщила среднюю цену исполнения
+func (m *OrderManager) SetPriceSource(source func(symbol string) (float64, bool)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.priceSource = source
+}
+
+// Функция возвращает площадку, через которую работает менеджер
+func (m *OrderManager) Broker() Broker {
+	return m.broker
+}
+
+// Функция для выставления ордера через менеджер
+func (m *OrderManager) PlaceOrder(req *OrderRequest) (*ManagedOrder, error) {
+	response, err := m.broker.PlaceOrder(req)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	order, ok := m.orders[response.OrderID]
+	if !ok {
+		// Обновление от площадки могло прийти раньше ответа
+		now := time.Now()
+		order = &ManagedOrder{ID: response.OrderID, State: StatePendingNew, Created: now, Updated: now}
+		m.orders[response.OrderID] = order
+	}
+	order.Request = *req
+	snapshot := *order
+	m.mu.Unlock()
+
+	if !m.events {
+		m.refresh(response.OrderID)
+		if current, ok := m.GetOrder(response.OrderID); ok {
+			snapshot = current
+		}
+	}
+	return &snapshot, nil
+}
+
+// Функция для отмены ордера через менеджер. До подтверждения площадкой ордер
+// находится в состоянии cancel_pending.
+func (m *OrderManager) CancelOrder(orderID int) error {
+	m.mu.Lock()
+	order, ok := m.orders[orderID]
+	if !ok {
+		m.mu.Unlock()
+		return fmt.Errorf("order %d is not managed", orderID)
+	}
+	if err := checkTransition(orderID, order.State, StateCancelPending); err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	previous := order.State
+	order.State = StateCancelPending
+	order.Updated = time.Now()
+	m.mu.Unlock()
+
+	if err := m.broker.CancelOrder(orderID); err != nil {
+		m.mu.Lock()
+		if order.State == StateCancelPending {
+			order.State = previous
+		}
+		m.mu.Unlock()
+		return err
+	}
+
+	if !m.events {
+		m.refresh(orderID)
+	}
+	return nil
+}
+
+// Функция для отмены всех открытых ордеров (по инструменту или всех, если symbol пустой)
+func (m *OrderManager) CancelAll(symbol string) error {
+	var lastErr error
+	for _, order := range m.OpenOrders(symbol) {
+		if order.State == StateCancelPending {
+			continue
+		}
+		if err := m.CancelOrder(order.ID); err != nil {
+			logger.Logger.Error().Err(err).Int("order_id", order.ID).Msg("Failed to cancel order")
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// Функция для изменения ордера через менеджер. Если площадка заменила ордер новым
+// (снятие и повторное выставление), старый ордер считается снятым, а новый выставлен
+// на неисполненный остаток. При ошибке *ModifyError состояние исходного ордера
+// перечитывается у площадки.
+func (m *OrderManager) ModifyOrder(req *ModifyOrderRequest) (*ManagedOrder, error) {
+	original, ok := m.GetOrder(req.OrderID)
+	if !ok {
+		return nil, fmt.Errorf("order %d is not managed", req.OrderID)
+	}
+	if original.State.IsTerminal() {
+		return nil, fmt.Errorf("order %d: can't modify in state %s", req.OrderID, original.State)
+	}
+
+	// Без нативного изменения ордер снимается и выставляется заново через всю цепочку площадки
+	var response *OrderResponse
+	var err error
+	if usesCancelReplace(m.broker) {
+		response, err = cancelReplace(m.broker, req, original.Request)
+	} else {
+		response, err = m.broker.ModifyOrder(req)
+	}
+	if err != nil {
+		var modifyErr *ModifyError
+		if errors.As(err, &modifyErr) && !errors.Is(err, ErrModifyRejected) {
+			m.refresh(req.OrderID)
+		}
+		return nil, err
+	}
+
+	request := original.Request
+	if req.Quantity > 0 {
+		request.Quantity = req.Quantity
+	}
+	if req.Price > 0 {
+		request.Price = req.Price
+	}
+	if req.StopPrice > 0 {
+		request.StopPrice = req.StopPrice
+	}
+
+	if response.OrderID != 0 && response.OrderID != req.OrderID {
+		// Исходный ордер снят с исполненным к этому моменту количеством
+		m.refresh(req.OrderID)
+		if current, ok := m.GetOrder(req.OrderID); ok {
+			original = current
+		}
+		request.Quantity -= original.FilledQuantity
+		if err := m.ApplyUpdate(OrderUpdate{OrderID: req.OrderID, Status: string(StateCancelled), FilledQuantity: original.FilledQuantity, AveragePrice: original.AveragePrice, Message: "replaced"}); err != nil {
+			logger.Logger.Warn().Err(err).Int("order_id", req.OrderID).Msg("Failed to mark replaced order")
+		}
+		now := time.Now()
+		m.mu.Lock()
+		order, ok := m.orders[response.OrderID]
+		if !ok {
+			order = &ManagedOrder{ID: response.OrderID, State: StatePendingNew, Created: now, Updated: now}
+			m.orders[response.OrderID] = order
+		}
+		order.Request = request
+		snapshot := *order
+		m.mu.Unlock()
+		return &snapshot, nil
+	}
+
+	m.mu.Lock()
+	order := m.orders[req.OrderID]
+	order.Request = request
+	order.Updated = time.Now()
+	snapshot := *order
+	m.mu.Unlock()
+	return &snapshot, nil
+}
+
+// Функция для применения обновления ордера. Возвращает ErrIllegalTransition,
+// если переход из текущего состояния недопустим.
+func (m *OrderManager) ApplyUpdate(update OrderUpdate) error {
+	state, ok := ParseOrderState(update.Status)
+	if !ok {
+		return fmt.Errorf("order %d: unknown status %q", update.OrderID, update.Status)
+	}
+	if update.Time.IsZero() {
+		update.Time = time.Now()
+	}
+
+	m.mu.Lock()
+	order, exists := m.orders[update.OrderID]
+	if !exists {
+		order = &ManagedOrder{
+			ID:      update.OrderID,
+			Request: OrderRequest{Symbol: update.Symbol, Side: update.Side, Quantity: update.Quantity},
+			State:   StatePendingNew,
+			Created: update.Time,
+		}
+		m.orders[update.OrderID] = order
+	}
+
+	// Площадки часто не различают активный и частично исполненный ордер
+	if state == StateWorking && update.FilledQuantity > 0 {
+		state = StatePartiallyFilled
+	}
+	if update.FilledQuantity < order.FilledQuantity {
+		m.mu.Unlock()
+		return fmt.Errorf("order %d: filled quantity decreased from %d to %d: %w", order.ID, order.FilledQuantity, update.FilledQuantity, ErrIllegalTransition)
+	}
+	if err := checkTransition(order.ID, order.State, state); err != nil {
+		m.mu.Unlock()
+		return err
+	}
+
+	var fills []Fill
+	if delta := update.FilledQuantity - order.FilledQuantity; delta > 0 {
+		price := m.fillPrice(order, update, delta)
+		fill := Fill{
+			OrderID:    order.ID,
+			Symbol:     order.Request.Symbol,
+			Side:       order.Request.Side,
+			Quantity:   delta,
+			Price:      price,
+			Commission: update.Commission - order.Commission,
+			Time:       update.Time,
+		}
+		if fill.Commission == 0 && m.costs != nil {
+			m.costs.Charge(&fill, 0)
+		}
+		fills = append(fills, fill)
+		m.fills = append(m.fills, fill)
+
+		order.FilledQuantity = update.FilledQuantity
+		if update.AveragePrice > 0 {
+			order.AveragePrice = update.AveragePrice
+		} else {
+			order.AveragePrice = price
+		}
+		order.Commission = update.Commission
+	}
+
+	if order.State != state {
+		logger.Logger.Info().
+			Int("order_id", order.ID).
+			Str("symbol", order.Request.Symbol).
+			Str("from", string(order.State)).
+			Str("to", string(state)).
+			Int("filled", order.FilledQuantity).
+			Msg("Order state changed")
+	}
+	order.State = state
+	if update.Message != "" {
+		order.Message = update.Message
+	}
+	order.Updated = update.Time
+	snapshot := *order
+	m.mu.Unlock()
+
+	m.notify(snapshot, fills)
+	return nil
+}
+
+// Функция определяет цену новой сделки по ордеру. Если площадка сообщила среднюю цену,
+// цена сделки выводится из ее изменения. Иначе берется цена лимитного ордера, а для
+// рыночного - последняя котировка инструмента. Вызывается под m.mu
+func (m *OrderManager) fillPrice(order *ManagedOrder, update OrderUpdate, delta int) float64 {
+	if update.AveragePrice > 0 {
+		return (update.AveragePrice*float64(update.FilledQuantity) - order.AveragePrice*float64(order.FilledQuantity)) / float64(delta)
+	}
+	if order.Request.Price > 0 {
+		return order.Request.Price
+	}
+	if m.priceSource != nil {
+		if price, ok := m.priceSource(order.Request.Symbol); ok && price > 0 {
+			return price
+		}
+	}
+	logger.Logger.Warn().
+		Int("order_id", order.ID).
+		Str("symbol", order.Request.Symbol).
+		Msg("Fill price unknown: broker reported no average price and there is no quote")
+	return order.AveragePrice
+}
+
+// Функция для принятия под управление ордера, выставленного в обход менеджера.
+// Сделки по уже исполненной части не формируются. Известный ордер не изменяется
+func (m *OrderManager) adopt(order ManagedOrder) {
+	m.mu.Lock()
+	if _, exists := m.orders[order.ID]; exists {
+		m.mu.Unlock()
+		return
+	}
+	m.orders[order.ID] = &order
+	m.mu.Unlock()
+
+	logger.Logger.Info().
+		Int("order_id", order.ID).
+		Str("symbol", order.Request.Symbol).
+		Str("state", string(order.State)).
+		Int("filled", order.FilledQuantity).
+		Msg("Order adopted")
+	m.notify(order, nil)
+}
+
+// Функция для опроса площадки по всем открытым ордерам
+func (m *OrderManager) Poll() {
+	for _, order := range m.OpenOrders("") {
+		m.refresh(order.ID)
+	}
+}
+
+// Функция для запуска периодического опроса площадки
+func (m *OrderManager) StartPolling(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-ticker.C:
+				m.Poll()
+			}
+		}
+	}()
+}
+
+// Функция для остановки опроса
+func (m *OrderManager) Stop() {
+	close(m.stop)
+}
+
+// Функция возвращает ордер по ID
+func (m *OrderManager) GetOrder(orderID int) (ManagedOrder, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	order, ok := m.orders[orderID]
+	if !ok {
+		return ManagedOrder{}, false
+	}
+	return *order, true
+}
+
+// Функция возвращает открытые ордера по инструменту (или все, если symbol пустой)
+func (m *OrderManager) OpenOrders(symbol string) []ManagedOrder {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var orders []ManagedOrder
+	for _, order := range m.orders {
+		if order.State.IsOpen() && (symbol == "" || order.Request.Symbol == symbol) {
+			orders = append(orders, *order)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].ID < orders[j].ID })
+	return orders
+}
+
+// Функция возвращает сделки по инструменту (или все, если symbol пустой)
+func (m *OrderManager) Fills(symbol string) []Fill {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var fills []Fill
+	for _, fill := range m.fills {
+		if symbol == "" || fill.Symbol == symbol {
+			fills = append(fills, fill)
+		}
+	}
+	return fills
+}
+
+// Функция для подписки на изменения ордеров
+func (m *OrderManager) SubscribeUpdates(handler func(order ManagedOrder)) {
+	m.handlerMu.Lock()
+	defer m.handlerMu.Unlock()
+	m.updateHandlers = append(m.updateHandlers, handler)
+}
+
+// Функция для подписки на сделки
+func (m *OrderManager) SubscribeFills(handler func(fill Fill)) {
+	m.handlerMu.Lock()
+	defer m.handlerMu.Unlock()
+	m.fillHandlers = append(m.fillHandlers, handler)
+}
+
+// Функция для запроса статуса ордера у площадки (возвращает ошибку запроса статуса)
+func (m *OrderManager) refresh(orderID int) error {
+	response, err := m.broker.GetOrderStatus(orderID)
+	if err != nil {
+		logger.Logger.Error().Err(err).Int("order_id", orderID).Msg("Failed to get order status")
+		return err
+	}
+
+	update := OrderUpdate{
+		OrderID:        orderID,
+		Status:         response.Status,
+		FilledQuantity: response.FilledQuantity,
+		AveragePrice:   response.AveragePrice,
+		Message:        response.Message,
+	}
+	// Площадка не сообщила исполненное количество - исполненный ордер считается исполненным целиком
+	if state, ok := ParseOrderState(response.Status); ok && state == StateFilled && update.FilledQuantity == 0 {
+		if order, ok := m.GetOrder(orderID); ok {
+			update.FilledQuantity = order.Request.Quantity
+		}
+	}
+	if err := m.ApplyUpdate(update); err != nil {
+		logger.Logger.Warn().Err(err).Int("order_id", orderID).Msg("Order status update rejected")
+	}
+	return nil
+}
+
+// Функция для вызова подписчиков (вызывается без блокировки m.mu)
+func (m *OrderManager) notify(order ManagedOrder, fills []Fill) {
+	m.handlerMu.RLock()
+	updateHandlers := m.updateHandlers
+	fillHandlers := m.fillHandlers
+	m.handlerMu.RUnlock()
+
+	for _, fill := range fills {
+		for _, handler := range fillHandlers {
+			handler(fill)
+		}
+	}
+	for _, handler := range updateHandlers {
+		handler(order)
+	}
+}
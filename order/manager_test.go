@@ -0,0 +1,216 @@
+package order
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"testing"
+)
+
+// Площадка для тестов: принимает ордера и отвечает заданным статусом
+type stubBroker struct {
+	mu        sync.Mutex
+	nextID    int
+	status    string
+	statusErr error
+	placeErr  error
+	placed    []OrderRequest
+	cancelled []int
+	positions []Position
+	balances  map[string]Balance
+}
+
+func (b *stubBroker) Name() string { return "stub" }
+
+func (b *stubBroker) PlaceOrder(req *OrderRequest) (*OrderResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.placeErr != nil {
+		return nil, b.placeErr
+	}
+	b.nextID++
+	b.placed = append(b.placed, *req)
+	return &OrderResponse{OrderID: b.nextID, Status: "success"}, nil
+}
+
+func (b *stubBroker) CancelOrder(orderID int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cancelled = append(b.cancelled, orderID)
+	return nil
+}
+
+func (b *stubBroker) ModifyOrder(req *ModifyOrderRequest) (*OrderResponse, error) {
+	return &OrderResponse{OrderID: req.OrderID, Status: "success"}, nil
+}
+
+func (b *stubBroker) GetOrderStatus(orderID int) (*OrderResponse, error) {
+	if b.statusErr != nil {
+		return nil, b.statusErr
+	}
+	status := b.status
+	if status == "" {
+		status = "working"
+	}
+	return &OrderResponse{OrderID: orderID, Status: status}, nil
+}
+
+func (b *stubBroker) GetPositions() ([]Position, error) { return b.positions, nil }
+
+func (b *stubBroker) GetBalances() (map[string]Balance, error) { return b.balances, nil }
+
+func (b *stubBroker) GetPortfolioInfo() (*PortfolioInfo, error) {
+	return &PortfolioInfo{Balances: b.balances, Positions: b.positions}, nil
+}
+
+func (b *stubBroker) GetOrdersHistory(req *OrdersHistoryRequest) (*OrdersHistoryResponse, error) {
+	return &OrdersHistoryResponse{}, nil
+}
+
+func (b *stubBroker) FindOrderByClientID(clientOrderID string) (*OrderHistory, error) {
+	return nil, ErrOrderNotFound
+}
+
+func TestOrderManagerFillPrice(t *testing.T) {
+	tests := []struct {
+		name       string
+		req        OrderRequest
+		quote      float64 // Последняя котировка (0 - котировки нет)
+		updates    []OrderUpdate
+		wantPrices []float64
+	}{
+		{
+			name:       "broker average price",
+			req:        OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 4, OrderType: "limit", Price: 100},
+			updates:    []OrderUpdate{{Status: "filled", FilledQuantity: 4, AveragePrice: 99.5}},
+			wantPrices: []float64{99.5},
+		},
+		{
+			name: "fill price derived from cumulative average",
+			req:  OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 4, OrderType: "limit", Price: 103},
+			updates: []OrderUpdate{
+				{Status: "partially_filled", FilledQuantity: 2, AveragePrice: 100},
+				{Status: "filled", FilledQuantity: 4, AveragePrice: 101},
+			},
+			wantPrices: []float64{100, 102},
+		},
+		{
+			name:       "limit order without average price",
+			req:        OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 4, OrderType: "limit", Price: 100},
+			quote:      98,
+			updates:    []OrderUpdate{{Status: "filled", FilledQuantity: 4}},
+			wantPrices: []float64{100},
+		},
+		{
+			name:       "market order without average price uses last quote",
+			req:        OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 4, OrderType: "market"},
+			quote:      98,
+			updates:    []OrderUpdate{{Status: "filled", FilledQuantity: 4}},
+			wantPrices: []float64{98},
+		},
+		{
+			name: "market order keeps known average without quote",
+			req:  OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 4, OrderType: "market"},
+			updates: []OrderUpdate{
+				{Status: "partially_filled", FilledQuantity: 2, AveragePrice: 97},
+				{Status: "filled", FilledQuantity: 4},
+			},
+			wantPrices: []float64{97, 97},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := NewOrderManager(&stubBroker{})
+			manager.SetPriceSource(func(symbol string) (float64, bool) {
+				return tt.quote, tt.quote > 0
+			})
+			order, err := manager.PlaceOrder(&tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, update := range tt.updates {
+				update.OrderID = order.ID
+				if err := manager.ApplyUpdate(update); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			fills := manager.Fills("SBER")
+			if len(fills) != len(tt.wantPrices) {
+				t.Fatalf("got %d fills, want %d", len(fills), len(tt.wantPrices))
+			}
+			for i, fill := range fills {
+				if math.Abs(fill.Price-tt.wantPrices[i]) > 1e-9 {
+					t.Errorf("fill %d price = %v, want %v", i, fill.Price, tt.wantPrices[i])
+				}
+			}
+		})
+	}
+}
+
+func TestOrderManagerApplyUpdate(t *testing.T) {
+	tests := []struct {
+		name        string
+		updates     []OrderUpdate
+		wantErr     bool
+		wantIllegal bool // Ошибка - недопустимый переход
+		wantState   OrderState
+	}{
+		{
+			name:      "working reported with fills is partially filled",
+			updates:   []OrderUpdate{{Status: "working", FilledQuantity: 1}},
+			wantState: StatePartiallyFilled,
+		},
+		{
+			name:      "partially filled order rejected by broker",
+			updates:   []OrderUpdate{{Status: "partially_filled", FilledQuantity: 1}, {Status: "rejected", FilledQuantity: 1}},
+			wantState: StateRejected,
+		},
+		{
+			name:        "filled order can't become working",
+			updates:     []OrderUpdate{{Status: "filled", FilledQuantity: 4}, {Status: "working", FilledQuantity: 4}},
+			wantErr:     true,
+			wantIllegal: true,
+			wantState:   StateFilled,
+		},
+		{
+			name:        "filled quantity can't decrease",
+			updates:     []OrderUpdate{{Status: "partially_filled", FilledQuantity: 2}, {Status: "partially_filled", FilledQuantity: 1}},
+			wantErr:     true,
+			wantIllegal: true,
+			wantState:   StatePartiallyFilled,
+		},
+		{
+			name:      "unknown status",
+			updates:   []OrderUpdate{{Status: "teleported"}},
+			wantErr:   true,
+			wantState: StateWorking,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := NewOrderManager(&stubBroker{})
+			order, err := manager.PlaceOrder(&OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 4, OrderType: "limit", Price: 100})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var lastErr error
+			for _, update := range tt.updates {
+				update.OrderID = order.ID
+				lastErr = manager.ApplyUpdate(update)
+			}
+			if (lastErr != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %v", lastErr, tt.wantErr)
+			}
+			if tt.wantIllegal && !errors.Is(lastErr, ErrIllegalTransition) {
+				t.Errorf("error = %v, want ErrIllegalTransition", lastErr)
+			}
+			if current, _ := manager.GetOrder(order.ID); current.State != tt.wantState {
+				t.Errorf("state = %s, want %s", current.State, tt.wantState)
+			}
+		})
+	}
+}
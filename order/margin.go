@@ -0,0 +1,345 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+
+	"trading-bot/logger"
+)
+
+// Ошибка для короткой продажи инструмента, недоступного для займа
+var ErrShortNotAllowed = errors.New("short selling is not allowed")
+
+// Ошибка для ордера, которому не хватает свободного обеспечения
+var ErrInsufficientMargin = errors.New("insufficient buying power")
+
+// Уровень маржинального обеспечения портфеля
+type MarginLevel string
+
+const (
+	MarginOK      MarginLevel = "ok"
+	MarginWarning MarginLevel = "warning"     // Стоимость портфеля ниже начальной маржи: увеличивать позиции нельзя
+	MarginCall    MarginLevel = "margin_call" // Стоимость портфеля ниже минимальной маржи: брокер закроет позиции
+)
+
+// Структура для маржинальных параметров инструмента (ставки риска брокера). Ставки - доли
+// стоимости позиции. Нулевая начальная ставка длинной позиции - 100% (без плеча), короткой -
+// как у длинной; нулевая минимальная ставка - половина начальной
+type MarginParams struct {
+	Shortable        bool    `json:"shortable"`         // Бумаги доступны для займа (короткие продажи разрешены)
+	InitialLong      float64 `json:"initial_long"`      // Начальная маржа длинной позиции
+	InitialShort     float64 `json:"initial_short"`     // Начальная маржа короткой позиции
+	MaintenanceLong  float64 `json:"maintenance_long"`  // Минимальная маржа длинной позиции
+	MaintenanceShort float64 `json:"maintenance_short"` // Минимальная маржа короткой позиции
+
+	InitialPerLot     float64 `json:"initial_per_lot"`     // Начальная маржа на лот в деньгах (ГО фьючерса, вместо ставок)
+	MaintenancePerLot float64 `json:"maintenance_per_lot"` // Минимальная маржа на лот (0 - половина начальной)
+}
+
+// Функция возвращает начальную и минимальную маржу позиции со знаком quantity и рыночной
+// стоимостью value (по модулю)
+func (p MarginParams) requirement(quantity int, value float64) (float64, float64) {
+	if p.InitialPerLot > 0 {
+		lots := math.Abs(float64(quantity))
+		maintenance := p.MaintenancePerLot
+		if maintenance <= 0 {
+			maintenance = p.InitialPerLot / 2
+		}
+		return lots * p.InitialPerLot, lots * maintenance
+	}
+	return value * p.initial(quantity), value * p.maintenance(quantity)
+}
+
+// Функция возвращает ставку начальной маржи позиции со знаком quantity
+func (p MarginParams) initial(quantity int) float64 {
+	rate := p.InitialLong
+	if rate <= 0 {
+		rate = 1
+	}
+	if quantity < 0 && p.InitialShort > 0 {
+		rate = p.InitialShort
+	}
+	return rate
+}
+
+// Функция возвращает ставку минимальной маржи позиции со знаком quantity
+func (p MarginParams) maintenance(quantity int) float64 {
+	rate := p.MaintenanceLong
+	if quantity < 0 {
+		rate = p.MaintenanceShort
+	}
+	if rate <= 0 {
+		rate = p.initial(quantity) / 2
+	}
+	return rate
+}
+
+// Структура для настройки маржинальной торговли
+type MarginConfig struct {
+	Currency string                  `json:"currency"` // Валюта обеспечения без оценки в базовой валюте (по умолчанию RUB)
+	Default  MarginParams            `json:"default"`  // Параметры инструментов без собственных настроек
+	Symbols  map[string]MarginParams `json:"symbols"`  // Параметры по инструментам (важнее ставок площадки)
+}
+
+// Функция возвращает настройки наличного счета: покупка на свои деньги, без коротких продаж
+func CashMarginConfig() MarginConfig {
+	return MarginConfig{Default: MarginParams{InitialLong: 1, MaintenanceLong: 1}}
+}
+
+// Интерфейс площадки, сообщающей маржинальные параметры инструментов
+type MarginSource interface {
+	MarginParams() map[string]MarginParams
+}
+
+// Функция для поиска площадки с маржинальными параметрами под цепочкой декораторов
+func findMarginSource(broker Broker) (MarginSource, bool) {
+	for broker != nil {
+		if source, ok := broker.(MarginSource); ok {
+			return source, true
+		}
+		wrapper, ok := broker.(brokerWrapper)
+		if !ok {
+			break
+		}
+		broker = wrapper.Unwrap()
+	}
+	return nil, false
+}
+
+// Структура для состояния обеспечения портфеля
+type MarginStatus struct {
+	Equity            float64     `json:"equity"`             // Стоимость портфеля: деньги и позиции
+	InitialMargin     float64     `json:"initial_margin"`     // Начальная маржа позиций
+	MaintenanceMargin float64     `json:"maintenance_margin"` // Минимальная маржа позиций
+	FreeMargin        float64     `json:"free_margin"`        // Свободное обеспечение для новых позиций
+	BuyingPower       float64     `json:"buying_power"`       // Покупательная способность по ставке по умолчанию
+	Level             MarginLevel `json:"level"`
+	Err               error       `json:"-"` // Курс какой-либо валюты неизвестен: стоимость и маржа занижены
+}
+
+// Маржинальная модель: ставки по инструментам, покупательная способность, проверка ордеров
+// и предупреждения о маржин-колле. Одни и те же правила действуют в бою, на бумаге и в бэктесте
+type MarginModel struct {
+	config    MarginConfig
+	lotSizes  map[string]int
+	bonds     *BondSpecs // Облигации: стоимость лота считается от номинала
+	valuation *Valuation // Оценка в базовой валюте (nil - только деньги в валюте Currency)
+
+	mu      sync.Mutex
+	sources []MarginSource
+	level   MarginLevel
+}
+
+// Функция для создания маржинальной модели
+func NewMarginModel(config MarginConfig, lotSizes map[string]int) *MarginModel {
+	if config.Currency == "" {
+		config.Currency = DefaultCurrency
+	}
+	return &MarginModel{config: config, lotSizes: lotSizes, level: MarginOK}
+}
+
+// Функция для подключения ставок риска площадки (если площадка их сообщает)
+func (m *MarginModel) AttachToBroker(broker Broker) bool {
+	source, ok := findMarginSource(broker)
+	if ok {
+		m.AttachSource(source)
+	}
+	return ok
+}
+
+// Функция для подключения источника маржинальных параметров (например, справочника фьючерсов).
+// Источники опрашиваются в порядке подключения
+func (m *MarginModel) AttachSource(source MarginSource) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sources = append(m.sources, source)
+}
+
+// Функция для подключения справочника облигаций (вызывается до начала торговли)
+func (m *MarginModel) AttachBonds(bonds *BondSpecs) {
+	m.bonds = bonds
+}
+
+// Функция для подключения оценки в базовой валюте: обеспечение - деньги во всех валютах
+// и позиции по курсу (вызывается до начала торговли)
+func (m *MarginModel) AttachValuation(valuation *Valuation) {
+	m.valuation = valuation
+}
+
+// Функция возвращает маржинальные параметры инструмента: из настроек, от источников или по умолчанию
+func (m *MarginModel) Params(symbol string) MarginParams {
+	if params, ok := m.config.Symbols[symbol]; ok {
+		return params
+	}
+	m.mu.Lock()
+	sources := m.sources
+	m.mu.Unlock()
+	for _, source := range sources {
+		if params, ok := source.MarginParams()[symbol]; ok {
+			return params
+		}
+	}
+	return m.config.Default
+}
+
+// Функция возвращает состояние обеспечения портфеля, не изменяя состояние модели
+func (m *MarginModel) Status(portfolio *PortfolioInfo) MarginStatus {
+	status := MarginStatus{Equity: portfolio.Balances[m.config.Currency].Value}
+	errs := make([]error, 0)
+	if m.valuation != nil {
+		cash, err := m.valuation.Cash(portfolio.Balances)
+		status.Equity = cash
+		errs = append(errs, err)
+	}
+	for _, position := range portfolio.Positions {
+		currency := m.valuation.Currency(position.Symbol)
+		equity, err := m.valuation.ToBase(position.EquityValue(), currency)
+		errs = append(errs, err)
+		marketValue, err := m.valuation.ToBase(position.MarketValue, currency)
+		errs = append(errs, err)
+		status.Equity += equity
+		initial, maintenance := m.Params(position.Symbol).requirement(position.Quantity, math.Abs(marketValue))
+		status.InitialMargin += initial
+		status.MaintenanceMargin += maintenance
+	}
+	status.Err = errors.Join(errs...)
+	status.FreeMargin = status.Equity - status.InitialMargin
+	status.BuyingPower = math.Max(status.FreeMargin, 0) / m.config.Default.initial(1)
+
+	status.Level = MarginOK
+	if status.Equity < status.MaintenanceMargin {
+		status.Level = MarginCall
+	} else if status.Equity < status.InitialMargin {
+		status.Level = MarginWarning
+	}
+	return status
+}
+
+// Функция для оценки обеспечения портфеля с предупреждением при смене уровня
+func (m *MarginModel) Evaluate(portfolio *PortfolioInfo) MarginStatus {
+	status := m.Status(portfolio)
+
+	m.mu.Lock()
+	previous := m.level
+	m.level = status.Level
+	m.mu.Unlock()
+	if status.Level == previous {
+		return status
+	}
+
+	event := logger.Logger.Info()
+	switch status.Level {
+	case MarginWarning:
+		event = logger.Logger.Warn()
+	case MarginCall:
+		event = logger.Logger.Error()
+	}
+	event.
+		Str("level", string(status.Level)).
+		Str("previous", string(previous)).
+		Float64("equity", status.Equity).
+		Float64("initial_margin", status.InitialMargin).
+		Float64("maintenance_margin", status.MaintenanceMargin).
+		Msg("Margin level changed")
+	return status
+}
+
+// Функция возвращает покупательную способность по инструменту (в базовой валюте) для стороны ордера.
+// Для фьючерса с ГО - стоимость контрактов, на которые хватает обеспечения, по цене price.
+// Без полной оценки портфеля (неизвестен курс) покупательная способность нулевая
+func (m *MarginModel) BuyingPower(status MarginStatus, symbol, side string, price float64) float64 {
+	if status.Err != nil {
+		return 0
+	}
+	quantity := 1
+	if side == "sell" {
+		quantity = -1
+	}
+	params := m.Params(symbol)
+	if params.InitialPerLot > 0 {
+		lotValue, err := m.LotValue(symbol, price)
+		if err != nil {
+			return 0
+		}
+		return math.Floor(math.Max(status.FreeMargin, 0)/params.InitialPerLot) * lotValue
+	}
+	return math.Max(status.FreeMargin, 0) / params.initial(quantity)
+}
+
+// Функция для проверки ордера: разрешена ли короткая продажа и хватает ли свободного
+// обеспечения на прирост начальной маржи и издержки cost (в валюте инструмента). Возвращает
+// требуемое и доступное обеспечение в базовой валюте. Если для оценки не хватает курса валюты,
+// ордер отклоняется с ошибкой data.ErrNoFXRate
+func (m *MarginModel) CheckOrder(portfolio *PortfolioInfo, symbol, side string, quantity int, price, cost float64) (float64, float64, error) {
+	current := 0
+	for _, position := range portfolio.Positions {
+		if position.Symbol == symbol {
+			current = position.Quantity
+		}
+	}
+	next := current + quantity
+	if side == "sell" {
+		next = current - quantity
+	}
+
+	params := m.Params(symbol)
+	if next < 0 && next < current && !params.Shortable {
+		return 0, 0, fmt.Errorf("%s: %w", symbol, ErrShortNotAllowed)
+	}
+
+	lotValue, err := m.LotValue(symbol, price)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: %w", symbol, err)
+	}
+	costValue, err := m.valuation.ToBase(cost, m.valuation.Currency(symbol))
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: %w", symbol, err)
+	}
+	nextInitial, _ := params.requirement(next, math.Abs(float64(next))*lotValue)
+	currentInitial, _ := params.requirement(current, math.Abs(float64(current))*lotValue)
+	required := nextInitial - currentInitial + costValue
+	if required <= 0 {
+		return required, 0, nil
+	}
+	status := m.Status(portfolio)
+	if status.Err != nil {
+		return required, 0, fmt.Errorf("%s: %w", symbol, status.Err)
+	}
+	available := status.FreeMargin
+	if required > available {
+		return required, available, fmt.Errorf("%s: %w", symbol, ErrInsufficientMargin)
+	}
+	return required, available, nil
+}
+
+// Функция возвращает стоимость одного лота в базовой валюте по цене price (для облигаций
+// цена - процент от номинала). Ошибка - курс валюты инструмента неизвестен
+func (m *MarginModel) LotValue(symbol string, price float64) (float64, error) {
+	return m.valuation.ToBase(m.bonds.unitPrice(symbol, price)*float64(m.lotSize(symbol)), m.valuation.Currency(symbol))
+}
+
+// Функция возвращает размер лота инструмента
+func (m *MarginModel) lotSize(symbol string) int {
+	if lotSize, ok := m.lotSizes[symbol]; ok && lotSize > 0 {
+		return lotSize
+	}
+	return 1
+}
+
+// Проверка коротких продаж и достаточности обеспечения (вместо проверки денег на маржинальном счете)
+type MarginCheck struct {
+	Model *MarginModel
+}
+
+func (c *MarginCheck) Name() string { return "margin" }
+
+func (c *MarginCheck) Check(ctx *RiskContext) *RiskRejection {
+	portfolio := &PortfolioInfo{Balances: ctx.Balances, Positions: ctx.Positions}
+	required, available, err := c.Model.CheckOrder(portfolio, ctx.Request.Symbol, ctx.Request.Side, ctx.Request.Quantity, ctx.Price, 0)
+	if err != nil {
+		return &RiskRejection{Reason: err.Error(), Value: required, Limit: available}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package order
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestMarginModelCheckOrderShorts(t *testing.T) {
+	config := MarginConfig{
+		Default: MarginParams{InitialLong: 1},
+		Symbols: map[string]MarginParams{
+			"SBER": {Shortable: true, InitialLong: 0.25, InitialShort: 0.4},
+			"GAZP": {InitialLong: 0.5},
+		},
+	}
+	model := NewMarginModel(config, map[string]int{"SBER": 10, "GAZP": 10})
+	// Деньги 10 000, 2 лота SBER (5 000, маржа 1 250), короткая GAZP (-3 000, маржа 1 500): свободно 9 250
+	portfolio := &PortfolioInfo{
+		Balances: map[string]Balance{"RUB": {Value: 10000}},
+		Positions: []Position{
+			{Symbol: "SBER", Quantity: 2, MarketValue: 5000},
+			{Symbol: "GAZP", Quantity: -2, MarketValue: -3000},
+		},
+	}
+
+	t.Run("closing long releases margin", func(t *testing.T) {
+		required, _, err := model.CheckOrder(portfolio, "SBER", "sell", 2, 250, 0)
+		if err != nil || required != -1250 {
+			t.Errorf("required = %v, err = %v, want -1250 and no error", required, err)
+		}
+	})
+
+	t.Run("flip to short uses short rate", func(t *testing.T) {
+		// Короткая позиция 3 лота: 3 * 2 500 * 0.4 = 3 000 вместо 1 250 по длинной
+		required, available, err := model.CheckOrder(portfolio, "SBER", "sell", 5, 250, 0)
+		if err != nil || required != 1750 || available != 9250 {
+			t.Errorf("required = %v, available = %v, err = %v, want 1750 of 9250", required, available, err)
+		}
+	})
+
+	t.Run("short beyond free margin", func(t *testing.T) {
+		required, available, err := model.CheckOrder(portfolio, "SBER", "sell", 30, 250, 0)
+		if !errors.Is(err, ErrInsufficientMargin) || required != 26750 || available != 9250 {
+			t.Errorf("required = %v, available = %v, err = %v, want ErrInsufficientMargin", required, available, err)
+		}
+	})
+
+	t.Run("not shortable", func(t *testing.T) {
+		if _, _, err := model.CheckOrder(portfolio, "GAZP", "sell", 1, 150, 0); !errors.Is(err, ErrShortNotAllowed) {
+			t.Errorf("increasing short: err = %v, want ErrShortNotAllowed", err)
+		}
+		// Уменьшать уже открытую короткую позицию можно
+		if _, _, err := model.CheckOrder(portfolio, "GAZP", "buy", 1, 150, 0); err != nil {
+			t.Errorf("covering short: %v", err)
+		}
+		if _, _, err := model.CheckOrder(portfolio, "LKOH", "sell", 1, 7000, 0); !errors.Is(err, ErrShortNotAllowed) {
+			t.Errorf("default params: err = %v, want ErrShortNotAllowed", err)
+		}
+	})
+}
+
+// Справочник с гарантийным обеспечением фьючерсов
+type staticMarginSource map[string]MarginParams
+
+func (s staticMarginSource) MarginParams() map[string]MarginParams { return s }
+
+func TestMarginModelCheckOrderFutures(t *testing.T) {
+	model := NewMarginModel(CashMarginConfig(), nil)
+	model.AttachSource(staticMarginSource{"SiZ4": {Shortable: true, InitialPerLot: 15000}})
+
+	// Стоимость фьючерса не входит в обеспечение, только вариационная маржа:
+	// 40 000 - 500 = 39 500, ГО одного контракта 15 000, свободно 24 500
+	portfolio := &PortfolioInfo{
+		Balances:  map[string]Balance{"RUB": {Value: 40000}},
+		Positions: []Position{{Symbol: "SiZ4", Quantity: 1, MarketValue: 90000, Futures: true, VariationMargin: -500}},
+	}
+	status := model.Status(portfolio)
+	if status.Equity != 39500 || status.InitialMargin != 15000 || status.MaintenanceMargin != 7500 || status.FreeMargin != 24500 {
+		t.Fatalf("status = %+v", status)
+	}
+
+	// ГО считается за контракт и не зависит от цены; издержки добавляются к требованию
+	required, available, err := model.CheckOrder(portfolio, "SiZ4", "buy", 1, 90000, 5)
+	if err != nil || required != 15005 || available != 24500 {
+		t.Errorf("buy 1: required = %v, available = %v, err = %v", required, available, err)
+	}
+	if _, _, err := model.CheckOrder(portfolio, "SiZ4", "buy", 2, 90000, 0); !errors.Is(err, ErrInsufficientMargin) {
+		t.Errorf("buy 2: err = %v, want ErrInsufficientMargin", err)
+	}
+	// Переворот в короткую позицию 2 контракта: 30 000 - 15 000
+	if required, _, err := model.CheckOrder(portfolio, "SiZ4", "sell", 3, 90000, 0); err != nil || required != 15000 {
+		t.Errorf("sell 3: required = %v, err = %v, want 15000", required, err)
+	}
+	// На свободное обеспечение хватает одного контракта
+	if power := model.BuyingPower(status, "SiZ4", "sell", 90000); math.Abs(power-90000) > 1e-9 {
+		t.Errorf("buying power = %v, want one contract (90000)", power)
+	}
+}
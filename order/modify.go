@@ -0,0 +1,162 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"trading-bot/logger"
+)
+
+// Ошибки изменения ордера. Каждая описывает, в каком состоянии остался исходный ордер
+var (
+	ErrModifyRejected      = errors.New("modify rejected, original order unchanged")
+	ErrModifyCancelFailed  = errors.New("cancel failed, original order still working")
+	ErrModifyUnconfirmed   = errors.New("modify not confirmed, original order state unknown")
+	ErrModifyFilled        = errors.New("original order filled before replace")
+	ErrModifyReplaceFailed = errors.New("original order cancelled, replacement failed")
+)
+
+// Структура для ошибки изменения ордера. errors.Is(err, ErrModify...) определяет этап,
+// на котором изменение не удалось
+type ModifyError struct {
+	OrderID        int
+	Stage          error // Одна из ошибок ErrModify...
+	FilledQuantity int   // Исполнено по исходному ордеру к моменту ошибки
+	Err            error // Ошибка площадки (может быть nil)
+}
+
+func (e *ModifyError) Error() string {
+	message := fmt.Sprintf("modify order %d: %v (filled %d)", e.OrderID, e.Stage, e.FilledQuantity)
+	if e.Err != nil {
+		message += ": " + e.Err.Error()
+	}
+	return message
+}
+
+// Функция для сравнения с этапом (errors.Is)
+func (e *ModifyError) Is(target error) bool {
+	return target == e.Stage
+}
+
+func (e *ModifyError) Unwrap() error {
+	return e.Err
+}
+
+// Интерфейс площадки без нативного изменения ордеров. OrderManager изменяет ее ордера сам
+// через cancelReplace поверх всей цепочки декораторов, чтобы новый ордер прошел проверки риска,
+// журнал, защиту от повторной отправки и ограничитель частоты
+type cancelReplaceBroker interface {
+	ModifiesByCancelReplace() bool
+}
+
+// Функция проверяет, изменяет ли площадка под цепочкой декораторов ордера снятием и повторным выставлением
+func usesCancelReplace(broker Broker) bool {
+	for broker != nil {
+		if venue, ok := broker.(cancelReplaceBroker); ok {
+			return venue.ModifiesByCancelReplace()
+		}
+		wrapper, ok := broker.(brokerWrapper)
+		if !ok {
+			break
+		}
+		broker = wrapper.Unwrap()
+	}
+	return false
+}
+
+// Параметры подтверждения снятия при cancel/replace
+var (
+	modifyConfirmTimeout = 10 * time.Second
+	modifyPollInterval   = 250 * time.Millisecond
+)
+
+// Функция для изменения ордера снятием и повторным выставлением (для площадок без нативного изменения).
+// Новый ордер выставляется только после подтвержденного снятия исходного, на количество
+// req.Quantity (новое общее количество) за вычетом исполненного за это время.
+// original - параметры исходного ордера
+func cancelReplace(broker Broker, req *ModifyOrderRequest, original OrderRequest) (*OrderResponse, error) {
+	logger.Logger.Info().Int("order_id", req.OrderID).Msg("Modifying order via cancel/replace")
+
+	// Отклоненное снятие оставляет ордер рабочим, а при неизвестном результате (ошибка
+	// сервера, обрыв связи) снятие проверяется по статусу ордера
+	if err := broker.CancelOrder(req.OrderID); err != nil {
+		// Снятие могло не пройти, потому что ордер уже исполнен
+		if status, statusErr := broker.GetOrderStatus(req.OrderID); statusErr == nil {
+			if state, _ := ParseOrderState(status.Status); state == StateFilled {
+				return nil, &ModifyError{OrderID: req.OrderID, Stage: ErrModifyFilled, FilledQuantity: filledQuantity(status, original.Quantity), Err: err}
+			}
+		}
+		if errors.Is(err, ErrCancelRejected) || errors.Is(err, ErrKillSwitchActive) || errors.Is(err, ErrThrottled) {
+			return nil, &ModifyError{OrderID: req.OrderID, Stage: ErrModifyCancelFailed, Err: err}
+		}
+		logger.Logger.Warn().Err(err).Int("order_id", req.OrderID).Msg("Cancel result unknown, checking order status")
+	}
+
+	final, err := confirmCancel(broker, req.OrderID)
+	if err != nil {
+		return nil, &ModifyError{OrderID: req.OrderID, Stage: ErrModifyUnconfirmed, Err: err}
+	}
+	filled := filledQuantity(final, original.Quantity)
+
+	quantity := original.Quantity
+	if req.Quantity > 0 {
+		quantity = req.Quantity
+	}
+	if quantity-filled <= 0 {
+		return nil, &ModifyError{OrderID: req.OrderID, Stage: ErrModifyFilled, FilledQuantity: filled}
+	}
+
+	replacement := original
+	replacement.Quantity = quantity - filled
+	replacement.ClientOrderID = NewClientOrderID()
+	if req.Price > 0 {
+		replacement.Price = req.Price
+	}
+	if req.StopPrice > 0 {
+		replacement.StopPrice = req.StopPrice
+	}
+
+	response, err := broker.PlaceOrder(&replacement)
+	if err != nil {
+		logger.Logger.Error().Err(err).Int("order_id", req.OrderID).Msg("Replacement order failed after cancel")
+		return nil, &ModifyError{OrderID: req.OrderID, Stage: ErrModifyReplaceFailed, FilledQuantity: filled, Err: err}
+	}
+
+	logger.Logger.Info().
+		Int("order_id", req.OrderID).
+		Int("new_order_id", response.OrderID).
+		Int("filled", filled).
+		Int("quantity", replacement.Quantity).
+		Msg("Order replaced")
+	return response, nil
+}
+
+// Функция для ожидания подтверждения снятия: ордер должен перейти в конечное состояние
+func confirmCancel(broker Broker, orderID int) (*OrderResponse, error) {
+	deadline := time.Now().Add(modifyConfirmTimeout)
+	for {
+		status, err := broker.GetOrderStatus(orderID)
+		if err == nil {
+			if state, ok := ParseOrderState(status.Status); ok && state.IsTerminal() {
+				return status, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return nil, fmt.Errorf("error checking order %d: %w", orderID, err)
+			}
+			return nil, fmt.Errorf("order %d is still %s after %s", orderID, status.Status, modifyConfirmTimeout)
+		}
+		time.Sleep(modifyPollInterval)
+	}
+}
+
+// Функция возвращает исполненное количество ордера (полностью исполненный ордер без
+// количества в ответе считается исполненным на quantity)
+func filledQuantity(status *OrderResponse, quantity int) int {
+	if state, _ := ParseOrderState(status.Status); state == StateFilled && status.FilledQuantity == 0 {
+		return quantity
+	}
+	return status.FilledQuantity
+}
@@ -0,0 +1,105 @@
+package order
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Площадка без нативного изменения: после снятия статус ордера - afterCancel
+type cancelReplaceVenue struct {
+	*stubBroker
+	cancelErr   error
+	afterCancel *OrderResponse // nil - ордер остается рабочим
+	cancelling  bool
+}
+
+func (v *cancelReplaceVenue) ModifiesByCancelReplace() bool { return true }
+
+func (v *cancelReplaceVenue) CancelOrder(orderID int) error {
+	v.cancelling = true
+	if v.cancelErr != nil {
+		return v.cancelErr
+	}
+	return v.stubBroker.CancelOrder(orderID)
+}
+
+func (v *cancelReplaceVenue) GetOrderStatus(orderID int) (*OrderResponse, error) {
+	if v.cancelling && v.afterCancel != nil && orderID == v.afterCancel.OrderID {
+		return v.afterCancel, nil
+	}
+	return v.stubBroker.GetOrderStatus(orderID)
+}
+
+func TestManagerModifyFillBetweenCancel(t *testing.T) {
+	venue := &cancelReplaceVenue{stubBroker: &stubBroker{}}
+	manager := NewOrderManager(venue)
+	original, err := manager.PlaceOrder(&OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 10, OrderType: "limit", Price: 250, ClientOrderID: "c1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Пока снятие шло, исполнилось 3 лота: новый ордер - на 8 минус 3
+	venue.afterCancel = &OrderResponse{OrderID: original.ID, Status: "cancelled", FilledQuantity: 3, AveragePrice: 249.5}
+	replaced, err := manager.ModifyOrder(&ModifyOrderRequest{OrderID: original.ID, Quantity: 8, Price: 251})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(venue.placed) != 2 {
+		t.Fatalf("placed = %+v, want original and replacement", venue.placed)
+	}
+	sent := venue.placed[1]
+	if sent.Quantity != 5 || sent.Price != 251 || sent.ClientOrderID == "c1" || sent.ClientOrderID == "" {
+		t.Errorf("replacement sent = %+v, want 5 lots at 251 with a new client ID", sent)
+	}
+	if replaced.ID == original.ID || replaced.Request.Quantity != 5 {
+		t.Errorf("replaced order = %+v, want new order for 5 lots", replaced)
+	}
+	old, _ := manager.GetOrder(original.ID)
+	if old.State != StateCancelled || old.FilledQuantity != 3 || old.AveragePrice != 249.5 {
+		t.Errorf("original = %+v, want cancelled with 3 filled at 249.5", old)
+	}
+}
+
+func TestCancelReplaceFailures(t *testing.T) {
+	defer func(timeout, interval time.Duration) {
+		modifyConfirmTimeout, modifyPollInterval = timeout, interval
+	}(modifyConfirmTimeout, modifyPollInterval)
+	modifyConfirmTimeout, modifyPollInterval = 20*time.Millisecond, time.Millisecond
+
+	original := OrderRequest{Symbol: "SBER", Side: "sell", Quantity: 10, OrderType: "limit", Price: 250}
+
+	// Весь остаток исполнен во время снятия: новый ордер не нужен
+	venue := &cancelReplaceVenue{stubBroker: &stubBroker{}, afterCancel: &OrderResponse{OrderID: 7, Status: "cancelled", FilledQuantity: 8}}
+	_, err := cancelReplace(venue, &ModifyOrderRequest{OrderID: 7, Quantity: 8}, original)
+	var modifyErr *ModifyError
+	if !errors.Is(err, ErrModifyFilled) || !errors.As(err, &modifyErr) || modifyErr.FilledQuantity != 8 || len(venue.placed) != 0 {
+		t.Errorf("filled during cancel: error = %v, placed %d", err, len(venue.placed))
+	}
+
+	// Снятие отклонено, потому что ордер уже исполнен (статус без количества)
+	venue = &cancelReplaceVenue{stubBroker: &stubBroker{}, cancelErr: ErrCancelRejected, afterCancel: &OrderResponse{OrderID: 7, Status: "filled"}}
+	_, err = cancelReplace(venue, &ModifyOrderRequest{OrderID: 7, Price: 255}, original)
+	if !errors.As(err, &modifyErr) || !errors.Is(err, ErrModifyFilled) || modifyErr.FilledQuantity != 10 {
+		t.Errorf("cancel rejected for filled order: error = %v", err)
+	}
+
+	// Снятие отклонено, ордер рабочий - исходный ордер не тронут
+	venue = &cancelReplaceVenue{stubBroker: &stubBroker{}, cancelErr: ErrCancelRejected}
+	if _, err = cancelReplace(venue, &ModifyOrderRequest{OrderID: 7, Price: 255}, original); !errors.Is(err, ErrModifyCancelFailed) {
+		t.Errorf("cancel rejected: error = %v, want ErrModifyCancelFailed", err)
+	}
+
+	// Результат снятия неизвестен и ордер не закрылся - состояние неизвестно, новый ордер не выставляется
+	venue = &cancelReplaceVenue{stubBroker: &stubBroker{}, cancelErr: errors.New("connection reset by peer")}
+	if _, err = cancelReplace(venue, &ModifyOrderRequest{OrderID: 7, Price: 255}, original); !errors.Is(err, ErrModifyUnconfirmed) || len(venue.placed) != 0 {
+		t.Errorf("cancel unconfirmed: error = %v, placed %d", err, len(venue.placed))
+	}
+
+	// Снятие прошло, новый ордер отклонен - исполненное по исходному сообщается
+	venue = &cancelReplaceVenue{stubBroker: &stubBroker{placeErr: ErrOrderRejected}, afterCancel: &OrderResponse{OrderID: 7, Status: "cancelled", FilledQuantity: 2}}
+	if _, err = cancelReplace(venue, &ModifyOrderRequest{OrderID: 7, Price: 255}, original); !errors.As(err, &modifyErr) || !errors.Is(err, ErrModifyReplaceFailed) || modifyErr.FilledQuantity != 2 {
+		t.Errorf("replacement rejected: error = %v", err)
+	}
+}
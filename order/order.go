@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"testing"
 	"time"
 	"trading-bot/logger"
@@ -25,13 +26,19 @@ type OrderRequest struct {
 	AccountID   string  `json:"account_id"`   // Идентификатор счета (если требуется)
 	Comment     string  `json:"comment"`      // Комментарий к ордеру
 	AccessToken string  `json:"access_token"` // Токен доступа
+
+	ClientOrderID string `json:"client_order_id"` // Уникальный клиентский ID ордера (для защиты от повторной отправки)
+	Strategy      string `json:"-"`               // Стратегия, выставившая ордер (для журнала, на площадку не передается)
+	Emergency     bool   `json:"-"`               // Аварийное закрытие позиции: не блокируется остановкой, лимитами риска и частоты
 }
 
 // Структура для ответа от API при создании/получении статуса ордера
 type OrderResponse struct {
-	OrderID int    `json:"order_id"`
-	Status  string `json:"status"`
-	Message string `json:"message"`
+	OrderID        int     `json:"order_id"`
+	Status         string  `json:"status"`
+	Message        string  `json:"message"`
+	FilledQuantity int     `json:"filled_quantity"` // Исполненное количество лотов (если площадка сообщает)
+	AveragePrice   float64 `json:"average_price"`   // Средняя цена исполнения (если площадка сообщает)
 }
 
 // Структура для хранения информации о портфеле
@@ -65,6 +72,20 @@ type Position struct {
 	ProfitLoss    float64   `json:"profitLoss"`    // Прибыль/убыток. Аналогично ожидаемой доходности, для расчета текущей прибыли/убытка по позиции
 	OpenDate      time.Time `json:"openDate"`      // Дата открытия позиции. Эта информация может быть полезна для анализа эффективности ваших торговых решений в зависимости от времени.
 	LastTradeTime time.Time `json:"lastTradeTime"` // Время последней сделки. Позволит отслеживать активность по инструменту.
+
+	Futures         bool    `json:"futures,omitempty"`         // Фьючерс: стоимость позиции не оплачивается, деньги движутся на клиринге
+	VariationMargin float64 `json:"variationMargin,omitempty"` // Вариационная маржа фьючерса с прошлого клиринга
+	Bond            bool    `json:"bond,omitempty"`            // Облигация: цена в процентах от номинала
+	AccruedInterest float64 `json:"accruedInterest,omitempty"` // НКД облигаций позиции
+}
+
+// Функция возвращает вклад позиции в стоимость портфеля: рыночную стоимость (для облигаций -
+// с НКД), а для фьючерса - еще не зачисленную вариационную маржу
+func (p Position) EquityValue() float64 {
+	if p.Futures {
+		return p.VariationMargin
+	}
+	return p.MarketValue + p.AccruedInterest
 }
 
 // Структура для запроса истории ордеров
@@ -92,13 +113,14 @@ type OrderHistory struct {
 	StopPrice     float64   `json:"stop_price"`
 	Status        string    `json:"status"`
 	ExecutionTime time.Time `json:"execution_time"`
+	ClientOrderID string    `json:"client_order_id"`
 	// ... другие поля, если необходимо
 }
 
 // Структура для запроса изменения ордера
 type ModifyOrderRequest struct {
 	OrderID     int     `json:"order_id"`
-	Quantity    int     `json:"quantity"`     // Новое количество (необязательно)
+	Quantity    int     `json:"quantity"`     // Новое общее количество, включая исполненное (необязательно)
 	Price       float64 `json:"price"`        // Новая цена (необязательно)
 	StopPrice   float64 `json:"stop_price"`   // Новая стоп-цена (необязательно)
 	AccessToken string  `json:"access_token"` // Токен доступа
@@ -111,6 +133,9 @@ type ModifyOrderResponse struct {
 	Message string `json:"message"`
 }
 
+// HTTP клиент для торговых запросов (с таймаутом, чтобы не зависать на ответе)
+var orderHTTPClient = &http.Client{Timeout: 15 * time.Second}
+
 // Функция для создания нового ордера
 func CreateOrder(order *OrderRequest) (*OrderResponse, error) {
 	// Формирование URL запроса
@@ -130,14 +155,24 @@ func CreateOrder(order *OrderRequest) (*OrderResponse, error) {
 		Str("order_type", order.OrderType).
 		Msg("Sending order creation request")
 
-	// Отправка HTTP запроса
-	resp, err := http.Post(orderURL, "application/json", bytes.NewBuffer(orderJSON))
+	// Отправка HTTP запроса. Ошибка сети или таймаут не означает, что ордер не принят
+	resp, err := orderHTTPClient.Post(orderURL, "application/json", bytes.NewBuffer(orderJSON))
 	if err != nil {
 		logger.Logger.Error().Err(err).Msg("Error making order creation request")
 		return nil, fmt.Errorf("error making order creation request: %w", err)
 	}
 	defer resp.Body.Close()
 
+	// Ошибка сервера - результат неизвестен, ошибка запроса - ордер точно отклонен
+	if resp.StatusCode >= http.StatusInternalServerError {
+		logger.Logger.Error().Int("status_code", resp.StatusCode).Msg("Finam API error - CreateOrder")
+		return nil, fmt.Errorf("order creation request failed with status code: %d", resp.StatusCode)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		logger.Logger.Error().Int("status_code", resp.StatusCode).Msg("Finam API error - CreateOrder")
+		return nil, fmt.Errorf("order creation request failed with status code %d: %w", resp.StatusCode, ErrOrderRejected)
+	}
+
 	// Чтение ответа
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -159,7 +194,7 @@ func CreateOrder(order *OrderRequest) (*OrderResponse, error) {
 			Str("status", orderResp.Status).
 			Str("message", orderResp.Message).
 			Msg("Order creation failed")
-		return nil, fmt.Errorf("order creation failed: %s", orderResp.Message)
+		return nil, fmt.Errorf("order creation failed: %s: %w", orderResp.Message, ErrOrderRejected)
 	}
 
 	// Логирование успешного создания ордера
@@ -194,16 +229,18 @@ func CancelOrder(orderID int, accessToken string) error {
 	}
 	defer resp.Body.Close()
 
-	// Проверка статуса ответа (опционально, если требуется обработка ответов)
-	if resp.StatusCode != http.StatusOK {
+	// Ошибка сервера - результат неизвестен, ошибка запроса - снятие точно отклонено
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
 		// Логирование ошибки при отмене ордера
 		logger.Logger.Error().
 			Int("status_code", resp.StatusCode).
 			Int("order_id", orderID).
 			Msg("Finam API error - CancelOrder")
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("order cancellation request failed with status code: %d", resp.StatusCode)
+		}
+		return fmt.Errorf("order cancellation request failed with status code %d: %w", resp.StatusCode, ErrCancelRejected)
 	}
-	// Обработка ответа (если требуется)
-	// ...
 
 	return nil
 
@@ -242,6 +279,8 @@ func GetOrderStatus(orderID int, accessToken string) (*OrderResponse, error) {
 
 		// Обработка ошибок на основе кода статуса
 		switch resp.StatusCode {
+		case http.StatusNotFound:
+			return nil, fmt.Errorf("order %d: %w", orderID, ErrOrderNotFound)
 		case http.StatusTooManyRequests:
 			return nil, fmt.Errorf("too many requests to Trade API, try again later")
 		case http.StatusUnauthorized:
@@ -282,8 +321,16 @@ func GetOrderStatus(orderID int, accessToken string) (*OrderResponse, error) {
 
 // Функция для получения информации о портфеле
 func GetPortfolioInfo(accessToken string) (*PortfolioInfo, error) {
+	return GetAccountPortfolioInfo(accessToken, "")
+}
+
+// Функция для получения информации о портфеле счета (пустой accountID - счет по умолчанию для токена)
+func GetAccountPortfolioInfo(accessToken, accountID string) (*PortfolioInfo, error) {
 	// Формирование URL запроса
 	portfolioURL := "https://trade-api.finam.ru/v1/portfolio" // TODO: Проверить URL в документации
+	if accountID != "" {
+		portfolioURL += "?accountId=" + url.QueryEscape(accountID)
+	}
 	// Создание HTTP клиента и запроса с токеном доступа
 	client := &http.Client{}
 	req, err := http.NewRequest("GET", portfolioURL, nil)
@@ -436,36 +483,9 @@ func GetOrdersHistory(req *OrdersHistoryRequest, accessToken string) (*OrdersHis
 	return &historyResp, nil
 }
 
-// Функция для изменения ордера (с отменой и созданием нового ордера). Функция принимает orderID (ID ордера, который нужно изменить) и newOrder (структуру OrderRequest с параметрами нового ордера)
-func ModifyOrder(orderID int, newOrder *OrderRequest) (*OrderResponse, error) {
-	// Логирование начала изменения ордера
-	logger.Logger.Info().
-		Int("order_id", orderID).
-		Msg("Modifying order")
-
-	// Отмена старого ордера
-	err := CancelOrder(orderID, newOrder.AccessToken)
-	if err != nil {
-		logger.Logger.Error().Err(err).Msg("Error canceling order")
-		return nil, fmt.Errorf("error canceling order: %w", err)
-	}
-
-	// Логирование успешной отмены ордера
-	logger.Logger.Info().
-		Int("order_id", orderID).
-		Msg("Order canceled successfully")
-
-	// Создание нового ордера
-	orderResp, err := CreateOrder(newOrder)
-	if err != nil {
-		logger.Logger.Error().Err(err).Msg("Error creating new order")
-		return nil, fmt.Errorf("error creating new order: %w", err)
-	}
-
-	// Логирование успешного создания нового ордера
-	logger.Logger.Info().
-		Int("new_order_id", orderResp.OrderID).
-		Msg("New order created successfully")
-
-	return orderResp, nil
+// Функция для изменения ордера через Trade API. Trade API не умеет изменять ордер,
+// поэтому выполняется защищенное снятие и повторное выставление (см. FinamBroker.ModifyOrder).
+// req.Quantity - новое общее количество ордера
+func ModifyOrder(req *ModifyOrderRequest) (*OrderResponse, error) {
+	return NewFinamBroker(req.AccessToken).ModifyOrder(req)
 }
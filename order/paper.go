@@ -0,0 +1,723 @@
+package order
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"trading-bot/data"
+	"trading-bot/logger"
+)
+
+// Статусы ордеров виртуального брокера
+const (
+	paperStatusWorking         = "working"
+	paperStatusPartiallyFilled = "partially_filled"
+	paperStatusFilled          = "filled"
+	paperStatusCancelled       = "cancelled"
+	paperStatusRejected        = "rejected"
+)
+
+// Структура для настройки виртуального брокера
+type PaperConfig struct {
+	InitialBalances   map[string]Balance // Начальные денежные остатки
+	SlippagePercent   float64            // Проскальзывание рыночных ордеров относительно лучшей цены (в процентах)
+	CommissionPercent float64            // Комиссия от оборота (в процентах)
+	LotSizes          map[string]int     // Размер лота по инструментам (по умолчанию 1)
+	MaxBookAge        time.Duration      // Максимальный возраст стакана для исполнения по лучшей цене (0 - без ограничения)
+	Costs             *CostModel         // Комиссии и проскальзывание (если nil - по CommissionPercent и SlippagePercent)
+	Margin            *MarginModel       // Короткие продажи и плечо (если nil - наличный счет без коротких продаж)
+	Futures           *FuturesSpecs      // Фьючерсные контракты: ГО вместо оплаты и вариационная маржа на клиринге
+	Bonds             *BondSpecs         // Облигации: цена в процентах от номинала, НКД по сделкам, купоны (PayCoupons)
+	Valuation         *Valuation         // Валюты инструментов и курсы: расчеты в валюте инструмента, оценка в базовой валюте
+}
+
+// Структура для ордера виртуального брокера
+type paperOrder struct {
+	id         int
+	request    OrderRequest
+	status     string
+	filled     int
+	avgPrice   float64
+	commission float64
+	message    string
+	created    time.Time
+	updated    time.Time
+}
+
+// Реализация Broker без отправки реальных ордеров. Потребляет живые котировки и стаканы
+// (через AttachToFeed) или бары бэктеста (через OnQuote):
+//   - рыночные ордера проходят уровни стакана в пределах их объема (без стакана - по последней сделке
+//     с проскальзыванием), лимитные, пересекающие стакан, - уровни не хуже лимита, остаток ждет сделок;
+//   - лимитные ордера исполняются, только когда сделка прошла сквозь лимит, и не больше объема сделки;
+//   - с каждой сделки списываются комиссия брокера и биржевой сбор по модели издержек;
+//   - короткие продажи и покупки с плечом проверяются маржинальной моделью;
+//   - по фьючерсам стоимость контракта не оплачивается, вариационная маржа зачисляется на клиринге (Clear);
+//   - по облигациям к цене от номинала добавляется НКД, купоны и погашение зачисляются PayCoupons;
+//   - расчеты по сделке идут в валюте инструмента, обеспечение оценивается в базовой валюте.
+type PaperBroker struct {
+	mu          sync.Mutex
+	config      PaperConfig
+	nextOrderID int
+	balances    map[string]Balance
+	positions   *PositionKeeper
+	costs       *CostModel
+	margin      *MarginModel
+	orders      map[int]*paperOrder
+	prices      map[string]float64
+	books       map[string]data.OrderBook
+	fills       []Fill
+	now         time.Time
+
+	handlers []func(update OrderUpdate)
+	updates  []OrderUpdate // Обновления, которые будут отправлены подписчикам после снятия блокировки
+}
+
+// Функция для создания виртуального брокера
+func NewPaperBroker(config *PaperConfig) *PaperBroker {
+	balances := make(map[string]Balance, len(config.InitialBalances))
+	for currency, balance := range config.InitialBalances {
+		balances[currency] = balance
+	}
+	costs := config.Costs
+	if costs == nil {
+		costs = NewCostModel(PercentCostConfig(config.CommissionPercent, config.SlippagePercent), config.LotSizes)
+	}
+	margin := config.Margin
+	if margin == nil {
+		margin = NewMarginModel(CashMarginConfig(), config.LotSizes)
+	}
+	positions := NewPositionKeeper(config.LotSizes)
+	if config.Futures != nil {
+		config.Futures.ApplyTo(positions)
+		margin.AttachSource(config.Futures)
+	}
+	if config.Bonds != nil {
+		config.Bonds.ApplyTo(positions)
+		costs.AttachBonds(config.Bonds)
+		margin.AttachBonds(config.Bonds)
+	}
+	if config.Valuation != nil {
+		margin.AttachValuation(config.Valuation)
+	}
+	return &PaperBroker{
+		config:      *config,
+		nextOrderID: 1,
+		balances:    balances,
+		positions:   positions,
+		costs:       costs,
+		margin:      margin,
+		orders:      make(map[int]*paperOrder),
+		prices:      make(map[string]float64),
+		books:       make(map[string]data.OrderBook),
+	}
+}
+
+func (p *PaperBroker) Name() string {
+	return "paper"
+}
+
+// Функция для подписки на живые котировки и стаканы из пакета data
+func (p *PaperBroker) AttachToFeed() {
+	data.SubscribeQuotes(p.OnQuote)
+	data.SubscribeOrderBooks(p.OnOrderBook)
+}
+
+// Функция для обработки сделки (котировки) по инструменту. Котировка с нулевым объемом
+// (например, бар бэктеста без объема) исполняет лимитные ордера без ограничения по объему.
+func (p *PaperBroker) OnQuote(quote data.Quote) {
+	p.mu.Lock()
+	defer p.unlockAndNotify()
+
+	p.updatePrice(quote.Symbol, quote.Price, quote.Time)
+
+	available := quote.Volume
+	for _, id := range p.workingOrderIDs(quote.Symbol) {
+		order := p.orders[id]
+		if order.request.OrderType != "limit" {
+			continue
+		}
+
+		// Лимит должен быть пройден насквозь: покупка - сделка ниже лимита, продажа - выше
+		limit := order.request.Price
+		tradedThrough := (order.request.Side == "buy" && quote.Price < limit) ||
+			(order.request.Side == "sell" && quote.Price > limit)
+		if !tradedThrough {
+			continue
+		}
+
+		quantity := order.request.Quantity - order.filled
+		if quote.Volume > 0 {
+			if available <= 0 {
+				break
+			}
+			if int64(quantity) > available {
+				quantity = int(available)
+			}
+			available -= int64(quantity)
+		}
+		p.fill(order, limit, 0, quantity)
+	}
+}
+
+// Функция для обработки обновления стакана
+func (p *PaperBroker) OnOrderBook(book data.OrderBook) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.books[book.Symbol] = book
+	if book.Time.After(p.now) {
+		p.now = book.Time
+	}
+}
+
+// Функция для обновления последней цены без исполнения лимитных ордеров
+// (например, по снимку котировки из REST API)
+func (p *PaperBroker) UpdatePrice(symbol string, price float64, t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.updatePrice(symbol, price, t)
+}
+
+// Функция для зачисления (списания) денег, например дивидендов в бэктесте
+func (p *PaperBroker) Deposit(currency string, amount float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	balance := p.balances[currency]
+	balance.Value += amount
+	balance.Available += amount
+	p.balances[currency] = balance
+}
+
+// Функция для клиринга по фьючерсам: вариационная маржа позиций по последним ценам
+// зачисляется на счет (списывается со счета)
+func (p *PaperBroker) Clear(t time.Time) []VariationMargin {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	margins := SettleFutures(p.positions, t)
+	for _, margin := range margins {
+		p.credit(p.config.Valuation.Currency(margin.Symbol), margin.Amount)
+	}
+	return margins
+}
+
+// Функция для выплат по облигациям с датой в интервале (from, to]: купоны и погашение
+// номинала зачисляются на счет (по короткой позиции - списываются)
+func (p *PaperBroker) PayCoupons(from, to time.Time) []CouponPayment {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.config.Bonds == nil {
+		return nil
+	}
+	payments := PayBondCashflows(p.positions, p.config.Bonds, from, to)
+	for _, payment := range payments {
+		p.credit(p.config.Valuation.Currency(payment.Symbol), payment.Amount)
+	}
+	return payments
+}
+
+// Функция для зачисления (списания) денег в валюте (вызывается под p.mu)
+func (p *PaperBroker) credit(currency string, amount float64) {
+	balance := p.balances[currency]
+	balance.Value += amount
+	balance.Available += amount
+	p.balances[currency] = balance
+}
+
+// Функция возвращает копию всех сделок виртуального брокера
+func (p *PaperBroker) Fills() []Fill {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]Fill(nil), p.fills...)
+}
+
+func (p *PaperBroker) PlaceOrder(req *OrderRequest) (*OrderResponse, error) {
+	p.mu.Lock()
+	defer p.unlockAndNotify()
+
+	order := &paperOrder{
+		id:      p.nextOrderID,
+		request: *req,
+		status:  paperStatusWorking,
+		created: p.now,
+		updated: p.now,
+	}
+	p.nextOrderID++
+	p.orders[order.id] = order
+
+	if req.Quantity <= 0 || (req.Side != "buy" && req.Side != "sell") {
+		return p.reject(order, fmt.Sprintf("invalid order: side=%q quantity=%d", req.Side, req.Quantity))
+	}
+
+	switch req.OrderType {
+	case "market":
+		// По стакану рыночный ордер проходит уровни противоположной стороны; объем сверх
+		// стакана снимается. Без стакана - по последней сделке с проскальзыванием
+		if levels, ok := p.bookLevels(req.Symbol, req.Side); ok {
+			p.sweep(order, levels, 0)
+			if order.status == paperStatusWorking || order.status == paperStatusPartiallyFilled {
+				p.cancelRest(order, "not enough liquidity in order book")
+			}
+			break
+		}
+		price, ok := p.lastPrice(req.Symbol)
+		if !ok {
+			return p.reject(order, fmt.Sprintf("no price for %s", req.Symbol))
+		}
+		p.fill(order, p.costs.SlippagePrice(req.Symbol, req.Side, price), price, req.Quantity)
+	case "limit":
+		if req.Price <= 0 {
+			return p.reject(order, "limit order without price")
+		}
+		// Лимитный ордер, пересекающий стакан, исполняется по уровням стакана не хуже лимита
+		// в пределах их объема, остаток остается в работе. Без стакана пересечение
+		// проверяется по последней сделке
+		if levels, ok := p.bookLevels(req.Symbol, req.Side); ok {
+			p.sweep(order, levels, req.Price)
+		} else if price, ok := p.lastPrice(req.Symbol); ok {
+			if (req.Side == "buy" && price <= req.Price) || (req.Side == "sell" && price >= req.Price) {
+				p.fill(order, price, 0, req.Quantity)
+			}
+		}
+	default:
+		return p.reject(order, fmt.Sprintf("unsupported order type: %s", req.OrderType))
+	}
+
+	if order.status == paperStatusRejected {
+		return nil, fmt.Errorf("order creation failed: %s: %w", order.message, ErrOrderRejected)
+	}
+	if order.status == paperStatusWorking {
+		p.queueUpdate(order)
+	}
+
+	logger.Logger.Info().
+		Int("order_id", order.id).
+		Str("symbol", req.Symbol).
+		Str("side", req.Side).
+		Int("quantity", req.Quantity).
+		Str("status", order.status).
+		Msg("Paper order accepted")
+
+	return &OrderResponse{OrderID: order.id, Status: "success"}, nil
+}
+
+func (p *PaperBroker) CancelOrder(orderID int) error {
+	p.mu.Lock()
+	defer p.unlockAndNotify()
+
+	order, ok := p.orders[orderID]
+	if !ok {
+		return fmt.Errorf("order %d not found", orderID)
+	}
+	if !isPaperOrderWorking(order) {
+		return fmt.Errorf("order %d can't be cancelled in status %s", orderID, order.status)
+	}
+
+	order.status = paperStatusCancelled
+	order.updated = p.now
+	p.queueUpdate(order)
+	logger.Logger.Info().Int("order_id", orderID).Msg("Paper order cancelled")
+	return nil
+}
+
+// Виртуальный брокер изменяет ордер на месте (нативное изменение)
+func (p *PaperBroker) ModifyOrder(req *ModifyOrderRequest) (*OrderResponse, error) {
+	p.mu.Lock()
+	defer p.unlockAndNotify()
+
+	order, ok := p.orders[req.OrderID]
+	if !ok {
+		return nil, &ModifyError{OrderID: req.OrderID, Stage: ErrModifyRejected, Err: ErrOrderNotFound}
+	}
+	if !isPaperOrderWorking(order) {
+		stage := ErrModifyRejected
+		if order.status == paperStatusFilled {
+			stage = ErrModifyFilled
+		}
+		return nil, &ModifyError{OrderID: req.OrderID, Stage: stage, FilledQuantity: order.filled, Err: fmt.Errorf("order is %s", order.status)}
+	}
+
+	if req.Quantity > 0 {
+		if req.Quantity <= order.filled {
+			return nil, &ModifyError{OrderID: req.OrderID, Stage: ErrModifyFilled, FilledQuantity: order.filled}
+		}
+		order.request.Quantity = req.Quantity
+	}
+	if req.Price > 0 {
+		order.request.Price = req.Price
+	}
+	if req.StopPrice > 0 {
+		order.request.StopPrice = req.StopPrice
+	}
+	order.updated = p.now
+	p.queueUpdate(order)
+
+	return &OrderResponse{OrderID: order.id, Status: "success"}, nil
+}
+
+func (p *PaperBroker) GetOrderStatus(orderID int) (*OrderResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	order, ok := p.orders[orderID]
+	if !ok {
+		return nil, fmt.Errorf("order %d: %w", orderID, ErrOrderNotFound)
+	}
+	return &OrderResponse{
+		OrderID:        order.id,
+		Status:         order.status,
+		Message:        order.message,
+		FilledQuantity: order.filled,
+		AveragePrice:   order.avgPrice,
+	}, nil
+}
+
+// Функция возвращает учет позиций виртуального брокера (лоты FIFO и результат)
+func (p *PaperBroker) PositionKeeper() *PositionKeeper {
+	return p.positions
+}
+
+// Функция возвращает модель издержек виртуального брокера
+func (p *PaperBroker) CostModel() *CostModel {
+	return p.costs
+}
+
+// Функция возвращает маржинальную модель виртуального брокера
+func (p *PaperBroker) MarginModel() *MarginModel {
+	return p.margin
+}
+
+func (p *PaperBroker) GetPositions() ([]Position, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.positionsSnapshot(), nil
+}
+
+func (p *PaperBroker) GetBalances() (map[string]Balance, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.balancesSnapshot(), nil
+}
+
+func (p *PaperBroker) GetPortfolioInfo() (*PortfolioInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return &PortfolioInfo{
+		Account: AccountInfo{
+			AccountID:   "paper",
+			AccountName: "Paper trading",
+			Currency:    p.config.Valuation.Base(),
+		},
+		Balances:  p.balancesSnapshot(),
+		Positions: p.positionsSnapshot(),
+	}, nil
+}
+
+func (p *PaperBroker) GetOrdersHistory(req *OrdersHistoryRequest) (*OrdersHistoryResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var response OrdersHistoryResponse
+	for _, order := range p.orders {
+		if req.Symbol != "" && order.request.Symbol != req.Symbol {
+			continue
+		}
+		if req.Status != "" && order.status != req.Status {
+			continue
+		}
+		if (!req.From.IsZero() && order.updated.Before(req.From)) || (!req.To.IsZero() && order.updated.After(req.To)) {
+			continue
+		}
+		response.Orders = append(response.Orders, OrderHistory{
+			OrderID:       order.id,
+			Symbol:        order.request.Symbol,
+			Side:          order.request.Side,
+			Quantity:      order.request.Quantity,
+			OrderType:     order.request.OrderType,
+			Price:         order.request.Price,
+			StopPrice:     order.request.StopPrice,
+			Status:        order.status,
+			ExecutionTime: order.updated,
+			ClientOrderID: order.request.ClientOrderID,
+		})
+	}
+	sort.Slice(response.Orders, func(i, j int) bool { return response.Orders[i].OrderID < response.Orders[j].OrderID })
+	return &response, nil
+}
+
+func (p *PaperBroker) FindOrderByClientID(clientOrderID string) (*OrderHistory, error) {
+	history, _ := p.GetOrdersHistory(&OrdersHistoryRequest{})
+	for i := range history.Orders {
+		if history.Orders[i].ClientOrderID == clientOrderID {
+			return &history.Orders[i], nil
+		}
+	}
+	return nil, fmt.Errorf("client order %s: %w", clientOrderID, ErrOrderNotFound)
+}
+
+// Функция для подписки на обновления ордеров (реализация OrderEventSource)
+func (p *PaperBroker) SubscribeOrderUpdates(handler func(update OrderUpdate)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.handlers = append(p.handlers, handler)
+}
+
+// Функция для постановки обновления ордера в очередь (вызывается под p.mu)
+func (p *PaperBroker) queueUpdate(order *paperOrder) {
+	if len(p.handlers) == 0 {
+		return
+	}
+	p.updates = append(p.updates, OrderUpdate{
+		OrderID:        order.id,
+		Symbol:         order.request.Symbol,
+		Side:           order.request.Side,
+		Quantity:       order.request.Quantity,
+		Status:         order.status,
+		FilledQuantity: order.filled,
+		AveragePrice:   order.avgPrice,
+		Commission:     order.commission,
+		Message:        order.message,
+		Time:           order.updated,
+	})
+}
+
+// Функция снимает блокировку и отправляет накопленные обновления подписчикам,
+// чтобы подписчики могли обращаться к брокеру из обработчика
+func (p *PaperBroker) unlockAndNotify() {
+	updates := p.updates
+	handlers := p.handlers
+	p.updates = nil
+	p.mu.Unlock()
+
+	for _, update := range updates {
+		for _, handler := range handlers {
+			handler(update)
+		}
+	}
+}
+
+// Функция для обновления последней цены и переоценки позиции (вызывается под p.mu)
+func (p *PaperBroker) updatePrice(symbol string, price float64, t time.Time) {
+	p.prices[symbol] = price
+	if t.After(p.now) {
+		p.now = t
+	}
+	p.positions.MarkAt(symbol, price, p.now)
+}
+
+// Функция возвращает уровни противоположной стороны актуального стакана: для покупки -
+// продажи, для продажи - покупки (вызывается под p.mu)
+func (p *PaperBroker) bookLevels(symbol string, side string) ([]data.OrderBookLevel, bool) {
+	book, ok := p.books[symbol]
+	if !ok || (p.config.MaxBookAge > 0 && p.now.Sub(book.Time) > p.config.MaxBookAge) {
+		return nil, false
+	}
+	levels := book.Bids
+	if side == "buy" {
+		levels = book.Asks
+	}
+	return levels, len(levels) > 0
+}
+
+// Функция возвращает цену последней сделки (вызывается под p.mu)
+func (p *PaperBroker) lastPrice(symbol string) (float64, bool) {
+	price, ok := p.prices[symbol]
+	return price, ok
+}
+
+// Функция для исполнения ордера по уровням стакана от лучшего (вызывается под p.mu).
+// limit - худшая допустимая цена (0 - без ограничения). Выбранный объем снимается со
+// стакана, чтобы следующие ордера до нового снимка не исполнились по той же ликвидности.
+// Отклонение от лучшей цены учитывается как проскальзывание
+func (p *PaperBroker) sweep(order *paperOrder, levels []data.OrderBookLevel, limit float64) {
+	req := &order.request
+	best := levels[0].Price
+	remaining := make([]data.OrderBookLevel, 0, len(levels))
+	for _, level := range levels {
+		quantity := req.Quantity - order.filled
+		withinLimit := limit == 0 || (req.Side == "buy" && level.Price <= limit) || (req.Side == "sell" && level.Price >= limit)
+		if quantity > 0 && withinLimit && level.Quantity > 0 && order.status != paperStatusRejected {
+			if int64(quantity) > level.Quantity {
+				quantity = int(level.Quantity)
+			}
+			reference := 0.0
+			if level.Price != best {
+				reference = best
+			}
+			p.fill(order, level.Price, reference, quantity)
+			if order.status != paperStatusRejected {
+				level.Quantity -= int64(quantity)
+			}
+		}
+		if level.Quantity > 0 {
+			remaining = append(remaining, level)
+		}
+	}
+
+	book := p.books[req.Symbol]
+	if req.Side == "buy" {
+		book.Asks = remaining
+	} else {
+		book.Bids = remaining
+	}
+	p.books[req.Symbol] = book
+}
+
+// Функция для снятия неисполненного остатка ордера (вызывается под p.mu)
+func (p *PaperBroker) cancelRest(order *paperOrder, message string) {
+	order.status = paperStatusCancelled
+	order.message = message
+	order.updated = p.now
+	p.queueUpdate(order)
+
+	logger.Logger.Warn().
+		Int("order_id", order.id).
+		Str("symbol", order.request.Symbol).
+		Int("filled", order.filled).
+		Int("quantity", order.request.Quantity).
+		Str("message", message).
+		Msg("Paper order remainder cancelled")
+}
+
+// Функция возвращает размер лота инструмента
+func (p *PaperBroker) LotSize(symbol string) int {
+	if lotSize, ok := p.config.LotSizes[symbol]; ok && lotSize > 0 {
+		return lotSize
+	}
+	return 1
+}
+
+// Функция для исполнения ордера (вызывается под p.mu). reference - цена до проскальзывания
+// (0 - без проскальзывания)
+func (p *PaperBroker) fill(order *paperOrder, price, reference float64, quantity int) {
+	if quantity <= 0 {
+		return
+	}
+
+	req := &order.request
+	lotSize := p.LotSize(req.Symbol)
+	currency := p.config.Valuation.Currency(req.Symbol)
+	cash := p.balances[currency]
+	amount := p.config.Bonds.unitPrice(req.Symbol, price) * float64(quantity*lotSize)
+	fill := Fill{
+		OrderID:  order.id,
+		Symbol:   req.Symbol,
+		Side:     req.Side,
+		Quantity: quantity,
+		Price:    price,
+		Time:     p.now,
+	}
+	commission := p.costs.Costs(fill, reference).Total()
+
+	// По облигациям покупатель платит продавцу НКД
+	interest := p.positions.AccruedInterest(fill)
+	cost := commission
+	if req.Side == "buy" {
+		cost += interest
+	}
+
+	// Короткая продажа и покупка с плечом - по правилам маржинальной модели. Деньги от
+	// короткой продажи зачисляются на счет, покупка сверх денег уводит остаток в минус.
+	// Стоимость фьючерса не оплачивается: списывается только комиссия
+	if p.positions.IsFutures(req.Symbol) {
+		amount = 0
+	}
+	amount += interest
+	portfolio := &PortfolioInfo{Balances: p.balances, Positions: p.positionsSnapshot()}
+	if _, _, err := p.margin.CheckOrder(portfolio, req.Symbol, req.Side, quantity, price, cost); err != nil {
+		p.reject(order, err.Error())
+		return
+	}
+	switch req.Side {
+	case "buy":
+		cash.Available -= amount + commission
+		cash.Value -= amount + commission
+	case "sell":
+		cash.Available += amount - commission
+		cash.Value += amount - commission
+	}
+	p.balances[currency] = cash
+	p.costs.Charge(&fill, reference)
+
+	order.avgPrice = (order.avgPrice*float64(order.filled) + price*float64(quantity)) / float64(order.filled+quantity)
+	order.filled += quantity
+	order.commission += commission
+	if order.filled >= req.Quantity {
+		order.status = paperStatusFilled
+	} else {
+		order.status = paperStatusPartiallyFilled
+	}
+	order.updated = p.now
+
+	p.fills = append(p.fills, fill)
+	p.positions.ApplyFill(fill)
+	p.positions.MarkAt(req.Symbol, p.prices[req.Symbol], p.now)
+	p.queueUpdate(order)
+
+	logger.Logger.Info().
+		Int("order_id", order.id).
+		Str("symbol", req.Symbol).
+		Str("side", req.Side).
+		Int("quantity", quantity).
+		Float64("price", price).
+		Float64("commission", commission).
+		Str("status", order.status).
+		Msg("Paper order filled")
+}
+
+// Функция для отклонения ордера (вызывается под p.mu)
+func (p *PaperBroker) reject(order *paperOrder, message string) (*OrderResponse, error) {
+	order.status = paperStatusRejected
+	order.message = message
+	order.updated = p.now
+	p.queueUpdate(order)
+
+	logger.Logger.Warn().
+		Int("order_id", order.id).
+		Str("symbol", order.request.Symbol).
+		Str("message", message).
+		Msg("Paper order rejected")
+
+	return nil, fmt.Errorf("order creation failed: %s: %w", message, ErrOrderRejected)
+}
+
+// Функция возвращает ID рабочих ордеров по инструменту по возрастанию (вызывается под p.mu)
+func (p *PaperBroker) workingOrderIDs(symbol string) []int {
+	var ids []int
+	for id, order := range p.orders {
+		if isPaperOrderWorking(order) && order.request.Symbol == symbol {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// Функция возвращает копию позиций (вызывается под p.mu)
+func (p *PaperBroker) positionsSnapshot() []Position {
+	return p.positions.BrokerPositions()
+}
+
+// Функция возвращает копию остатков (вызывается под p.mu)
+func (p *PaperBroker) balancesSnapshot() map[string]Balance {
+	balances := make(map[string]Balance, len(p.balances))
+	for currency, balance := range p.balances {
+		balances[currency] = balance
+	}
+	return balances
+}
+
+// Функция проверяет, может ли ордер еще исполняться
+func isPaperOrderWorking(order *paperOrder) bool {
+	return order.status == paperStatusWorking || order.status == paperStatusPartiallyFilled
+}
@@ -0,0 +1,215 @@
+package order
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"trading-bot/data"
+)
+
+// Функция возвращает виртуального брокера без издержек с короткими продажами и стаканом
+func newTestPaperBroker(book *data.OrderBook) *PaperBroker {
+	margin := NewMarginModel(MarginConfig{Default: MarginParams{Shortable: true, InitialLong: 1, InitialShort: 1}}, nil)
+	broker := NewPaperBroker(&PaperConfig{
+		InitialBalances: map[string]Balance{DefaultCurrency: {Value: 1_000_000, Available: 1_000_000}},
+		Margin:          margin,
+	})
+	if book != nil {
+		broker.OnOrderBook(*book)
+	}
+	return broker
+}
+
+func TestPaperBrokerPlaceOrder(t *testing.T) {
+	now := time.Date(2024, time.July, 1, 10, 0, 0, 0, data.ExchangeLocation)
+	book := &data.OrderBook{
+		Symbol: "SBER",
+		Bids:   []data.OrderBookLevel{{Price: 99, Quantity: 3}, {Price: 98, Quantity: 3}},
+		Asks:   []data.OrderBookLevel{{Price: 100, Quantity: 5}, {Price: 101, Quantity: 5}},
+		Time:   now,
+	}
+
+	tests := []struct {
+		name       string
+		book       *data.OrderBook
+		lastPrice  float64
+		req        OrderRequest
+		wantErr    bool
+		wantStatus string
+		wantFilled int
+		wantPrice  float64
+	}{
+		{
+			name:       "market order walks the book",
+			book:       book,
+			req:        OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 8, OrderType: "market"},
+			wantStatus: paperStatusFilled,
+			wantFilled: 8,
+			wantPrice:  (5*100 + 3*101) / 8.0,
+		},
+		{
+			name:       "market order larger than the book is cut",
+			book:       book,
+			req:        OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 12, OrderType: "market"},
+			wantStatus: paperStatusCancelled,
+			wantFilled: 10,
+			wantPrice:  (5*100 + 5*101) / 10.0,
+		},
+		{
+			name:       "market sell hits bids",
+			book:       book,
+			req:        OrderRequest{Symbol: "SBER", Side: "sell", Quantity: 4, OrderType: "market"},
+			wantStatus: paperStatusFilled,
+			wantFilled: 4,
+			wantPrice:  (3*99 + 98) / 4.0,
+		},
+		{
+			name:       "crossing limit fills available volume and keeps the rest working",
+			book:       book,
+			req:        OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 8, OrderType: "limit", Price: 100.5},
+			wantStatus: paperStatusPartiallyFilled,
+			wantFilled: 5,
+			wantPrice:  100,
+		},
+		{
+			name:       "crossing limit fills through levels within limit",
+			book:       book,
+			req:        OrderRequest{Symbol: "SBER", Side: "sell", Quantity: 4, OrderType: "limit", Price: 98},
+			wantStatus: paperStatusFilled,
+			wantFilled: 4,
+			wantPrice:  (3*99 + 98) / 4.0,
+		},
+		{
+			name:       "passive limit rests",
+			book:       book,
+			req:        OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 1, OrderType: "limit", Price: 99.5},
+			wantStatus: paperStatusWorking,
+		},
+		{
+			name:       "market order without book fills at last price",
+			lastPrice:  100,
+			req:        OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 3, OrderType: "market"},
+			wantStatus: paperStatusFilled,
+			wantFilled: 3,
+			wantPrice:  100,
+		},
+		{
+			name:       "limit order without book crosses last price",
+			lastPrice:  100,
+			req:        OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 3, OrderType: "limit", Price: 101},
+			wantStatus: paperStatusFilled,
+			wantFilled: 3,
+			wantPrice:  100,
+		},
+		{
+			name:    "market order without any price",
+			req:     OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 1, OrderType: "market"},
+			wantErr: true,
+		},
+		{
+			name:    "limit order without price",
+			book:    book,
+			req:     OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 1, OrderType: "limit"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid side",
+			book:    book,
+			req:     OrderRequest{Symbol: "SBER", Side: "hold", Quantity: 1, OrderType: "market"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := newTestPaperBroker(tt.book)
+			if tt.lastPrice > 0 {
+				broker.UpdatePrice("SBER", tt.lastPrice, now)
+			}
+
+			response, err := broker.PlaceOrder(&tt.req)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			status, err := broker.GetOrderStatus(response.OrderID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status.Status != tt.wantStatus || status.FilledQuantity != tt.wantFilled {
+				t.Errorf("status = %s filled = %d, want %s filled = %d", status.Status, status.FilledQuantity, tt.wantStatus, tt.wantFilled)
+			}
+			if math.Abs(status.AveragePrice-tt.wantPrice) > 1e-9 {
+				t.Errorf("average price = %v, want %v", status.AveragePrice, tt.wantPrice)
+			}
+		})
+	}
+}
+
+func TestPaperBrokerConsumesBookLiquidity(t *testing.T) {
+	broker := newTestPaperBroker(&data.OrderBook{
+		Symbol: "SBER",
+		Asks:   []data.OrderBookLevel{{Price: 100, Quantity: 5}, {Price: 101, Quantity: 5}},
+	})
+
+	first, err := broker.PlaceOrder(&OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 4, OrderType: "market"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := broker.PlaceOrder(&OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 4, OrderType: "market"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		orderID   int
+		wantPrice float64
+	}{
+		{first.OrderID, 100},
+		{second.OrderID, (100 + 3*101) / 4.0},
+	}
+	for _, tt := range tests {
+		status, _ := broker.GetOrderStatus(tt.orderID)
+		if math.Abs(status.AveragePrice-tt.wantPrice) > 1e-9 {
+			t.Errorf("order %d average price = %v, want %v", tt.orderID, status.AveragePrice, tt.wantPrice)
+		}
+	}
+}
+
+func TestPaperBrokerLimitFillsOnTradeThrough(t *testing.T) {
+	now := time.Date(2024, time.July, 1, 10, 0, 0, 0, data.ExchangeLocation)
+
+	tests := []struct {
+		name       string
+		quote      data.Quote
+		wantFilled int
+	}{
+		{"trade at limit does not fill", data.Quote{Symbol: "SBER", Price: 99, Volume: 10, Time: now}, 0},
+		{"trade through limit fills up to trade volume", data.Quote{Symbol: "SBER", Price: 98.9, Volume: 2, Time: now}, 2},
+		{"trade through limit fills whole order", data.Quote{Symbol: "SBER", Price: 98.9, Volume: 10, Time: now}, 5},
+		{"bar without volume fills whole order", data.Quote{Symbol: "SBER", Price: 98.9, Time: now}, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := newTestPaperBroker(nil)
+			response, err := broker.PlaceOrder(&OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 5, OrderType: "limit", Price: 99})
+			if err != nil {
+				t.Fatal(err)
+			}
+			broker.OnQuote(tt.quote)
+
+			status, _ := broker.GetOrderStatus(response.OrderID)
+			if status.FilledQuantity != tt.wantFilled {
+				t.Errorf("filled = %d, want %d", status.FilledQuantity, tt.wantFilled)
+			}
+		})
+	}
+}
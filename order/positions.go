@@ -0,0 +1,532 @@
+package order
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"trading-bot/data"
+	"trading-bot/logger"
+)
+
+// Структура для налогового лота: часть позиции, открытая одной сделкой
+type TaxLot struct {
+	OrderID    int       `json:"order_id"`
+	Quantity   int       `json:"quantity"`   // Открытый остаток лота (в лотах инструмента, всегда положительный)
+	Price      float64   `json:"price"`      // Цена открытия
+	Commission float64   `json:"commission"` // Комиссия, приходящаяся на открытый остаток
+	Settlement float64   `json:"settlement"` // Расчетная цена последнего клиринга (для фьючерсов, 0 - клиринга еще не было)
+	Time       time.Time `json:"time"`
+
+	AccruedInterest float64 `json:"accrued_interest,omitempty"` // НКД открытия остатка лота облигаций: уплаченный (отрицательный) или полученный
+}
+
+// Функция возвращает цену, от которой считается вариационная маржа лота
+func (l *TaxLot) marginBase() float64 {
+	if l.Settlement > 0 {
+		return l.Settlement
+	}
+	return l.Price
+}
+
+// Структура для позиции и результата по инструменту. Quantity положительное для длинной
+// позиции и отрицательное для короткой. RealizedPnL и UnrealizedPnL - без учета комиссий,
+// RealizedCommission - комиссии закрытых лотов (открытия и закрытия). По фьючерсам деньги
+// движутся не по сделкам, а на клиринге: VariationMargin - вариационная маржа с прошлого клиринга.
+// Цена облигации - процент от номинала, НКД сделок и купоны учитываются отдельно от результата
+// по цене в InterestIncome
+type PositionPnL struct {
+	Symbol             string    `json:"symbol"`
+	Quantity           int       `json:"quantity"`
+	LotSize            int       `json:"lot_size"`
+	Multiplier         float64   `json:"multiplier"` // Стоимость изменения цены на единицу для одного лота
+	Futures            bool      `json:"futures"`
+	Bond               bool      `json:"bond"`
+	AveragePrice       float64   `json:"average_price"` // Средняя цена открытых лотов (FIFO)
+	LastPrice          float64   `json:"last_price"`
+	RealizedPnL        float64   `json:"realized_pnl"`
+	UnrealizedPnL      float64   `json:"unrealized_pnl"`
+	Commission         float64   `json:"commission"` // Все комиссии по инструменту
+	RealizedCommission float64   `json:"realized_commission"`
+	Slippage           float64   `json:"slippage"` // Потери на проскальзывании (уже в цене сделок)
+	VariationMargin    float64   `json:"variation_margin"`
+	PendingMargin      float64   `json:"pending_margin"`   // Вариационная маржа закрытых с прошлого клиринга лотов
+	AccruedInterest    float64   `json:"accrued_interest"` // Текущий НКД позиции по облигациям (со знаком позиции)
+	InterestIncome     float64   `json:"interest_income"`  // Купоны и НКД закрытых лотов (полученный при закрытии за вычетом уплаченного)
+	Lots               []TaxLot  `json:"lots"`             // Открытые лоты от старых к новым
+	OpenDate           time.Time `json:"open_date"`
+	LastTradeTime      time.Time `json:"last_trade_time"`
+}
+
+// Функция возвращает результат с учетом комиссий и процентного дохода
+func (p *PositionPnL) NetPnL() float64 {
+	return p.RealizedPnL + p.UnrealizedPnL + p.InterestIncome + p.UnrealizedInterest() - p.Commission
+}
+
+// Функция возвращает реализованный результат закрытых лотов с учетом их комиссий и
+// полученный процентный доход
+func (p *PositionPnL) NetRealizedPnL() float64 {
+	return p.RealizedPnL - p.RealizedCommission + p.InterestIncome
+}
+
+// Функция возвращает процентный доход открытой позиции по облигациям: текущий НКД за вычетом
+// НКД, уплаченного при открытии лотов
+func (p *PositionPnL) UnrealizedInterest() float64 {
+	interest := p.AccruedInterest
+	for _, lot := range p.Lots {
+		interest += lot.AccruedInterest
+	}
+	return interest
+}
+
+// Функция для преобразования в позицию в формате площадки
+func (p *PositionPnL) ToPosition() Position {
+	position := Position{
+		Symbol:        p.Symbol,
+		Quantity:      p.Quantity,
+		AveragePrice:  p.AveragePrice,
+		CurrentPrice:  p.LastPrice,
+		MarketValue:   p.LastPrice * float64(p.Quantity) * p.Multiplier,
+		ProfitLoss:    p.UnrealizedPnL,
+		Futures:       p.Futures,
+		Bond:          p.Bond,
+		OpenDate:      p.OpenDate,
+		LastTradeTime: p.LastTradeTime,
+	}
+	if p.Futures {
+		position.VariationMargin = p.VariationMargin
+	}
+	if p.Bond {
+		position.AccruedInterest = p.AccruedInterest
+	}
+	if p.AveragePrice > 0 && p.LastPrice > 0 {
+		position.ExpectedYield = (p.LastPrice - p.AveragePrice) / p.AveragePrice * 100
+		if p.Quantity < 0 {
+			position.ExpectedYield = -position.ExpectedYield
+		}
+	}
+	return position
+}
+
+// Структура для итогов по всем инструментам
+type PnLTotals struct {
+	RealizedPnL    float64 `json:"realized_pnl"`
+	UnrealizedPnL  float64 `json:"unrealized_pnl"`
+	InterestIncome float64 `json:"interest_income"` // Купоны и НКД, включая НКД открытых позиций
+	Commission     float64 `json:"commission"`
+	Slippage       float64 `json:"slippage"`
+	NetPnL         float64 `json:"net_pnl"`
+}
+
+// Учет позиций по сделкам с лотами FIFO: средняя цена, реализованный и нереализованный
+// результат, комиссии. Поддерживает длинные и короткие позиции. Один и тот же учет
+// используется в бою (сделки OrderManager), виртуальным брокером и в бэктесте
+type PositionKeeper struct {
+	mu        sync.Mutex
+	lotSizes  map[string]int
+	futures   map[string]float64   // Стоимость пункта цены фьючерсов
+	bonds     map[string]data.Bond // Облигации: номинал, купоны для НКД
+	positions map[string]*PositionPnL
+}
+
+// Функция для создания учета позиций. lotSizes - размеры лотов по инструментам (по умолчанию 1)
+func NewPositionKeeper(lotSizes map[string]int) *PositionKeeper {
+	return &PositionKeeper{
+		lotSizes:  lotSizes,
+		futures:   make(map[string]float64),
+		bonds:     make(map[string]data.Bond),
+		positions: make(map[string]*PositionPnL),
+	}
+}
+
+// Функция для учета инструмента как фьючерса: результат считается по стоимости пункта цены
+// контракта, деньги движутся на клиринге (см. Settle)
+func (k *PositionKeeper) SetFutures(symbol string, pointValue float64) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.futures[symbol] = pointValue
+	if position, ok := k.positions[symbol]; ok {
+		k.applySpecs(position)
+	}
+}
+
+// Функция проверяет, учитывается ли инструмент как фьючерс
+func (k *PositionKeeper) IsFutures(symbol string) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	_, ok := k.futures[symbol]
+	return ok
+}
+
+// Функция для учета инструмента как облигации: цена - процент от номинала, по сделкам
+// учитывается НКД
+func (k *PositionKeeper) SetBond(bond data.Bond) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.bonds[bond.Symbol] = bond
+	if position, ok := k.positions[bond.Symbol]; ok {
+		k.applySpecs(position)
+	}
+}
+
+// Функция проверяет, учитывается ли инструмент как облигация
+func (k *PositionKeeper) IsBond(symbol string) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	_, ok := k.bonds[symbol]
+	return ok
+}
+
+// Функция возвращает НКД по сделке с облигациями (в деньгах, без знака). Для остальных
+// инструментов - 0
+func (k *PositionKeeper) AccruedInterest(fill Fill) float64 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.fillInterest(fill)
+}
+
+// Функция возвращает НКД по сделке (вызывается под k.mu)
+func (k *PositionKeeper) fillInterest(fill Fill) float64 {
+	bond, ok := k.bonds[fill.Symbol]
+	if !ok {
+		return 0
+	}
+	return bond.AccruedInterest(bond.SettlementDate(fill.Time)) * float64(fill.Quantity*k.lotSize(fill.Symbol))
+}
+
+// Функция для зачисления купона по позиции в облигациях: amount - купон на одну облигацию.
+// Длинная позиция получает купон, короткая - компенсирует. Возвращает сумму выплаты
+func (k *PositionKeeper) ReceiveCoupon(symbol string, amount float64) float64 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	position, ok := k.positions[symbol]
+	if !ok || !position.Bond || position.Quantity == 0 {
+		return 0
+	}
+	income := amount * float64(position.Quantity*position.LotSize)
+	position.InterestIncome += income
+	return income
+}
+
+// Функция для клиринга позиции по фьючерсу: возвращает вариационную маржу с прошлого клиринга
+// (по открытым лотам от прошлой расчетной цены или цены сделки, плюс закрытые лоты), расчетная
+// цена становится базой следующего клиринга
+func (k *PositionKeeper) Settle(symbol string, price float64) float64 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	position, ok := k.positions[symbol]
+	if !ok || !position.Futures || price <= 0 {
+		return 0
+	}
+	position.LastPrice = price
+	position.recalculate()
+	margin := position.VariationMargin
+	for i := range position.Lots {
+		position.Lots[i].Settlement = price
+	}
+	position.PendingMargin = 0
+	position.recalculate()
+
+	logger.Logger.Debug().
+		Str("symbol", symbol).
+		Float64("price", price).
+		Int("position", position.Quantity).
+		Float64("variation_margin", margin).
+		Msg("Futures position settled")
+	return margin
+}
+
+// Функция для подписки на сделки менеджера ордеров
+func (k *PositionKeeper) AttachToManager(manager *OrderManager) {
+	manager.SubscribeFills(func(fill Fill) {
+		k.ApplyFill(fill)
+	})
+}
+
+// Функция для подписки на котировки из пакета data (переоценка позиций)
+func (k *PositionKeeper) AttachToFeed() {
+	data.SubscribeQuotes(func(quote data.Quote) {
+		k.Mark(quote.Symbol, quote.Price)
+	})
+}
+
+// Функция для учета сделки. Встречная сделка закрывает самые старые лоты (FIFO),
+// остаток открывает новый лот. Возвращает реализованный результат сделки
+func (k *PositionKeeper) ApplyFill(fill Fill) float64 {
+	if fill.Quantity <= 0 {
+		return 0
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	position := k.position(fill.Symbol)
+	direction := 1
+	if fill.Side == "sell" {
+		direction = -1
+	}
+
+	realized, closedCommission := 0.0, 0.0
+	remaining := fill.Quantity
+	commissionPerLot := fill.Commission / float64(fill.Quantity)
+	// НКД сделки: продавец получает, покупатель платит
+	interestPerLot := -float64(direction) * k.fillInterest(fill) / float64(fill.Quantity)
+
+	// Закрытие лотов противоположного направления
+	for remaining > 0 && len(position.Lots) > 0 && position.Quantity*direction < 0 {
+		lot := &position.Lots[0]
+		matched := min(remaining, lot.Quantity)
+		sign := 1.0
+		if position.Quantity < 0 {
+			sign = -1
+		}
+		realized += (fill.Price - lot.Price) * float64(matched) * position.Multiplier * sign
+		if position.Futures {
+			position.PendingMargin += (fill.Price - lot.marginBase()) * float64(matched) * position.Multiplier * sign
+		}
+		lotCommission := lot.Commission * float64(matched) / float64(lot.Quantity)
+		closedCommission += lotCommission + commissionPerLot*float64(matched)
+		lot.Commission -= lotCommission
+		lotInterest := lot.AccruedInterest * float64(matched) / float64(lot.Quantity)
+		position.InterestIncome += lotInterest + interestPerLot*float64(matched)
+		lot.AccruedInterest -= lotInterest
+		lot.Quantity -= matched
+		position.Quantity += matched * direction
+		remaining -= matched
+		if lot.Quantity == 0 {
+			position.Lots = position.Lots[1:]
+		}
+	}
+
+	// Остаток открывает новый лот
+	if remaining > 0 {
+		if position.Quantity == 0 {
+			position.OpenDate = fill.Time
+		}
+		position.Lots = append(position.Lots, TaxLot{
+			OrderID:    fill.OrderID,
+			Quantity:   remaining,
+			Price:      fill.Price,
+			Commission: commissionPerLot * float64(remaining),
+			Time:       fill.Time,
+
+			AccruedInterest: interestPerLot * float64(remaining),
+		})
+		position.Quantity += remaining * direction
+	}
+
+	position.RealizedPnL += realized
+	position.Commission += fill.Commission
+	position.RealizedCommission += closedCommission
+	position.Slippage += fill.Slippage
+	position.LastTradeTime = fill.Time
+	if position.LastPrice == 0 {
+		position.LastPrice = fill.Price
+	}
+	position.recalculate()
+	k.accrue(position, fill.Time)
+
+	logger.Logger.Debug().
+		Str("symbol", fill.Symbol).
+		Str("side", fill.Side).
+		Int("quantity", fill.Quantity).
+		Float64("price", fill.Price).
+		Int("position", position.Quantity).
+		Float64("realized", realized).
+		Msg("Position updated")
+	return realized
+}
+
+// Функция для переоценки позиции по цене
+func (k *PositionKeeper) Mark(symbol string, price float64) {
+	k.MarkAt(symbol, price, time.Now())
+}
+
+// Функция для переоценки позиции по цене на момент t (НКД облигаций считается на дату
+// расчетов по сделке в момент t)
+func (k *PositionKeeper) MarkAt(symbol string, price float64, t time.Time) {
+	if price <= 0 {
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if position, ok := k.positions[symbol]; ok {
+		position.LastPrice = price
+		position.recalculate()
+		k.accrue(position, t)
+	}
+}
+
+// Функция для пересчета текущего НКД позиции по облигациям (вызывается под k.mu)
+func (k *PositionKeeper) accrue(position *PositionPnL, t time.Time) {
+	bond, ok := k.bonds[position.Symbol]
+	if !ok {
+		return
+	}
+	position.AccruedInterest = bond.AccruedInterest(bond.SettlementDate(t)) * float64(position.Quantity*position.LotSize)
+}
+
+// Функция для замены позиции данными площадки (сверка): открытые лоты заменяются одним лотом
+// по средней цене площадки, реализованный результат и комиссии сохраняются
+func (k *PositionKeeper) Reset(brokerPosition Position) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	position := k.position(brokerPosition.Symbol)
+	position.Quantity = brokerPosition.Quantity
+	position.Lots = nil
+	if brokerPosition.Quantity != 0 {
+		quantity := brokerPosition.Quantity
+		if quantity < 0 {
+			quantity = -quantity
+		}
+		position.Lots = []TaxLot{{Quantity: quantity, Price: brokerPosition.AveragePrice, Time: brokerPosition.OpenDate}}
+		position.OpenDate = brokerPosition.OpenDate
+	}
+	if brokerPosition.CurrentPrice > 0 {
+		position.LastPrice = brokerPosition.CurrentPrice
+	}
+	position.recalculate()
+	k.accrue(position, time.Now())
+}
+
+// Функция возвращает позицию по инструменту (в том числе закрытую, с реализованным результатом)
+func (k *PositionKeeper) Position(symbol string) (PositionPnL, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	position, ok := k.positions[symbol]
+	if !ok {
+		return *k.newPosition(symbol), false
+	}
+	return position.snapshot(), true
+}
+
+// Функция возвращает все инструменты, по которым были сделки
+func (k *PositionKeeper) Positions() []PositionPnL {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	positions := make([]PositionPnL, 0, len(k.positions))
+	for _, position := range k.positions {
+		positions = append(positions, position.snapshot())
+	}
+	sort.Slice(positions, func(i, j int) bool { return positions[i].Symbol < positions[j].Symbol })
+	return positions
+}
+
+// Функция возвращает открытые позиции в формате площадки
+func (k *PositionKeeper) BrokerPositions() []Position {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	positions := make([]Position, 0, len(k.positions))
+	for _, position := range k.positions {
+		if position.Quantity != 0 {
+			positions = append(positions, position.ToPosition())
+		}
+	}
+	sort.Slice(positions, func(i, j int) bool { return positions[i].Symbol < positions[j].Symbol })
+	return positions
+}
+
+// Функция возвращает итоги по всем инструментам
+func (k *PositionKeeper) Totals() PnLTotals {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	var totals PnLTotals
+	for _, position := range k.positions {
+		totals.RealizedPnL += position.RealizedPnL
+		totals.UnrealizedPnL += position.UnrealizedPnL
+		totals.InterestIncome += position.InterestIncome + position.UnrealizedInterest()
+		totals.Commission += position.Commission
+		totals.Slippage += position.Slippage
+	}
+	totals.NetPnL = totals.RealizedPnL + totals.UnrealizedPnL + totals.InterestIncome - totals.Commission
+	return totals
+}
+
+// Функция возвращает позицию по инструменту, создавая ее при необходимости (вызывается под k.mu)
+func (k *PositionKeeper) position(symbol string) *PositionPnL {
+	position, ok := k.positions[symbol]
+	if !ok {
+		position = k.newPosition(symbol)
+		k.positions[symbol] = position
+	}
+	return position
+}
+
+// Функция для создания пустой позиции по инструменту (вызывается под k.mu)
+func (k *PositionKeeper) newPosition(symbol string) *PositionPnL {
+	position := &PositionPnL{Symbol: symbol}
+	k.applySpecs(position)
+	return position
+}
+
+// Функция для пересчета лота, типа инструмента и множителя позиции по текущим спецификациям.
+// Открытая позиция переоценивается с новым множителем (вызывается под k.mu)
+func (k *PositionKeeper) applySpecs(position *PositionPnL) {
+	position.LotSize = k.lotSize(position.Symbol)
+	position.Multiplier = float64(position.LotSize)
+	position.Futures, position.Bond = false, false
+	if pointValue, ok := k.futures[position.Symbol]; ok {
+		position.Futures = true
+		position.Multiplier = pointValue
+	}
+	if bond, ok := k.bonds[position.Symbol]; ok {
+		position.Bond = true
+		position.Multiplier = float64(position.LotSize) * bond.FaceValue / 100
+	}
+	position.recalculate()
+}
+
+// Функция возвращает размер лота инструмента
+func (k *PositionKeeper) lotSize(symbol string) int {
+	if lotSize, ok := k.lotSizes[symbol]; ok && lotSize > 0 {
+		return lotSize
+	}
+	return 1
+}
+
+// Функция для пересчета средней цены и нереализованного результата
+func (p *PositionPnL) recalculate() {
+	quantity, value := 0, 0.0
+	for _, lot := range p.Lots {
+		quantity += lot.Quantity
+		value += lot.Price * float64(lot.Quantity)
+	}
+	p.AveragePrice = 0
+	p.UnrealizedPnL = 0
+	p.VariationMargin = p.PendingMargin
+	if quantity == 0 {
+		return
+	}
+	p.AveragePrice = value / float64(quantity)
+	if p.LastPrice > 0 {
+		p.UnrealizedPnL = (p.LastPrice - p.AveragePrice) * float64(p.Quantity) * p.Multiplier
+	}
+	if p.Futures && p.LastPrice > 0 {
+		sign := 1.0
+		if p.Quantity < 0 {
+			sign = -1
+		}
+		for _, lot := range p.Lots {
+			p.VariationMargin += (p.LastPrice - lot.marginBase()) * float64(lot.Quantity) * p.Multiplier * sign
+		}
+	}
+}
+
+// Функция возвращает копию позиции
+func (p *PositionPnL) snapshot() PositionPnL {
+	snapshot := *p
+	snapshot.Lots = append([]TaxLot(nil), p.Lots...)
+	return snapshot
+}
@@ -0,0 +1,175 @@
+package order
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"trading-bot/data"
+)
+
+func TestPositionKeeperApplyFill(t *testing.T) {
+	fill := func(side string, quantity int, price, commission float64) Fill {
+		return Fill{Symbol: "SBER", Side: side, Quantity: quantity, Price: price, Commission: commission, Time: time.Now()}
+	}
+
+	tests := []struct {
+		name               string
+		lotSize            int
+		fills              []Fill
+		mark               float64 // Цена переоценки (0 - без переоценки)
+		wantQuantity       int
+		wantAverage        float64
+		wantRealized       float64
+		wantUnrealized     float64
+		wantRealizedCharge float64
+		wantLots           int
+	}{
+		{
+			name:         "open long",
+			fills:        []Fill{fill("buy", 10, 100, 0)},
+			wantQuantity: 10, wantAverage: 100, wantLots: 1,
+		},
+		{
+			name:         "average of lots",
+			fills:        []Fill{fill("buy", 5, 100, 0), fill("buy", 5, 110, 0)},
+			mark:         105,
+			wantQuantity: 10, wantAverage: 105, wantLots: 2,
+		},
+		{
+			name:         "sell closes oldest lots first",
+			fills:        []Fill{fill("buy", 5, 100, 0), fill("buy", 5, 110, 0), fill("sell", 6, 120, 0)},
+			mark:         120,
+			wantQuantity: 4, wantAverage: 110, wantRealized: 5*20 + 1*10, wantUnrealized: 40, wantLots: 1,
+		},
+		{
+			name:         "sell through zero opens short",
+			fills:        []Fill{fill("buy", 5, 100, 0), fill("sell", 8, 90, 0)},
+			mark:         92,
+			wantQuantity: -3, wantAverage: 90, wantRealized: -50, wantUnrealized: -6, wantLots: 1,
+		},
+		{
+			name:         "cover short with profit",
+			fills:        []Fill{fill("sell", 5, 100, 0), fill("buy", 5, 90, 0)},
+			wantQuantity: 0, wantRealized: 50,
+		},
+		{
+			name:         "short unrealized loss",
+			fills:        []Fill{fill("sell", 5, 100, 0)},
+			mark:         104,
+			wantQuantity: -5, wantAverage: 100, wantUnrealized: -20, wantLots: 1,
+		},
+		{
+			name:         "lot size multiplies result",
+			lotSize:      10,
+			fills:        []Fill{fill("buy", 2, 100, 0), fill("sell", 2, 101.5, 0)},
+			wantQuantity: 0, wantRealized: 30,
+		},
+		{
+			name:         "commission of closed part",
+			fills:        []Fill{fill("buy", 10, 100, 10), fill("sell", 5, 110, 4)},
+			wantQuantity: 5, wantAverage: 100, wantRealized: 50, wantRealizedCharge: 5 + 4, wantLots: 1,
+		},
+		{
+			name:         "zero quantity is ignored",
+			fills:        []Fill{fill("buy", 0, 100, 0)},
+			wantQuantity: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lotSizes map[string]int
+			if tt.lotSize > 0 {
+				lotSizes = map[string]int{"SBER": tt.lotSize}
+			}
+			keeper := NewPositionKeeper(lotSizes)
+			for _, f := range tt.fills {
+				keeper.ApplyFill(f)
+			}
+			if tt.mark > 0 {
+				keeper.Mark("SBER", tt.mark)
+			}
+
+			position, _ := keeper.Position("SBER")
+			checks := []struct {
+				field     string
+				got, want float64
+			}{
+				{"quantity", float64(position.Quantity), float64(tt.wantQuantity)},
+				{"average price", position.AveragePrice, tt.wantAverage},
+				{"realized", position.RealizedPnL, tt.wantRealized},
+				{"unrealized", position.UnrealizedPnL, tt.wantUnrealized},
+				{"realized commission", position.RealizedCommission, tt.wantRealizedCharge},
+				{"lots", float64(len(position.Lots)), float64(tt.wantLots)},
+			}
+			for _, check := range checks {
+				if math.Abs(check.got-check.want) > 1e-9 {
+					t.Errorf("%s = %v, want %v", check.field, check.got, check.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPositionKeeperSpecsUpdateExistingPositions(t *testing.T) {
+	bond := data.Bond{Symbol: "SU26238", FaceValue: 1000}
+
+	tests := []struct {
+		name           string
+		symbol         string
+		lotSize        int
+		apply          func(keeper *PositionKeeper)
+		wantMultiplier float64
+		wantFutures    bool
+		wantBond       bool
+		wantUnrealized float64
+	}{
+		{
+			name:           "share",
+			symbol:         "SBER",
+			lotSize:        10,
+			apply:          func(keeper *PositionKeeper) {},
+			wantMultiplier: 10,
+			wantUnrealized: 2 * 10 * 1,
+		},
+		{
+			name:           "futures registered after position opened",
+			symbol:         "SiU4",
+			apply:          func(keeper *PositionKeeper) { keeper.SetFutures("SiU4", 2) },
+			wantMultiplier: 2,
+			wantFutures:    true,
+			wantUnrealized: 2 * 2 * 1,
+		},
+		{
+			name:           "bond registered after position opened",
+			symbol:         bond.Symbol,
+			apply:          func(keeper *PositionKeeper) { keeper.SetBond(bond) },
+			wantMultiplier: 10,
+			wantBond:       true,
+			wantUnrealized: 2 * 10 * 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lotSizes map[string]int
+			if tt.lotSize > 0 {
+				lotSizes = map[string]int{tt.symbol: tt.lotSize}
+			}
+			keeper := NewPositionKeeper(lotSizes)
+			keeper.ApplyFill(Fill{Symbol: tt.symbol, Side: "buy", Quantity: 2, Price: 100})
+			tt.apply(keeper)
+			keeper.Mark(tt.symbol, 101)
+
+			position, _ := keeper.Position(tt.symbol)
+			if position.Multiplier != tt.wantMultiplier || position.Futures != tt.wantFutures || position.Bond != tt.wantBond {
+				t.Errorf("multiplier = %v futures = %v bond = %v, want %v %v %v",
+					position.Multiplier, position.Futures, position.Bond, tt.wantMultiplier, tt.wantFutures, tt.wantBond)
+			}
+			if math.Abs(position.UnrealizedPnL-tt.wantUnrealized) > 1e-9 {
+				t.Errorf("unrealized = %v, want %v", position.UnrealizedPnL, tt.wantUnrealized)
+			}
+		})
+	}
+}
@@ -0,0 +1,514 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"trading-bot/logger"
+)
+
+// Виды расхождений с площадкой
+type DiscrepancyKind string
+
+const (
+	DiscrepancyUnknownOrder     DiscrepancyKind = "unknown_order"     // Рабочий ордер площадки, неизвестный менеджеру (выставлен в другом терминале)
+	DiscrepancyMissingOrder     DiscrepancyKind = "missing_order"     // Открытый ордер менеджера, которого нет среди рабочих ордеров площадки
+	DiscrepancyQuantityMismatch DiscrepancyKind = "quantity_mismatch" // Позиция в учете не совпадает с позицией площадки
+	DiscrepancyCashMismatch     DiscrepancyKind = "cash_mismatch"     // Денежный остаток не совпадает с ожидаемым по сделкам
+)
+
+// Действия при расхождении
+type ReconcileAction string
+
+const (
+	ActionReport  ReconcileAction = "report"  // Только отчет
+	ActionCorrect ReconcileAction = "correct" // Исправить внутреннее состояние по данным площадки
+	ActionHalt    ReconcileAction = "halt"    // Включить аварийную остановку
+)
+
+// Структура для настройки сверки с площадкой
+type ReconcileConfig struct {
+	IntervalMinutes int                                 `json:"interval_minutes"` // Период сверки (0 - только вручную)
+	ReportPath      string                              `json:"report_path"`      // Журнал отчетов (JSONL)
+	Currency        string                              `json:"currency"`         // Валюта денежного остатка
+	CashTolerance   float64                             `json:"cash_tolerance"`   // Допустимое расхождение денег
+	HistoryDays     int                                 `json:"history_days"`     // Глубина истории ордеров (в днях)
+	Actions         map[DiscrepancyKind]ReconcileAction `json:"actions"`          // Действия по видам расхождений
+	Account         string                              `json:"account"`          // Счет для отметки в отчетах
+}
+
+// Функция возвращает настройки сверки по умолчанию: ордера и деньги исправляются,
+// расхождение позиций останавливает торговлю
+func DefaultReconcileConfig() ReconcileConfig {
+	return ReconcileConfig{
+		IntervalMinutes: 5,
+		ReportPath:      "logs/reconcile.jsonl",
+		Currency:        DefaultCurrency,
+		CashTolerance:   1,
+		HistoryDays:     1,
+		Actions: map[DiscrepancyKind]ReconcileAction{
+			DiscrepancyUnknownOrder:     ActionCorrect,
+			DiscrepancyMissingOrder:     ActionCorrect,
+			DiscrepancyQuantityMismatch: ActionHalt,
+			DiscrepancyCashMismatch:     ActionCorrect,
+		},
+	}
+}
+
+// Структура для одного расхождения
+type Discrepancy struct {
+	Kind     DiscrepancyKind `json:"kind"`
+	Symbol   string          `json:"symbol,omitempty"`
+	OrderID  int             `json:"order_id,omitempty"`
+	Expected float64         `json:"expected"` // Значение во внутреннем учете
+	Actual   float64         `json:"actual"`   // Значение на площадке
+	Action   ReconcileAction `json:"action"`
+	Message  string          `json:"message"`
+}
+
+// Структура для отчета сверки
+type ReconcileReport struct {
+	Time          time.Time     `json:"time"`
+	Account       string        `json:"account,omitempty"`
+	Initial       bool          `json:"initial"` // Первая сверка после запуска: учет позиций заполняется данными площадки
+	Discrepancies []Discrepancy `json:"discrepancies"`
+	Halted        bool          `json:"halted"`
+	Errors        []string      `json:"errors,omitempty"`
+}
+
+// Сверка внутреннего состояния (ордера OrderManager, позиции PositionKeeper, деньги)
+// с площадкой по GetPortfolioInfo и GetOrdersHistory
+type Reconciler struct {
+	manager *OrderManager
+	keeper  *PositionKeeper
+	config  ReconcileConfig
+
+	mu           sync.Mutex
+	killSwitch   *KillSwitch
+	valuation    *Valuation // Валюты инструментов: движение денег учитывается только в валюте сверки
+	initialized  bool
+	expectedCash float64 // Остаток на момент последней сверки плюс движение денег по сделкам
+	lastReport   *ReconcileReport
+	portfolios   []func(portfolio *PortfolioInfo)
+
+	stop chan bool
+}
+
+// Функция для создания сверки. Движение денег отслеживается по сделкам менеджера
+func NewReconciler(manager *OrderManager, keeper *PositionKeeper, config ReconcileConfig) *Reconciler {
+	defaults := DefaultReconcileConfig()
+	if config.Currency == "" {
+		config.Currency = defaults.Currency
+	}
+	if config.HistoryDays <= 0 {
+		config.HistoryDays = defaults.HistoryDays
+	}
+	if config.Actions == nil {
+		config.Actions = defaults.Actions
+	}
+
+	r := &Reconciler{
+		manager: manager,
+		keeper:  keeper,
+		config:  config,
+		stop:    make(chan bool),
+	}
+	manager.SubscribeFills(r.onFill)
+	return r
+}
+
+// Функция для подключения валют инструментов: сделки, выплаты и клиринг в других валютах
+// не меняют ожидаемый остаток валюты сверки
+func (r *Reconciler) AttachValuation(valuation *Valuation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.valuation = valuation
+}
+
+// Функция проверяет, идут ли расчеты по инструменту в валюте сверки (вызывается под r.mu)
+func (r *Reconciler) inCurrency(symbol string) bool {
+	return r.valuation.Currency(symbol) == r.config.Currency
+}
+
+// Функция для подключения аварийной остановки (действие halt)
+func (r *Reconciler) AttachKillSwitch(killSwitch *KillSwitch) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.killSwitch = killSwitch
+}
+
+// Функция для подписки на портфель площадки, полученный при каждой сверке (например, для
+// базы дневного убытка)
+func (r *Reconciler) SubscribePortfolio(handler func(portfolio *PortfolioInfo)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.portfolios = append(r.portfolios, handler)
+}
+
+// Функция для запуска периодической сверки
+func (r *Reconciler) Start() {
+	if r.config.IntervalMinutes <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Duration(r.config.IntervalMinutes) * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := r.Run(); err != nil {
+					logger.Logger.Error().Err(err).Msg("Reconciliation failed")
+				}
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Функция для остановки периодической сверки
+func (r *Reconciler) Stop() {
+	close(r.stop)
+}
+
+// Функция возвращает последний отчет сверки
+func (r *Reconciler) LastReport() *ReconcileReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastReport
+}
+
+// Функция для выполнения сверки
+func (r *Reconciler) Run() (*ReconcileReport, error) {
+	broker := r.manager.Broker()
+	portfolio, err := broker.GetPortfolioInfo()
+	if err != nil {
+		return nil, fmt.Errorf("error getting portfolio for reconciliation: %w", err)
+	}
+	history, err := broker.GetOrdersHistory(&OrdersHistoryRequest{
+		From: time.Now().AddDate(0, 0, -r.config.HistoryDays),
+		To:   time.Now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting orders history for reconciliation: %w", err)
+	}
+
+	r.mu.Lock()
+	initial := !r.initialized
+	handlers := r.portfolios
+	r.mu.Unlock()
+	for _, handler := range handlers {
+		handler(portfolio)
+	}
+
+	report := &ReconcileReport{Time: time.Now(), Account: r.config.Account, Initial: initial}
+	r.reconcileOrders(report, history.Orders)
+	r.reconcilePositions(report, portfolio.Positions)
+	r.reconcileCash(report, portfolio.Balances[r.config.Currency].Value)
+
+	halt := false
+	for _, discrepancy := range report.Discrepancies {
+		if discrepancy.Action == ActionHalt {
+			halt = true
+		}
+	}
+
+	r.mu.Lock()
+	r.initialized = true
+	r.lastReport = report
+	killSwitch := r.killSwitch
+	r.mu.Unlock()
+
+	if halt {
+		if killSwitch != nil {
+			killSwitch.Trigger(KillSourceReconcile, fmt.Sprintf("%d discrepancies with broker", len(report.Discrepancies)))
+			report.Halted = true
+		} else {
+			report.Errors = append(report.Errors, "halt requested but no kill switch attached")
+		}
+	}
+
+	for _, discrepancy := range report.Discrepancies {
+		logger.Logger.Warn().
+			Str("kind", string(discrepancy.Kind)).
+			Str("symbol", discrepancy.Symbol).
+			Int("order_id", discrepancy.OrderID).
+			Float64("expected", discrepancy.Expected).
+			Float64("actual", discrepancy.Actual).
+			Str("action", string(discrepancy.Action)).
+			Msg(discrepancy.Message)
+	}
+	logger.Logger.Info().
+		Int("discrepancies", len(report.Discrepancies)).
+		Bool("halted", report.Halted).
+		Bool("initial", initial).
+		Msg("Reconciliation completed")
+
+	if r.config.ReportPath != "" {
+		if err := appendJSONLine(r.config.ReportPath, report); err != nil {
+			logger.Logger.Error().Err(err).Str("path", r.config.ReportPath).Msg("Failed to write reconciliation report")
+		}
+	}
+	return report, nil
+}
+
+// Функция для сверки ордеров: рабочие ордера площадки против открытых ордеров менеджера
+func (r *Reconciler) reconcileOrders(report *ReconcileReport, history []OrderHistory) {
+	brokerOrders := make(map[int]OrderHistory, len(history))
+	for _, order := range history {
+		brokerOrders[order.OrderID] = order
+	}
+
+	ids := make([]int, 0, len(brokerOrders))
+	for id := range brokerOrders {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		order := brokerOrders[id]
+		state, ok := ParseOrderState(order.Status)
+		if !ok || !state.IsOpen() {
+			continue
+		}
+		if _, known := r.manager.GetOrder(id); known {
+			continue
+		}
+		discrepancy := Discrepancy{
+			Kind:    DiscrepancyUnknownOrder,
+			Symbol:  order.Symbol,
+			OrderID: id,
+			Actual:  float64(order.Quantity),
+			Action:  r.action(DiscrepancyUnknownOrder),
+			Message: fmt.Sprintf("broker has working %s order %d unknown to order manager", order.Side, id),
+		}
+		if discrepancy.Action == ActionCorrect {
+			r.adopt(order, state)
+		}
+		report.Discrepancies = append(report.Discrepancies, discrepancy)
+	}
+
+	for _, managed := range r.manager.OpenOrders("") {
+		order, found := brokerOrders[managed.ID]
+		state, _ := ParseOrderState(order.Status)
+		if found && state.IsOpen() {
+			continue
+		}
+		message := fmt.Sprintf("order %d is %s locally but not found at broker", managed.ID, managed.State)
+		if found {
+			message = fmt.Sprintf("order %d is %s locally but %s at broker", managed.ID, managed.State, order.Status)
+		}
+		discrepancy := Discrepancy{
+			Kind:     DiscrepancyMissingOrder,
+			Symbol:   managed.Request.Symbol,
+			OrderID:  managed.ID,
+			Expected: float64(managed.RemainingQuantity()),
+			Action:   r.action(DiscrepancyMissingOrder),
+			Message:  message,
+		}
+		if discrepancy.Action == ActionCorrect {
+			if err := r.correctOrder(managed, found, state); err != nil {
+				report.Errors = append(report.Errors, err.Error())
+			}
+		}
+		report.Discrepancies = append(report.Discrepancies, discrepancy)
+	}
+}
+
+// Функция для сверки позиций учета с позициями площадки. При первой сверке
+// учет заполняется позициями площадки
+func (r *Reconciler) reconcilePositions(report *ReconcileReport, brokerPositions []Position) {
+	actual := make(map[string]Position, len(brokerPositions))
+	for _, position := range brokerPositions {
+		actual[position.Symbol] = position
+	}
+	expected := make(map[string]Position)
+	for _, position := range r.keeper.BrokerPositions() {
+		expected[position.Symbol] = position
+	}
+
+	symbols := make([]string, 0, len(actual)+len(expected))
+	for symbol := range actual {
+		symbols = append(symbols, symbol)
+	}
+	for symbol := range expected {
+		if _, ok := actual[symbol]; !ok {
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.Strings(symbols)
+
+	for _, symbol := range symbols {
+		brokerPosition, local := actual[symbol], expected[symbol]
+		if brokerPosition.Quantity == local.Quantity {
+			continue
+		}
+		action := r.action(DiscrepancyQuantityMismatch)
+		if report.Initial {
+			action = ActionCorrect
+		}
+		if action == ActionCorrect {
+			brokerPosition.Symbol = symbol
+			r.keeper.Reset(brokerPosition)
+		}
+		report.Discrepancies = append(report.Discrepancies, Discrepancy{
+			Kind:     DiscrepancyQuantityMismatch,
+			Symbol:   symbol,
+			Expected: float64(local.Quantity),
+			Actual:   float64(brokerPosition.Quantity),
+			Action:   action,
+			Message:  fmt.Sprintf("position %s is %d locally but %d at broker", symbol, local.Quantity, brokerPosition.Quantity),
+		})
+	}
+}
+
+// Функция для сверки денежного остатка с ожидаемым по сделкам
+func (r *Reconciler) reconcileCash(report *ReconcileReport, actual float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if report.Initial {
+		r.expectedCash = actual
+		return
+	}
+	expected := r.expectedCash
+	if math.Abs(actual-expected) <= r.config.CashTolerance {
+		return
+	}
+
+	action := r.action(DiscrepancyCashMismatch)
+	if action == ActionCorrect {
+		r.expectedCash = actual
+	}
+	report.Discrepancies = append(report.Discrepancies, Discrepancy{
+		Kind:     DiscrepancyCashMismatch,
+		Expected: expected,
+		Actual:   actual,
+		Action:   action,
+		Message:  fmt.Sprintf("%s cash is %.2f by fills but %.2f at broker", r.config.Currency, expected, actual),
+	})
+}
+
+// Функция для учета вариационной маржи, зачисленной площадкой на клиринге
+func (r *Reconciler) ApplyVariationMargin(margins []VariationMargin) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, margin := range margins {
+		if r.inCurrency(margin.Symbol) {
+			r.expectedCash += margin.Amount
+		}
+	}
+}
+
+// Функция для учета выплат по облигациям (купонов и погашения) в ожидаемых деньгах
+func (r *Reconciler) ApplyCouponPayments(payments []CouponPayment) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, payment := range payments {
+		if r.inCurrency(payment.Symbol) {
+			r.expectedCash += payment.Amount
+		}
+	}
+}
+
+// Функция для учета движения денег по сделке
+func (r *Reconciler) onFill(fill Fill) {
+	position, _ := r.keeper.Position(fill.Symbol)
+	amount := fill.Price * float64(fill.Quantity) * position.Multiplier
+	if position.Futures {
+		amount = 0 // Деньги по фьючерсу движутся на клиринге (ApplyVariationMargin)
+	}
+	amount += r.keeper.AccruedInterest(fill) // НКД по облигациям
+	if fill.Side == "buy" {
+		amount = -amount
+	}
+
+	r.mu.Lock()
+	if r.inCurrency(fill.Symbol) {
+		r.expectedCash += amount - fill.Commission
+	}
+	r.mu.Unlock()
+}
+
+// Функция для принятия под управление ордера, выставленного в обход менеджера.
+// Уже исполненная часть не порождает сделок: она учтена в позиции площадки
+func (r *Reconciler) adopt(order OrderHistory, state OrderState) {
+	filled := 0
+	if status, err := r.manager.Broker().GetOrderStatus(order.OrderID); err == nil {
+		filled = status.FilledQuantity
+	}
+	if filled > 0 && state == StateWorking {
+		state = StatePartiallyFilled
+	}
+
+	now := time.Now()
+	r.manager.adopt(ManagedOrder{
+		ID: order.OrderID,
+		Request: OrderRequest{
+			Symbol:        order.Symbol,
+			Side:          order.Side,
+			Quantity:      order.Quantity,
+			OrderType:     order.OrderType,
+			Price:         order.Price,
+			StopPrice:     order.StopPrice,
+			ClientOrderID: order.ClientOrderID,
+		},
+		State:          state,
+		FilledQuantity: filled,
+		Message:        "adopted by reconciliation",
+		Created:        now,
+		Updated:        now,
+	})
+}
+
+// Функция для исправления состояния ордера, которого нет среди рабочих ордеров площадки.
+// Ордер, который площадка по запросу статуса считает рабочим (например, GTC старше глубины
+// истории), остается открытым; принудительно закрывается только ордер, закрытый по истории
+// или отсутствующий на площадке
+func (r *Reconciler) correctOrder(managed ManagedOrder, found bool, brokerState OrderState) error {
+	err := r.manager.refresh(managed.ID)
+	current, ok := r.manager.GetOrder(managed.ID)
+	if !ok || current.State.IsTerminal() {
+		return nil
+	}
+	if err == nil {
+		// Статус прочитан, а ордер остался открытым: площадка считает его рабочим
+		return nil
+	}
+	if !errors.Is(err, ErrOrderNotFound) && !(found && brokerState.IsTerminal()) {
+		return fmt.Errorf("order %d left %s, status unknown: %w", managed.ID, current.State, err)
+	}
+
+	state, message := StateExpired, "not found at broker"
+	if found && brokerState.IsTerminal() {
+		state, message = brokerState, "closed at broker"
+	}
+	if !current.State.CanTransitionTo(state) {
+		state = StateCancelled
+	}
+	if err := r.manager.ApplyUpdate(OrderUpdate{
+		OrderID:        managed.ID,
+		Status:         string(state),
+		FilledQuantity: current.FilledQuantity,
+		AveragePrice:   current.AveragePrice,
+		Commission:     current.Commission,
+		Message:        message,
+	}); err != nil {
+		return fmt.Errorf("error correcting order %d: %w", managed.ID, err)
+	}
+	return nil
+}
+
+// Функция возвращает действие для вида расхождения (по умолчанию - отчет)
+func (r *Reconciler) action(kind DiscrepancyKind) ReconcileAction {
+	if action, ok := r.config.Actions[kind]; ok {
+		return action
+	}
+	return ActionReport
+}
@@ -0,0 +1,65 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Функция создает сверку с открытым ордером 7, которого нет в истории площадки
+// (GTC-ордер старше HistoryDays)
+func newTestReconciler(broker *stubBroker) (*Reconciler, *OrderManager) {
+	manager := NewOrderManager(broker)
+	manager.adopt(ManagedOrder{
+		ID:      7,
+		Request: OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 10, OrderType: "limit", Price: 250},
+		State:   StateWorking,
+		Created: time.Now().AddDate(0, 0, -5),
+		Updated: time.Now().AddDate(0, 0, -5),
+	})
+	return NewReconciler(manager, NewPositionKeeper(nil), ReconcileConfig{}), manager
+}
+
+func TestReconcilerKeepsOrderWorkingAtBroker(t *testing.T) {
+	reconciler, manager := newTestReconciler(&stubBroker{status: "working"})
+
+	report, err := reconciler.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Errors) != 0 {
+		t.Errorf("report errors = %v", report.Errors)
+	}
+	if order, _ := manager.GetOrder(7); order.State != StateWorking {
+		t.Errorf("order state = %s, want %s: broker still reports it working", order.State, StateWorking)
+	}
+}
+
+func TestReconcilerClosesOrderMissingAtBroker(t *testing.T) {
+	tests := []struct {
+		name      string
+		statusErr error
+		want      OrderState
+	}{
+		{name: "not found", statusErr: fmt.Errorf("order 7: %w", ErrOrderNotFound), want: StateExpired},
+		{name: "status unavailable", statusErr: errors.New("connection reset by peer"), want: StateWorking},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reconciler, manager := newTestReconciler(&stubBroker{statusErr: tt.statusErr})
+
+			report, err := reconciler.Run()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if order, _ := manager.GetOrder(7); order.State != tt.want {
+				t.Errorf("order state = %s, want %s", order.State, tt.want)
+			}
+			// Неизвестный статус попадает в ошибки отчета, ордер ждет следующей сверки
+			if unknown := tt.want.IsOpen(); unknown != (len(report.Errors) == 1) {
+				t.Errorf("report errors = %v", report.Errors)
+			}
+		})
+	}
+}
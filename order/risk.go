@@ -0,0 +1,824 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"trading-bot/data"
+	"trading-bot/logger"
+)
+
+// Ошибка для ордера, отклоненного проверками риска
+var ErrRiskRejected = errors.New("order rejected by risk check")
+
+// Ошибка оценки ордера без цены: рыночный ордер по инструменту без последней котировки
+var errNoOrderPrice = errors.New("no limit price or last quote")
+
+// Структура для причины отклонения ордера проверкой риска
+type RiskRejection struct {
+	Time     time.Time `json:"time"`
+	Check    string    `json:"check"`  // Название проверки
+	Reason   string    `json:"reason"` // Описание причины
+	Symbol   string    `json:"symbol"`
+	Side     string    `json:"side"`
+	Quantity int       `json:"quantity"`
+	Price    float64   `json:"price"`
+	Limit    float64   `json:"limit"` // Значение лимита
+	Value    float64   `json:"value"` // Значение, нарушившее лимит
+}
+
+func (r *RiskRejection) Error() string {
+	return fmt.Sprintf("risk check %s: %s (value %g, limit %g)", r.Check, r.Reason, r.Value, r.Limit)
+}
+
+// Отклонение проверкой риска - окончательный отказ, ордер на площадку не уходил
+func (r *RiskRejection) Is(target error) bool {
+	return target == ErrRiskRejected || target == ErrOrderRejected
+}
+
+// Структура с данными для проверки ордера. Стоимости ордера и позиций и стоимость портфеля
+// приводятся к базовой валюте Valuation
+type RiskContext struct {
+	Request   *OrderRequest
+	Price     float64 // Оценка цены исполнения: лимитная цена или последняя котировка
+	LastPrice float64 // Последняя котировка (0, если неизвестна)
+	LotSize   int     // Размер лота инструмента
+	UnitPrice float64 // Стоимость одной бумаги по цене Price в валюте инструмента (для облигаций - от номинала, с НКД)
+	Currency  string  // Валюта торгов инструмента
+	Positions []Position
+	Balances  map[string]Balance
+	Working   []WorkingOrder // Остатки открытых ордеров, кроме проверяемого
+	Valuation *Valuation     // Оценка в базовой валюте (nil - все суммы в одной валюте)
+	Now       time.Time
+}
+
+// Структура для неисполненного остатка рабочего ордера: проверки позиции и экспозиции
+// считают, что он еще может исполниться
+type WorkingOrder struct {
+	Symbol    string
+	Side      string
+	Quantity  int     // Неисполненный остаток (в лотах)
+	LotSize   int     // Размер лота инструмента
+	UnitPrice float64 // Стоимость одной бумаги в валюте инструмента (0 - цена неизвестна)
+	Currency  string  // Валюта торгов инструмента
+}
+
+// Функция возвращает оценку стоимости ордера в базовой валюте (ошибка - цена ордера или курс
+// валюты неизвестны)
+func (c *RiskContext) OrderValue() (float64, error) {
+	if c.Price <= 0 {
+		return 0, errNoOrderPrice
+	}
+	return c.Valuation.ToBase(c.LocalOrderValue(), c.Currency)
+}
+
+// Функция возвращает оценку стоимости ордера в валюте инструмента
+func (c *RiskContext) LocalOrderValue() float64 {
+	return c.UnitPrice * float64(c.Request.Quantity*c.LotSize)
+}
+
+// Функция возвращает рыночную стоимость позиции в базовой валюте (ошибка - курс валюты неизвестен)
+func (c *RiskContext) PositionValue(position Position) (float64, error) {
+	return c.Valuation.ToBase(position.MarketValue, c.Valuation.Currency(position.Symbol))
+}
+
+// Функция возвращает оценку стоимости остатка рабочего ордера в базовой валюте со знаком
+// (продажа - отрицательная)
+func (c *RiskContext) WorkingValue(order WorkingOrder) (float64, error) {
+	if order.UnitPrice <= 0 {
+		return 0, errNoOrderPrice
+	}
+	value, err := c.Valuation.ToBase(order.UnitPrice*float64(order.Quantity*order.LotSize), order.Currency)
+	if order.Side == "sell" {
+		value = -value
+	}
+	return value, err
+}
+
+// Функция возвращает остаток рабочих ордеров по инструменту ордера в ту же сторону
+// (в лотах, со знаком)
+func (c *RiskContext) WorkingLots() int {
+	lots := 0
+	for _, order := range c.Working {
+		if order.Symbol == c.Request.Symbol && order.Side == c.Request.Side {
+			lots += order.Quantity
+		}
+	}
+	if c.Request.Side == "sell" {
+		return -lots
+	}
+	return lots
+}
+
+// Функция возвращает позицию по инструменту ордера (в лотах, со знаком)
+func (c *RiskContext) PositionLots() int {
+	for _, position := range c.Positions {
+		if position.Symbol == c.Request.Symbol {
+			return position.Quantity
+		}
+	}
+	return 0
+}
+
+// Функция возвращает изменение позиции в лотах после исполнения ордера
+func (c *RiskContext) SignedQuantity() int {
+	if c.Request.Side == "sell" {
+		return -c.Request.Quantity
+	}
+	return c.Request.Quantity
+}
+
+// Функция проверяет, увеличивает ли ордер абсолютную позицию с учетом исполнения рабочих
+// ордеров в ту же сторону
+func (c *RiskContext) IncreasesPosition() bool {
+	current := c.PositionLots() + c.WorkingLots()
+	return abs(current+c.SignedQuantity()) > abs(current)
+}
+
+// Функция возвращает стоимость портфеля в базовой валюте: деньги и позиции (ошибка - курс
+// какой-либо валюты неизвестен, оценка занижена)
+func (c *RiskContext) Equity() (float64, error) {
+	if c.Valuation != nil {
+		return c.Valuation.Equity(&PortfolioInfo{Balances: c.Balances, Positions: c.Positions})
+	}
+	var equity float64
+	for _, balance := range c.Balances {
+		equity += balance.Value
+	}
+	for _, position := range c.Positions {
+		equity += position.EquityValue()
+	}
+	return equity, nil
+}
+
+// Функция возвращает отказ из-за неизвестной цены или курса: денежные лимиты без оценки
+// в базовой валюте не проверить, поэтому ордер отклоняется
+func valuationRejection(err error) *RiskRejection {
+	return &RiskRejection{Reason: "cannot value order: " + err.Error()}
+}
+
+// Интерфейс одной проверки риска. Check возвращает nil, если ордер допустим.
+type RiskCheck interface {
+	Name() string
+	Check(ctx *RiskContext) *RiskRejection
+}
+
+// Интерфейс проверки, которой нужно знать о принятых площадкой ордерах (например, лимит частоты)
+type riskRecorder interface {
+	Record(ctx *RiskContext)
+}
+
+// Интерфейс проверки, которой нужна оценка портфеля вне проверки ордеров (например, база
+// дневного убытка)
+type portfolioObserver interface {
+	Observe(ctx *RiskContext)
+}
+
+// Структура для лимитов риска (нулевое значение - проверка отключена). Денежные лимиты -
+// в базовой валюте оценки портфеля
+type RiskLimits struct {
+	MaxOrderValue      float64            `json:"max_order_value"`    // Максимальная стоимость одного ордера
+	MaxPositionLots    int                `json:"max_position_lots"`  // Максимальная позиция по инструменту (в лотах)
+	PositionLots       map[string]int     `json:"position_lots"`      // Лимиты позиции по отдельным инструментам
+	MaxGrossExposure   float64            `json:"max_gross_exposure"` // Максимальная сумма позиций по модулю
+	MaxNetExposure     float64            `json:"max_net_exposure"`   // Максимальная сумма позиций с учетом знака (по модулю)
+	MaxOrdersPerMinute int                `json:"max_orders_per_minute"`
+	PriceCollarPercent float64            `json:"price_collar_percent"`  // Допустимое отклонение цены ордера от последней котировки
+	PriceSteps         map[string]float64 `json:"price_steps"`           // Шаг цены по инструментам
+	LotSizes           map[string]int     `json:"lot_sizes"`             // Размер лота по инструментам
+	DailyLossLimit     float64            `json:"daily_loss_limit"`      // Максимальный убыток за день
+	DailyLossStatePath string             `json:"daily_loss_state_path"` // Файл базы дневного убытка (переживает перезапуск)
+	CheckCash          bool               `json:"check_cash"`            // Проверять достаточность денег для покупки
+}
+
+// Функция возвращает набор проверок по лимитам
+func NewRiskChecks(limits RiskLimits) []RiskCheck {
+	var checks []RiskCheck
+	checks = append(checks, &LotStepCheck{PriceSteps: limits.PriceSteps})
+	if limits.PriceCollarPercent > 0 {
+		checks = append(checks, &PriceCollarCheck{Percent: limits.PriceCollarPercent})
+	}
+	if limits.MaxOrderValue > 0 {
+		checks = append(checks, &MaxOrderValueCheck{Limit: limits.MaxOrderValue})
+	}
+	if limits.MaxPositionLots > 0 || len(limits.PositionLots) > 0 {
+		checks = append(checks, &MaxPositionCheck{Default: limits.MaxPositionLots, PerSymbol: limits.PositionLots})
+	}
+	if limits.MaxGrossExposure > 0 || limits.MaxNetExposure > 0 {
+		checks = append(checks, &ExposureCheck{MaxGross: limits.MaxGrossExposure, MaxNet: limits.MaxNetExposure})
+	}
+	if limits.MaxOrdersPerMinute > 0 {
+		checks = append(checks, &OrderRateCheck{MaxOrders: limits.MaxOrdersPerMinute, Window: time.Minute})
+	}
+	if limits.DailyLossLimit > 0 {
+		checks = append(checks, NewDailyLossCheck(limits.DailyLossLimit, limits.DailyLossStatePath))
+	}
+	if limits.CheckCash {
+		checks = append(checks, &CashCheck{})
+	}
+	return checks
+}
+
+// Движок проверок риска перед отправкой ордера. Проверки выполняются по порядку до первого отказа,
+// каждый отказ пишется в журнал аудита (JSONL).
+type RiskEngine struct {
+	mu          sync.Mutex
+	checks      []RiskCheck
+	lotSizes    map[string]int
+	bonds       *BondSpecs
+	valuation   *Valuation
+	priceSource func(symbol string) (float64, bool)
+	auditPath   string
+	handlers    []func(rejection *RiskRejection)
+}
+
+// Функция для создания движка проверок риска. auditPath - файл журнала отказов (пустой - без журнала)
+func NewRiskEngine(limits RiskLimits, auditPath string) *RiskEngine {
+	return &RiskEngine{
+		checks:      NewRiskChecks(limits),
+		lotSizes:    limits.LotSizes,
+		priceSource: lastQuotePrice,
+		auditPath:   auditPath,
+	}
+}
+
+// Функция для добавления проверки
+func (e *RiskEngine) AddCheck(check RiskCheck) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.checks = append(e.checks, check)
+}
+
+// Функция для подключения справочника облигаций: стоимость ордера считается от номинала
+func (e *RiskEngine) AttachBonds(bonds *BondSpecs) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.bonds = bonds
+}
+
+// Функция для подключения оценки в базовой валюте: денежные лимиты сравниваются с суммами,
+// пересчитанными по курсу
+func (e *RiskEngine) AttachValuation(valuation *Valuation) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.valuation = valuation
+}
+
+// Функция для замены источника последних цен (по умолчанию - котировки из пакета data)
+func (e *RiskEngine) SetPriceSource(source func(symbol string) (float64, bool)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.priceSource = source
+}
+
+// Функция для подписки на отказы (например, для аварийной остановки)
+func (e *RiskEngine) SubscribeRejections(handler func(rejection *RiskRejection)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.handlers = append(e.handlers, handler)
+}
+
+// Функция для проверки ордера. Возвращает *RiskRejection, если ордер отклонен
+func (e *RiskEngine) Evaluate(req *OrderRequest, portfolio *PortfolioInfo) (*RiskContext, error) {
+	return e.evaluate(req, portfolio, nil)
+}
+
+// Функция для проверки ордера с учетом остатков рабочих ордеров
+func (e *RiskEngine) evaluate(req *OrderRequest, portfolio *PortfolioInfo, working []ManagedOrder) (*RiskContext, error) {
+	e.mu.Lock()
+	ctx := e.newContext(req, portfolio, working)
+	var rejection *RiskRejection
+	for _, check := range e.checks {
+		if rejection = check.Check(ctx); rejection != nil {
+			rejection.Time = ctx.Now
+			rejection.Check = check.Name()
+			rejection.Symbol = req.Symbol
+			rejection.Side = req.Side
+			rejection.Quantity = req.Quantity
+			rejection.Price = ctx.Price
+			break
+		}
+	}
+	handlers := e.handlers
+	e.mu.Unlock()
+
+	if rejection == nil {
+		return ctx, nil
+	}
+
+	logger.Logger.Warn().
+		Str("check", rejection.Check).
+		Str("symbol", rejection.Symbol).
+		Str("side", rejection.Side).
+		Int("quantity", rejection.Quantity).
+		Float64("value", rejection.Value).
+		Float64("limit", rejection.Limit).
+		Str("reason", rejection.Reason).
+		Msg("Order rejected by risk check")
+	e.audit(rejection)
+	for _, handler := range handlers {
+		handler(rejection)
+	}
+	return ctx, rejection
+}
+
+// Функция для учета ордера, принятого площадкой
+func (e *RiskEngine) Record(ctx *RiskContext) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, check := range e.checks {
+		if recorder, ok := check.(riskRecorder); ok {
+			recorder.Record(ctx)
+		}
+	}
+}
+
+// Функция для передачи проверкам портфеля, полученного вне проверки ордеров (при сверке
+// с площадкой)
+func (e *RiskEngine) ObservePortfolio(portfolio *PortfolioInfo) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ctx := &RiskContext{
+		Positions: portfolio.Positions,
+		Balances:  portfolio.Balances,
+		Valuation: e.valuation,
+		Now:       time.Now(),
+	}
+	for _, check := range e.checks {
+		if observer, ok := check.(portfolioObserver); ok {
+			observer.Observe(ctx)
+		}
+	}
+}
+
+// Функция для подготовки данных проверки (вызывается под e.mu)
+func (e *RiskEngine) newContext(req *OrderRequest, portfolio *PortfolioInfo, working []ManagedOrder) *RiskContext {
+	ctx := &RiskContext{
+		Request:   req,
+		LotSize:   e.lotSize(req.Symbol),
+		Positions: portfolio.Positions,
+		Balances:  portfolio.Balances,
+		Valuation: e.valuation,
+		Currency:  e.valuation.Currency(req.Symbol),
+		Now:       time.Now(),
+	}
+	if price, ok := e.priceSource(req.Symbol); ok {
+		ctx.LastPrice = price
+	}
+	ctx.Price = ctx.LastPrice
+	if req.OrderType == "limit" && req.Price > 0 {
+		ctx.Price = req.Price
+	}
+	ctx.UnitPrice = e.unitPrice(req.Symbol, ctx.Price, ctx.Now)
+
+	for _, order := range working {
+		remaining := order.RemainingQuantity()
+		if remaining <= 0 || order.Request.Symbol == "" {
+			continue
+		}
+		price := order.Request.Price
+		if order.Request.OrderType != "limit" || price <= 0 {
+			price = order.Request.StopPrice
+		}
+		if price <= 0 {
+			price, _ = e.priceSource(order.Request.Symbol)
+		}
+		ctx.Working = append(ctx.Working, WorkingOrder{
+			Symbol:    order.Request.Symbol,
+			Side:      order.Request.Side,
+			Quantity:  remaining,
+			LotSize:   e.lotSize(order.Request.Symbol),
+			UnitPrice: e.unitPrice(order.Request.Symbol, price, ctx.Now),
+			Currency:  e.valuation.Currency(order.Request.Symbol),
+		})
+	}
+	return ctx
+}
+
+// Функция возвращает размер лота инструмента (вызывается под e.mu)
+func (e *RiskEngine) lotSize(symbol string) int {
+	if lotSize, ok := e.lotSizes[symbol]; ok && lotSize > 0 {
+		return lotSize
+	}
+	return 1
+}
+
+// Функция возвращает стоимость одной бумаги по цене: для облигаций - от номинала, с НКД
+// (вызывается под e.mu)
+func (e *RiskEngine) unitPrice(symbol string, price float64, now time.Time) float64 {
+	if bond, ok := e.bonds.Bond(symbol); ok && price > 0 {
+		return bond.DirtyValue(price, now)
+	}
+	return price
+}
+
+// Функция для записи отказа в журнал аудита
+func (e *RiskEngine) audit(rejection *RiskRejection) {
+	if e.auditPath == "" {
+		return
+	}
+	if err := appendJSONLine(e.auditPath, rejection); err != nil {
+		logger.Logger.Error().Err(err).Str("path", e.auditPath).Msg("Failed to write risk audit")
+	}
+}
+
+// Декоратор Broker, который пропускает каждый ордер через RiskEngine, в том числе изменения
+// ордеров: проверяется ордер с новыми количеством и ценой
+type RiskCheckedBroker struct {
+	Broker
+	engine  *RiskEngine
+	manager *OrderManager // Параметры изменяемых ордеров (без менеджера изменения отклоняются)
+}
+
+// Функция для создания декоратора проверок риска
+func NewRiskCheckedBroker(broker Broker, engine *RiskEngine) *RiskCheckedBroker {
+	return &RiskCheckedBroker{Broker: broker, engine: engine}
+}
+
+// Функция возвращает площадку под декоратором
+func (b *RiskCheckedBroker) Unwrap() Broker {
+	return b.Broker
+}
+
+func (b *RiskCheckedBroker) PlaceOrder(req *OrderRequest) (*OrderResponse, error) {
+	// Аварийное закрытие позиций не должно блокироваться лимитами (например, без последней котировки)
+	if req.Emergency {
+		logger.Logger.Warn().Str("symbol", req.Symbol).Str("side", req.Side).Int("quantity", req.Quantity).Msg("Emergency order bypasses risk checks")
+		return b.Broker.PlaceOrder(req)
+	}
+	portfolio, err := b.Broker.GetPortfolioInfo()
+	if err != nil {
+		return nil, fmt.Errorf("risk check: error getting portfolio: %w", err)
+	}
+	ctx, err := b.engine.evaluate(req, portfolio, b.workingOrders(0))
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := b.Broker.PlaceOrder(req)
+	if err != nil {
+		return nil, err
+	}
+	b.engine.Record(ctx)
+	return response, nil
+}
+
+// Функция для подключения менеджера ордеров, по которому восстанавливаются параметры
+// изменяемого ордера и остатки рабочих ордеров (вызывается до начала торговли)
+func (b *RiskCheckedBroker) AttachOrderManager(manager *OrderManager) {
+	b.manager = manager
+}
+
+// Функция возвращает открытые ордера менеджера, кроме изменяемого ордера exclude
+// (его остаток заменяется новым количеством)
+func (b *RiskCheckedBroker) workingOrders(exclude int) []ManagedOrder {
+	if b.manager == nil {
+		return nil
+	}
+	var working []ManagedOrder
+	for _, order := range b.manager.OpenOrders("") {
+		if order.ID != exclude {
+			working = append(working, order)
+		}
+	}
+	return working
+}
+
+// Изменение проверяется как ордер с новыми количеством и ценой. Новое количество проверяется
+// целиком, включая уже исполненное (консервативная оценка)
+func (b *RiskCheckedBroker) ModifyOrder(req *ModifyOrderRequest) (*OrderResponse, error) {
+	var original ManagedOrder
+	ok := false
+	if b.manager != nil {
+		original, ok = b.manager.GetOrder(req.OrderID)
+	}
+	if !ok {
+		return nil, &ModifyError{OrderID: req.OrderID, Stage: ErrModifyRejected, Err: fmt.Errorf("risk check: order %d is unknown", req.OrderID)}
+	}
+
+	modified := original.Request
+	if req.Quantity > 0 {
+		modified.Quantity = req.Quantity
+	}
+	if req.Price > 0 {
+		modified.Price = req.Price
+	}
+	if req.StopPrice > 0 {
+		modified.StopPrice = req.StopPrice
+	}
+
+	portfolio, err := b.Broker.GetPortfolioInfo()
+	if err != nil {
+		return nil, &ModifyError{OrderID: req.OrderID, Stage: ErrModifyRejected, Err: fmt.Errorf("risk check: error getting portfolio: %w", err)}
+	}
+	ctx, err := b.engine.evaluate(&modified, portfolio, b.workingOrders(req.OrderID))
+	if err != nil {
+		return nil, &ModifyError{OrderID: req.OrderID, Stage: ErrModifyRejected, Err: err}
+	}
+
+	response, err := b.Broker.ModifyOrder(req)
+	if err != nil {
+		return nil, err
+	}
+	b.engine.Record(ctx)
+	return response, nil
+}
+
+// Проверка допустимого количества и шага цены
+type LotStepCheck struct {
+	PriceSteps map[string]float64
+}
+
+func (c *LotStepCheck) Name() string { return "lot_step" }
+
+func (c *LotStepCheck) Check(ctx *RiskContext) *RiskRejection {
+	req := ctx.Request
+	if req.Quantity <= 0 {
+		return &RiskRejection{Reason: "quantity must be a positive number of lots", Value: float64(req.Quantity), Limit: 1}
+	}
+	step := c.PriceSteps[req.Symbol]
+	if step <= 0 {
+		return nil
+	}
+	for _, price := range []float64{req.Price, req.StopPrice} {
+		if price > 0 && !isMultiple(price, step) {
+			return &RiskRejection{Reason: "price is not a multiple of price step", Value: price, Limit: step}
+		}
+	}
+	return nil
+}
+
+// Проверка отклонения цены ордера от последней котировки
+type PriceCollarCheck struct {
+	Percent float64
+}
+
+func (c *PriceCollarCheck) Name() string { return "price_collar" }
+
+func (c *PriceCollarCheck) Check(ctx *RiskContext) *RiskRejection {
+	if ctx.LastPrice <= 0 {
+		return &RiskRejection{Reason: "no last quote to check price against", Limit: c.Percent}
+	}
+	if ctx.Request.OrderType != "limit" {
+		return nil
+	}
+	deviation := math.Abs(ctx.Request.Price-ctx.LastPrice) / ctx.LastPrice * 100
+	if deviation > c.Percent {
+		return &RiskRejection{Reason: "limit price too far from last quote", Value: deviation, Limit: c.Percent}
+	}
+	return nil
+}
+
+// Проверка максимальной стоимости ордера
+type MaxOrderValueCheck struct {
+	Limit float64
+}
+
+func (c *MaxOrderValueCheck) Name() string { return "max_order_value" }
+
+func (c *MaxOrderValueCheck) Check(ctx *RiskContext) *RiskRejection {
+	value, err := ctx.OrderValue()
+	if err != nil {
+		return valuationRejection(err)
+	}
+	if value > c.Limit {
+		return &RiskRejection{Reason: "order value exceeds limit", Value: value, Limit: c.Limit}
+	}
+	return nil
+}
+
+// Проверка максимальной позиции по инструменту после исполнения ордера и рабочих ордеров
+// в ту же сторону
+type MaxPositionCheck struct {
+	Default   int
+	PerSymbol map[string]int
+}
+
+func (c *MaxPositionCheck) Name() string { return "max_position" }
+
+func (c *MaxPositionCheck) Check(ctx *RiskContext) *RiskRejection {
+	limit, ok := c.PerSymbol[ctx.Request.Symbol]
+	if !ok {
+		limit = c.Default
+	}
+	if limit <= 0 || !ctx.IncreasesPosition() {
+		return nil
+	}
+	if projected := abs(ctx.PositionLots() + ctx.WorkingLots() + ctx.SignedQuantity()); projected > limit {
+		return &RiskRejection{Reason: "position would exceed limit", Value: float64(projected), Limit: float64(limit)}
+	}
+	return nil
+}
+
+// Проверка суммарной позиции портфеля после исполнения ордера и остатков рабочих ордеров
+type ExposureCheck struct {
+	MaxGross float64
+	MaxNet   float64
+}
+
+func (c *ExposureCheck) Name() string { return "exposure" }
+
+func (c *ExposureCheck) Check(ctx *RiskContext) *RiskRejection {
+	if !ctx.IncreasesPosition() {
+		return nil
+	}
+
+	var gross, net float64
+	for _, position := range ctx.Positions {
+		value, err := ctx.PositionValue(position)
+		if err != nil {
+			return valuationRejection(err)
+		}
+		gross += math.Abs(value)
+		net += value
+	}
+	for _, order := range ctx.Working {
+		value, err := ctx.WorkingValue(order)
+		if err != nil {
+			return valuationRejection(err)
+		}
+		gross += math.Abs(value)
+		net += value
+	}
+	value, err := ctx.OrderValue()
+	if err != nil {
+		return valuationRejection(err)
+	}
+	gross += value
+	if ctx.Request.Side == "sell" {
+		net -= value
+	} else {
+		net += value
+	}
+
+	if c.MaxGross > 0 && gross > c.MaxGross {
+		return &RiskRejection{Reason: "gross exposure would exceed limit", Value: gross, Limit: c.MaxGross}
+	}
+	if c.MaxNet > 0 && math.Abs(net) > c.MaxNet {
+		return &RiskRejection{Reason: "net exposure would exceed limit", Value: math.Abs(net), Limit: c.MaxNet}
+	}
+	return nil
+}
+
+// Проверка частоты ордеров в скользящем окне
+type OrderRateCheck struct {
+	MaxOrders int
+	Window    time.Duration
+	sent      []time.Time
+}
+
+func (c *OrderRateCheck) Name() string { return "order_rate" }
+
+func (c *OrderRateCheck) Check(ctx *RiskContext) *RiskRejection {
+	cutoff := ctx.Now.Add(-c.Window)
+	for len(c.sent) > 0 && c.sent[0].Before(cutoff) {
+		c.sent = c.sent[1:]
+	}
+	if len(c.sent) >= c.MaxOrders {
+		return &RiskRejection{Reason: "too many orders in window", Value: float64(len(c.sent) + 1), Limit: float64(c.MaxOrders)}
+	}
+	return nil
+}
+
+func (c *OrderRateCheck) Record(ctx *RiskContext) {
+	c.sent = append(c.sent, ctx.Now)
+}
+
+// Состояние дневного убытка: база дня и последняя оценка портфеля
+type dailyLossState struct {
+	Day         string  `json:"day"`          // Торговый день базы ("2006-01-02" по времени биржи)
+	StartEquity float64 `json:"start_equity"` // Стоимость портфеля на начало дня
+	LastEquity  float64 `json:"last_equity"`  // Последняя оценка за день (закрытие для следующего дня)
+}
+
+// Проверка дневного убытка: после превышения лимита разрешены только ордера, сокращающие позицию.
+// База дня - последняя оценка портфеля предыдущего дня, без нее - первая оценка дня. Портфель
+// оценивается при сверке с площадкой (Observe) и при проверке ордеров, база сохраняется
+// в StatePath и не сбрасывается перезапуском
+type DailyLossCheck struct {
+	Limit     float64
+	StatePath string // Файл состояния (пустой - без сохранения)
+	state     dailyLossState
+}
+
+// Функция для создания проверки дневного убытка с базой из файла состояния
+func NewDailyLossCheck(limit float64, statePath string) *DailyLossCheck {
+	check := &DailyLossCheck{Limit: limit, StatePath: statePath}
+	if statePath != "" {
+		if _, err := readJSON(statePath, &check.state); err != nil {
+			logger.Logger.Error().Err(err).Str("path", statePath).Msg("Failed to load daily loss baseline, it will be taken from current equity")
+		}
+	}
+	return check
+}
+
+func (c *DailyLossCheck) Name() string { return "daily_loss" }
+
+func (c *DailyLossCheck) Check(ctx *RiskContext) *RiskRejection {
+	equity, err := ctx.Equity()
+	if err != nil {
+		// Без полной оценки портфеля убыток неизвестен: разрешено только сокращать позиции
+		if ctx.IncreasesPosition() {
+			return valuationRejection(err)
+		}
+		return nil
+	}
+	if c.observe(ctx.Now, equity) {
+		c.save()
+	}
+
+	loss := c.state.StartEquity - equity
+	if loss > c.Limit && ctx.IncreasesPosition() {
+		return &RiskRejection{Reason: "daily loss limit reached", Value: loss, Limit: c.Limit}
+	}
+	return nil
+}
+
+// Функция для учета оценки портфеля вне проверки ордера (при сверке с площадкой)
+func (c *DailyLossCheck) Observe(ctx *RiskContext) {
+	equity, err := ctx.Equity()
+	if err != nil {
+		logger.Logger.Warn().Err(err).Msg("Cannot value portfolio for daily loss baseline")
+		return
+	}
+	c.observe(ctx.Now, equity)
+	c.save()
+}
+
+// Функция для учета оценки портфеля. Возвращает true, если начался новый день
+func (c *DailyLossCheck) observe(now time.Time, equity float64) bool {
+	day := now.In(data.ExchangeLocation).Format("2006-01-02")
+	newDay := day != c.state.Day
+	if newDay {
+		start := equity
+		if c.state.Day != "" && c.state.Day < day {
+			start = c.state.LastEquity
+		}
+		c.state = dailyLossState{Day: day, StartEquity: start}
+		logger.Logger.Info().Str("day", day).Float64("start_equity", start).Msg("Daily loss baseline set")
+	}
+	c.state.LastEquity = equity
+	return newDay
+}
+
+// Функция для сохранения базы дневного убытка
+func (c *DailyLossCheck) save() {
+	if c.StatePath == "" {
+		return
+	}
+	if err := writeJSONAtomic(c.StatePath, c.state); err != nil {
+		logger.Logger.Error().Err(err).Str("path", c.StatePath).Msg("Failed to save daily loss baseline")
+	}
+}
+
+// Проверка достаточности денег для покупки: деньги в валюте инструмента (или в Currency)
+type CashCheck struct {
+	Currency string
+}
+
+func (c *CashCheck) Name() string { return "cash" }
+
+func (c *CashCheck) Check(ctx *RiskContext) *RiskRejection {
+	if ctx.Request.Side != "buy" {
+		return nil
+	}
+	currency := c.Currency
+	if currency == "" {
+		currency = ctx.Currency
+	}
+	available := ctx.Balances[currency].Available
+	if ctx.Price <= 0 {
+		return valuationRejection(errNoOrderPrice)
+	}
+	if value := ctx.LocalOrderValue(); value > available {
+		return &RiskRejection{Reason: "insufficient funds", Value: value, Limit: available}
+	}
+	return nil
+}
+
+// Функция возвращает цену последней котировки из пакета data
+func lastQuotePrice(symbol string) (float64, bool) {
+	quote, ok := data.LastQuote(symbol)
+	if !ok || quote.Price <= 0 {
+		return 0, false
+	}
+	return quote.Price, true
+}
+
+// Функция проверяет, кратна ли цена шагу (с допуском на погрешность float64)
+func isMultiple(price float64, step float64) bool {
+	ratio := price / step
+	return math.Abs(ratio-math.Round(ratio)) < 1e-6
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
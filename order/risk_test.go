@@ -0,0 +1,383 @@
+package order
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"trading-bot/data"
+)
+
+// Функция возвращает движок проверок с заданными последними ценами
+func newTestRiskEngine(limits RiskLimits, prices map[string]float64) *RiskEngine {
+	engine := NewRiskEngine(limits, "")
+	engine.SetPriceSource(func(symbol string) (float64, bool) {
+		price, ok := prices[symbol]
+		return price, ok
+	})
+	return engine
+}
+
+func TestRiskChecks(t *testing.T) {
+	rub := func(amount float64) map[string]Balance {
+		return map[string]Balance{DefaultCurrency: {Value: amount, Available: amount}}
+	}
+	usd := NewValuation(DefaultCurrency, data.NewFXRates(nil))
+	usd.SetCurrency("AAPL", "USD")
+
+	tests := []struct {
+		name      string
+		limits    RiskLimits
+		prices    map[string]float64
+		valuation *Valuation
+		req       OrderRequest
+		portfolio PortfolioInfo
+		wantCheck string // Название отклонившей проверки ("" - ордер допустим)
+	}{
+		{
+			name:      "zero quantity",
+			req:       OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 0, OrderType: "market"},
+			wantCheck: "lot_step",
+		},
+		{
+			name:      "price off step",
+			limits:    RiskLimits{PriceSteps: map[string]float64{"SBER": 0.01}},
+			req:       OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 1, OrderType: "limit", Price: 100.005},
+			wantCheck: "lot_step",
+		},
+		{
+			name:   "price on step",
+			limits: RiskLimits{PriceSteps: map[string]float64{"SBER": 0.01}},
+			req:    OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 1, OrderType: "limit", Price: 100.01},
+		},
+		{
+			name:      "limit price outside collar",
+			limits:    RiskLimits{PriceCollarPercent: 5},
+			prices:    map[string]float64{"SBER": 100},
+			req:       OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 1, OrderType: "limit", Price: 106},
+			wantCheck: "price_collar",
+		},
+		{
+			name:   "limit price inside collar",
+			limits: RiskLimits{PriceCollarPercent: 5},
+			prices: map[string]float64{"SBER": 100},
+			req:    OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 1, OrderType: "limit", Price: 104},
+		},
+		{
+			name:      "collar without last quote",
+			limits:    RiskLimits{PriceCollarPercent: 5},
+			req:       OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 1, OrderType: "limit", Price: 104},
+			wantCheck: "price_collar",
+		},
+		{
+			name:      "order value over limit",
+			limits:    RiskLimits{MaxOrderValue: 1000},
+			req:       OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 11, OrderType: "limit", Price: 100},
+			wantCheck: "max_order_value",
+		},
+		{
+			name:      "order value counts lot size",
+			limits:    RiskLimits{MaxOrderValue: 1000, LotSizes: map[string]int{"SBER": 10}},
+			req:       OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 2, OrderType: "limit", Price: 100},
+			wantCheck: "max_order_value",
+		},
+		{
+			name:   "market order valued at last quote",
+			limits: RiskLimits{MaxOrderValue: 1000},
+			prices: map[string]float64{"SBER": 100},
+			req:    OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 10, OrderType: "market"},
+		},
+		{
+			name:      "market order without price",
+			limits:    RiskLimits{MaxOrderValue: 1000},
+			req:       OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 1, OrderType: "market"},
+			wantCheck: "max_order_value",
+		},
+		{
+			name:      "order value without FX rate",
+			limits:    RiskLimits{MaxOrderValue: 1_000_000},
+			valuation: usd,
+			req:       OrderRequest{Symbol: "AAPL", Side: "buy", Quantity: 1, OrderType: "limit", Price: 100},
+			wantCheck: "max_order_value",
+		},
+		{
+			name:      "position over limit",
+			limits:    RiskLimits{MaxPositionLots: 5},
+			prices:    map[string]float64{"SBER": 100},
+			req:       OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 2, OrderType: "market"},
+			portfolio: PortfolioInfo{Positions: []Position{{Symbol: "SBER", Quantity: 4}}},
+			wantCheck: "max_position",
+		},
+		{
+			name:      "reducing position over limit",
+			limits:    RiskLimits{MaxPositionLots: 5},
+			prices:    map[string]float64{"SBER": 100},
+			req:       OrderRequest{Symbol: "SBER", Side: "sell", Quantity: 2, OrderType: "market"},
+			portfolio: PortfolioInfo{Positions: []Position{{Symbol: "SBER", Quantity: 8}}},
+		},
+		{
+			name:      "per-symbol position limit",
+			limits:    RiskLimits{MaxPositionLots: 5, PositionLots: map[string]int{"SBER": 10}},
+			prices:    map[string]float64{"SBER": 100},
+			req:       OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 2, OrderType: "market"},
+			portfolio: PortfolioInfo{Positions: []Position{{Symbol: "SBER", Quantity: 4}}},
+		},
+		{
+			name:      "gross exposure over limit",
+			limits:    RiskLimits{MaxGrossExposure: 1000},
+			prices:    map[string]float64{"SBER": 100},
+			req:       OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 2, OrderType: "market"},
+			portfolio: PortfolioInfo{Positions: []Position{{Symbol: "GAZP", Quantity: -9, MarketValue: -900}}},
+			wantCheck: "exposure",
+		},
+		{
+			name:      "net exposure within limit",
+			limits:    RiskLimits{MaxNetExposure: 1000},
+			prices:    map[string]float64{"SBER": 100},
+			req:       OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 2, OrderType: "market"},
+			portfolio: PortfolioInfo{Positions: []Position{{Symbol: "GAZP", Quantity: -9, MarketValue: -900}}},
+		},
+		{
+			name:      "insufficient cash",
+			limits:    RiskLimits{CheckCash: true},
+			prices:    map[string]float64{"SBER": 100},
+			req:       OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 6, OrderType: "market"},
+			portfolio: PortfolioInfo{Balances: rub(500)},
+			wantCheck: "cash",
+		},
+		{
+			name:      "cash is not needed to sell",
+			limits:    RiskLimits{CheckCash: true},
+			prices:    map[string]float64{"SBER": 100},
+			req:       OrderRequest{Symbol: "SBER", Side: "sell", Quantity: 6, OrderType: "market"},
+			portfolio: PortfolioInfo{Balances: rub(500)},
+		},
+		{
+			name:      "cash check without price",
+			limits:    RiskLimits{CheckCash: true},
+			req:       OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 1, OrderType: "market"},
+			portfolio: PortfolioInfo{Balances: rub(500)},
+			wantCheck: "cash",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := newTestRiskEngine(tt.limits, tt.prices)
+			if tt.valuation != nil {
+				engine.AttachValuation(tt.valuation)
+			}
+
+			_, err := engine.Evaluate(&tt.req, &tt.portfolio)
+			var rejection *RiskRejection
+			if errors.As(err, &rejection) {
+				if rejection.Check != tt.wantCheck {
+					t.Errorf("rejected by %s (%s), want %q", rejection.Check, rejection.Reason, tt.wantCheck)
+				}
+				if !errors.Is(err, ErrRiskRejected) || !errors.Is(err, ErrOrderRejected) {
+					t.Errorf("rejection %v does not match ErrRiskRejected and ErrOrderRejected", err)
+				}
+			} else if tt.wantCheck != "" {
+				t.Errorf("order accepted, want rejection by %s", tt.wantCheck)
+			}
+		})
+	}
+}
+
+func TestOrderRateCheck(t *testing.T) {
+	check := &OrderRateCheck{MaxOrders: 2, Window: time.Minute}
+	start := time.Date(2024, time.July, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		offset   time.Duration
+		rejected bool
+	}{
+		{0, false},
+		{10 * time.Second, false},
+		{20 * time.Second, true},
+		{61 * time.Second, false}, // Первый ордер вышел из окна
+	}
+
+	for _, tt := range tests {
+		ctx := &RiskContext{Request: &OrderRequest{Symbol: "SBER"}, Now: start.Add(tt.offset)}
+		rejection := check.Check(ctx)
+		if (rejection != nil) != tt.rejected {
+			t.Fatalf("at %s: rejection = %v, want rejected %v", tt.offset, rejection, tt.rejected)
+		}
+		if rejection == nil {
+			check.Record(ctx)
+		}
+	}
+}
+
+func TestDailyLossCheck(t *testing.T) {
+	check := &DailyLossCheck{Limit: 100}
+	day := time.Date(2024, time.July, 1, 10, 0, 0, 0, data.ExchangeLocation)
+	long := []Position{{Symbol: "SBER", Quantity: 5}}
+
+	tests := []struct {
+		name     string
+		now      time.Time
+		cash     float64
+		side     string
+		rejected bool
+	}{
+		{"start of day sets equity", day, 1000, "buy", false},
+		{"loss within limit", day.Add(time.Hour), 950, "buy", false},
+		{"loss over limit blocks increase", day.Add(2 * time.Hour), 850, "buy", true},
+		{"loss over limit allows reduction", day.Add(2 * time.Hour), 850, "sell", false},
+		{"next day starts over", day.AddDate(0, 0, 1), 850, "buy", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := &RiskContext{
+				Request:   &OrderRequest{Symbol: "SBER", Side: tt.side, Quantity: 1},
+				Positions: long,
+				Balances:  map[string]Balance{DefaultCurrency: {Value: tt.cash}},
+				Now:       tt.now,
+			}
+			if rejection := check.Check(ctx); (rejection != nil) != tt.rejected {
+				t.Errorf("rejection = %v, want rejected %v", rejection, tt.rejected)
+			}
+		})
+	}
+}
+
+func TestRiskCheckedBroker(t *testing.T) {
+	tests := []struct {
+		name       string
+		req        OrderRequest
+		wantErr    bool
+		wantPlaced int
+	}{
+		{"accepted order reaches broker", OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 1, OrderType: "limit", Price: 100}, false, 1},
+		{"rejected order does not reach broker", OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 20, OrderType: "limit", Price: 100}, true, 0},
+		{"emergency order bypasses checks", OrderRequest{Symbol: "SBER", Side: "sell", Quantity: 20, OrderType: "market", Emergency: true}, false, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &stubBroker{}
+			broker := NewRiskCheckedBroker(stub, newTestRiskEngine(RiskLimits{MaxOrderValue: 1000}, nil))
+			_, err := broker.PlaceOrder(&tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if len(stub.placed) != tt.wantPlaced {
+				t.Errorf("placed %d orders, want %d", len(stub.placed), tt.wantPlaced)
+			}
+		})
+	}
+}
+
+func TestRiskCheckedBrokerModifyOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		known   bool
+		modify  ModifyOrderRequest
+		wantErr bool
+	}{
+		{"modification within limits", true, ModifyOrderRequest{Quantity: 5}, false},
+		{"modification over limits", true, ModifyOrderRequest{Quantity: 20}, true},
+		{"repricing over limits", true, ModifyOrderRequest{Price: 300}, true},
+		{"unknown order", false, ModifyOrderRequest{Quantity: 5}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &stubBroker{}
+			broker := NewRiskCheckedBroker(stub, newTestRiskEngine(RiskLimits{MaxOrderValue: 1000}, nil))
+			manager := NewOrderManager(stub)
+			broker.AttachOrderManager(manager)
+
+			req := tt.modify
+			req.OrderID = 42
+			if tt.known {
+				order, err := manager.PlaceOrder(&OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 4, OrderType: "limit", Price: 100})
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.OrderID = order.ID
+			}
+
+			_, err := broker.ModifyOrder(&req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %v", err, tt.wantErr)
+			}
+			var modifyErr *ModifyError
+			if err != nil && !errors.As(err, &modifyErr) {
+				t.Errorf("error = %v, want *ModifyError", err)
+			}
+		})
+	}
+}
+
+func TestDailyLossBaselineSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "daily_loss.json")
+	open := time.Date(2024, time.July, 1, 10, 0, 0, 0, data.ExchangeLocation)
+	at := func(now time.Time, cash float64) *RiskContext {
+		return &RiskContext{
+			Request:   &OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 1},
+			Positions: []Position{{Symbol: "SBER", Quantity: 5}},
+			Balances:  map[string]Balance{DefaultCurrency: {Value: cash}},
+			Now:       now,
+		}
+	}
+
+	// База дня берется при сверке на открытии, а не при первом ордере после убытка
+	check := NewDailyLossCheck(100, path)
+	check.Observe(at(open, 1000))
+	if rejection := check.Check(at(open.Add(3*time.Hour), 880)); rejection == nil {
+		t.Fatal("loss of 120 accepted, want rejection")
+	}
+
+	// После перезапуска база дня прежняя
+	check = NewDailyLossCheck(100, path)
+	if rejection := check.Check(at(open.Add(4*time.Hour), 880)); rejection == nil || rejection.Value != 120 {
+		t.Fatalf("rejection after restart = %v, want loss 120", rejection)
+	}
+	check.Observe(at(open.Add(8*time.Hour), 870))
+
+	// База следующего дня - последняя оценка предыдущего: утренний убыток тоже учитывается
+	check = NewDailyLossCheck(100, path)
+	if rejection := check.Check(at(open.AddDate(0, 0, 1), 760)); rejection == nil || rejection.Value != 110 {
+		t.Errorf("rejection next day = %v, want loss 110 from previous close", rejection)
+	}
+}
+
+func TestRiskCheckedBrokerCountsWorkingOrders(t *testing.T) {
+	prices := map[string]float64{"SBER": 100, "GAZP": 100}
+	stub := &stubBroker{positions: []Position{{Symbol: "SBER", Quantity: 1, MarketValue: 100}}}
+	manager := NewOrderManager(stub)
+	working, err := manager.PlaceOrder(&OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 3, OrderType: "limit", Price: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker := NewRiskCheckedBroker(stub, newTestRiskEngine(RiskLimits{MaxPositionLots: 5, MaxGrossExposure: 800}, prices))
+	broker.AttachOrderManager(manager)
+
+	// Позиция 1 и рабочая покупка 3: еще 2 лота дадут 6
+	_, err = broker.PlaceOrder(&OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 2, OrderType: "market"})
+	var rejection *RiskRejection
+	if !errors.As(err, &rejection) || rejection.Check != "max_position" || rejection.Value != 6 {
+		t.Fatalf("error = %v, want max_position rejection at 6 lots", err)
+	}
+
+	// Продажа не складывается с рабочей покупкой в позицию, но ее остаток входит в экспозицию:
+	// 100 позиции + 300 рабочего ордера + 500 продажи
+	_, err = broker.PlaceOrder(&OrderRequest{Symbol: "GAZP", Side: "sell", Quantity: 5, OrderType: "market"})
+	if !errors.As(err, &rejection) || rejection.Check != "exposure" || rejection.Value != 900 {
+		t.Fatalf("error = %v, want exposure rejection at 900", err)
+	}
+
+	// Изменяемый ордер заменяет свой остаток, а не добавляется к нему
+	if _, err := broker.ModifyOrder(&ModifyOrderRequest{OrderID: working.ID, Quantity: 4}); err != nil {
+		t.Errorf("modify to 4 lots error = %v, want accepted", err)
+	}
+	if len(stub.placed) != 1 {
+		t.Errorf("placed = %d, want only the working order", len(stub.placed))
+	}
+}
@@ -0,0 +1,100 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Состояние ордера в жизненном цикле
+type OrderState string
+
+const (
+	StatePendingNew      OrderState = "pending_new"      // Отправлен, площадка еще не подтвердила
+	StateWorking         OrderState = "working"          // Активен в стакане
+	StatePartiallyFilled OrderState = "partially_filled" // Исполнен частично, остаток активен
+	StateFilled          OrderState = "filled"           // Исполнен полностью
+	StateCancelPending   OrderState = "cancel_pending"   // Отправлена отмена, площадка еще не подтвердила
+	StateCancelled       OrderState = "cancelled"        // Снят
+	StateRejected        OrderState = "rejected"         // Отклонен площадкой
+	StateExpired         OrderState = "expired"          // Истек срок действия
+)
+
+// Ошибка для недопустимого перехода между состояниями ордера
+var ErrIllegalTransition = errors.New("illegal order state transition")
+
+// Допустимые переходы между состояниями. Из конечных состояний переходов нет.
+// Площадка может отклонить уже выставленный ордер (например, при нехватке маржи
+// на очередном исполнении), а отклоненная отмена возвращает ордер в прежнее состояние.
+var orderTransitions = map[OrderState][]OrderState{
+	StatePendingNew:      {StateWorking, StatePartiallyFilled, StateFilled, StateCancelPending, StateCancelled, StateRejected, StateExpired},
+	StateWorking:         {StatePartiallyFilled, StateFilled, StateCancelPending, StateCancelled, StateRejected, StateExpired},
+	StatePartiallyFilled: {StateFilled, StateCancelPending, StateCancelled, StateRejected, StateExpired},
+	StateCancelPending:   {StatePendingNew, StateWorking, StatePartiallyFilled, StateFilled, StateCancelled, StateRejected, StateExpired},
+}
+
+// Соответствие статусов площадок (Finam, Transaq, PaperBroker) состояниям ордера
+var brokerStatuses = map[string]OrderState{
+	"pending_new":      StatePendingNew,
+	"pending":          StatePendingNew,
+	"new":              StatePendingNew,
+	"forwarding":       StatePendingNew,
+	"watching":         StatePendingNew,
+	"wait":             StatePendingNew,
+	"inactive":         StatePendingNew,
+	"active":           StateWorking,
+	"working":          StateWorking,
+	"partially_filled": StatePartiallyFilled,
+	"partial":          StatePartiallyFilled,
+	"matched":          StateFilled,
+	"filled":           StateFilled,
+	"executed":         StateFilled,
+	"cancel_pending":   StateCancelPending,
+	"cancelled":        StateCancelled,
+	"canceled":         StateCancelled,
+	"removed":          StateCancelled,
+	"disabled":         StateCancelled,
+	"rejected":         StateRejected,
+	"denied":           StateRejected,
+	"refused":          StateRejected,
+	"failed":           StateRejected,
+	"expired":          StateExpired,
+}
+
+// Функция для преобразования статуса площадки в состояние ордера
+func ParseOrderState(status string) (OrderState, bool) {
+	state, ok := brokerStatuses[strings.ToLower(strings.TrimSpace(status))]
+	return state, ok
+}
+
+// Функция проверяет, является ли состояние конечным
+func (s OrderState) IsTerminal() bool {
+	return s == StateFilled || s == StateCancelled || s == StateRejected || s == StateExpired
+}
+
+// Функция проверяет, может ли ордер в этом состоянии еще исполняться
+func (s OrderState) IsOpen() bool {
+	return !s.IsTerminal()
+}
+
+// Функция проверяет, допустим ли переход из состояния s в состояние next.
+// Повтор того же состояния допустим (например, очередное частичное исполнение).
+func (s OrderState) CanTransitionTo(next OrderState) bool {
+	if s == next {
+		return true
+	}
+	for _, allowed := range orderTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// Функция для проверки перехода с ошибкой ErrIllegalTransition
+func checkTransition(orderID int, from OrderState, to OrderState) error {
+	if !from.CanTransitionTo(to) {
+		return fmt.Errorf("order %d: %s -> %s: %w", orderID, from, to, ErrIllegalTransition)
+	}
+	return nil
+}
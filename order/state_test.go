@@ -0,0 +1,90 @@
+package order
+
+import "testing"
+
+func TestOrderStateTransitions(t *testing.T) {
+	tests := []struct {
+		from, to OrderState
+		want     bool
+	}{
+		{StatePendingNew, StateWorking, true},
+		{StatePendingNew, StateRejected, true},
+		{StatePendingNew, StateFilled, true},
+		{StateWorking, StatePartiallyFilled, true},
+		{StateWorking, StateRejected, true},
+		{StateWorking, StatePendingNew, false},
+		{StatePartiallyFilled, StatePartiallyFilled, true},
+		{StatePartiallyFilled, StateFilled, true},
+		{StatePartiallyFilled, StateRejected, true},
+		{StatePartiallyFilled, StateWorking, false},
+		{StateCancelPending, StatePendingNew, true},
+		{StateCancelPending, StateWorking, true},
+		{StateCancelPending, StateCancelled, true},
+		{StateCancelPending, StateRejected, true},
+		{StateFilled, StateFilled, true},
+		{StateFilled, StateCancelled, false},
+		{StateCancelled, StateWorking, false},
+		{StateRejected, StateWorking, false},
+		{StateExpired, StateFilled, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.from)+"->"+string(tt.to), func(t *testing.T) {
+			if got := tt.from.CanTransitionTo(tt.to); got != tt.want {
+				t.Errorf("CanTransitionTo = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOrderState(t *testing.T) {
+	tests := []struct {
+		status string
+		want   OrderState
+		ok     bool
+	}{
+		{"working", StateWorking, true},
+		{" Active ", StateWorking, true},
+		{"matched", StateFilled, true},
+		{"canceled", StateCancelled, true},
+		{"denied", StateRejected, true},
+		{"watching", StatePendingNew, true},
+		{"unknown", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			got, ok := ParseOrderState(tt.status)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("ParseOrderState(%q) = %q, %v, want %q, %v", tt.status, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestOrderStateIsTerminal(t *testing.T) {
+	tests := []struct {
+		state OrderState
+		want  bool
+	}{
+		{StatePendingNew, false},
+		{StateWorking, false},
+		{StatePartiallyFilled, false},
+		{StateCancelPending, false},
+		{StateFilled, true},
+		{StateCancelled, true},
+		{StateRejected, true},
+		{StateExpired, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.state), func(t *testing.T) {
+			if got := tt.state.IsTerminal(); got != tt.want {
+				t.Errorf("IsTerminal = %v, want %v", got, tt.want)
+			}
+			if got := tt.state.IsOpen(); got == tt.want {
+				t.Errorf("IsOpen = %v, want %v", got, !tt.want)
+			}
+		})
+	}
+}
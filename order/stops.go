@@ -0,0 +1,703 @@
+package order
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"trading-bot/data"
+	"trading-bot/logger"
+)
+
+// Типы условных ордеров
+type StopKind string
+
+const (
+	StopMarket StopKind = "stop"        // Стоп-рыночный: при достижении стоп-цены - рыночный ордер
+	StopLimit  StopKind = "stop_limit"  // Стоп-лимитный: при достижении стоп-цены - лимитный ордер по LimitPrice
+	TakeProfit StopKind = "take_profit" // Тейк-профит: при движении цены в пользу позиции до стоп-цены
+)
+
+// Состояния условного ордера
+type StopStatus string
+
+const (
+	StopPending   StopStatus = "pending"   // Ждет исполнения родительского ордера (bracket)
+	StopActive    StopStatus = "active"    // Отслеживается
+	StopTriggered StopStatus = "triggered" // Сработал, выставлен ордер ChildOrderID
+	StopCancelled StopStatus = "cancelled" // Снят (вручную или вторым ордером OCO)
+)
+
+// Структура для условного ордера
+type StopOrder struct {
+	ID            string          `json:"id"`
+	Kind          StopKind        `json:"kind"`
+	Symbol        string          `json:"symbol"`
+	Side          string          `json:"side"`
+	Quantity      int             `json:"quantity"`
+	StopPrice     float64         `json:"stop_price"`                // Цена активации
+	LimitPrice    float64         `json:"limit_price,omitempty"`     // Цена лимитного ордера (stop_limit, take_profit с лимитом)
+	GroupID       string          `json:"group_id,omitempty"`        // Группа OCO: срабатывание одного снимает остальные
+	ParentOrderID int             `json:"parent_order_id,omitempty"` // Родительский ордер bracket
+	Status        StopStatus      `json:"status"`
+	Native        bool            `json:"native"`              // Ордер выставлен на площадке (не эмулируется)
+	NativeID      int             `json:"native_id,omitempty"` // ID условного ордера на площадке
+	ChildOrderID  int             `json:"child_order_id,omitempty"`
+	Trailing      *TrailingConfig `json:"trailing,omitempty"`       // Трейлинг-стоп: стоп-цена следует за ценой
+	ExtremePrice  float64         `json:"extreme_price,omitempty"`  // Лучшая цена с момента выставления трейлинг-стопа
+	ATR           float64         `json:"atr,omitempty"`            // Последнее значение ATR (режим atr)
+	PositionBound bool            `json:"position_bound,omitempty"` // Количество следует за позицией (TrailPosition)
+	Created       time.Time       `json:"created"`
+	Updated       time.Time       `json:"updated"`
+}
+
+// Функция проверяет, срабатывает ли условный ордер при цене price
+func (s *StopOrder) Triggered(price float64) bool {
+	rising := (s.Kind == TakeProfit) == (s.Side == "sell")
+	if rising {
+		return price >= s.StopPrice
+	}
+	return price <= s.StopPrice
+}
+
+// Функция возвращает ордер, выставляемый при срабатывании
+func (s *StopOrder) childRequest() *OrderRequest {
+	req := &OrderRequest{
+		Symbol:    s.Symbol,
+		Side:      s.Side,
+		Quantity:  s.Quantity,
+		OrderType: "market",
+		Comment:   fmt.Sprintf("%s %s", s.Kind, s.ID),
+	}
+	if s.Kind == StopLimit || (s.Kind == TakeProfit && s.LimitPrice > 0) {
+		req.OrderType = "limit"
+		req.Price = s.LimitPrice
+	}
+	return req
+}
+
+// Структура для нативного условного ордера площадки: стоп-лосс и/или тейк-профит
+// (оба ноги - одна пара OCO на стороне площадки)
+type StopOrderRequest struct {
+	Symbol        string
+	Side          string
+	Quantity      int
+	StopLoss      *StopOrder // Нога стоп-лосс (stop или stop_limit)
+	TakeProfit    *StopOrder // Нога тейк-профит
+	ClientOrderID string
+}
+
+// Структура для обновления нативного условного ордера от площадки
+type StopUpdate struct {
+	NativeID int
+	Status   StopStatus // StopTriggered или StopCancelled (остальные статусы не передаются)
+	Leg      StopKind   // Сработавшая нога: TakeProfit или StopMarket (для стоп-лосса)
+	OrderID  int        // Ордер, выставленный площадкой при срабатывании (0, если еще неизвестен)
+}
+
+// Интерфейс площадки с нативными условными ордерами (Transaq newstoporder)
+type NativeStopBroker interface {
+	PlaceStopOrder(req *StopOrderRequest) (*OrderResponse, error)
+	CancelStopOrder(stopOrderID int) error
+	SubscribeStopUpdates(handler func(update StopUpdate))
+}
+
+// Функция для поиска площадки с нативными условными ордерами под цепочкой декораторов
+func findNativeStops(broker Broker) (NativeStopBroker, bool) {
+	for broker != nil {
+		if native, ok := broker.(NativeStopBroker); ok {
+			return native, true
+		}
+		wrapper, ok := broker.(brokerWrapper)
+		if !ok {
+			break
+		}
+		broker = wrapper.Unwrap()
+	}
+	return nil, false
+}
+
+// Менеджер условных ордеров: стоп, стоп-лимит, тейк-профит, bracket и OCO.
+// Если площадка поддерживает нативные условные ордера, они выставляются на площадке,
+// иначе эмулируются по котировкам. Состояние сохраняется в файл и переживает перезапуск.
+type StopManager struct {
+	manager   *OrderManager
+	native    NativeStopBroker
+	statePath string
+
+	mu        sync.Mutex
+	stops     map[string]*StopOrder
+	replacing map[string]bool         // Нативные ордера, переставляемые на площадке
+	atr       map[atrCacheKey]float64 // Последние значения ATR по инструменту и периоду
+}
+
+// Функция для создания менеджера условных ордеров. statePath - файл состояния (пустой - без сохранения)
+func NewStopManager(manager *OrderManager, statePath string) (*StopManager, error) {
+	s := &StopManager{
+		manager:   manager,
+		statePath: statePath,
+		stops:     make(map[string]*StopOrder),
+		replacing: make(map[string]bool),
+		atr:       make(map[atrCacheKey]float64),
+	}
+	s.native, _ = findNativeStops(manager.Broker())
+
+	if statePath != "" {
+		var stored []*StopOrder
+		if _, err := readJSON(statePath, &stored); err != nil {
+			return nil, err
+		}
+		for _, stop := range stored {
+			s.stops[stop.ID] = stop
+		}
+	}
+
+	manager.SubscribeFills(s.onFill)
+	manager.SubscribeUpdates(s.onOrderUpdate)
+	if s.native != nil {
+		s.native.SubscribeStopUpdates(s.onNativeUpdate)
+	}
+
+	logger.Logger.Info().
+		Int("stops", len(s.stops)).
+		Bool("native", s.native != nil).
+		Msg("Stop manager started")
+	return s, nil
+}
+
+// Функция для подписки на котировки из пакета data (эмуляция срабатывания)
+func (s *StopManager) AttachToFeed() {
+	data.SubscribeQuotes(s.OnQuote)
+}
+
+// Функция для выставления одиночного условного ордера
+func (s *StopManager) PlaceStop(stop StopOrder) (*StopOrder, error) {
+	stops, err := s.place([]StopOrder{stop}, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	return &stops[0], nil
+}
+
+// Функция для выставления пары OCO: срабатывание одного ордера снимает другой
+func (s *StopManager) PlaceOCO(first StopOrder, second StopOrder) ([]StopOrder, error) {
+	return s.place([]StopOrder{first, second}, NewClientOrderID(), 0)
+}
+
+// Функция для выставления bracket: входной ордер и пара OCO стоп-лосс/тейк-профит,
+// которая активируется по мере исполнения входного ордера. Нулевая цена - нога не нужна
+func (s *StopManager) PlaceBracket(entry *OrderRequest, stopLoss float64, takeProfit float64) (*ManagedOrder, []StopOrder, error) {
+	exitSide := "sell"
+	if entry.Side == "sell" {
+		exitSide = "buy"
+	}
+
+	var legs []StopOrder
+	if stopLoss > 0 {
+		legs = append(legs, StopOrder{Kind: StopMarket, Symbol: entry.Symbol, Side: exitSide, StopPrice: stopLoss})
+	}
+	if takeProfit > 0 {
+		legs = append(legs, StopOrder{Kind: TakeProfit, Symbol: entry.Symbol, Side: exitSide, StopPrice: takeProfit})
+	}
+
+	for i := range legs {
+		if err := validateStop(&legs[i], true); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	parent, err := s.manager.PlaceOrder(entry)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(legs) == 0 {
+		return parent, nil, nil
+	}
+
+	stops, err := s.place(legs, NewClientOrderID(), parent.ID)
+	if err != nil {
+		return parent, nil, err
+	}
+
+	// Входной ордер мог исполниться до регистрации ног
+	if current, ok := s.manager.GetOrder(parent.ID); ok && current.FilledQuantity > 0 {
+		s.resizeChildren(parent.ID, current.FilledQuantity)
+		stops = s.children(parent.ID)
+	}
+	return parent, stops, nil
+}
+
+// Функция для снятия условного ордера (нативная пара OCO снимается целиком)
+func (s *StopManager) CancelStop(id string) error {
+	s.mu.Lock()
+	stop, ok := s.stops[id]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("stop order %s not found", id)
+	}
+	if stop.Status == StopTriggered || stop.Status == StopCancelled {
+		s.mu.Unlock()
+		return fmt.Errorf("stop order %s is already %s", id, stop.Status)
+	}
+	nativeID := stop.NativeID
+	s.mu.Unlock()
+
+	if nativeID != 0 {
+		if err := s.native.CancelStopOrder(nativeID); err != nil {
+			return fmt.Errorf("error cancelling native stop order %d: %w", nativeID, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, other := range s.stops {
+		if other.ID == id || (nativeID != 0 && other.NativeID == nativeID) {
+			other.Status = StopCancelled
+			other.Updated = now
+		}
+	}
+	s.save()
+	logger.Logger.Info().Str("stop_id", id).Msg("Stop order cancelled")
+	return nil
+}
+
+// Функция для снятия всех условных ордеров по инструменту (или всех, если symbol пустой)
+func (s *StopManager) CancelAll(symbol string) error {
+	var lastErr error
+	for _, stop := range s.Stops(symbol) {
+		if err := s.CancelStop(stop.ID); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// Функция возвращает активные и ожидающие условные ордера по инструменту (или все, если symbol пустой)
+func (s *StopManager) Stops(symbol string) []StopOrder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var stops []StopOrder
+	for _, stop := range s.stops {
+		if (stop.Status == StopActive || stop.Status == StopPending) && (symbol == "" || stop.Symbol == symbol) {
+			stops = append(stops, *stop)
+		}
+	}
+	sort.Slice(stops, func(i, j int) bool { return stops[i].Created.Before(stops[j].Created) })
+	return stops
+}
+
+// Функция для восстановления после перезапуска: ноги bracket, родительский ордер которых
+// исполнился, пока бот не работал, активируются
+func (s *StopManager) Recover() {
+	parents := make(map[int]bool)
+	s.mu.Lock()
+	for _, stop := range s.stops {
+		if stop.Status == StopPending && stop.ParentOrderID != 0 {
+			parents[stop.ParentOrderID] = true
+		}
+	}
+	s.mu.Unlock()
+
+	for parentID := range parents {
+		response, err := s.manager.Broker().GetOrderStatus(parentID)
+		if err != nil {
+			logger.Logger.Error().Err(err).Int("order_id", parentID).Msg("Failed to check bracket parent order")
+			continue
+		}
+		state, _ := ParseOrderState(response.Status)
+		switch {
+		case response.FilledQuantity > 0:
+			s.resizeChildren(parentID, response.FilledQuantity)
+		case state == StateFilled:
+			s.resizeChildren(parentID, s.parentQuantity(parentID))
+		case state.IsTerminal():
+			s.cancelChildren(parentID)
+		}
+	}
+}
+
+// Функция для проверки эмулируемых условных ордеров по котировке
+func (s *StopManager) OnQuote(quote data.Quote) {
+	if quote.Price <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	var triggered []StopOrder
+	var moves []StopOrder
+	siblings := make(map[string][]string) // Ордера группы OCO, снятые вместе со сработавшим стопом
+	changed := false
+	now := time.Now()
+	for _, stop := range s.stops {
+		if stop.Status != StopActive || stop.Symbol != quote.Symbol {
+			continue
+		}
+		if stop.Native || !stop.Triggered(quote.Price) {
+			// Трейлинг-стоп подтягивается за ценой; нативный переставляется на площадке после разблокировки
+			if stop.Trailing != nil && !s.replacing[stop.ID] {
+				previous := stop.StopPrice
+				if stop.trail(quote.Price) {
+					if stop.Native {
+						moves = append(moves, *stop)
+						stop.StopPrice = previous
+					} else {
+						stop.Updated = now
+						changed = true
+					}
+				}
+			}
+			continue
+		}
+		// Срабатывание и снятие остальных ордеров группы OCO сохраняются только после того,
+		// как площадка приняла ордер на исполнение (до этого другие котировки их не трогают)
+		stop.Status = StopTriggered
+		stop.Updated = now
+		triggered = append(triggered, *stop)
+		if stop.GroupID != "" {
+			for _, other := range s.stops {
+				if other.GroupID == stop.GroupID && other.ID != stop.ID && other.Status == StopActive {
+					other.Status = StopCancelled
+					other.Updated = now
+					siblings[stop.ID] = append(siblings[stop.ID], other.ID)
+				}
+			}
+		}
+	}
+	if changed && len(triggered) == 0 {
+		s.save()
+	}
+	s.mu.Unlock()
+
+	for _, stop := range moves {
+		logger.Logger.Info().
+			Str("stop_id", stop.ID).
+			Str("symbol", stop.Symbol).
+			Float64("stop_price", stop.StopPrice).
+			Float64("price", quote.Price).
+			Msg("Trailing stop moved")
+		s.modify(stop.ID, stop.StopPrice, stop.Quantity)
+	}
+
+	for _, stop := range triggered {
+		logger.Logger.Info().
+			Str("stop_id", stop.ID).
+			Str("kind", string(stop.Kind)).
+			Str("symbol", stop.Symbol).
+			Float64("stop_price", stop.StopPrice).
+			Float64("price", quote.Price).
+			Msg("Stop order triggered")
+
+		child, err := s.manager.PlaceOrder(stop.childRequest())
+		s.mu.Lock()
+		if err != nil {
+			// Позиция не должна остаться без защиты: стоп и ордера группы снова активны
+			// и сработают по следующей котировке
+			logger.Logger.Error().Err(err).Str("stop_id", stop.ID).Msg("Failed to place order for triggered stop, stop reactivated")
+			s.reactivate(stop.ID, siblings[stop.ID])
+		} else {
+			s.stops[stop.ID].ChildOrderID = child.ID
+		}
+		s.save()
+		s.mu.Unlock()
+	}
+}
+
+// Функция для возврата сработавшего стопа и снятых вместе с ним ордеров группы в активное
+// состояние (вызывается под s.mu)
+func (s *StopManager) reactivate(id string, siblings []string) {
+	now := time.Now()
+	for _, stopID := range append([]string{id}, siblings...) {
+		if stop, ok := s.stops[stopID]; ok {
+			stop.Status = StopActive
+			stop.Updated = now
+		}
+	}
+}
+
+// Функция для обработки обновления нативного условного ордера
+func (s *StopManager) onNativeUpdate(update StopUpdate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := false
+	for _, stop := range s.stops {
+		if !stop.Native || stop.NativeID != update.NativeID || stop.Status != StopActive {
+			continue
+		}
+		// Снятие старого ордера при перестановке - не снятие стопа
+		if s.replacing[stop.ID] && update.Status == StopCancelled {
+			continue
+		}
+		// Сработала одна нога пары, вторая снимается площадкой
+		stop.Status = StopCancelled
+		if update.Status == StopTriggered && (stop.Kind == TakeProfit) == (update.Leg == TakeProfit) {
+			stop.Status = StopTriggered
+			stop.ChildOrderID = update.OrderID
+		}
+		stop.Updated = time.Now()
+		changed = true
+	}
+	if changed {
+		s.save()
+		logger.Logger.Info().
+			Int("native_id", update.NativeID).
+			Str("status", string(update.Status)).
+			Int("order_id", update.OrderID).
+			Msg("Native stop order updated")
+	}
+}
+
+// Функция для регистрации условных ордеров (одиночный, OCO или ноги bracket)
+func (s *StopManager) place(legs []StopOrder, groupID string, parentOrderID int) ([]StopOrder, error) {
+	now := time.Now()
+	stops := make([]*StopOrder, len(legs))
+	for i := range legs {
+		stop := legs[i]
+		// Начальная стоп-цена трейлинг-стопа - от последней котировки
+		if stop.Trailing != nil && stop.StopPrice == 0 {
+			s.initTrailing(&stop)
+		}
+		if err := validateStop(&stop, parentOrderID != 0); err != nil {
+			return nil, err
+		}
+		stop.ID = NewClientOrderID()
+		stop.GroupID = groupID
+		stop.ParentOrderID = parentOrderID
+		stop.Status = StopActive
+		if parentOrderID != 0 {
+			stop.Status = StopPending
+			stop.Quantity = 0
+		}
+		stop.Created = now
+		stop.Updated = now
+		stops[i] = &stop
+	}
+
+	if parentOrderID == 0 {
+		if err := s.placeNative(stops); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	result := make([]StopOrder, len(stops))
+	for i, stop := range stops {
+		s.stops[stop.ID] = stop
+		result[i] = *stop
+	}
+	s.save()
+	s.mu.Unlock()
+
+	for _, stop := range result {
+		logger.Logger.Info().
+			Str("stop_id", stop.ID).
+			Str("kind", string(stop.Kind)).
+			Str("symbol", stop.Symbol).
+			Str("side", stop.Side).
+			Float64("stop_price", stop.StopPrice).
+			Str("status", string(stop.Status)).
+			Bool("native", stop.Native).
+			Msg("Stop order registered")
+	}
+	return result, nil
+}
+
+// Функция для выставления группы на площадке, если она поддерживает такую комбинацию.
+// Иначе ордера остаются эмулируемыми
+func (s *StopManager) placeNative(stops []*StopOrder) error {
+	if s.native == nil {
+		return nil
+	}
+
+	req := &StopOrderRequest{Symbol: stops[0].Symbol, Side: stops[0].Side, Quantity: stops[0].Quantity, ClientOrderID: stops[0].ID}
+	for _, stop := range stops {
+		if stop.Symbol != req.Symbol || stop.Side != req.Side || stop.Quantity != req.Quantity {
+			return nil
+		}
+		switch {
+		case stop.Kind == TakeProfit && req.TakeProfit == nil:
+			req.TakeProfit = stop
+		case stop.Kind != TakeProfit && req.StopLoss == nil:
+			req.StopLoss = stop
+		default:
+			return nil // Две ноги одного типа площадка не поддерживает
+		}
+	}
+
+	response, err := s.native.PlaceStopOrder(req)
+	if err != nil {
+		return fmt.Errorf("error placing native stop order: %w", err)
+	}
+	for _, stop := range stops {
+		stop.Native = true
+		stop.NativeID = response.OrderID
+	}
+	return nil
+}
+
+// Функция для обработки сделок: ноги bracket получают исполненное количество родителя
+func (s *StopManager) onFill(fill Fill) {
+	s.mu.Lock()
+	hasChildren := false
+	for _, stop := range s.stops {
+		if stop.ParentOrderID == fill.OrderID && (stop.Status == StopPending || stop.Status == StopActive) {
+			hasChildren = true
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if hasChildren {
+		if order, ok := s.manager.GetOrder(fill.OrderID); ok {
+			s.resizeChildren(fill.OrderID, order.FilledQuantity)
+		}
+	}
+	s.syncPosition(fill.Symbol)
+}
+
+// Функция для снятия ожидающих ног bracket, если родитель завершился без исполнения
+func (s *StopManager) onOrderUpdate(order ManagedOrder) {
+	if order.State.IsTerminal() && order.FilledQuantity == 0 {
+		s.cancelChildren(order.ID)
+	}
+}
+
+// Функция для установки количества ног bracket равным исполненному количеству родителя.
+// Нативная пара переставляется на площадке с новым количеством
+func (s *StopManager) resizeChildren(parentOrderID int, quantity int) {
+	s.mu.Lock()
+	var children []*StopOrder
+	for _, stop := range s.stops {
+		if stop.ParentOrderID == parentOrderID && (stop.Status == StopPending || stop.Status == StopActive) && stop.Quantity != quantity {
+			children = append(children, stop)
+		}
+	}
+	if len(children) == 0 {
+		s.mu.Unlock()
+		return
+	}
+	oldNativeID := children[0].NativeID
+	for _, stop := range children {
+		stop.Quantity = quantity
+		stop.Status = StopActive
+		stop.Native = false
+		stop.NativeID = 0
+		stop.Updated = time.Now()
+	}
+	s.mu.Unlock()
+
+	if oldNativeID != 0 {
+		if err := s.native.CancelStopOrder(oldNativeID); err != nil {
+			logger.Logger.Error().Err(err).Int("native_id", oldNativeID).Msg("Failed to cancel native stop order for resize")
+		}
+	}
+	if err := s.placeNative(children); err != nil {
+		logger.Logger.Error().Err(err).Int("parent_order_id", parentOrderID).Msg("Native stop order failed, emulating")
+	}
+
+	s.mu.Lock()
+	s.save()
+	s.mu.Unlock()
+
+	logger.Logger.Info().
+		Int("parent_order_id", parentOrderID).
+		Int("quantity", quantity).
+		Msg("Bracket legs activated")
+}
+
+// Функция для снятия ног bracket, родитель которого не исполнился
+func (s *StopManager) cancelChildren(parentOrderID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := false
+	for _, stop := range s.stops {
+		if stop.ParentOrderID == parentOrderID && stop.Status == StopPending {
+			stop.Status = StopCancelled
+			stop.Updated = time.Now()
+			changed = true
+		}
+	}
+	if changed {
+		s.save()
+	}
+}
+
+// Функция возвращает ноги bracket
+func (s *StopManager) children(parentOrderID int) []StopOrder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var stops []StopOrder
+	for _, stop := range s.stops {
+		if stop.ParentOrderID == parentOrderID {
+			stops = append(stops, *stop)
+		}
+	}
+	sort.Slice(stops, func(i, j int) bool { return stops[i].Created.Before(stops[j].Created) })
+	return stops
+}
+
+// Функция возвращает количество родительского ордера
+func (s *StopManager) parentQuantity(parentOrderID int) int {
+	if order, ok := s.manager.GetOrder(parentOrderID); ok {
+		return order.Request.Quantity
+	}
+	return 0
+}
+
+// Функция для сохранения состояния (вызывается под s.mu). Сработавшие и снятые
+// ордера старше суток не сохраняются
+func (s *StopManager) save() {
+	if s.statePath == "" {
+		return
+	}
+	cutoff := time.Now().Add(-24 * time.Hour)
+	stops := make([]*StopOrder, 0, len(s.stops))
+	for id, stop := range s.stops {
+		if (stop.Status == StopTriggered || stop.Status == StopCancelled) && stop.Updated.Before(cutoff) {
+			delete(s.stops, id)
+			continue
+		}
+		stops = append(stops, stop)
+	}
+	sort.Slice(stops, func(i, j int) bool { return stops[i].Created.Before(stops[j].Created) })
+
+	if err := writeJSONAtomic(s.statePath, stops); err != nil {
+		logger.Logger.Error().Err(err).Str("path", s.statePath).Msg("Failed to save stop orders")
+	}
+}
+
+// Функция для проверки параметров условного ордера
+func validateStop(stop *StopOrder, bracketLeg bool) error {
+	switch stop.Kind {
+	case StopMarket, TakeProfit:
+	case StopLimit:
+		if stop.LimitPrice <= 0 {
+			return fmt.Errorf("stop_limit order without limit price")
+		}
+	default:
+		return fmt.Errorf("unsupported stop order kind: %q", stop.Kind)
+	}
+	if stop.Side != "buy" && stop.Side != "sell" {
+		return fmt.Errorf("invalid stop order side: %q", stop.Side)
+	}
+	if stop.StopPrice <= 0 {
+		return fmt.Errorf("stop order without stop price")
+	}
+	if stop.Trailing != nil {
+		if stop.Kind != StopMarket {
+			return fmt.Errorf("trailing is supported for stop orders only, got %q", stop.Kind)
+		}
+		if err := stop.Trailing.validate(); err != nil {
+			return err
+		}
+	}
+	if !bracketLeg && stop.Quantity <= 0 {
+		return fmt.Errorf("stop order quantity must be positive")
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package order
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"trading-bot/data"
+)
+
+func TestStopOrderTriggered(t *testing.T) {
+	tests := []struct {
+		name  string
+		kind  StopKind
+		side  string
+		price float64
+		want  bool
+	}{
+		{"sell stop above stop price", StopMarket, "sell", 96, false},
+		{"sell stop at stop price", StopMarket, "sell", 95, true},
+		{"sell stop below stop price", StopMarket, "sell", 90, true},
+		{"buy stop below stop price", StopMarket, "buy", 94, false},
+		{"buy stop above stop price", StopMarket, "buy", 96, true},
+		{"sell stop limit", StopLimit, "sell", 95, true},
+		{"sell take profit below stop price", TakeProfit, "sell", 94, false},
+		{"sell take profit above stop price", TakeProfit, "sell", 96, true},
+		{"buy take profit above stop price", TakeProfit, "buy", 96, false},
+		{"buy take profit below stop price", TakeProfit, "buy", 94, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stop := &StopOrder{Kind: tt.kind, Side: tt.side, StopPrice: 95}
+			if got := stop.Triggered(tt.price); got != tt.want {
+				t.Errorf("Triggered(%v) = %v, want %v", tt.price, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStopOrderChildRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		stop      StopOrder
+		wantType  string
+		wantPrice float64
+	}{
+		{"stop", StopOrder{Kind: StopMarket, StopPrice: 95}, "market", 0},
+		{"stop limit", StopOrder{Kind: StopLimit, StopPrice: 95, LimitPrice: 94.5}, "limit", 94.5},
+		{"take profit", StopOrder{Kind: TakeProfit, StopPrice: 110}, "market", 0},
+		{"take profit with limit", StopOrder{Kind: TakeProfit, StopPrice: 110, LimitPrice: 109.5}, "limit", 109.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.stop.Symbol, tt.stop.Side, tt.stop.Quantity = "SBER", "sell", 3
+			req := tt.stop.childRequest()
+			if req.OrderType != tt.wantType || req.Price != tt.wantPrice || req.Quantity != 3 || req.Side != "sell" {
+				t.Errorf("child request = %+v, want %s at %v", req, tt.wantType, tt.wantPrice)
+			}
+		})
+	}
+}
+
+func TestValidateStop(t *testing.T) {
+	tests := []struct {
+		name       string
+		stop       StopOrder
+		bracketLeg bool
+		wantErr    bool
+	}{
+		{"valid stop", StopOrder{Kind: StopMarket, Side: "sell", Quantity: 1, StopPrice: 95}, false, false},
+		{"stop limit without limit price", StopOrder{Kind: StopLimit, Side: "sell", Quantity: 1, StopPrice: 95}, false, true},
+		{"unknown kind", StopOrder{Kind: "iceberg", Side: "sell", Quantity: 1, StopPrice: 95}, false, true},
+		{"invalid side", StopOrder{Kind: StopMarket, Side: "short", Quantity: 1, StopPrice: 95}, false, true},
+		{"no stop price", StopOrder{Kind: StopMarket, Side: "sell", Quantity: 1}, false, true},
+		{"no quantity", StopOrder{Kind: StopMarket, Side: "sell", StopPrice: 95}, false, true},
+		{"bracket leg takes quantity from parent", StopOrder{Kind: StopMarket, Side: "sell", StopPrice: 95}, true, false},
+		{"trailing take profit", StopOrder{Kind: TakeProfit, Side: "sell", Quantity: 1, StopPrice: 95, Trailing: &TrailingConfig{}}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateStop(&tt.stop, tt.bracketLeg); (err != nil) != tt.wantErr {
+				t.Errorf("validateStop = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// Функция возвращает все условные ордера менеджера, включая сработавшие и снятые
+func allStops(manager *StopManager) []StopOrder {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	var stops []StopOrder
+	for _, stop := range manager.stops {
+		stops = append(stops, *stop)
+	}
+	return stops
+}
+
+// Функция возвращает статусы условных ордеров по ID
+func stopStatuses(manager *StopManager) map[string]StopStatus {
+	statuses := make(map[string]StopStatus)
+	for _, stop := range allStops(manager) {
+		statuses[stop.ID] = stop.Status
+	}
+	return statuses
+}
+
+func TestStopManagerOCO(t *testing.T) {
+	tests := []struct {
+		name       string
+		price      float64
+		placeErr   error
+		wantStop   StopStatus
+		wantTake   StopStatus
+		wantPlaced []string // Типы выставленных ордеров
+	}{
+		{"no trigger", 100, nil, StopActive, StopActive, nil},
+		{"stop loss triggers and cancels take profit", 94, nil, StopTriggered, StopCancelled, []string{"market"}},
+		{"take profit triggers and cancels stop loss", 111, nil, StopCancelled, StopTriggered, []string{"limit"}},
+		{"rejected exit order reactivates the pair", 94, errors.New("broker is down"), StopActive, StopActive, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &stubBroker{}
+			stops, err := NewStopManager(NewOrderManager(stub), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			pair, err := stops.PlaceOCO(
+				StopOrder{Kind: StopMarket, Symbol: "SBER", Side: "sell", Quantity: 2, StopPrice: 95},
+				StopOrder{Kind: TakeProfit, Symbol: "SBER", Side: "sell", Quantity: 2, StopPrice: 110, LimitPrice: 110},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stub.placeErr = tt.placeErr
+			stops.OnQuote(data.Quote{Symbol: "SBER", Price: tt.price, Volume: 1, Time: time.Now()})
+			// Котировка другого инструмента не влияет на ордера
+			stops.OnQuote(data.Quote{Symbol: "GAZP", Price: 1, Volume: 1, Time: time.Now()})
+
+			statuses := stopStatuses(stops)
+			if statuses[pair[0].ID] != tt.wantStop || statuses[pair[1].ID] != tt.wantTake {
+				t.Errorf("statuses = stop %s, take %s, want %s, %s", statuses[pair[0].ID], statuses[pair[1].ID], tt.wantStop, tt.wantTake)
+			}
+			if len(stub.placed) != len(tt.wantPlaced) {
+				t.Fatalf("placed %d orders, want %d", len(stub.placed), len(tt.wantPlaced))
+			}
+			for i, req := range stub.placed {
+				if req.OrderType != tt.wantPlaced[i] || req.Side != "sell" || req.Quantity != 2 {
+					t.Errorf("placed order %+v, want %s sell 2", req, tt.wantPlaced[i])
+				}
+			}
+		})
+	}
+}
+
+func TestStopManagerBracket(t *testing.T) {
+	tests := []struct {
+		name         string
+		update       OrderUpdate
+		wantStatus   StopStatus
+		wantQuantity int
+	}{
+		{"legs wait for entry fill", OrderUpdate{Status: "working"}, StopPending, 0},
+		{"partial fill activates legs for filled quantity", OrderUpdate{Status: "partially_filled", FilledQuantity: 2}, StopActive, 2},
+		{"full fill", OrderUpdate{Status: "filled", FilledQuantity: 5, AveragePrice: 100}, StopActive, 5},
+		{"entry cancelled without fills cancels legs", OrderUpdate{Status: "cancelled"}, StopCancelled, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := NewOrderManager(&stubBroker{})
+			stops, err := NewStopManager(manager, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			parent, legs, err := stops.PlaceBracket(&OrderRequest{Symbol: "SBER", Side: "buy", Quantity: 5, OrderType: "limit", Price: 100}, 95, 110)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(legs) != 2 {
+				t.Fatalf("got %d legs, want 2", len(legs))
+			}
+
+			update := tt.update
+			update.OrderID = parent.ID
+			if err := manager.ApplyUpdate(update); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, leg := range allStops(stops) {
+				if leg.Status != tt.wantStatus || leg.Quantity != tt.wantQuantity || leg.Side != "sell" {
+					t.Errorf("leg %s: status %s quantity %d side %s, want %s %d sell", leg.Kind, leg.Status, leg.Quantity, leg.Side, tt.wantStatus, tt.wantQuantity)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package order
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Функция для атомарной записи состояния в JSON: запись во временный файл, fsync и переименование
+func writeJSONAtomic(path string, v interface{}) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating state directory: %w", err)
+	}
+
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("error creating state file: %w", err)
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("error syncing state file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error closing state file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("error renaming state file: %w", err)
+	}
+	return nil
+}
+
+// Функция для чтения состояния из JSON. Отсутствующий файл - не ошибка (возвращается false)
+func readJSON(path string, v interface{}) (bool, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error reading state file: %w", err)
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		return false, fmt.Errorf("error parsing state file %s: %w", path, err)
+	}
+	return true, nil
+}
+
+// Функция для дописывания записи в JSONL файл
+func appendJSONLine(path string, v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error marshaling record: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,380 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"trading-bot/logger"
+)
+
+// Ошибка для сообщения, отклоненного ограничителем частоты (ордер на площадку не отправлялся)
+var ErrThrottled = errors.New("order rate limit exceeded")
+
+// Тип сообщения площадке
+type MessageKind string
+
+const (
+	MessageOrder  MessageKind = "order"
+	MessageCancel MessageKind = "cancel"
+	MessageModify MessageKind = "modify"
+)
+
+// Действие при превышении лимита в секунду
+type ThrottleAction string
+
+const (
+	ThrottleQueue  ThrottleAction = "queue"  // Ждать освобождения лимита (не дольше MaxWaitSeconds)
+	ThrottleReject ThrottleAction = "reject" // Сразу отклонять
+)
+
+// Структура для лимита сообщений (нулевое значение - без ограничения)
+type RateLimit struct {
+	PerSecond  int `json:"per_second"`
+	PerSession int `json:"per_session"`
+}
+
+// Структура для настройки ограничителя частоты сообщений площадке
+type ThrottleConfig struct {
+	Action         ThrottleAction       `json:"action"`
+	MaxWaitSeconds float64              `json:"max_wait_seconds"` // Максимальное ожидание в очереди
+	Account        RateLimit            `json:"account"`          // Лимит на счет по умолчанию
+	Accounts       map[string]RateLimit `json:"accounts"`         // Лимиты отдельных счетов
+	Symbol         RateLimit            `json:"symbol"`           // Лимит на инструмент по умолчанию
+	Symbols        map[string]RateLimit `json:"symbols"`          // Лимиты отдельных инструментов
+	// Порог биржи для отношения сообщений к сделкам за сессию (0 - не проверяется). При достижении
+	// порога новые ордера отклоняются, снятия пропускаются
+	MaxOrderToTrade float64 `json:"max_order_to_trade"`
+	WarnRatio       float64 `json:"warn_ratio"`   // Доля порога, при которой выдается предупреждение
+	MinMessages     int     `json:"min_messages"` // Отношение проверяется после стольких сообщений за сессию
+}
+
+// Функция возвращает настройки по умолчанию
+func DefaultThrottleConfig() ThrottleConfig {
+	return ThrottleConfig{
+		Action:          ThrottleQueue,
+		MaxWaitSeconds:  5,
+		Account:         RateLimit{PerSecond: 20},
+		Symbol:          RateLimit{PerSecond: 5},
+		MaxOrderToTrade: 1000,
+		WarnRatio:       0.8,
+		MinMessages:     100,
+	}
+}
+
+// Структура для статистики сообщений за сессию
+type ThrottleStats struct {
+	Orders       int     `json:"orders"`
+	Cancels      int     `json:"cancels"`
+	Modifies     int     `json:"modifies"`
+	Trades       int     `json:"trades"`
+	Rejected     int     `json:"rejected"`       // Отклонено ограничителем
+	OrderToTrade float64 `json:"order_to_trade"` // Сообщений на сделку (при отсутствии сделок - число сообщений)
+}
+
+// Функция возвращает количество сообщений
+func (s ThrottleStats) Messages() int {
+	return s.Orders + s.Cancels + s.Modifies
+}
+
+// Функция для пересчета отношения сообщений к сделкам
+func (s *ThrottleStats) ratio() {
+	s.OrderToTrade = float64(s.Messages()) / float64(max(s.Trades, 1))
+}
+
+// Структура для счетчика сообщений по счету или инструменту
+type rateCounter struct {
+	recent []time.Time // Сообщения за последнюю секунду
+	stats  ThrottleStats
+	warned bool // Предупреждение об отношении к сделкам уже выдано в этой сессии
+}
+
+// Функция для удаления сообщений старше секунды
+func (c *rateCounter) prune(now time.Time) {
+	cutoff := now.Add(-time.Second)
+	for len(c.recent) > 0 && !c.recent[0].After(cutoff) {
+		c.recent = c.recent[1:]
+	}
+}
+
+// Функция для учета сообщения
+func (c *rateCounter) add(kind MessageKind, now time.Time) {
+	c.recent = append(c.recent, now)
+	switch kind {
+	case MessageOrder:
+		c.stats.Orders++
+	case MessageCancel:
+		c.stats.Cancels++
+	case MessageModify:
+		c.stats.Modifies++
+	}
+	c.stats.ratio()
+}
+
+// Структура для ордера, по которому учитываются снятия, изменения и сделки
+type throttledOrder struct {
+	account string
+	symbol  string
+}
+
+// Декоратор Broker, ограничивающий частоту ордеров, снятий и изменений в секунду и за сессию
+// по счетам и инструментам и следящий за отношением сообщений к сделкам. Сессия - торговый день
+type ThrottledBroker struct {
+	Broker
+	config ThrottleConfig
+
+	mu       sync.Mutex
+	session  string // Дата текущей сессии
+	accounts map[string]*rateCounter
+	symbols  map[string]*rateCounter
+	orders   map[int]throttledOrder
+
+	now func() time.Time
+}
+
+// Функция для создания декоратора
+func NewThrottledBroker(broker Broker, config ThrottleConfig) *ThrottledBroker {
+	return &ThrottledBroker{
+		Broker:   broker,
+		config:   config,
+		accounts: make(map[string]*rateCounter),
+		symbols:  make(map[string]*rateCounter),
+		orders:   make(map[int]throttledOrder),
+		now:      time.Now,
+	}
+}
+
+// Функция возвращает площадку под декоратором
+func (b *ThrottledBroker) Unwrap() Broker {
+	return b.Broker
+}
+
+// Функция для подсчета сделок по сделкам менеджера ордеров
+func (b *ThrottledBroker) AttachToManager(manager *OrderManager) {
+	manager.SubscribeFills(b.onFill)
+}
+
+func (b *ThrottledBroker) PlaceOrder(req *OrderRequest) (*OrderResponse, error) {
+	account := orderAccount(req)
+	if err := b.acquire(MessageOrder, account, req.Symbol, req.Emergency); err != nil {
+		return nil, err
+	}
+
+	response, err := b.Broker.PlaceOrder(req)
+	if err == nil {
+		b.mu.Lock()
+		b.orders[response.OrderID] = throttledOrder{account: account, symbol: req.Symbol}
+		b.mu.Unlock()
+	}
+	return response, err
+}
+
+func (b *ThrottledBroker) CancelOrder(orderID int) error {
+	order := b.order(orderID)
+	if err := b.acquire(MessageCancel, order.account, order.symbol, false); err != nil {
+		return err
+	}
+	return b.Broker.CancelOrder(orderID)
+}
+
+func (b *ThrottledBroker) ModifyOrder(req *ModifyOrderRequest) (*OrderResponse, error) {
+	order := b.order(req.OrderID)
+	if err := b.acquire(MessageModify, order.account, order.symbol, false); err != nil {
+		return nil, &ModifyError{OrderID: req.OrderID, Stage: ErrModifyRejected, Err: err}
+	}
+
+	response, err := b.Broker.ModifyOrder(req)
+	if err == nil && response.OrderID != 0 && response.OrderID != req.OrderID {
+		b.mu.Lock()
+		b.orders[response.OrderID] = order
+		b.mu.Unlock()
+	}
+	return response, err
+}
+
+// Функция возвращает статистику за сессию по счету
+func (b *ThrottledBroker) AccountStats(account string) ThrottleStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.rollSession()
+	if counter, ok := b.accounts[account]; ok {
+		return counter.stats
+	}
+	return ThrottleStats{}
+}
+
+// Функция возвращает статистику за сессию по инструментам
+func (b *ThrottledBroker) SymbolStats() map[string]ThrottleStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.rollSession()
+	stats := make(map[string]ThrottleStats, len(b.symbols))
+	for symbol, counter := range b.symbols {
+		stats[symbol] = counter.stats
+	}
+	return stats
+}
+
+// Функция для получения разрешения на сообщение. При превышении лимита в секунду сообщение
+// ждет в очереди или отклоняется, при превышении лимита за сессию или порога отношения
+// к сделкам - отклоняется (снятия по порогу отношения не отклоняются). Аварийный ордер
+// (emergency) никогда не отклоняется: он только ждет освобождения лимита в секунду
+func (b *ThrottledBroker) acquire(kind MessageKind, account, symbol string, emergency bool) error {
+	deadline := b.now().Add(time.Duration(b.config.MaxWaitSeconds * float64(time.Second)))
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for {
+		now := b.now()
+		b.rollSession()
+		accountCounter := b.counter(b.accounts, account)
+		symbolCounter := b.counter(b.symbols, symbol)
+		accountLimit := b.limit(b.config.Accounts, account, b.config.Account)
+		symbolLimit := b.limit(b.config.Symbols, symbol, b.config.Symbol)
+
+		if err := b.checkSession(kind, account, accountCounter, accountLimit, symbol, symbolCounter, symbolLimit); err != nil && !emergency {
+			accountCounter.stats.Rejected++
+			symbolCounter.stats.Rejected++
+			logger.Logger.Warn().Err(err).Str("account", account).Str("symbol", symbol).Str("kind", string(kind)).Msg("Message rejected by throttle")
+			return err
+		}
+
+		accountCounter.prune(now)
+		symbolCounter.prune(now)
+		wait := max(waitTime(accountCounter, accountLimit, now), waitTime(symbolCounter, symbolLimit, now))
+		if wait == 0 {
+			accountCounter.add(kind, now)
+			symbolCounter.add(kind, now)
+			b.warnRatio(account, accountCounter)
+			return nil
+		}
+
+		if !emergency && (b.config.Action == ThrottleReject || now.Add(wait).After(deadline)) {
+			accountCounter.stats.Rejected++
+			symbolCounter.stats.Rejected++
+			logger.Logger.Warn().Str("account", account).Str("symbol", symbol).Str("kind", string(kind)).Msg("Message rejected by throttle: per second limit")
+			return fmt.Errorf("%s %s: per second limit: %w: %w", kind, symbol, ErrThrottled, ErrOrderRejected)
+		}
+
+		logger.Logger.Debug().Str("symbol", symbol).Dur("wait", wait).Msg("Message queued by throttle")
+		b.mu.Unlock()
+		time.Sleep(wait)
+		b.mu.Lock()
+	}
+}
+
+// Функция для проверки лимитов за сессию и порога отношения к сделкам (вызывается под b.mu)
+func (b *ThrottledBroker) checkSession(kind MessageKind, account string, accountCounter *rateCounter, accountLimit RateLimit,
+	symbol string, symbolCounter *rateCounter, symbolLimit RateLimit) error {
+	if accountLimit.PerSession > 0 && accountCounter.stats.Messages() >= accountLimit.PerSession {
+		return fmt.Errorf("%s: account %q session limit %d: %w: %w", kind, account, accountLimit.PerSession, ErrThrottled, ErrOrderRejected)
+	}
+	if symbolLimit.PerSession > 0 && symbolCounter.stats.Messages() >= symbolLimit.PerSession {
+		return fmt.Errorf("%s: symbol %s session limit %d: %w: %w", kind, symbol, symbolLimit.PerSession, ErrThrottled, ErrOrderRejected)
+	}
+	// Снятие уменьшает риск, поэтому по порогу отношения не блокируется
+	if kind != MessageCancel && b.ratioExceeded(accountCounter, 1) {
+		return fmt.Errorf("%s: account %q order-to-trade ratio %.1f reached limit %.1f: %w: %w",
+			kind, account, accountCounter.stats.OrderToTrade, b.config.MaxOrderToTrade, ErrThrottled, ErrOrderRejected)
+	}
+	return nil
+}
+
+// Функция для предупреждения о приближении отношения к порогу биржи (вызывается под b.mu)
+func (b *ThrottledBroker) warnRatio(account string, counter *rateCounter) {
+	if counter.warned || b.config.WarnRatio <= 0 || !b.ratioExceeded(counter, b.config.WarnRatio) {
+		return
+	}
+	counter.warned = true
+	logger.Logger.Warn().
+		Str("account", account).
+		Int("messages", counter.stats.Messages()).
+		Int("trades", counter.stats.Trades).
+		Float64("ratio", counter.stats.OrderToTrade).
+		Float64("limit", b.config.MaxOrderToTrade).
+		Msg("Order-to-trade ratio is approaching exchange threshold")
+}
+
+// Функция проверяет, достигло ли отношение к сделкам доли share от порога (вызывается под b.mu)
+func (b *ThrottledBroker) ratioExceeded(counter *rateCounter, share float64) bool {
+	if b.config.MaxOrderToTrade <= 0 || counter.stats.Messages() < b.config.MinMessages {
+		return false
+	}
+	return counter.stats.OrderToTrade >= b.config.MaxOrderToTrade*share
+}
+
+// Функция для учета сделки
+func (b *ThrottledBroker) onFill(fill Fill) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.rollSession()
+	order, ok := b.orders[fill.OrderID]
+	if !ok {
+		order.symbol = fill.Symbol
+	}
+	for _, counter := range []*rateCounter{b.counter(b.accounts, order.account), b.counter(b.symbols, order.symbol)} {
+		counter.stats.Trades++
+		counter.stats.ratio()
+	}
+}
+
+// Функция возвращает счет и инструмент ордера (для неизвестного ордера - пустые)
+func (b *ThrottledBroker) order(orderID int) throttledOrder {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.orders[orderID]
+}
+
+// Функция для сброса счетчиков при начале новой сессии (вызывается под b.mu)
+func (b *ThrottledBroker) rollSession() {
+	session := b.now().Format("2006-01-02")
+	if session == b.session {
+		return
+	}
+	if b.session != "" {
+		logger.Logger.Info().Str("session", session).Msg("Throttle counters reset for new session")
+	}
+	b.session = session
+	b.accounts = make(map[string]*rateCounter)
+	b.symbols = make(map[string]*rateCounter)
+}
+
+// Функция возвращает счетчик по ключу, создавая его при необходимости (вызывается под b.mu)
+func (b *ThrottledBroker) counter(counters map[string]*rateCounter, key string) *rateCounter {
+	counter, ok := counters[key]
+	if !ok {
+		counter = &rateCounter{}
+		counters[key] = counter
+	}
+	return counter
+}
+
+// Функция возвращает лимит по ключу
+func (b *ThrottledBroker) limit(limits map[string]RateLimit, key string, fallback RateLimit) RateLimit {
+	if limit, ok := limits[key]; ok {
+		return limit
+	}
+	return fallback
+}
+
+// Функция возвращает, сколько ждать освобождения лимита в секунду (0 - лимит свободен)
+func waitTime(counter *rateCounter, limit RateLimit, now time.Time) time.Duration {
+	if limit.PerSecond <= 0 || len(counter.recent) < limit.PerSecond {
+		return 0
+	}
+	return counter.recent[len(counter.recent)-limit.PerSecond].Add(time.Second).Sub(now)
+}
+
+// Функция возвращает счет ордера: код счета, иначе код клиента
+func orderAccount(req *OrderRequest) string {
+	if req.AccountID != "" {
+		return req.AccountID
+	}
+	return req.ClientCode
+}
@@ -0,0 +1,108 @@
+package order
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestThrottledBrokerPerSecondLimit(t *testing.T) {
+	venue := &stubBroker{}
+	config := ThrottleConfig{Action: ThrottleReject, Account: RateLimit{PerSecond: 3}, Symbol: RateLimit{PerSecond: 2}}
+	broker := NewThrottledBroker(venue, config)
+	now := time.Date(2024, 4, 2, 10, 0, 0, 0, time.UTC)
+	broker.now = func() time.Time { return now }
+
+	place := func(symbol string) error {
+		_, err := broker.PlaceOrder(&OrderRequest{AccountID: "A1", Symbol: symbol, Side: "buy", Quantity: 1, OrderType: "market"})
+		return err
+	}
+
+	// Лимит инструмента - 2 в секунду, лимит счета - 3 в секунду
+	if err := place("SBER"); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(100 * time.Millisecond)
+	if err := place("SBER"); err != nil {
+		t.Fatal(err)
+	}
+	if err := place("SBER"); !errors.Is(err, ErrThrottled) || !errors.Is(err, ErrOrderRejected) {
+		t.Fatalf("third SBER order in a second: error = %v, want ErrThrottled", err)
+	}
+	if err := place("GAZP"); err != nil {
+		t.Fatalf("GAZP order within account limit: %v", err)
+	}
+	if err := place("LKOH"); !errors.Is(err, ErrThrottled) {
+		t.Fatalf("fourth order on account in a second: error = %v, want ErrThrottled", err)
+	}
+	if len(venue.placed) != 3 {
+		t.Errorf("venue got %d orders, want 3", len(venue.placed))
+	}
+
+	// Через секунду после первого ордера по SBER освобождается одно место
+	now = now.Add(900 * time.Millisecond)
+	if err := place("SBER"); err != nil {
+		t.Errorf("SBER order after a second: %v", err)
+	}
+	if stats := broker.SymbolStats()["SBER"]; stats.Orders != 3 || stats.Rejected != 1 {
+		t.Errorf("SBER stats = %+v, want 3 orders and 1 rejected", stats)
+	}
+}
+
+func TestThrottledBrokerSessionLimit(t *testing.T) {
+	venue := &stubBroker{}
+	config := ThrottleConfig{Action: ThrottleReject, Accounts: map[string]RateLimit{"A1": {PerSession: 3}}}
+	broker := NewThrottledBroker(venue, config)
+	now := time.Date(2024, 4, 2, 10, 0, 0, 0, time.UTC)
+	broker.now = func() time.Time { return now }
+	next := func() { now = now.Add(time.Minute) }
+
+	request := OrderRequest{AccountID: "A1", Symbol: "SBER", Side: "buy", Quantity: 1, OrderType: "limit", Price: 250}
+	placed, err := broker.PlaceOrder(&request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next()
+	if _, err := broker.ModifyOrder(&ModifyOrderRequest{OrderID: placed.OrderID, Price: 251}); err != nil {
+		t.Fatal(err)
+	}
+	next()
+	if err := broker.CancelOrder(placed.OrderID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Снятие, изменение и ордер считаются в один лимит за сессию
+	next()
+	if _, err := broker.PlaceOrder(&request); !errors.Is(err, ErrThrottled) {
+		t.Fatalf("order over session limit: error = %v, want ErrThrottled", err)
+	}
+	if err := broker.CancelOrder(placed.OrderID); !errors.Is(err, ErrThrottled) {
+		t.Fatalf("cancel over session limit: error = %v, want ErrThrottled", err)
+	}
+	// Аварийный ордер проходит сверх лимита
+	emergency := request
+	emergency.Emergency = true
+	if _, err := broker.PlaceOrder(&emergency); err != nil {
+		t.Fatalf("emergency order over session limit: %v", err)
+	}
+	// Лимит действует только на свой счет
+	other := request
+	other.AccountID = "A2"
+	if _, err := broker.PlaceOrder(&other); err != nil {
+		t.Fatalf("order on another account: %v", err)
+	}
+
+	stats := broker.AccountStats("A1")
+	if stats.Orders != 2 || stats.Modifies != 1 || stats.Cancels != 1 || stats.Rejected != 2 {
+		t.Errorf("A1 stats = %+v, want 2 orders, 1 modify, 1 cancel, 2 rejected", stats)
+	}
+
+	// На следующий день счетчики сбрасываются
+	now = time.Date(2024, 4, 3, 10, 0, 0, 0, time.UTC)
+	if _, err := broker.PlaceOrder(&request); err != nil {
+		t.Errorf("order in new session: %v", err)
+	}
+	if stats := broker.AccountStats("A1"); stats.Orders != 1 || stats.Rejected != 0 {
+		t.Errorf("A1 stats in new session = %+v, want 1 order", stats)
+	}
+}
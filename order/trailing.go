@@ -0,0 +1,355 @@
+package order
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-gota/gota/dataframe"
+
+	"trading-bot/data"
+	"trading-bot/logger"
+	"trading-bot/utils"
+)
+
+// Способы расчета расстояния трейлинг-стопа
+type TrailingMode string
+
+const (
+	TrailFixed   TrailingMode = "fixed"   // Фиксированное расстояние в пунктах цены
+	TrailPercent TrailingMode = "percent" // Процент от лучшей цены
+	TrailATR     TrailingMode = "atr"     // Кратное ATR (utils.CalculateATRChannels)
+)
+
+// Период ATR по умолчанию
+const defaultATRPeriod = 14
+
+// Структура для настройки трейлинг-стопа
+type TrailingConfig struct {
+	Mode      TrailingMode `json:"mode"`
+	Distance  float64      `json:"distance"`             // Пункты (fixed), проценты (percent) или множитель ATR (atr)
+	ATRPeriod int          `json:"atr_period,omitempty"` // Период ATR (по умолчанию 14)
+	MinStep   float64      `json:"min_step,omitempty"`   // Минимальный сдвиг стопа: меньшие сдвиги не переставляют ордер на площадке
+}
+
+// Функция для проверки настроек трейлинг-стопа
+func (c *TrailingConfig) validate() error {
+	switch c.Mode {
+	case TrailFixed, TrailPercent, TrailATR:
+	default:
+		return fmt.Errorf("unsupported trailing mode: %q", c.Mode)
+	}
+	if c.Distance <= 0 {
+		return fmt.Errorf("trailing distance must be positive")
+	}
+	if c.Mode == TrailPercent && c.Distance >= 100 {
+		return fmt.Errorf("trailing distance %g%% is too large", c.Distance)
+	}
+	if c.MinStep < 0 {
+		return fmt.Errorf("trailing min step must not be negative")
+	}
+	return nil
+}
+
+// Функция возвращает период ATR
+func (c *TrailingConfig) atrPeriod() int {
+	if c.ATRPeriod > 0 {
+		return c.ATRPeriod
+	}
+	return defaultATRPeriod
+}
+
+// Функция возвращает расстояние стопа от лучшей цены (0 - расстояние еще неизвестно, нет ATR)
+func (s *StopOrder) trailDistance() float64 {
+	switch s.Trailing.Mode {
+	case TrailFixed:
+		return s.Trailing.Distance
+	case TrailPercent:
+		return s.ExtremePrice * s.Trailing.Distance / 100
+	case TrailATR:
+		return s.ATR * s.Trailing.Distance
+	}
+	return 0
+}
+
+// Функция для подтягивания трейлинг-стопа за ценой. Стоп сдвигается только в пользу позиции
+// и не меньше чем на MinStep. Возвращает true, если стоп-цена изменилась
+func (s *StopOrder) trail(price float64) bool {
+	if s.Trailing == nil || price <= 0 {
+		return false
+	}
+	// Стоп на продажу защищает длинную позицию и следует за максимумом, на покупку - за минимумом
+	long := s.Side == "sell"
+	if s.ExtremePrice == 0 || (long && price > s.ExtremePrice) || (!long && price < s.ExtremePrice) {
+		s.ExtremePrice = price
+	}
+
+	distance := s.trailDistance()
+	if distance <= 0 {
+		return false
+	}
+	candidate := s.ExtremePrice - distance
+	if !long {
+		candidate = s.ExtremePrice + distance
+	}
+	if candidate <= 0 {
+		return false
+	}
+	if s.StopPrice > 0 {
+		step := candidate - s.StopPrice
+		if !long {
+			step = -step
+		}
+		if step <= 0 || step < s.Trailing.MinStep {
+			return false
+		}
+	}
+	s.StopPrice = candidate
+	return true
+}
+
+// Функция для выставления трейлинг-стопа на текущую позицию по инструменту.
+// Стоп привязан к позиции: его количество следует за позицией, при закрытии позиции он снимается.
+// Прежний трейлинг-стоп по инструменту заменяется
+func (s *StopManager) TrailPosition(symbol string, trailing TrailingConfig) (*StopOrder, error) {
+	if err := trailing.validate(); err != nil {
+		return nil, err
+	}
+	position, err := s.position(symbol)
+	if err != nil {
+		return nil, err
+	}
+	if position.Quantity == 0 {
+		return nil, fmt.Errorf("no open position for %s", symbol)
+	}
+
+	side, quantity := "sell", position.Quantity
+	if quantity < 0 {
+		side, quantity = "buy", -quantity
+	}
+	// Текущая цена позиции есть и у площадки, и у виртуального брокера (в том числе в бэктесте)
+	price := position.CurrentPrice
+	if quote, ok := data.LastQuote(symbol); ok && price <= 0 {
+		price = quote.Price
+	}
+	if price <= 0 {
+		price = position.AveragePrice
+	}
+
+	stop := StopOrder{
+		Kind:          StopMarket,
+		Symbol:        symbol,
+		Side:          side,
+		Quantity:      quantity,
+		Trailing:      &trailing,
+		PositionBound: true,
+	}
+	if trailing.Mode == TrailATR {
+		s.mu.Lock()
+		stop.ATR = s.atr[atrKey(symbol, trailing.atrPeriod())]
+		s.mu.Unlock()
+		if stop.ATR <= 0 {
+			return nil, fmt.Errorf("no ATR for %s: bars are required before placing an ATR trailing stop", symbol)
+		}
+	}
+	if !stop.trail(price) {
+		return nil, fmt.Errorf("cannot compute trailing stop price for %s at %g", symbol, price)
+	}
+
+	for _, existing := range s.Stops(symbol) {
+		if existing.PositionBound {
+			if err := s.CancelStop(existing.ID); err != nil {
+				return nil, fmt.Errorf("error replacing trailing stop %s: %w", existing.ID, err)
+			}
+		}
+	}
+	return s.PlaceStop(stop)
+}
+
+// Функция для обновления ATR по барам инструмента (для трейлинг-стопов в режиме atr).
+// Вызывается при получении новых свечей: в бою, на бумаге и в бэктесте
+func (s *StopManager) UpdateBars(symbol string, bars *dataframe.DataFrame) {
+	s.mu.Lock()
+	periods := make(map[int]bool)
+	for _, stop := range s.stops {
+		if stop.Symbol == symbol && stop.Trailing != nil && stop.Trailing.Mode == TrailATR {
+			periods[stop.Trailing.atrPeriod()] = true
+		}
+	}
+	for key := range s.atr {
+		if key.symbol == symbol {
+			periods[key.period] = true
+		}
+	}
+	s.mu.Unlock()
+	// Период по умолчанию считается всегда, чтобы стоп можно было выставить сразу
+	periods[defaultATRPeriod] = true
+
+	values := make(map[int]float64, len(periods))
+	for period := range periods {
+		if bars.Nrow() < period {
+			continue
+		}
+		channels, err := utils.CalculateATRChannels(bars, period, 1)
+		if err != nil {
+			logger.Logger.Error().Err(err).Str("symbol", symbol).Msg("Failed to calculate ATR for trailing stop")
+			return
+		}
+		atr := channels.Col("ATR").Float()
+		values[period] = atr[len(atr)-1]
+	}
+
+	s.mu.Lock()
+	for period, value := range values {
+		s.atr[atrKey(symbol, period)] = value
+	}
+	for _, stop := range s.stops {
+		if stop.Symbol == symbol && stop.Trailing != nil && stop.Trailing.Mode == TrailATR {
+			if value, ok := values[stop.Trailing.atrPeriod()]; ok {
+				stop.ATR = value
+			}
+		}
+	}
+	s.mu.Unlock()
+}
+
+// Ключ кэша ATR
+type atrCacheKey struct {
+	symbol string
+	period int
+}
+
+// Функция возвращает ключ кэша ATR
+func atrKey(symbol string, period int) atrCacheKey {
+	return atrCacheKey{symbol: symbol, period: period}
+}
+
+// Функция возвращает позицию по инструменту (нулевую, если позиции нет)
+func (s *StopManager) position(symbol string) (Position, error) {
+	positions, err := s.manager.Broker().GetPositions()
+	if err != nil {
+		return Position{}, fmt.Errorf("error getting positions: %w", err)
+	}
+	for _, position := range positions {
+		if position.Symbol == symbol {
+			return position, nil
+		}
+	}
+	return Position{Symbol: symbol}, nil
+}
+
+// Функция для приведения стопов, привязанных к позиции, к текущей позиции:
+// количество следует за позицией, при закрытии или развороте позиции стоп снимается
+func (s *StopManager) syncPosition(symbol string) {
+	var bound []StopOrder
+	for _, stop := range s.Stops(symbol) {
+		if stop.PositionBound && stop.Status == StopActive {
+			bound = append(bound, stop)
+		}
+	}
+	if len(bound) == 0 {
+		return
+	}
+
+	position, err := s.position(symbol)
+	if err != nil {
+		logger.Logger.Error().Err(err).Str("symbol", symbol).Msg("Failed to sync position-bound stops")
+		return
+	}
+	for _, stop := range bound {
+		quantity := position.Quantity
+		if stop.Side == "buy" {
+			quantity = -quantity
+		}
+		if quantity <= 0 {
+			if err := s.CancelStop(stop.ID); err != nil {
+				logger.Logger.Error().Err(err).Str("stop_id", stop.ID).Msg("Failed to cancel stop for closed position")
+			}
+			continue
+		}
+		if quantity != stop.Quantity {
+			s.modify(stop.ID, stop.StopPrice, quantity)
+		}
+	}
+}
+
+// Функция для изменения стоп-цены и количества условного ордера. Эмулируемый ордер меняется
+// под блокировкой; нативный переставляется на площадке: старый снимается, и до выставления нового
+// стоп эмулируется, так что позиция не остается без защиты. Если старый ордер снять не удалось
+// (например, он уже сработал), изменение не применяется
+func (s *StopManager) modify(id string, stopPrice float64, quantity int) {
+	s.mu.Lock()
+	stop, ok := s.stops[id]
+	if !ok || stop.Status != StopActive || s.replacing[id] {
+		s.mu.Unlock()
+		return
+	}
+	if !stop.Native {
+		stop.StopPrice = stopPrice
+		stop.Quantity = quantity
+		stop.Updated = time.Now()
+		s.save()
+		s.mu.Unlock()
+		return
+	}
+	s.replacing[id] = true
+	oldNativeID := stop.NativeID
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.replacing, id)
+		s.mu.Unlock()
+	}()
+
+	if err := s.native.CancelStopOrder(oldNativeID); err != nil {
+		logger.Logger.Warn().Err(err).Str("stop_id", id).Int("native_id", oldNativeID).Msg("Failed to cancel native stop order for replace")
+		return
+	}
+
+	s.mu.Lock()
+	if stop.Status != StopActive {
+		s.mu.Unlock()
+		return
+	}
+	stop.StopPrice = stopPrice
+	stop.Quantity = quantity
+	stop.Native = false
+	stop.NativeID = 0
+	stop.Updated = time.Now()
+	replacement := *stop
+	s.save()
+	s.mu.Unlock()
+
+	if err := s.placeNative([]*StopOrder{&replacement}); err != nil {
+		logger.Logger.Error().Err(err).Str("stop_id", id).Msg("Native stop order failed, emulating")
+		return
+	}
+
+	s.mu.Lock()
+	// Пока ордер переставлялся, эмуляция могла сработать - тогда новый нативный ордер снимается
+	if stop.Status != StopActive || stop.StopPrice != replacement.StopPrice || stop.Quantity != replacement.Quantity {
+		s.mu.Unlock()
+		if replacement.NativeID != 0 {
+			if err := s.native.CancelStopOrder(replacement.NativeID); err != nil {
+				logger.Logger.Error().Err(err).Int("native_id", replacement.NativeID).Msg("Failed to cancel stale native stop order")
+			}
+		}
+		return
+	}
+	stop.Native = replacement.Native
+	stop.NativeID = replacement.NativeID
+	s.save()
+	s.mu.Unlock()
+}
+
+// Функция для расчета начальной стоп-цены трейлинг-стопа по последней котировке
+func (s *StopManager) initTrailing(stop *StopOrder) {
+	if stop.Trailing.Mode == TrailATR && stop.ATR == 0 {
+		s.mu.Lock()
+		stop.ATR = s.atr[atrKey(stop.Symbol, stop.Trailing.atrPeriod())]
+		s.mu.Unlock()
+	}
+	if quote, ok := data.LastQuote(stop.Symbol); ok {
+		stop.trail(quote.Price)
+	}
+}
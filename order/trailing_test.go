@@ -0,0 +1,134 @@
+package order
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"trading-bot/data"
+)
+
+func TestStopOrderTrailRatchet(t *testing.T) {
+	// Стоп на продажу (длинная позиция) идет только вверх за максимумом
+	long := &StopOrder{Side: "sell", Trailing: &TrailingConfig{Mode: TrailFixed, Distance: 5, MinStep: 1}}
+	for _, step := range []struct {
+		price float64
+		moved bool
+		stop  float64
+	}{
+		{100, true, 95},
+		{103, true, 98},
+		{101, false, 98},   // Откат цены не опускает стоп
+		{103.5, false, 98}, // Сдвиг на 0.5 меньше MinStep
+		{104.2, true, 99.2},
+		{0, false, 99.2},
+	} {
+		if moved := long.trail(step.price); moved != step.moved || math.Abs(long.StopPrice-step.stop) > 1e-9 {
+			t.Fatalf("long at %g: moved %v, stop %g, want %v, %g", step.price, moved, long.StopPrice, step.moved, step.stop)
+		}
+	}
+	if long.ExtremePrice != 104.2 {
+		t.Errorf("long extreme = %g, want 104.2", long.ExtremePrice)
+	}
+
+	// Стоп на покупку (короткая позиция) в процентах идет только вниз за минимумом
+	short := &StopOrder{Side: "buy", Trailing: &TrailingConfig{Mode: TrailPercent, Distance: 10}}
+	short.trail(200)
+	short.trail(180)
+	short.trail(190)
+	if math.Abs(short.StopPrice-198) > 1e-9 || short.ExtremePrice != 180 {
+		t.Errorf("short stop = %g, extreme %g, want 198 from minimum 180", short.StopPrice, short.ExtremePrice)
+	}
+
+	// Без ATR расстояние неизвестно, стоп не выставляется
+	atr := &StopOrder{Side: "sell", Trailing: &TrailingConfig{Mode: TrailATR, Distance: 2}}
+	if atr.trail(100) || atr.StopPrice != 0 {
+		t.Errorf("ATR stop without ATR moved to %g", atr.StopPrice)
+	}
+	atr.ATR = 1.5
+	if !atr.trail(100) || atr.StopPrice != 97 {
+		t.Errorf("ATR stop = %g, want 97", atr.StopPrice)
+	}
+}
+
+// Площадка с нативными условными ордерами: хуки вызываются внутри запросов, чтобы
+// воспроизвести события, приходящие во время перестановки ордера
+type nativeStopBroker struct {
+	*stubBroker
+	nextStopID int
+	cancelled  []int
+	handlers   []func(update StopUpdate)
+	onPlace    func(req *StopOrderRequest)
+}
+
+func (b *nativeStopBroker) PlaceStopOrder(req *StopOrderRequest) (*OrderResponse, error) {
+	b.nextStopID++
+	if b.onPlace != nil {
+		b.onPlace(req)
+	}
+	return &OrderResponse{OrderID: b.nextStopID, Status: "success"}, nil
+}
+
+func (b *nativeStopBroker) CancelStopOrder(stopOrderID int) error {
+	b.cancelled = append(b.cancelled, stopOrderID)
+	// Площадка сообщает о снятии ордера
+	for _, handler := range b.handlers {
+		handler(StopUpdate{NativeID: stopOrderID, Status: StopCancelled})
+	}
+	return nil
+}
+
+func (b *nativeStopBroker) SubscribeStopUpdates(handler func(update StopUpdate)) {
+	b.handlers = append(b.handlers, handler)
+}
+
+func TestStopManagerModifyNativeReplaceRace(t *testing.T) {
+	native := &nativeStopBroker{stubBroker: &stubBroker{}}
+	manager := NewOrderManager(native)
+	stops, err := NewStopManager(manager, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop, err := stops.PlaceStop(StopOrder{
+		Kind:      StopMarket,
+		Symbol:    "SBER",
+		Side:      "sell",
+		Quantity:  2,
+		StopPrice: 95,
+		Trailing:  &TrailingConfig{Mode: TrailFixed, Distance: 5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stop.Native || stop.NativeID != 1 {
+		t.Fatalf("stop = %+v, want native order 1", stop)
+	}
+
+	// Пока новый нативный ордер выставляется, стоп эмулируется и срабатывает по котировке
+	var during []StopOrder
+	native.onPlace = func(req *StopOrderRequest) {
+		if req.StopLoss.StopPrice != 100 {
+			t.Errorf("replacement stop price = %g, want 100", req.StopLoss.StopPrice)
+		}
+		during = allStops(stops)
+		stops.OnQuote(data.Quote{Symbol: "SBER", Price: 99, Volume: 1, Time: time.Now()})
+	}
+	stops.OnQuote(data.Quote{Symbol: "SBER", Price: 105, Volume: 1, Time: time.Now()})
+
+	// Снятие старого ордера при перестановке не снимает стоп
+	if len(during) != 1 || during[0].Status != StopActive || during[0].Native {
+		t.Fatalf("stop during replace = %+v, want active emulated stop", during)
+	}
+	if len(native.placed) != 1 || native.placed[0].Side != "sell" || native.placed[0].OrderType != "market" {
+		t.Fatalf("placed = %+v, want one emulated market sell", native.placed)
+	}
+	// Опоздавший нативный ордер снимается, чтобы позиция не закрылась дважды
+	if fmt.Sprint(native.cancelled) != "[1 2]" {
+		t.Errorf("cancelled native orders = %v, want old 1 and stale replacement 2", native.cancelled)
+	}
+	final := allStops(stops)[0]
+	if final.Status != StopTriggered || final.Native || final.ChildOrderID != 1 {
+		t.Errorf("stop = %+v, want triggered emulated stop with child order 1", final)
+	}
+}
@@ -43,11 +43,19 @@ func (s *SimpleTrendStrategy) GetSignals(quotes *data.Quote, history *dataframe.
 	// 3. Формирование  сигналов
 	var signals []TradingSignal
 	if quotes.Price > sma {
-		signals = append(signals, TradingSignal{
+		signal := TradingSignal{
 			Symbol: quotes.Symbol,
 			Side:   "buy",
 			Price:  quotes.Price, //  Покупаем  по  текущей  рыночной  цене
-		})
+		}
+		//  Уровни  Stop-Loss  и  Take-Profit  от  цены  входа
+		if s.StopLossPercent > 0 {
+			signal.StopLoss = quotes.Price * (1 - s.StopLossPercent/100)
+		}
+		if s.TakeProfitPercent > 0 {
+			signal.TakeProfit = quotes.Price * (1 + s.TakeProfitPercent/100)
+		}
+		signals = append(signals, signal)
 	} else if quotes.Price < sma {
 		signals = append(signals, TradingSignal{
 			Symbol: quotes.Symbol,
@@ -61,7 +69,7 @@ func (s *SimpleTrendStrategy) GetSignals(quotes *data.Quote, history *dataframe.
 
 // Функция для расчета простой скользящей средней (SMA)
 func calculateSMA(df *dataframe.DataFrame, period int) float64 {
-	closePrices := df.Col("Close").Float() //  Предполагается,  что  в  DataFrame  есть  столбец  "Close"  с  ценами  закрытия
+	closePrices := df.Col(data.ColumnClose).Float() //  Столбец  "Close"  формируется  в  data.CandleSeries.ToDataFrame
 	sum := 0.0
 	for i := len(closePrices) - period; i < len(closePrices); i++ {
 		sum += closePrices[i]
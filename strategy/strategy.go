@@ -18,4 +18,7 @@ type TradingSignal struct {
 	Symbol string  `json:"symbol"` //  Тикер  инструмента
 	Side   string  `json:"side"`   //  Направление:  "buy"  или  "sell"
 	Price  float64 `json:"price"`  //  Цена,  по  которой  нужно  открыть  позицию
+
+	StopLoss   float64 `json:"stop_loss"`   //  Цена  Stop-Loss  (0  -  без  стопа)
+	TakeProfit float64 `json:"take_profit"` //  Цена  Take-Profit  (0  -  без  тейк-профита)
 }